@@ -8,12 +8,16 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/ZJUSCT/CSOJ/internal/api/admin"
 	"github.com/ZJUSCT/CSOJ/internal/api/user"
+	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
+	"github.com/ZJUSCT/CSOJ/internal/pubsub"
 
 	"go.uber.org/zap"
 )
@@ -27,12 +31,20 @@ func main() {
 	// config
 	var configPath string
 	flag.StringVar(&configPath, "c", "configs/config.yaml", "path to config file")
+	var migrateFrom string
+	flag.StringVar(&migrateFrom, "migrate-from", "", "copy the data of this SQLite database into the configured database, then exit")
 	flag.Parse()
 
 	cfg, err := config.Load(configPath)
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+	if err := judger.ValidatePostSubmissionHook(cfg.PostSubmissionHook); err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
 
 	// logger
 	var zapCfg zap.Config
@@ -60,13 +72,41 @@ func main() {
 	defer logger.Sync()
 	zap.ReplaceGlobals(logger)
 
+	// display timezone; the database converts stored times to it when reading them
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			zap.S().Fatalf("failed to load timezone: %v", err)
+		}
+		time.Local = loc
+		zap.S().Infof("using timezone %s", loc)
+	}
+
 	// database
-	db, err := database.Init(cfg.Storage.Database)
+	db, err := database.Init(cfg.Storage.Driver, cfg.Storage.Database)
 	if err != nil {
 		zap.S().Fatalf("failed to initialize database: %v", err)
 	}
 	zap.S().Info("database initialized successfully")
 
+	// one-off copy of an existing SQLite database into the configured backend
+	if migrateFrom != "" {
+		src, err := database.Init(database.DriverSQLite, migrateFrom)
+		if err != nil {
+			zap.S().Fatalf("failed to open the source database: %v", err)
+		}
+		if err := database.CopyAll(db, src); err != nil {
+			zap.S().Fatalf("failed to migrate data: %v", err)
+		}
+		zap.S().Infof("migrated %s into the %s database", migrateFrom, cfg.Storage.Driver)
+		return
+	}
+
+	// initial account of a new deployment
+	if err := auth.BootstrapAdmin(db, cfg.Bootstrap.Admin); err != nil {
+		zap.S().Fatalf("failed to create the bootstrap user: %v", err)
+	}
+
 	// recovery and cleanup
 	if err := judger.RecoverAndCleanup(db, cfg); err != nil {
 		zap.S().Errorf("failed to recover and cleanup interrupted tasks: %v", err)
@@ -89,10 +129,13 @@ func main() {
 	}
 	zap.S().Infof("found %d contest directories in '%s'", len(contestDirs), cfg.ContestsRoot)
 
-	contests, problems, err := judger.LoadAllContestsAndProblems(contestDirs)
+	contests, problems, loadErrs, err := judger.LoadAllContestsAndProblems(contestDirs)
 	if err != nil {
 		zap.S().Fatalf("failed to load contests and problems: %v", err)
 	}
+	if len(loadErrs) > 0 {
+		zap.S().Warnf("%d contest/problem directories failed to load", len(loadErrs))
+	}
 	appState.Contests = contests
 	appState.Problems = problems
 	zap.S().Infof("loaded %d contests and %d problems", len(contests), len(problems))
@@ -117,9 +160,26 @@ func main() {
 	go scheduler.Run()
 	zap.S().Info("judger scheduler started")
 
+	// retention janitor for old submission content and logs
+	go judger.StartJanitor(db, cfg)
+
+	// permanent deletion of submissions left in the recycle bin
+	go judger.StartRecycleBinPurger(db, cfg)
+
+	// final standings of contests with lock_scores_at_end
+	go judger.StartFinalizer(db, appState)
+
+	// closing of broker topics that were never closed by their publisher
+	pubsub.GetBroker().SetBufferSize(cfg.Websocket.ClientBufferSize())
+	if idle := cfg.Websocket.TopicIdle(); idle > 0 {
+		go pubsub.GetBroker().StartReaper(idle)
+	}
+
 	// API routers
-	userEngine := user.NewUserRouter(cfg, db, scheduler, appState)
-	adminEngine := admin.NewAdminRouter(cfg, db, scheduler, appState)
+	// Shared so the admin API can rotate the secret the user API signs asset URLs with
+	assetKeys := auth.NewAssetKeyring(cfg.Auth.AssetSigningSecret(), cfg.Auth.AssetURL.PreviousSecret)
+	userEngine := user.NewUserRouter(cfg, db, scheduler, appState, assetKeys)
+	adminEngine := admin.NewAdminRouter(cfg, db, scheduler, appState, assetKeys)
 
 	// start servers
 	go func() {
@@ -12,7 +12,6 @@ import (
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 )
 
@@ -93,7 +92,9 @@ func (h *Handler) handleCreateContestAnnouncement(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to write announcements file: %w", err))
 		return
 	}
-	zap.S().Infof("admin created announcement '%s' in contest '%s'", newAnn.ID, contestID)
+	util.Logger(c).Infof("admin created announcement '%s' in contest '%s'", newAnn.ID, contestID)
+	h.audit(c, "announcement.create", newAnn.ID, gin.H{"contest_id": contestID, "title": newAnn.Title})
+	judger.PublishAnnouncement(contestID, judger.AnnouncementCreated, newAnn)
 	h.reload(c)
 }
 
@@ -125,18 +126,18 @@ func (h *Handler) handleUpdateContestAnnouncement(c *gin.Context) {
 		return
 	}
 
-	found := false
+	var updated *judger.Announcement
 	for _, ann := range announcements {
 		if ann.ID == announcementID {
 			ann.Title = req.Title
 			ann.Description = req.Description
 			ann.UpdatedAt = time.Now()
-			found = true
+			updated = ann
 			break
 		}
 	}
 
-	if !found {
+	if updated == nil {
 		util.Error(c, http.StatusNotFound, "announcement not found")
 		return
 	}
@@ -145,7 +146,9 @@ func (h *Handler) handleUpdateContestAnnouncement(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to write announcements file: %w", err))
 		return
 	}
-	zap.S().Infof("admin updated announcement '%s' in contest '%s'", announcementID, contestID)
+	util.Logger(c).Infof("admin updated announcement '%s' in contest '%s'", announcementID, contestID)
+	h.audit(c, "announcement.update", announcementID, gin.H{"contest_id": contestID, "title": req.Title})
+	judger.PublishAnnouncement(contestID, judger.AnnouncementUpdated, updated)
 	h.reload(c)
 }
 
@@ -188,6 +191,7 @@ func (h *Handler) handleDeleteContestAnnouncement(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to write announcements file: %w", err))
 		return
 	}
-	zap.S().Warnf("admin deleted announcement '%s' from contest '%s'", announcementID, contestID)
+	util.Logger(c).Warnf("admin deleted announcement '%s' from contest '%s'", announcementID, contestID)
+	h.audit(c, "announcement.delete", announcementID, gin.H{"contest_id": contestID})
 	h.reload(c)
 }
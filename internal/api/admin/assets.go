@@ -2,16 +2,20 @@ package admin
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 )
 
 type AssetInfo struct {
@@ -129,6 +133,38 @@ func (h *Handler) handleListProblemAssets(c *gin.Context) {
 	util.Success(c, assets, "Assets listed successfully")
 }
 
+// validateAsset checks an uploaded asset against the size limit, the allowed extensions and the
+// allowed MIME types of its detected content.
+func validateAsset(file *multipart.FileHeader, limits config.Assets) error {
+	if maxBytes := limits.MaxBytes(); maxBytes > 0 && file.Size > maxBytes {
+		return fmt.Errorf("%s is too large, the maximum asset size is %d bytes", file.Filename, maxBytes)
+	}
+
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if !slices.Contains(limits.Extensions(), ext) {
+		return fmt.Errorf("%s: file extension %q is not allowed", file.Filename, ext)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("could not open %s for validation", file.Filename)
+	}
+	defer src.Close()
+
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(src, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("could not read %s for validation", file.Filename)
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(buffer[:n]))
+	for _, allowed := range limits.Types() {
+		if allowed == contentType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*"))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: content type %s is not allowed", file.Filename, contentType)
+}
+
 // handleUploadAsset is a generic handler for uploading assets.
 func (h *Handler) handleUploadAsset(c *gin.Context, basePath string) {
 	form, err := c.MultipartForm()
@@ -140,6 +176,14 @@ func (h *Handler) handleUploadAsset(c *gin.Context, basePath string) {
 	files := form.File["files"]
 	relativePath := form.Value["path"] // Optional subdirectory path
 
+	// Check every file before saving any, so a rejected batch leaves the directory untouched.
+	for _, file := range files {
+		if err := validateAsset(file, h.cfg.Assets); err != nil {
+			util.Error(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
 	for _, file := range files {
 		// Construct the destination path safely
 		destRelPath := filepath.Join(append(relativePath, file.Filename)...)
@@ -162,6 +206,7 @@ func (h *Handler) handleUploadAsset(c *gin.Context, basePath string) {
 		}
 	}
 
+	h.audit(c, "asset.upload", c.Param("id"), gin.H{"path": relativePath, "files": len(files)})
 	util.Success(c, gin.H{"files_uploaded": len(files)}, "Files uploaded successfully")
 }
 
@@ -211,7 +256,8 @@ func (h *Handler) handleDeleteAsset(c *gin.Context, basePath string) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to delete asset: %w", err))
 		return
 	}
-	zap.S().Warnf("admin deleted asset at '%s'", req.Path)
+	util.Logger(c).Warnf("admin deleted asset at '%s'", req.Path)
+	h.audit(c, "asset.delete", c.Param("id"), gin.H{"path": req.Path})
 	util.Success(c, nil, "Asset deleted successfully")
 }
 
@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/api"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAuditActor is recorded when the admin API runs without API keys.
+const defaultAuditActor = "admin"
+
+// adminActor is the name of the admin key that authenticated the request.
+func adminActor(c *gin.Context) string {
+	if actor := c.GetString(api.AdminNameKey); actor != "" {
+		return actor
+	}
+	return defaultAuditActor
+}
+
+// audit records a state-changing admin action. Failing to write the entry is logged but does
+// not fail the request, since the action itself has already been performed.
+func (h *Handler) audit(c *gin.Context, action, targetID string, detail map[string]interface{}) {
+	entry := &models.AuditLog{
+		Actor:    adminActor(c),
+		Action:   action,
+		TargetID: targetID,
+		ClientIP: c.ClientIP(),
+		Detail:   detail,
+	}
+	if err := database.CreateAuditLog(h.db, entry); err != nil {
+		util.Logger(c).Errorf("failed to write audit log entry for %s on %s: %v", action, targetID, err)
+	}
+}
+
+// getAuditLogs lists audit log entries, newest first, filtered by action, target_id, actor
+// and an optional RFC3339 time range.
+func (h *Handler) getAuditLogs(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
+	}
+	offset := (page - 1) * limit
+
+	query := h.db.Model(&models.AuditLog{})
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if targetID := c.Query("target_id"); targetID != "" {
+		query = query.Where("target_id = ?", targetID)
+	}
+	if actor := c.Query("actor"); actor != "" {
+		query = query.Where("actor = ?", actor)
+	}
+	for param, cond := range map[string]string{"after": "created_at >= ?", "before": "created_at <= ?"} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				util.Error(c, http.StatusBadRequest, "invalid "+param+", expected RFC3339: "+v)
+				return
+			}
+			query = query.Where(cond, t)
+		}
+	}
+
+	var totalItems int64
+	if err := query.Count(&totalItems).Error; err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var entries []models.AuditLog
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error; err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	util.Success(c, gin.H{
+		"items":        entries,
+		"total_items":  totalItems,
+		"total_pages":  int(math.Ceil(float64(totalItems) / float64(limit))),
+		"current_page": page,
+		"per_page":     limit,
+	}, "Audit log retrieved successfully")
+}
@@ -1,9 +1,14 @@
 package admin
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/judger"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 )
@@ -13,19 +18,45 @@ func (h *Handler) getClusterStatus(c *gin.Context) {
 	type ClusterStatusResponse struct {
 		ResourceStatus interface{}    `json:"resource_status"`
 		QueueLengths   map[string]int `json:"queue_lengths"`
+		RunningTotal   int64          `json:"running_total"`
+		MaxConcurrent  int            `json:"max_concurrent_total"`
 	}
 
 	status := h.scheduler.GetClusterStates()
 	queueLengths := h.scheduler.GetQueueLengths()
+	runningTotal, maxConcurrent := h.scheduler.GetConcurrencyUsage()
 
 	response := ClusterStatusResponse{
 		ResourceStatus: status,
 		QueueLengths:   queueLengths,
+		RunningTotal:   runningTotal,
+		MaxConcurrent:  maxConcurrent,
 	}
 
 	util.Success(c, response, "Cluster status retrieved")
 }
 
+// getScalingSignal tells external autoscalers how loaded each cluster is and how many nodes it
+// should have.
+func (h *Handler) getScalingSignal(c *gin.Context) {
+	signals, err := h.scheduler.ScalingSignals(time.Now())
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to compute scaling signals: %w", err))
+		return
+	}
+	util.Success(c, signals, "Scaling signals retrieved")
+}
+
+// getClusterQueue lists the submissions waiting in a cluster's queue in scheduling order.
+func (h *Handler) getClusterQueue(c *gin.Context) {
+	entries, err := h.scheduler.QueueSnapshot(c.Param("clusterName"))
+	if err != nil {
+		util.Error(c, http.StatusNotFound, err)
+		return
+	}
+	util.Success(c, entries, "Cluster queue retrieved")
+}
+
 func (h *Handler) getNodeDetails(c *gin.Context) {
 	clusterName := c.Param("clusterName")
 	nodeName := c.Param("nodeName")
@@ -46,6 +77,7 @@ func (h *Handler) pauseNode(c *gin.Context) {
 		util.Error(c, http.StatusNotFound, err)
 		return
 	}
+	h.audit(c, "node.pause", clusterName+"/"+nodeName, nil)
 	util.Success(c, nil, fmt.Sprintf("Node '%s/%s' paused successfully", clusterName, nodeName))
 }
 
@@ -57,5 +89,125 @@ func (h *Handler) resumeNode(c *gin.Context) {
 		util.Error(c, http.StatusNotFound, err)
 		return
 	}
+	h.audit(c, "node.resume", clusterName+"/"+nodeName, nil)
 	util.Success(c, nil, fmt.Sprintf("Node '%s/%s' resumed successfully", clusterName, nodeName))
 }
+
+// interruptNode interrupts every submission running on a node at once, like interrupting them
+// one by one. With requeue, each one is replaced by a fresh copy in the queue instead.
+func (h *Handler) interruptNode(c *gin.Context) {
+	clusterName := c.Param("clusterName")
+	nodeName := c.Param("nodeName")
+
+	var req struct {
+		Requeue bool `json:"requeue"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			util.Error(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	dockerCfg, ok := h.nodeDockerConfig(clusterName, nodeName)
+	if !ok {
+		util.Error(c, http.StatusNotFound, fmt.Sprintf("node '%s/%s' not found", clusterName, nodeName))
+		return
+	}
+	docker, err := judger.GetDockerManager(dockerCfg)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to connect to docker on node %s: %w", nodeName, err))
+		return
+	}
+	subs, err := database.GetRunningSubmissionsOnNode(h.db, clusterName, nodeName)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	reason := fmt.Sprintf("Interrupted by admin with all submissions on node %s", nodeName)
+	interrupted := make([]string, 0, len(subs))
+	requeued := make(map[string]string)
+	for i := range subs {
+		sub := &subs[i]
+		if err := h.stopRunningSubmission(c, docker, sub, reason); err != nil {
+			util.Logger(c).Errorf("failed to interrupt submission %s on node %s/%s: %v", sub.ID, clusterName, nodeName, err)
+			continue
+		}
+		interrupted = append(interrupted, sub.ID)
+		if !req.Requeue || sub.DryRun {
+			continue
+		}
+		newSubID, err := h.queueCopy(sub, sub.StartStep)
+		if err != nil {
+			util.Logger(c).Errorf("failed to requeue interrupted submission %s: %v", sub.ID, err)
+			continue
+		}
+		requeued[sub.ID] = newSubID
+	}
+
+	util.Logger(c).Warnf("admin interrupted %d submissions on node %s/%s (%d requeued)", len(interrupted), clusterName, nodeName, len(requeued))
+	h.audit(c, "node.interrupt_all", clusterName+"/"+nodeName, gin.H{"submissions": interrupted, "requeued": requeued})
+	util.Success(c, gin.H{
+		"interrupted": interrupted,
+		"requeued":    requeued,
+	}, fmt.Sprintf("Interrupted %d submissions on node '%s/%s'", len(interrupted), clusterName, nodeName))
+}
+
+// nodeUsageTimeout bounds how long the usage endpoint waits for a node's Docker daemon.
+const nodeUsageTimeout = 10 * time.Second
+
+// nodeDockerConfig finds the Docker connection settings of a configured node.
+func (h *Handler) nodeDockerConfig(clusterName, nodeName string) (config.DockerConfig, bool) {
+	for _, clusterCfg := range h.cfg.Cluster {
+		if clusterCfg.Name != clusterName {
+			continue
+		}
+		for _, nodeCfg := range clusterCfg.Nodes {
+			if nodeCfg.Name == nodeName {
+				return nodeCfg.Docker, true
+			}
+		}
+	}
+	return config.DockerConfig{}, false
+}
+
+// getNodeUsage asks a node's Docker daemon for the live CPU and memory use of the judge
+// containers running on it. An unreachable node is reported with 502 Bad Gateway.
+func (h *Handler) getNodeUsage(c *gin.Context) {
+	clusterName := c.Param("clusterName")
+	nodeName := c.Param("nodeName")
+
+	dockerCfg, ok := h.nodeDockerConfig(clusterName, nodeName)
+	if !ok {
+		util.Error(c, http.StatusNotFound, fmt.Sprintf("node '%s/%s' not found", clusterName, nodeName))
+		return
+	}
+	docker, err := judger.GetDockerManager(dockerCfg)
+	if err != nil {
+		util.Error(c, http.StatusBadGateway, fmt.Errorf("failed to connect to docker on node %s: %w", nodeName, err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), nodeUsageTimeout)
+	defer cancel()
+	usage, err := docker.JudgeContainerUsage(ctx)
+	if err != nil {
+		util.Logger(c).Warnf("failed to read container usage on node %s/%s: %v", clusterName, nodeName, err)
+		util.Error(c, http.StatusBadGateway, fmt.Errorf("node %s is unreachable: %w", nodeName, err))
+		return
+	}
+
+	var cpuPercent float64
+	var memoryBytes uint64
+	for _, u := range usage {
+		cpuPercent += u.CPUPercent
+		memoryBytes += u.MemoryBytes
+	}
+	util.Success(c, gin.H{
+		"cluster":      clusterName,
+		"node":         nodeName,
+		"cpu_percent":  cpuPercent,
+		"memory_bytes": memoryBytes,
+		"containers":   usage,
+	}, "Node usage retrieved successfully")
+}
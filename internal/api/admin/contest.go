@@ -4,12 +4,12 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 )
 
 // getAllContests returns a list of all loaded contests, regardless of their start/end times.
@@ -61,7 +61,8 @@ func (h *Handler) createContest(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to create contest files: %w", err))
 		return
 	}
-	zap.S().Infof("admin created contest '%s'", newContest.ID)
+	util.Logger(c).Infof("admin created contest '%s'", newContest.ID)
+	h.audit(c, "contest.create", newContest.ID, nil)
 
 	// Reload state and respond
 	h.reload(c)
@@ -96,7 +97,8 @@ func (h *Handler) updateContest(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to update contest files: %w", err))
 		return
 	}
-	zap.S().Infof("admin updated contest '%s'", updatedContest.ID)
+	util.Logger(c).Infof("admin updated contest '%s'", updatedContest.ID)
+	h.audit(c, "contest.update", updatedContest.ID, nil)
 	h.reload(c)
 }
 
@@ -167,7 +169,8 @@ func (h *Handler) handleUpdateContestProblemOrder(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to update contest file: %w", err))
 		return
 	}
-	zap.S().Infof("admin updated problem order for contest '%s'", contestID)
+	util.Logger(c).Infof("admin updated problem order for contest '%s'", contestID)
+	h.audit(c, "contest.reorder_problems", contestID, gin.H{"problem_ids": req.ProblemIDs})
 	h.reload(c)
 }
 
@@ -186,7 +189,8 @@ func (h *Handler) deleteContest(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to delete contest files: %w", err))
 		return
 	}
-	zap.S().Warnf("admin deleted contest '%s'", contestID)
+	util.Logger(c).Warnf("admin deleted contest '%s'", contestID)
+	h.audit(c, "contest.delete", contestID, gin.H{"problem_ids": contest.ProblemIDs})
 	h.reload(c)
 }
 
@@ -217,7 +221,8 @@ func (h *Handler) createProblemInContest(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to create problem files: %w", err))
 		return
 	}
-	zap.S().Infof("admin created problem '%s' in contest '%s'", newProblem.ID, contestID)
+	util.Logger(c).Infof("admin created problem '%s' in contest '%s'", newProblem.ID, contestID)
+	h.audit(c, "problem.create", newProblem.ID, gin.H{"contest_id": contestID})
 	h.reload(c)
 }
 
@@ -227,18 +232,69 @@ func (h *Handler) getContestLeaderboard(c *gin.Context) {
 	h.appState.RLock()
 	// Add tag query parameter
 	tags := c.Query("tags") // Comma-separated string of tags
-	_, ok := h.appState.Contests[contestID]
+	contest, ok := h.appState.Contests[contestID]
+	var columns []judger.LeaderboardColumn
+	var scoring *database.Scoring
+	if ok {
+		columns = contest.LeaderboardColumns(h.appState.Problems, time.Now(), true)
+		scoring = contest.LeaderboardScoring()
+	}
+	h.appState.RUnlock()
+	if !ok {
+		util.Error(c, http.StatusNotFound, "contest not found")
+		return
+	}
+	leaderboard, err := database.GetLeaderboard(h.db, contestID, tags, scoring)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	util.Success(c, gin.H{"problems": columns, "entries": leaderboard}, "Leaderboard retrieved")
+}
+
+// getFinalStanding returns the leaderboard frozen at the end of a contest with lock_scores_at_end.
+func (h *Handler) getFinalStanding(c *gin.Context) {
+	contestID := c.Param("id")
+	h.appState.RLock()
+	contest, ok := h.appState.Contests[contestID]
+	var columns []judger.LeaderboardColumn
+	if ok {
+		columns = contest.LeaderboardColumns(h.appState.Problems, time.Now(), true)
+	}
 	h.appState.RUnlock()
 	if !ok {
 		util.Error(c, http.StatusNotFound, "contest not found")
 		return
 	}
-	leaderboard, err := database.GetLeaderboard(h.db, contestID, tags)
+	standing, found, err := database.GetFinalStanding(h.db, contestID, c.Query("tags"))
 	if err != nil {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
-	util.Success(c, leaderboard, "Leaderboard retrieved")
+	if !found {
+		util.Error(c, http.StatusNotFound, "no final standing has been saved for this contest")
+		return
+	}
+	util.Success(c, gin.H{"problems": columns, "entries": standing}, "Final standing retrieved")
+}
+
+// saveFinalStanding (re)takes the final standing from the current scores, e.g. after manual
+// corrections to a locked contest.
+func (h *Handler) saveFinalStanding(c *gin.Context) {
+	contestID := c.Param("id")
+	h.appState.RLock()
+	contest, ok := h.appState.Contests[contestID]
+	h.appState.RUnlock()
+	if !ok {
+		util.Error(c, http.StatusNotFound, "contest not found")
+		return
+	}
+	if err := database.SaveFinalStanding(h.db, contestID, contest.LeaderboardScoring()); err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to save final standing: %w", err))
+		return
+	}
+	h.audit(c, "contest.finalize", contestID, nil)
+	util.Success(c, nil, "Final standing saved")
 }
 
 // getContestTrend provides an admin-accessible endpoint for the contest score trend.
@@ -253,14 +309,14 @@ func (h *Handler) getContestTrend(c *gin.Context) {
 	}
 
 	h.appState.RLock()
-	_, ok := h.appState.Contests[contestID]
+	contest, ok := h.appState.Contests[contestID]
 	h.appState.RUnlock()
 	if !ok {
 		util.Error(c, http.StatusNotFound, "contest not found")
 		return
 	}
 	// This logic is copied from user/contest.go and is fine for admin use.
-	leaderboard, err := database.GetLeaderboard(h.db, contestID, "") // Trend doesn't support tag filtering for now
+	leaderboard, err := database.GetLeaderboard(h.db, contestID, "", contest.LeaderboardScoring()) // Trend doesn't support tag filtering for now
 	if err != nil {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
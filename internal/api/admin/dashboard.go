@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// dashboardRecentFailures is how many failed submissions the dashboard lists.
+const dashboardRecentFailures = 10
+
+type clusterLoad struct {
+	Queued  int   `json:"queued"`
+	Running int64 `json:"running"`
+}
+
+type dashboardResponse struct {
+	*database.DashboardCounts
+	Clusters       map[string]clusterLoad `json:"clusters"`
+	RunningTotal   int64                  `json:"running_total"`
+	ActiveContests int                    `json:"active_contests"`
+	RecentFailures []models.Submission    `json:"recent_failures"`
+}
+
+// getDashboard aggregates the figures of the admin landing page in one response.
+func (h *Handler) getDashboard(c *gin.Context) {
+	now := time.Now()
+	counts, err := database.GetDashboardCounts(h.db, now.Add(-24*time.Hour))
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	failures, err := database.GetRecentFailedSubmissions(h.db, dashboardRecentFailures)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	queueLengths := h.scheduler.GetQueueLengths()
+	clusters := make(map[string]clusterLoad)
+	for name, running := range h.scheduler.GetRunningCounts() {
+		clusters[name] = clusterLoad{Queued: queueLengths[name], Running: running}
+	}
+	runningTotal, _ := h.scheduler.GetConcurrencyUsage()
+
+	activeContests := 0
+	h.appState.RLock()
+	for _, contest := range h.appState.Contests {
+		if !now.Before(contest.StartTime) && !now.After(contest.EndTime) {
+			activeContests++
+		}
+	}
+	h.appState.RUnlock()
+
+	util.Success(c, dashboardResponse{
+		DashboardCounts: counts,
+		Clusters:        clusters,
+		RunningTotal:    runningTotal,
+		ActiveContests:  activeContests,
+		RecentFailures:  failures,
+	}, "Dashboard retrieved successfully")
+}
+
+// getPubSubStats reports the topics of the message broker, so topics that are never closed show
+// up as a growing count.
+func (h *Handler) getPubSubStats(c *gin.Context) {
+	util.Success(c, pubsub.GetBroker().Stats(), "Broker stats retrieved")
+}
@@ -1,8 +1,11 @@
 package admin
 
 import (
+	"github.com/ZJUSCT/CSOJ/internal/api"
+	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
+	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
 
@@ -12,6 +15,8 @@ type Handler struct {
 	db        *gorm.DB
 	scheduler *judger.Scheduler
 	appState  *judger.AppState
+	upgrader  *websocket.Upgrader
+	assetKeys *auth.AssetKeyring
 }
 
 // NewHandler creates a new admin handler with its dependencies.
@@ -20,11 +25,14 @@ func NewHandler(
 	db *gorm.DB,
 	scheduler *judger.Scheduler,
 	appState *judger.AppState,
+	assetKeys *auth.AssetKeyring,
 ) *Handler {
 	return &Handler{
 		cfg:       cfg,
 		db:        db,
 		scheduler: scheduler,
 		appState:  appState,
+		assetKeys: assetKeys,
+		upgrader:  api.NewWebsocketUpgrader(cfg.CORS),
 	}
 }
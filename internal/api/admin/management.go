@@ -3,17 +3,19 @@ package admin
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/database/models"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 )
 
 func (h *Handler) reload(c *gin.Context) {
 	// Load new data into temporary variables
-	zap.S().Info("starting reload process...")
+	util.Logger(c).Info("starting reload process...")
 
 	// Find contest directories from the root
 	contestDirs, err := judger.FindContestDirs(h.cfg.ContestsRoot)
@@ -21,15 +23,26 @@ func (h *Handler) reload(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to scan contests_root directory: %w", err))
 		return
 	}
-	zap.S().Infof("found %d contest directories in '%s'", len(contestDirs), h.cfg.ContestsRoot)
+	util.Logger(c).Infof("found %d contest directories in '%s'", len(contestDirs), h.cfg.ContestsRoot)
 
 	// Load all contests and problems from the found directories
-	newContests, newProblems, err := judger.LoadAllContestsAndProblems(contestDirs)
+	newContests, newProblems, loadErrs, err := judger.LoadAllContestsAndProblems(contestDirs)
 	if err != nil {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to load new contests/problems: %w", err))
 		return
 	}
-	zap.S().Infof("successfully loaded %d new contests and %d new problems from disk", len(newContests), len(newProblems))
+	util.Logger(c).Infof("successfully loaded %d new contests and %d new problems from disk", len(newContests), len(newProblems))
+
+	// In strict mode, any load error aborts the reload and keeps the current state.
+	if len(loadErrs) > 0 && c.Query("strict") == "true" {
+		util.Logger(c).Warnf("strict reload aborted: %d load errors", len(loadErrs))
+		c.JSON(http.StatusUnprocessableEntity, util.Response{
+			Code:    -1,
+			Data:    gin.H{"errors": loadErrs},
+			Message: fmt.Sprintf("Reload aborted: %d contests/problems failed to load", len(loadErrs)),
+		})
+		return
+	}
 
 	newProblemIDs := make(map[string]struct{}, len(newProblems))
 	for id := range newProblems {
@@ -58,10 +71,57 @@ func (h *Handler) reload(c *gin.Context) {
 	h.appState.Problems = newProblems
 	h.appState.ProblemToContestMap = newProblemToContestMap
 	h.appState.Unlock()
-	zap.S().Info("app state reloaded successfully")
+	util.Logger(c).Info("app state reloaded successfully")
 
+	if loadErrs == nil {
+		loadErrs = []judger.LoadError{}
+	}
+	message := "Reload successful"
+	if len(loadErrs) > 0 {
+		message = fmt.Sprintf("Reload finished with %d load errors", len(loadErrs))
+	}
 	util.Success(c, gin.H{
 		"contests_loaded": len(newContests),
 		"problems_loaded": len(newProblems),
-	}, "Reload successful")
+		"errors":          loadErrs,
+	}, message)
+}
+
+func (h *Handler) runCleanup(c *gin.Context) {
+	days := h.cfg.Storage.Retention.Days
+	if daysStr := c.Query("days"); daysStr != "" {
+		d, err := strconv.Atoi(daysStr)
+		if err != nil {
+			util.Error(c, http.StatusBadRequest, "invalid days parameter")
+			return
+		}
+		days = d
+	}
+	if days <= 0 {
+		util.Error(c, http.StatusBadRequest, "retention is disabled; specify a positive 'days' parameter")
+		return
+	}
+
+	result, err := judger.CleanupExpiredSubmissions(h.db, h.cfg, days)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("cleanup failed: %w", err))
+		return
+	}
+	util.Logger(c).Infof("manual cleanup removed %d files from %d submissions, freed %d bytes",
+		result.FilesRemoved, result.SubmissionsCleaned, result.BytesFreed)
+	h.audit(c, "maintenance.cleanup", "", gin.H{"days": days, "submissions_cleaned": result.SubmissionsCleaned})
+	util.Success(c, result, "Cleanup finished")
+}
+
+// rotateAssetSecret replaces the asset URL signing secret. URLs signed with the old secret are
+// accepted until they expire, so no outstanding link breaks.
+func (h *Handler) rotateAssetSecret(c *gin.Context) {
+	previousValidUntil, err := h.assetKeys.Rotate(auth.AssetURLTTL)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to generate secret: %w", err))
+		return
+	}
+	util.Logger(c).Infof("rotated asset signing secret, previous secret accepted until %s", previousValidUntil.Format(time.RFC3339))
+	h.audit(c, "auth.asset_secret.rotate", "", gin.H{"previous_valid_until": previousValidUntil})
+	util.Success(c, gin.H{"previous_valid_until": previousValidUntil}, "Asset signing secret rotated")
 }
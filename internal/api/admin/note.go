@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxNoteLength caps the size of a submission note in bytes.
+const maxNoteLength = 10000
+
+func (h *Handler) getSubmissionNotes(c *gin.Context) {
+	subID := c.Param("id")
+	if _, err := database.GetSubmission(h.db, subID); err != nil {
+		util.Error(c, http.StatusNotFound, "submission not found")
+		return
+	}
+	notes, err := database.GetSubmissionNotes(h.db, subID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	util.Success(c, notes, "Submission notes retrieved successfully")
+}
+
+func (h *Handler) createSubmissionNote(c *gin.Context) {
+	subID := c.Param("id")
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" {
+		util.Error(c, http.StatusBadRequest, "content must not be empty")
+		return
+	}
+	if len(req.Content) > maxNoteLength {
+		util.Error(c, http.StatusBadRequest, fmt.Sprintf("content must not exceed %d bytes", maxNoteLength))
+		return
+	}
+	if _, err := database.GetSubmission(h.db, subID); err != nil {
+		util.Error(c, http.StatusNotFound, "submission not found")
+		return
+	}
+
+	note := &models.SubmissionNote{SubmissionID: subID, Author: adminActor(c), Content: req.Content}
+	if err := database.CreateSubmissionNote(h.db, note); err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	h.audit(c, "submission.note.create", subID, gin.H{"note_id": note.ID})
+	util.Success(c, note, "Submission note added successfully")
+}
+
+func (h *Handler) deleteSubmissionNote(c *gin.Context) {
+	subID := c.Param("id")
+	noteID, err := strconv.ParseUint(c.Param("noteID"), 10, 64)
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, "invalid note id")
+		return
+	}
+	if err := database.DeleteSubmissionNote(h.db, subID, uint(noteID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.Error(c, http.StatusNotFound, "note not found")
+		} else {
+			util.Error(c, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	h.audit(c, "submission.note.delete", subID, gin.H{"note_id": noteID})
+	util.Success(c, nil, "Submission note deleted successfully")
+}
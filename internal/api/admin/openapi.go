@@ -0,0 +1,168 @@
+package admin
+
+import (
+	"github.com/ZJUSCT/CSOJ/internal/api/openapi"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/judger"
+	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+)
+
+// apiSpec describes the admin API for /openapi.json. Routes missing from the docs are still
+// listed, with a summary derived from the handler name.
+var apiSpec = openapi.Spec{
+	Title:   "CSOJ Admin API",
+	Version: "v1",
+	SecuritySchemes: map[string]openapi.SecurityScheme{
+		"bearer": {Type: "http", Scheme: "bearer", Description: "An API key from admin.keys"},
+		"apiKey": {Type: "apiKey", In: "header", Name: "X-API-Key"},
+	},
+	Docs: openapi.Docs{
+		"GET /api/v1/ws/submissions/:id/containers/:conID/logs": {
+			Summary: "Stream container logs (websocket)", Query: []string{"api_key", "ansi"},
+		},
+
+		"POST /api/v1/reload":                   {Summary: "Reload contests and problems from disk"},
+		"POST /api/v1/maintenance/cleanup":      {Summary: "Delete the files of expired submissions", Response: judger.CleanupResult{}},
+		"POST /api/v1/auth/asset-secret/rotate": {Summary: "Rotate the asset URL signing secret"},
+		"GET /api/v1/audit":                     {Summary: "List audit log entries", Query: []string{"page", "limit", "action", "target_id", "actor", "after", "before"}},
+		"GET /api/v1/dashboard":                 {Summary: "Get the dashboard figures", Response: dashboardResponse{}},
+		"GET /api/v1/debug/pubsub":              {Summary: "Get the topic and subscriber counts of the message broker", Response: pubsub.Stats{}},
+
+		"GET /api/v1/users":     {Summary: "List users", Query: []string{"query"}, Response: []models.User{}},
+		"POST /api/v1/users":    {Summary: "Create a user", Request: models.User{}, Response: models.User{}},
+		"GET /api/v1/users/:id": {Summary: "Get a user", Response: models.User{}},
+		"PATCH /api/v1/users/:id": {
+			Summary: "Update a user",
+			Request: struct {
+				Nickname    *string `json:"nickname"`
+				Signature   *string `json:"signature"`
+				BanReason   *string `json:"ban_reason"`
+				BannedUntil *string `json:"banned_until"`
+				DisableRank *bool   `json:"disable_rank"`
+				Tags        *string `json:"tags"`
+			}{},
+			Response: models.User{},
+		},
+		"DELETE /api/v1/users/:id":       {Summary: "Delete a user"},
+		"GET /api/v1/users/:id/history":  {Summary: "Get a user's score history", Query: []string{"contest_id"}},
+		"GET /api/v1/users/:id/scores":   {Summary: "Get a user's best scores", Response: []models.UserProblemBestScore{}},
+		"GET /api/v1/users/:id/timeline": {Summary: "Get a user's activity timeline", Query: []string{"page", "limit"}},
+
+		"GET /api/v1/tags": {Summary: "List tags with their usage"},
+		"POST /api/v1/tags": {
+			Summary: "Create a tag",
+			Request: struct {
+				Name        string `json:"name"`
+				Description string `json:"description"`
+			}{},
+			Response: models.Tag{},
+		},
+
+		"GET /api/v1/submissions": {
+			Summary: "List submissions",
+			Query: []string{"page", "limit", "problem_id", "status", "content_hash", "user_query", "score_min", "score_max",
+				"created_after", "created_before", "is_valid"},
+		},
+		"GET /api/v1/submissions/:id": {Summary: "Get a submission", Response: models.Submission{}},
+		"PATCH /api/v1/submissions/:id": {
+			Summary: "Manually update a submission's result",
+			Request: struct {
+				Status      *models.Status  `json:"status"`
+				Score       *int            `json:"score"`
+				Performance *float64        `json:"performance"`
+				Info        *models.JSONMap `json:"info"`
+			}{},
+			Response: models.Submission{},
+		},
+		"DELETE /api/v1/submissions/:id":         {Summary: "Move a submission to the recycle bin"},
+		"POST /api/v1/submissions/requeue-stuck": {Summary: "Queue again the queued submissions missing from the scheduler", Response: requeueResult{}},
+		"POST /api/v1/submissions/:id/rejudge": {
+			Summary: "Rejudge a submission as a new submission",
+			Request: struct {
+				FromStep int `json:"from_step"`
+			}{},
+		},
+		"POST /api/v1/submissions/:id/mark-active":   {Summary: "Pin a submission as the one that counts for its user's score on the problem"},
+		"DELETE /api/v1/submissions/:id/mark-active": {Summary: "Clear a pinned scoring submission"},
+		"GET /api/v1/submissions/:id/notes":          {Summary: "List a submission's notes", Response: []models.SubmissionNote{}},
+		"POST /api/v1/submissions/:id/notes": {
+			Summary: "Add a note to a submission",
+			Request: struct {
+				Content string `json:"content"`
+			}{},
+			Response: models.SubmissionNote{},
+		},
+
+		"GET /api/v1/contests":     {Summary: "List loaded contests", Response: map[string]judger.Contest{}},
+		"POST /api/v1/contests":    {Summary: "Create a contest", Request: judger.Contest{}},
+		"GET /api/v1/contests/:id": {Summary: "Get a contest", Response: judger.Contest{}},
+		"PUT /api/v1/contests/:id": {Summary: "Replace a contest definition", Request: judger.Contest{}},
+		"GET /api/v1/contests/:id/leaderboard": {
+			Summary: "Get the live leaderboard",
+			Response: struct {
+				Problems []judger.LeaderboardColumn  `json:"problems"`
+				Entries  []database.LeaderboardEntry `json:"entries"`
+			}{},
+		},
+		"GET /api/v1/contests/:id/usage": {
+			Summary: "List the compute charged to each user against the contest quota",
+			Response: struct {
+				Quota judger.ContestQuota `json:"quota"`
+				Users []contestUsageEntry `json:"users"`
+			}{},
+		},
+		"PUT /api/v1/contests/:id/usage/:userID": {
+			Summary: "Override the contest quota for a user",
+			Request: struct {
+				CPUSecondsLimit *float64 `json:"cpu_seconds_limit"`
+				RunsLimit       *int     `json:"runs_limit"`
+			}{},
+			Response: contestUsageEntry{},
+		},
+		"POST /api/v1/contests/:id/usage/:userID/reset": {Summary: "Clear the compute charged to a user in a contest", Response: contestUsageEntry{}},
+		"POST /api/v1/contests/:id/problems":            {Summary: "Create a problem in a contest", Request: judger.Problem{}},
+		"PUT /api/v1/contests/:id/problems/order": {
+			Summary: "Reorder a contest's problems",
+			Request: struct {
+				ProblemIDs []string `json:"problem_ids"`
+			}{},
+		},
+		"POST /api/v1/contests/:id/assets": {Summary: "Upload contest assets", Multipart: []string{"files"}},
+		"POST /api/v1/contests/:id/announcements": {
+			Summary: "Post an announcement",
+			Request: struct {
+				Title       string `json:"title"`
+				Description string `json:"description"`
+			}{},
+		},
+
+		"GET /api/v1/problems":     {Summary: "List loaded problems", Response: map[string]judger.Problem{}},
+		"GET /api/v1/problems/:id": {Summary: "Get a problem definition", Response: judger.Problem{}},
+		"GET /api/v1/problems/:id/shared-content": {
+			Summary: "Find files submitted by several users", Response: []database.SharedContent{},
+		},
+		"PUT /api/v1/problems/:id":         {Summary: "Replace a problem definition", Request: judger.Problem{}},
+		"POST /api/v1/problems/:id/assets": {Summary: "Upload problem assets", Multipart: []string{"files"}},
+
+		"POST /api/v1/scores/recalculate": {
+			Summary: "Recalculate a user's score on a problem",
+			Request: struct {
+				UserID    string `json:"user_id"`
+				ProblemID string `json:"problem_id"`
+			}{},
+		},
+
+		"GET /api/v1/clusters/scaling-signal":               {Summary: "Get the load and desired node count of each cluster", Response: []judger.ScalingSignal{}},
+		"GET /api/v1/clusters/:clusterName/queue":           {Summary: "List the submissions waiting in a cluster's queue in scheduling order", Response: []judger.QueueEntry{}},
+		"GET /api/v1/clusters/:clusterName/nodes/:nodeName": {Summary: "Get a node's details", Response: judger.NodeDetail{}},
+		"POST /api/v1/clusters/:clusterName/nodes/:nodeName/interrupt-all": {
+			Summary: "Interrupt all submissions on a node",
+			Request: struct {
+				Requeue bool `json:"requeue"`
+			}{},
+		},
+
+		"GET /api/v1/containers/:id": {Summary: "Get a container", Response: models.Container{}},
+	},
+}
@@ -3,11 +3,12 @@ package admin
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 )
 
 // getAllProblems returns a list of all loaded problems.
@@ -17,6 +18,57 @@ func (h *Handler) getAllProblems(c *gin.Context) {
 	util.Success(c, h.appState.Problems, "All loaded problems retrieved")
 }
 
+// getSharedContent lists files submitted to a problem by more than one user, to help spot
+// copied solutions.
+func (h *Handler) getSharedContent(c *gin.Context) {
+	problemID := c.Param("id")
+
+	h.appState.RLock()
+	_, ok := h.appState.Problems[problemID]
+	h.appState.RUnlock()
+	if !ok {
+		util.Error(c, http.StatusNotFound, "problem not found")
+		return
+	}
+
+	shared, err := database.GetSharedContent(h.db, problemID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	util.Success(c, shared, "Shared submission content retrieved")
+}
+
+// getProblemStats returns aggregate submission and score statistics of a problem.
+func (h *Handler) getProblemStats(c *gin.Context) {
+	problemID := c.Param("id")
+
+	h.appState.RLock()
+	problem, ok := h.appState.Problems[problemID]
+	h.appState.RUnlock()
+	if !ok {
+		util.Error(c, http.StatusNotFound, "problem not found")
+		return
+	}
+
+	bucketSize, err := strconv.Atoi(c.DefaultQuery("bucket_size", "10"))
+	if err != nil || bucketSize <= 0 {
+		util.Error(c, http.StatusBadRequest, "bucket_size must be a positive integer")
+		return
+	}
+	performanceBuckets := 0
+	if problem.Score.Mode == "performance" {
+		performanceBuckets = 10
+	}
+
+	stats, err := database.GetProblemStats(h.db, problemID, bucketSize, performanceBuckets)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	util.Success(c, stats, "Problem statistics retrieved")
+}
+
 // getProblem returns the full definition of a single problem, with no time restrictions.
 func (h *Handler) getProblem(c *gin.Context) {
 	problemID := c.Param("id")
@@ -63,7 +115,8 @@ func (h *Handler) updateProblem(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to update problem files: %w", err))
 		return
 	}
-	zap.S().Infof("admin updated problem '%s'", updatedProblem.ID)
+	util.Logger(c).Infof("admin updated problem '%s'", updatedProblem.ID)
+	h.audit(c, "problem.update", updatedProblem.ID, nil)
 	h.reload(c)
 }
 
@@ -88,6 +141,7 @@ func (h *Handler) deleteProblem(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to delete problem files: %w", err))
 		return
 	}
-	zap.S().Warnf("admin deleted problem '%s' from contest '%s'", problemID, contest.ID)
+	util.Logger(c).Warnf("admin deleted problem '%s' from contest '%s'", problemID, contest.ID)
+	h.audit(c, "problem.delete", problemID, gin.H{"contest_id": contest.ID})
 	h.reload(c)
 }
@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/judger"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// contestUsageEntry is a user's charged usage of a contest with their standing against its quota.
+// Submissions still queued or running are not included.
+type contestUsageEntry struct {
+	models.ContestUsage
+	Quota judger.QuotaStatus `json:"quota"`
+}
+
+// contestByID looks up the contest named by the id parameter, answering 404 if it does not exist.
+func (h *Handler) contestByID(c *gin.Context) (*judger.Contest, bool) {
+	h.appState.RLock()
+	contest, ok := h.appState.Contests[c.Param("id")]
+	h.appState.RUnlock()
+	if !ok {
+		util.Error(c, http.StatusNotFound, "contest not found")
+	}
+	return contest, ok
+}
+
+// getContestUsage lists the compute charged to each user of a contest, heaviest users first.
+func (h *Handler) getContestUsage(c *gin.Context) {
+	contest, ok := h.contestByID(c)
+	if !ok {
+		return
+	}
+	usages, err := database.GetContestUsages(h.db, contest.ID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	entries := make([]contestUsageEntry, len(usages))
+	for i, usage := range usages {
+		entries[i] = contestUsageEntry{ContestUsage: usage, Quota: contest.QuotaStatus(usage, 0)}
+	}
+	util.Success(c, gin.H{"quota": contest.Quota, "users": entries}, "Contest usage retrieved")
+}
+
+// setUserQuota overrides the contest's quota for one user. A null limit restores the contest's.
+func (h *Handler) setUserQuota(c *gin.Context) {
+	userID := c.Param("userID")
+	var req struct {
+		CPUSecondsLimit *float64 `json:"cpu_seconds_limit"`
+		RunsLimit       *int     `json:"runs_limit"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	if (req.CPUSecondsLimit != nil && *req.CPUSecondsLimit < 0) || (req.RunsLimit != nil && *req.RunsLimit < 0) {
+		util.Error(c, http.StatusBadRequest, "limits must not be negative")
+		return
+	}
+	contest, ok := h.contestByID(c)
+	if !ok {
+		return
+	}
+	if _, err := database.GetUserByID(h.db, userID); err != nil {
+		util.Error(c, http.StatusNotFound, "user not found")
+		return
+	}
+
+	usage, err := database.GetContestUsage(h.db, userID, contest.ID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	usage.CPUSecondsLimit = req.CPUSecondsLimit
+	usage.RunsLimit = req.RunsLimit
+	if err := database.SaveContestUsage(h.db, &usage); err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to save quota: %w", err))
+		return
+	}
+	h.audit(c, "contest.quota_set", userID, gin.H{"contest_id": contest.ID, "cpu_seconds_limit": req.CPUSecondsLimit, "runs_limit": req.RunsLimit})
+	util.Success(c, contestUsageEntry{ContestUsage: usage, Quota: contest.QuotaStatus(usage, 0)}, "User quota updated")
+}
+
+// resetUserUsage clears the compute charged to a user in a contest, keeping their limits.
+func (h *Handler) resetUserUsage(c *gin.Context) {
+	userID := c.Param("userID")
+	contest, ok := h.contestByID(c)
+	if !ok {
+		return
+	}
+	usage, err := database.GetContestUsage(h.db, userID, contest.ID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	previous := gin.H{"cpu_seconds": usage.CPUSeconds, "runs": usage.Runs}
+	now := time.Now()
+	usage.CPUSeconds = 0
+	usage.Runs = 0
+	usage.ResetAt = &now
+	if err := database.SaveContestUsage(h.db, &usage); err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to reset usage: %w", err))
+		return
+	}
+	h.audit(c, "contest.usage_reset", userID, gin.H{"contest_id": contest.ID, "previous": previous})
+	util.Success(c, contestUsageEntry{ContestUsage: usage, Quota: contest.QuotaStatus(usage, 0)}, "User usage reset")
+}
@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type deletedSubmission struct {
+	models.Submission
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// getDeletedSubmissions lists the submissions in the recycle bin, most recently deleted first.
+func (h *Handler) getDeletedSubmissions(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	subs, totalItems, err := database.GetDeletedSubmissions(h.db, (page-1)*limit, limit)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	retention := h.cfg.Storage.RecycleBin.Retention()
+	items := make([]deletedSubmission, len(subs))
+	for i := range subs {
+		items[i] = deletedSubmission{Submission: subs[i], PurgeAt: subs[i].DeletedAt.Time.Add(retention)}
+	}
+
+	util.Success(c, gin.H{
+		"items":        items,
+		"total_items":  totalItems,
+		"total_pages":  int(math.Ceil(float64(totalItems) / float64(limit))),
+		"current_page": page,
+		"per_page":     limit,
+	}, "Deleted submissions retrieved successfully")
+}
+
+// restoreSubmission takes a submission out of the recycle bin and moves its content back. The
+// submitter's scores are recalculated so the submission counts again.
+func (h *Handler) restoreSubmission(c *gin.Context) {
+	sub, err := database.GetDeletedSubmission(h.db, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.Error(c, http.StatusNotFound, "Submission not found in the recycle bin")
+			return
+		}
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	if time.Since(sub.DeletedAt.Time) > h.cfg.Storage.RecycleBin.Retention() {
+		util.Error(c, http.StatusGone, "Submission is past the retention window and can no longer be restored")
+		return
+	}
+
+	moved, err := util.MoveContent(h.cfg.Storage.RecycleBinPath(), h.cfg.Storage.SubmissionContent, sub.ID)
+	if err != nil {
+		util.Logger(c).Errorf("failed to move content of submission %s out of the recycle bin: %v", sub.ID, err)
+		util.Error(c, http.StatusInternalServerError, "failed to restore submission content")
+		return
+	}
+	if err := database.RestoreSubmission(h.db, sub.ID); err != nil {
+		if moved {
+			if _, err := util.MoveContent(h.cfg.Storage.SubmissionContent, h.cfg.Storage.RecycleBinPath(), sub.ID); err != nil {
+				util.Logger(c).Errorf("failed to move content of submission %s back to the recycle bin: %v", sub.ID, err)
+			}
+		}
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	util.Logger(c).Infof("admin restored submission %s from the recycle bin", sub.ID)
+	h.audit(c, "submission.restore", sub.ID, gin.H{"user_id": sub.UserID, "problem_id": sub.ProblemID})
+
+	if !h.recalculateSubmissionScores(c, sub, "submission restored") {
+		return
+	}
+	util.Success(c, nil, "Submission restored successfully")
+}
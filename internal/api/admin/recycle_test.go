@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/judger"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecycleBinRecalculatesScores(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	db, err := database.Init(database.DriverSQLite, filepath.Join(dir, "csoj.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.Create(&models.User{ID: "u", Username: "alice"}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, s := range []models.Submission{
+		{ID: "best", UserID: "u", ProblemID: "p", Status: models.StatusSuccess, Score: 90, IsValid: true, CreatedAt: created},
+		{ID: "second", UserID: "u", ProblemID: "p", Status: models.StatusSuccess, Score: 60, IsValid: true, CreatedAt: created.Add(time.Minute)},
+	} {
+		if err := db.Omit("User").Create(&s).Error; err != nil {
+			t.Fatalf("create submission: %v", err)
+		}
+	}
+	if err := db.Create(&models.UserProblemBestScore{UserID: "u", ContestID: "c", ProblemID: "p", Score: 90, SubmissionID: "best", LastScoreTime: created}).Error; err != nil {
+		t.Fatalf("create best score: %v", err)
+	}
+
+	contest := &judger.Contest{ID: "c"}
+	h := &Handler{
+		db:  db,
+		cfg: &config.Config{Storage: config.Storage{SubmissionContent: filepath.Join(dir, "content")}},
+		appState: &judger.AppState{
+			Problems:            map[string]*judger.Problem{"p": {ID: "p", Score: judger.ScoreConfig{Mode: "score"}}},
+			ProblemToContestMap: map[string]*judger.Contest{"p": contest},
+		},
+	}
+	call := func(handler gin.HandlerFunc, method string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "/submissions/best", nil)
+		c.Params = gin.Params{{Key: "id", Value: "best"}}
+		handler(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+	}
+	bestScore := func() models.UserProblemBestScore {
+		t.Helper()
+		var row models.UserProblemBestScore
+		if err := db.Where("user_id = ? AND problem_id = ?", "u", "p").First(&row).Error; err != nil {
+			t.Fatalf("load best score: %v", err)
+		}
+		return row
+	}
+
+	call(h.deleteSubmission, http.MethodDelete)
+	if got := bestScore(); got.SubmissionID != "second" || got.Score != 60 {
+		t.Errorf("after delete: best is %s with %d, want second with 60", got.SubmissionID, got.Score)
+	}
+
+	call(h.restoreSubmission, http.MethodPost)
+	if got := bestScore(); got.SubmissionID != "best" || got.Score != 90 {
+		t.Errorf("after restore: best is %s with %d, want best with 90", got.SubmissionID, got.Score)
+	}
+}
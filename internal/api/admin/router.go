@@ -2,6 +2,8 @@ package admin
 
 import (
 	"github.com/ZJUSCT/CSOJ/internal/api"
+	"github.com/ZJUSCT/CSOJ/internal/api/openapi"
+	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/embedui"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
@@ -14,49 +16,84 @@ func NewAdminRouter(
 	cfg *config.Config,
 	db *gorm.DB,
 	scheduler *judger.Scheduler,
-	appState *judger.AppState) *gin.Engine {
+	appState *judger.AppState,
+	assetKeys *auth.AssetKeyring) *gin.Engine {
 
 	r := gin.Default()
+	r.MaxMultipartMemory = api.MultipartMemory
 
+	r.Use(api.RequestLoggerMiddleware())
 	r.Use(api.CORSMiddleware(cfg.CORS))
+	r.Use(api.BodyLimitMiddleware(cfg.MaxBodyBytes()))
 
-	h := NewHandler(cfg, db, scheduler, appState)
+	h := NewHandler(cfg, db, scheduler, appState, assetKeys)
+
+	adminAuth := api.AdminAuthMiddleware(cfg.Admin)
 
 	v1 := r.Group("/api/v1")
+	v1.Use(adminAuth)
 	{
 		// Websocket
 		v1.GET("/ws/submissions/:id/containers/:conID/logs", h.handleAdminContainerWs)
 
 		// Management
 		v1.POST("/reload", h.reload)
+		v1.POST("/maintenance/cleanup", h.runCleanup)
+		v1.POST("/auth/asset-secret/rotate", h.rotateAssetSecret)
+		v1.GET("/audit", h.getAuditLogs)
+		v1.GET("/dashboard", h.getDashboard)
+		v1.GET("/debug/pubsub", h.getPubSubStats)
 
 		// User Management
 		users := v1.Group("/users")
 		{
 			users.GET("", h.getAllUsers)
 			users.POST("", h.createUser)
+			users.POST("/import", h.importUsers)
 			users.GET("/:id", h.getUser)
 			users.PATCH("/:id", h.updateUser)
 			users.DELETE("/:id", h.deleteUser)
 			users.GET("/:id/history", h.getUserContestHistory)
+			users.GET("/:id/timeline", h.getUserTimeline)
 			users.POST("/:id/reset-password", h.resetUserPassword)
+			users.POST("/:id/impersonation-token", h.createImpersonationToken)
 			users.POST("/:id/register-contest", h.registerUserForContest)
 			users.GET("/:id/scores", h.getUserScores)
+			users.PUT("/:id/scores", h.setUserScore)
 			users.GET("/:id/download_solutions/:contest_id", h.handleDownloadSolutions)
 		}
 
+		// Tag Management
+		tags := v1.Group("/tags")
+		{
+			tags.GET("", h.getTags)
+			tags.POST("", h.createTag)
+			tags.DELETE("/:name", h.deleteTag)
+		}
+
 		// Submission Management
 		submissions := v1.Group("/submissions")
 		{
 			submissions.GET("", h.getAllSubmissions)
+			submissions.GET("/deleted", h.getDeletedSubmissions)
+			submissions.POST("/requeue-stuck", h.requeueStuckSubmissions)
 			submissions.GET("/:id", h.getSubmission)
 			submissions.GET("/:id/content", h.getSubmissionContent)
 			submissions.PATCH("/:id", h.updateSubmission)
 			submissions.DELETE("/:id", h.deleteSubmission)
+			submissions.POST("/:id/restore", h.restoreSubmission)
 			submissions.GET("/:id/containers/:conID/log", h.getContainerLog)
+			submissions.GET("/:id/containers/:conID/log.json", h.getContainerLogJSON)
 			submissions.POST("/:id/rejudge", h.rejudgeSubmission)
+			submissions.POST("/:id/rerun", h.rerunSubmission)
 			submissions.PATCH("/:id/validity", h.updateSubmissionValidity)
+			submissions.POST("/:id/validity/preview", h.previewSubmissionValidity)
+			submissions.POST("/:id/mark-active", h.markSubmissionActive)
+			submissions.DELETE("/:id/mark-active", h.unmarkSubmissionActive)
 			submissions.POST("/:id/interrupt", h.interruptSubmission)
+			submissions.GET("/:id/notes", h.getSubmissionNotes)
+			submissions.POST("/:id/notes", h.createSubmissionNote)
+			submissions.DELETE("/:id/notes/:noteID", h.deleteSubmissionNote)
 		}
 
 		// Contest & Problem Management
@@ -69,6 +106,11 @@ func NewAdminRouter(
 			contests.DELETE("/:id", h.deleteContest)
 			contests.GET("/:id/leaderboard", h.getContestLeaderboard)
 			contests.GET("/:id/trend", h.getContestTrend)
+			contests.GET("/:id/final-standing", h.getFinalStanding)
+			contests.POST("/:id/final-standing", h.saveFinalStanding)
+			contests.GET("/:id/usage", h.getContestUsage)
+			contests.PUT("/:id/usage/:userID", h.setUserQuota)
+			contests.POST("/:id/usage/:userID/reset", h.resetUserUsage)
 			contests.POST("/:id/problems", h.createProblemInContest)
 			contests.PUT("/:id/problems/order", h.handleUpdateContestProblemOrder)
 			// Contest Assets
@@ -87,6 +129,8 @@ func NewAdminRouter(
 		{
 			problems.GET("", h.getAllProblems)
 			problems.GET("/:id", h.getProblem)
+			problems.GET("/:id/stats", h.getProblemStats)
+			problems.GET("/:id/shared-content", h.getSharedContent)
 			problems.PUT("/:id", h.updateProblem)
 			problems.DELETE("/:id", h.deleteProblem)
 			// Problem Assets
@@ -106,7 +150,11 @@ func NewAdminRouter(
 		clusters := v1.Group("/clusters")
 		{
 			clusters.GET("/status", h.getClusterStatus)
+			clusters.GET("/scaling-signal", h.getScalingSignal)
+			clusters.GET("/:clusterName/queue", h.getClusterQueue)
 			clusters.GET("/:clusterName/nodes/:nodeName", h.getNodeDetails)
+			clusters.GET("/:clusterName/nodes/:nodeName/usage", h.getNodeUsage)
+			clusters.POST("/:clusterName/nodes/:nodeName/interrupt-all", h.interruptNode)
 			clusters.POST("/:clusterName/nodes/:nodeName/pause", h.pauseNode)
 			clusters.POST("/:clusterName/nodes/:nodeName/resume", h.resumeNode)
 		}
@@ -119,6 +167,8 @@ func NewAdminRouter(
 		}
 	}
 
+	r.GET("/openapi.json", adminAuth, openapi.Handler(r, apiSpec))
+
 	embedui.RegisterUIHandlers(r, "admin")
 
 	return r
@@ -7,7 +7,6 @@ import (
 	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 )
 
 func (h *Handler) recalculateScore(c *gin.Context) {
@@ -37,12 +36,13 @@ func (h *Handler) recalculateScore(c *gin.Context) {
 	h.appState.RUnlock()
 
 	// Using an empty submission ID for the source, as this is an admin-triggered action.
-	err := database.RecalculateScoresForUserProblem(h.db, req.UserID, req.ProblemID, contest.ID, "admin-recalc", problem.Score.Mode, problem.Score.MaxPerformanceScore)
+	err := database.RecalculateScoresForUserProblem(h.db, req.UserID, req.ProblemID, contest.ID, "admin-recalc", problem.Score.Mode, problem.Score.MaxPerformanceScore, problem.Score.Weighted())
 	if err != nil {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to recalculate scores: %w", err))
 		return
 	}
 
-	zap.S().Infof("admin triggered score recalculation for user %s on problem %s", req.UserID, req.ProblemID)
+	util.Logger(c).Infof("admin triggered score recalculation for user %s on problem %s", req.UserID, req.ProblemID)
+	h.audit(c, "score.recalculate", req.UserID, gin.H{"problem_id": req.ProblemID})
 	util.Success(c, nil, "Score recalculation triggered successfully")
 }
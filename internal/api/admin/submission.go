@@ -1,19 +1,13 @@
 package admin
 
 import (
-	"archive/zip"
-	"bytes"
 	"fmt"
-	"io"
-	"io/fs"
 	"math"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
+	"time"
 
-	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/database/models"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
@@ -21,7 +15,6 @@ import (
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
@@ -54,12 +47,20 @@ func (h *Handler) getAllSubmissions(c *gin.Context) {
 	if status := c.Query("status"); status != "" {
 		query = query.Where("submissions.status = ?", status)
 	}
+	if contentHash := c.Query("content_hash"); contentHash != "" {
+		query = query.Where("submissions.content_hash = ?", contentHash)
+	}
 	if userQuery := c.Query("user_query"); userQuery != "" {
 		likeQuery := "%" + userQuery + "%"
 		// Join with users table to filter by user attributes
 		query = query.Joins("JOIN users ON users.id = submissions.user_id").
 			Where("users.id = ? OR users.username LIKE ? OR users.nickname LIKE ?", userQuery, likeQuery, likeQuery)
 	}
+	query, err = applySubmissionRangeFilters(c, query)
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
 
 	// Get total count
 	var totalItems int64
@@ -77,10 +78,28 @@ func (h *Handler) getAllSubmissions(c *gin.Context) {
 		return
 	}
 
+	ids := make([]string, len(subs))
+	for i := range subs {
+		ids[i] = subs[i].ID
+	}
+	noteCounts, err := database.CountSubmissionNotes(h.db, ids)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	type submissionItem struct {
+		models.Submission
+		NoteCount int64 `json:"note_count"`
+	}
+	items := make([]submissionItem, len(subs))
+	for i := range subs {
+		items[i] = submissionItem{Submission: subs[i], NoteCount: noteCounts[subs[i].ID]}
+	}
+
 	totalPages := int(math.Ceil(float64(totalItems) / float64(limit)))
 
 	response := gin.H{
-		"items":        subs,
+		"items":        items,
 		"total_items":  totalItems,
 		"total_pages":  totalPages,
 		"current_page": page,
@@ -90,6 +109,47 @@ func (h *Handler) getAllSubmissions(c *gin.Context) {
 	util.Success(c, response, "Submissions retrieved successfully")
 }
 
+// applySubmissionRangeFilters applies the optional score_min, score_max, created_after,
+// created_before (RFC3339) and is_valid query parameters.
+func applySubmissionRangeFilters(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	if v := c.Query("score_min"); v != "" {
+		scoreMin, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score_min: %s", v)
+		}
+		query = query.Where("submissions.score >= ?", scoreMin)
+	}
+	if v := c.Query("score_max"); v != "" {
+		scoreMax, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score_max: %s", v)
+		}
+		query = query.Where("submissions.score <= ?", scoreMax)
+	}
+	if v := c.Query("created_after"); v != "" {
+		after, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_after, expected RFC3339: %s", v)
+		}
+		query = query.Where("submissions.created_at >= ?", after)
+	}
+	if v := c.Query("created_before"); v != "" {
+		before, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_before, expected RFC3339: %s", v)
+		}
+		query = query.Where("submissions.created_at < ?", before)
+	}
+	if v := c.Query("is_valid"); v != "" {
+		isValid, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid is_valid: %s", v)
+		}
+		query = query.Where("submissions.is_valid = ?", isValid)
+	}
+	return query, nil
+}
+
 func (h *Handler) getSubmission(c *gin.Context) {
 	sub, err := database.GetSubmission(h.db, c.Param("id"))
 	if err != nil {
@@ -113,80 +173,18 @@ func (h *Handler) getSubmissionContent(c *gin.Context) {
 		return
 	}
 
-	submissionPath := filepath.Join(h.cfg.Storage.SubmissionContent, subID)
-
-	// Check if the directory exists
-	info, err := os.Stat(submissionPath)
-	if os.IsNotExist(err) || !info.IsDir() {
-		util.Error(c, http.StatusNotFound, "submission content not found on disk")
-		return
-	}
-
-	// Create a buffer to write our archive to.
-	buf := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(buf)
-
-	// Walk the directory and add files to the zip.
-	err = filepath.Walk(submissionPath, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Create a proper zip header
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
-
-		// Update the header name to be relative to the submission directory
-		relPath, err := filepath.Rel(submissionPath, path)
-		if err != nil {
-			return err
-		}
-		header.Name = filepath.ToSlash(relPath) // Use forward slashes in zip
-
-		// If it's a directory, just create the header
-		if info.IsDir() {
-			header.Name += "/"
+	submissionPath, cleanup, err := util.OpenContent(h.cfg.Storage.SubmissionContent, subID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			util.Error(c, http.StatusNotFound, "submission content not found on disk")
 		} else {
-			// Set compression method
-			header.Method = zip.Deflate
-		}
-
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
-		}
-
-		// If it's a file, write its content to the zip
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return err
-			}
+			util.Error(c, http.StatusInternalServerError, err)
 		}
-		return nil
-	})
-
-	if err != nil {
-		zap.S().Errorf("failed to create zip archive for submission %s: %v", subID, err)
-		util.Error(c, http.StatusInternalServerError, "failed to create zip archive")
 		return
 	}
+	defer cleanup()
 
-	// Close the zip writer to finalize the archive
-	zipWriter.Close()
-
-	// Set headers for file download
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Type", "application/zip")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"submission_%s.zip\"", subID))
-	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+	util.ServeDirectoryArchive(c, submissionPath, "submission_"+subID, c.Query("format"))
 }
 
 func (h *Handler) updateSubmission(c *gin.Context) {
@@ -226,19 +224,20 @@ func (h *Handler) updateSubmission(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
-	zap.S().Warnf("admin manually updated submission %s", sub.ID)
+	util.Logger(c).Warnf("admin manually updated submission %s", sub.ID)
+	h.audit(c, "submission.update", sub.ID, gin.H{"status": req.Status, "score": req.Score, "performance": req.Performance, "info": req.Info})
 
 	h.appState.RLock()
 	contest, ok := h.appState.ProblemToContestMap[sub.ProblemID]
 	problem, probOk := h.appState.Problems[sub.ProblemID]
 	h.appState.RUnlock()
 	if !ok || !probOk {
-		zap.S().Errorf("failed to find parent contest or problem %s during score recalculation for submission %s", sub.ProblemID, sub.ID)
+		util.Logger(c).Errorf("failed to find parent contest or problem %s during score recalculation for submission %s", sub.ProblemID, sub.ID)
 		util.Success(c, sub, "Submission manually updated, but failed to trigger score recalculation: problem/contest definition not found.")
 		return
 	}
 
-	if err := database.RecalculateScoresForUserProblem(h.db, sub.UserID, sub.ProblemID, contest.ID, sub.ID, problem.Score.Mode, problem.Score.MaxPerformanceScore); err != nil {
+	if err := database.RecalculateScoresForUserProblem(h.db, sub.UserID, sub.ProblemID, contest.ID, sub.ID, problem.Score.Mode, problem.Score.MaxPerformanceScore, problem.Score.Weighted()); err != nil {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("submission manually updated, but failed to recalculate scores: %w", err))
 		return
 	}
@@ -246,61 +245,142 @@ func (h *Handler) updateSubmission(c *gin.Context) {
 	util.Success(c, sub, "Submission manually updated and scores recalculated successfully.")
 }
 
+// deleteSubmission moves a submission to the recycle bin. Its content is moved out of the
+// submission directory and its logs are kept, so it can be restored until it is purged. The
+// submitter's scores are recalculated without it.
 func (h *Handler) deleteSubmission(c *gin.Context) {
 	subID := c.Param("id")
-	// First, get submission to find its content path, if any.
 	sub, err := database.GetSubmission(h.db, subID)
 	if err != nil {
 		util.Error(c, http.StatusNotFound, "submission not found")
 		return
 	}
+	if sub.Status == models.StatusQueued || sub.Status == models.StatusRunning {
+		util.Error(c, http.StatusConflict, "Submission is still being judged, interrupt it first")
+		return
+	}
 
-	// Delete from DB. GORM's cascading delete will handle associated containers.
-	if err := h.db.Delete(&models.Submission{}, subID).Error; err != nil {
-		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to delete submission from database: %w", err))
+	moved, err := util.MoveContent(h.cfg.Storage.SubmissionContent, h.cfg.Storage.RecycleBinPath(), sub.ID)
+	if err != nil {
+		util.Logger(c).Errorf("failed to move content of submission %s to the recycle bin: %v", sub.ID, err)
+		util.Error(c, http.StatusInternalServerError, "failed to move submission content to the recycle bin")
 		return
 	}
+	if err := database.SoftDeleteSubmission(h.db, sub.ID); err != nil {
+		if moved {
+			if _, err := util.MoveContent(h.cfg.Storage.RecycleBinPath(), h.cfg.Storage.SubmissionContent, sub.ID); err != nil {
+				util.Logger(c).Errorf("failed to move content of submission %s back from the recycle bin: %v", sub.ID, err)
+			}
+		}
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to delete submission: %w", err))
+		return
+	}
+
+	purgeAt := time.Now().Add(h.cfg.Storage.RecycleBin.Retention())
+	util.Logger(c).Warnf("admin moved submission %s to the recycle bin", sub.ID)
+	h.audit(c, "submission.delete", sub.ID, gin.H{"user_id": sub.UserID, "problem_id": sub.ProblemID, "score": sub.Score})
 
-	// Delete submission content from disk.
-	submissionPath := filepath.Join(h.cfg.Storage.SubmissionContent, subID)
-	if err := os.RemoveAll(submissionPath); err != nil {
-		zap.S().Errorf("failed to delete submission content at %s: %v", submissionPath, err)
-		util.Error(c, http.StatusInternalServerError, "DB record deleted, but failed to delete submission content from disk")
+	if !h.recalculateSubmissionScores(c, sub, "submission moved to the recycle bin") {
 		return
 	}
-	zap.S().Warnf("admin deleted submission %s and its content", sub.ID)
-	util.Success(c, nil, "Submission and its content deleted successfully")
+	util.Success(c, gin.H{"purge_at": purgeAt}, "Submission moved to the recycle bin")
 }
 
-func (h *Handler) getContainerLog(c *gin.Context) {
+// recalculateSubmissionScores recalculates the submitter's scores on the problem of a submission
+// that was recycled or restored. Invalid submissions never count, so there is nothing to do for
+// them. On failure it writes the error response, prefixed with what has already been done, and
+// returns false.
+func (h *Handler) recalculateSubmissionScores(c *gin.Context, sub *models.Submission, done string) bool {
+	if !sub.IsValid {
+		return true
+	}
+	h.appState.RLock()
+	contest, ok := h.appState.ProblemToContestMap[sub.ProblemID]
+	problem, probOk := h.appState.Problems[sub.ProblemID]
+	h.appState.RUnlock()
+	if !ok || !probOk {
+		util.Logger(c).Errorf("failed to find parent contest or problem %s during score recalculation for submission %s", sub.ProblemID, sub.ID)
+		util.Error(c, http.StatusInternalServerError, fmt.Sprintf("%s, but failed to recalculate scores: problem/contest definition not found", done))
+		return false
+	}
+	if err := database.RecalculateScoresForUserProblem(h.db, sub.UserID, sub.ProblemID, contest.ID, sub.ID, problem.Score.Mode, problem.Score.MaxPerformanceScore, problem.Score.Weighted()); err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("%s, but failed to recalculate scores: %w", done, err))
+		return false
+	}
+	return true
+}
+
+// openContainerLog opens the stored log of the container named in the route, writing the
+// error response on failure.
+func (h *Handler) openContainerLog(c *gin.Context) (*os.File, bool) {
 	con, err := database.GetContainer(h.db, c.Param("conID"))
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			util.Error(c, http.StatusNotFound, "Container not found")
-			return
+			return nil, false
 		}
 		util.Error(c, http.StatusInternalServerError, err)
-		return
+		return nil, false
 	}
 
 	if con.LogFilePath == "" {
 		util.Error(c, http.StatusNotFound, "Log file path not recorded")
-		return
+		return nil, false
 	}
 
 	file, err := os.Open(con.LogFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			util.Error(c, http.StatusNotFound, "Log file not found on disk")
-			return
+			return nil, false
 		}
 		util.Error(c, http.StatusInternalServerError, "Failed to open log file")
+		return nil, false
+	}
+	return file, true
+}
+
+func (h *Handler) getContainerLog(c *gin.Context) {
+	stripANSI, err := pubsub.ParseANSIMode(c.Query("ansi"))
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	file, ok := h.openContainerLog(c)
+	if !ok {
 		return
 	}
 	defer file.Close()
 
 	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
-	io.Copy(c.Writer, file)
+	pubsub.CopyLog(c.Writer, file, stripANSI)
+}
+
+// getContainerLogJSON returns the stored log as an array of {stream, data, ts} entries,
+// with consecutive output of the same stream merged.
+func (h *Handler) getContainerLogJSON(c *gin.Context) {
+	stripANSI, err := pubsub.ParseANSIMode(c.Query("ansi"))
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	file, ok := h.openContainerLog(c)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	entries, err := pubsub.ParseLog(file)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to read log file: %w", err))
+		return
+	}
+	if stripANSI {
+		for i := range entries {
+			entries[i].Data = pubsub.StripANSI(entries[i].Data)
+		}
+	}
+	util.Success(c, entries, "Container log retrieved")
 }
 
 func (h *Handler) rejudgeSubmission(c *gin.Context) {
@@ -310,44 +390,101 @@ func (h *Handler) rejudgeSubmission(c *gin.Context) {
 		util.Error(c, http.StatusNotFound, "Original submission not found")
 		return
 	}
+	h.resubmit(c, originalSub, 0)
+}
 
-	if err := database.UpdateSubmissionValidity(h.db, originalSub.ID, false); err != nil {
-		util.Error(c, http.StatusInternalServerError, err)
+// rerunSubmission re-judges a submission starting at a given workflow step. Earlier steps are
+// skipped and /mnt/work is provisioned from the original submission content, so this is only
+// correct if the skipped steps produce nothing the remaining steps depend on.
+func (h *Handler) rerunSubmission(c *gin.Context) {
+	originalSub, err := database.GetSubmission(h.db, c.Param("id"))
+	if err != nil {
+		util.Error(c, http.StatusNotFound, "Original submission not found")
+		return
+	}
+	if originalSub.Status == models.StatusQueued || originalSub.Status == models.StatusRunning {
+		util.Error(c, http.StatusBadRequest, "Submission has not finished yet")
 		return
 	}
 
-	newSubID := uuid.NewString()
-	newSub := models.Submission{
-		ID:        newSubID,
-		ProblemID: originalSub.ProblemID,
-		UserID:    originalSub.UserID,
-		Status:    models.StatusQueued,
-		Cluster:   originalSub.Cluster,
-		IsValid:   true,
+	var req struct {
+		FromStep int `json:"from_step"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
 	}
 
-	srcDir := filepath.Join(h.cfg.Storage.SubmissionContent, originalSub.ID)
-	destDir := filepath.Join(h.cfg.Storage.SubmissionContent, newSubID)
-	if err := copyDir(srcDir, destDir); err != nil {
-		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to copy submission content: %w", err))
+	h.appState.RLock()
+	problem, ok := h.appState.Problems[originalSub.ProblemID]
+	h.appState.RUnlock()
+	if !ok {
+		util.Error(c, http.StatusInternalServerError, "Problem definition not found for rejudge")
 		return
 	}
+	if req.FromStep < 0 || req.FromStep >= len(problem.Workflow) {
+		util.Error(c, http.StatusBadRequest, fmt.Sprintf("from_step must be between 0 and %d", len(problem.Workflow)-1))
+		return
+	}
+	h.resubmit(c, originalSub, req.FromStep)
+}
 
-	if err := database.CreateSubmission(h.db, &newSub); err != nil {
+// resubmit invalidates the original submission and queues a copy of it, starting at startStep.
+func (h *Handler) resubmit(c *gin.Context, originalSub *models.Submission, startStep int) {
+	if originalSub.DryRun {
+		util.Error(c, http.StatusBadRequest, "dry run submissions cannot be rejudged")
+		return
+	}
+	newSubID, err := h.queueCopy(originalSub, startStep)
+	if err != nil {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
 
+	if startStep > 0 {
+		util.Logger(c).Infof("re-running submission %s from step %d as %s", originalSub.ID, startStep, newSubID)
+	}
+	h.audit(c, "submission.rejudge", originalSub.ID, gin.H{"new_submission_id": newSubID, "from_step": startStep})
+	util.Success(c, gin.H{"new_submission_id": newSubID}, "Rejudge successfully submitted")
+}
+
+// queueCopy invalidates a submission and queues a copy of it that starts at startStep,
+// returning the ID of the copy.
+func (h *Handler) queueCopy(originalSub *models.Submission, startStep int) (string, error) {
 	h.appState.RLock()
-	problem, ok := h.appState.Problems[newSub.ProblemID]
+	problem, ok := h.appState.Problems[originalSub.ProblemID]
 	h.appState.RUnlock()
 	if !ok {
-		util.Error(c, http.StatusInternalServerError, "Problem definition not found for rejudge")
-		return
+		return "", fmt.Errorf("problem definition not found for rejudge")
+	}
+	if err := database.UpdateSubmissionValidity(h.db, originalSub.ID, false); err != nil {
+		return "", err
 	}
-	h.scheduler.Submit(&newSub, problem)
 
-	util.Success(c, gin.H{"new_submission_id": newSubID}, "Rejudge successfully submitted")
+	newSubID := uuid.NewString()
+	newSub := models.Submission{
+		ID:          newSubID,
+		ProblemID:   originalSub.ProblemID,
+		UserID:      originalSub.UserID,
+		Status:      models.StatusQueued,
+		Cluster:     originalSub.Cluster,
+		IsValid:     !originalSub.Practice,
+		Practice:    originalSub.Practice,
+		ContentHash: originalSub.ContentHash,
+		CurrentStep: startStep,
+		StartStep:   startStep,
+		AdminQueued: true,
+	}
+
+	if err := copySubmissionContent(h.cfg.Storage.SubmissionContent, originalSub.ID, newSubID); err != nil {
+		return "", fmt.Errorf("failed to copy submission content: %w", err)
+	}
+
+	if err := database.CreateSubmission(h.db, &newSub); err != nil {
+		return "", err
+	}
+	h.scheduler.Submit(&newSub, problem)
+	return newSubID, nil
 }
 
 func (h *Handler) updateSubmissionValidity(c *gin.Context) {
@@ -366,12 +503,21 @@ func (h *Handler) updateSubmissionValidity(c *gin.Context) {
 		util.Error(c, http.StatusNotFound, err)
 		return
 	}
+	if sub.DryRun && reqBody.IsValid {
+		util.Error(c, http.StatusBadRequest, "dry run submissions cannot be marked as valid")
+		return
+	}
+	if sub.Practice && reqBody.IsValid {
+		util.Error(c, http.StatusBadRequest, "practice submissions cannot be marked as valid")
+		return
+	}
 
 	// First, apply the validity change to the submission
 	if err := database.UpdateSubmissionValidity(h.db, subID, reqBody.IsValid); err != nil {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
+	h.audit(c, "submission.validity", subID, gin.H{"user_id": sub.UserID, "problem_id": sub.ProblemID, "is_valid": reqBody.IsValid})
 
 	// Now, unconditionally trigger the score recalculation logic.
 	// Get contest and problem info needed for the recalculation function.
@@ -381,7 +527,7 @@ func (h *Handler) updateSubmissionValidity(c *gin.Context) {
 	h.appState.RUnlock()
 	if !ok || !probOk {
 		// This should not happen in a consistent system, but handle it
-		zap.S().Errorf("failed to find parent contest or problem %s during score recalculation for submission %s", sub.ProblemID, sub.ID)
+		util.Logger(c).Errorf("failed to find parent contest or problem %s during score recalculation for submission %s", sub.ProblemID, sub.ID)
 		// Even if we can't find the problem definition, we proceed to send a success message because the validity itself was updated.
 		// The error is logged for the admin to investigate.
 		util.Success(c, nil, "Submission validity updated, but failed to trigger score recalculation: problem/contest definition not found.")
@@ -389,7 +535,7 @@ func (h *Handler) updateSubmissionValidity(c *gin.Context) {
 	}
 
 	// Trigger the comprehensive recalculation logic
-	if err := database.RecalculateScoresForUserProblem(h.db, sub.UserID, sub.ProblemID, contest.ID, sub.ID, problem.Score.Mode, problem.Score.MaxPerformanceScore); err != nil {
+	if err := database.RecalculateScoresForUserProblem(h.db, sub.UserID, sub.ProblemID, contest.ID, sub.ID, problem.Score.Mode, problem.Score.MaxPerformanceScore, problem.Score.Weighted()); err != nil {
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("submission validity updated, but failed to recalculate scores: %w", err))
 		return
 	}
@@ -397,6 +543,164 @@ func (h *Handler) updateSubmissionValidity(c *gin.Context) {
 	util.Success(c, nil, "Submission validity updated and scores recalculated successfully.")
 }
 
+// previewSubmissionValidity reports how a validity change would affect scores and ranks,
+// without persisting anything.
+func (h *Handler) previewSubmissionValidity(c *gin.Context) {
+	var reqBody struct {
+		IsValid bool `json:"is_valid"`
+	}
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	sub, err := database.GetSubmission(h.db, c.Param("id"))
+	if err != nil {
+		util.Error(c, http.StatusNotFound, err)
+		return
+	}
+	if sub.DryRun && reqBody.IsValid {
+		util.Error(c, http.StatusBadRequest, "dry run submissions cannot be marked as valid")
+		return
+	}
+	if sub.Practice && reqBody.IsValid {
+		util.Error(c, http.StatusBadRequest, "practice submissions cannot be marked as valid")
+		return
+	}
+
+	h.appState.RLock()
+	contest, ok := h.appState.ProblemToContestMap[sub.ProblemID]
+	problem, probOk := h.appState.Problems[sub.ProblemID]
+	h.appState.RUnlock()
+	if !ok || !probOk {
+		util.Error(c, http.StatusNotFound, "problem or contest definition not found")
+		return
+	}
+
+	preview, err := database.PreviewValidityChange(h.db, sub, contest.ID, reqBody.IsValid, problem.Score.Mode, problem.Score.MaxPerformanceScore, problem.Score.Weighted(), contest.LeaderboardScoring())
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to preview validity change: %w", err))
+		return
+	}
+	util.Success(c, preview, "Validity change previewed, nothing was saved")
+}
+
+// markSubmissionActive pins a submission as the one that counts for its user on the problem, e.g.
+// the one a student declared final, instead of their best one.
+func (h *Handler) markSubmissionActive(c *gin.Context) {
+	sub, err := database.GetSubmission(h.db, c.Param("id"))
+	if err != nil {
+		util.Error(c, http.StatusNotFound, err)
+		return
+	}
+	if sub.Status == models.StatusQueued || sub.Status == models.StatusRunning {
+		util.Error(c, http.StatusConflict, "Submission is still being judged")
+		return
+	}
+	if !sub.IsValid {
+		util.Error(c, http.StatusBadRequest, "only valid submissions can count for scoring")
+		return
+	}
+
+	h.appState.RLock()
+	contest, ok := h.appState.ProblemToContestMap[sub.ProblemID]
+	problem, probOk := h.appState.Problems[sub.ProblemID]
+	h.appState.RUnlock()
+	if !ok || !probOk {
+		util.Error(c, http.StatusNotFound, "problem or contest definition not found")
+		return
+	}
+
+	if err := database.PinScoringSubmission(h.db, sub, contest.ID, problem.Score.Mode, problem.Score.MaxPerformanceScore, problem.Score.Weighted()); err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to mark submission as active: %w", err))
+		return
+	}
+	h.audit(c, "submission.mark_active", sub.ID, gin.H{"user_id": sub.UserID, "problem_id": sub.ProblemID})
+	util.Success(c, nil, "Submission marked as active and scores recalculated")
+}
+
+// unmarkSubmissionActive clears the pin set by markSubmissionActive, so the user's best valid
+// submission counts again.
+func (h *Handler) unmarkSubmissionActive(c *gin.Context) {
+	sub, err := database.GetSubmission(h.db, c.Param("id"))
+	if err != nil {
+		util.Error(c, http.StatusNotFound, err)
+		return
+	}
+
+	h.appState.RLock()
+	contest, ok := h.appState.ProblemToContestMap[sub.ProblemID]
+	problem, probOk := h.appState.Problems[sub.ProblemID]
+	h.appState.RUnlock()
+	if !ok || !probOk {
+		util.Error(c, http.StatusNotFound, "problem or contest definition not found")
+		return
+	}
+
+	unpinned, err := database.UnpinScoringSubmission(h.db, sub, contest.ID, problem.Score.Mode, problem.Score.MaxPerformanceScore, problem.Score.Weighted())
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to clear the active submission: %w", err))
+		return
+	}
+	if !unpinned {
+		util.Error(c, http.StatusConflict, "Submission is not marked as active")
+		return
+	}
+	h.audit(c, "submission.unmark_active", sub.ID, gin.H{"user_id": sub.UserID, "problem_id": sub.ProblemID})
+	util.Success(c, nil, "Active submission cleared and scores recalculated")
+}
+
+// skippedRequeue is a queued submission that requeueStuckSubmissions could not queue.
+type skippedRequeue struct {
+	SubmissionID string `json:"submission_id"`
+	ProblemID    string `json:"problem_id"`
+	Reason       string `json:"reason"`
+}
+
+type requeueResult struct {
+	Requeued      []string         `json:"requeued"`
+	AlreadyQueued int              `json:"already_queued"`
+	Skipped       []skippedRequeue `json:"skipped"`
+}
+
+// requeueStuckSubmissions puts every submission in the Queued status that is missing from the
+// scheduler's queues back in line. Submissions that are still queued are left alone, so it is
+// safe to run repeatedly.
+func (h *Handler) requeueStuckSubmissions(c *gin.Context) {
+	subs, err := database.GetQueuedSubmissions(h.db)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	result := requeueResult{Requeued: []string{}, Skipped: []skippedRequeue{}}
+	h.appState.RLock()
+	for i := range subs {
+		sub := &subs[i]
+		problem, ok := h.appState.Problems[sub.ProblemID]
+		if !ok {
+			result.Skipped = append(result.Skipped, skippedRequeue{SubmissionID: sub.ID, ProblemID: sub.ProblemID, Reason: "problem not found"})
+			continue
+		}
+		added, err := h.scheduler.Requeue(sub, problem)
+		switch {
+		case err != nil:
+			result.Skipped = append(result.Skipped, skippedRequeue{SubmissionID: sub.ID, ProblemID: sub.ProblemID, Reason: err.Error()})
+		case added:
+			result.Requeued = append(result.Requeued, sub.ID)
+		default:
+			result.AlreadyQueued++
+		}
+	}
+	h.appState.RUnlock()
+
+	util.Logger(c).Infof("admin requeued %d stuck submissions, %d already queued, %d skipped", len(result.Requeued), result.AlreadyQueued, len(result.Skipped))
+	if len(result.Requeued) > 0 {
+		h.audit(c, "submission.requeue_stuck", "", gin.H{"requeued": result.Requeued, "skipped": len(result.Skipped)})
+	}
+	util.Success(c, result, "Stuck submissions requeued")
+}
+
 func (h *Handler) interruptSubmission(c *gin.Context) {
 	subID := c.Param("id")
 	sub, err := database.GetSubmission(h.db, subID)
@@ -420,78 +724,27 @@ func (h *Handler) interruptSubmission(c *gin.Context) {
 		msg := pubsub.FormatMessage("error", "Submission interrupted by admin.")
 		pubsub.GetBroker().Publish(sub.ID, msg)
 		pubsub.GetBroker().CloseTopic(sub.ID)
+		h.scheduler.RunPostSubmissionHook(sub)
+		h.audit(c, "submission.interrupt", sub.ID, gin.H{"status": models.StatusQueued})
 		util.Success(c, nil, "Queued submission interrupted")
 
 	case models.StatusRunning:
-		h.appState.RLock()
-		problem, ok := h.appState.Problems[sub.ProblemID]
-		h.appState.RUnlock()
-		if !ok {
-			util.Error(c, http.StatusInternalServerError, "Problem definition not found for running submission")
-			return
-		}
-
-		var dockerCfg config.DockerConfig
-		var nodeCfgFound bool
-		for _, clusterCfg := range h.cfg.Cluster {
-			if clusterCfg.Name == sub.Cluster {
-				for _, nodeCfg := range clusterCfg.Nodes {
-					if nodeCfg.Name == sub.Node {
-						dockerCfg = nodeCfg.Docker
-						nodeCfgFound = true
-						break
-					}
-				}
-				break
-			}
-		}
-
-		if !nodeCfgFound {
-			zap.S().Errorf("node config '%s'/'%s' not found for sub %s, cannot stop container but will mark as failed", sub.Cluster, sub.Node, sub.ID)
+		var docker *judger.DockerManager
+		if dockerCfg, ok := h.nodeDockerConfig(sub.Cluster, sub.Node); !ok {
+			util.Logger(c).Errorf("node config '%s'/'%s' not found for sub %s, cannot stop container but will mark as failed", sub.Cluster, sub.Node, sub.ID)
 		} else {
-			docker, err := judger.NewDockerManager(dockerCfg)
+			docker, err = judger.GetDockerManager(dockerCfg)
 			if err != nil {
 				util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to connect to docker on node %s: %w", sub.Node, err))
 				return
 			}
-			for _, container := range sub.Containers {
-				if container.DockerID != "" {
-					zap.S().Infof("forcefully cleaning up container %s for submission %s", container.DockerID, sub.ID)
-					docker.CleanupContainer(container.DockerID)
-				}
-			}
 		}
 
-		err := h.db.Transaction(func(tx *gorm.DB) error {
-			if err := tx.Model(&models.Submission{}).Where("id = ?", subID).Updates(map[string]interface{}{
-				"status": models.StatusFailed,
-				"info":   models.JSONMap{"error": "Interrupted by admin while running"},
-			}).Error; err != nil {
-				return err
-			}
-			return tx.Model(&models.Container{}).Where("submission_id = ? AND status = ?", subID, models.StatusRunning).Update("status", models.StatusFailed).Error
-		})
-		if err != nil {
+		if err := h.stopRunningSubmission(c, docker, sub, "Interrupted by admin while running"); err != nil {
 			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to update database: %w", err))
 			return
 		}
-
-		// Parse allocated cores from submission record to release them
-		var coresToRelease []int
-		if sub.AllocatedCores != "" {
-			coreStrs := strings.Split(sub.AllocatedCores, ",")
-			for _, s := range coreStrs {
-				coreID, err := strconv.Atoi(s)
-				if err == nil {
-					coresToRelease = append(coresToRelease, coreID)
-				}
-			}
-		}
-		h.scheduler.ReleaseResources(problem.Cluster, sub.Node, coresToRelease, problem.Memory)
-
-		msg := pubsub.FormatMessage("error", "Submission interrupted by admin.")
-		pubsub.GetBroker().Publish(sub.ID, msg)
-		pubsub.GetBroker().CloseTopic(sub.ID)
+		h.audit(c, "submission.interrupt", sub.ID, gin.H{"status": models.StatusRunning, "node": sub.Node})
 		util.Success(c, nil, "Running submission interrupted successfully")
 
 	case models.StatusSuccess, models.StatusFailed:
@@ -501,3 +754,41 @@ func (h *Handler) interruptSubmission(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, fmt.Sprintf("Unknown submission status: %s", sub.Status))
 	}
 }
+
+// stopRunningSubmission removes the containers of a running submission, marks it and its running
+// containers as failed with the given reason, releases its resources and starts the
+// post-submission hook. With a nil docker the containers are left alone.
+func (h *Handler) stopRunningSubmission(c *gin.Context, docker *judger.DockerManager, sub *models.Submission, reason string) error {
+	if docker != nil {
+		for _, container := range sub.Containers {
+			if container.DockerID != "" {
+				util.Logger(c).Infof("forcefully cleaning up container %s for submission %s", container.DockerID, sub.ID)
+				docker.CleanupContainer(container.DockerID)
+			}
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Submission{}).Where("id = ?", sub.ID).Updates(map[string]interface{}{
+			"status": models.StatusFailed,
+			"info":   models.JSONMap{"error": reason},
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Container{}).Where("submission_id = ? AND status = ?", sub.ID, models.StatusRunning).Update("status", models.StatusFailed).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	h.scheduler.ReleaseResources(sub.ID)
+
+	msg := pubsub.FormatMessage("error", "Submission interrupted by admin.")
+	pubsub.GetBroker().Publish(sub.ID, msg)
+	pubsub.GetBroker().CloseTopic(sub.ID)
+
+	sub.Status = models.StatusFailed
+	sub.Info = models.JSONMap{"error": reason}
+	h.scheduler.RunPostSubmissionHook(sub)
+	return nil
+}
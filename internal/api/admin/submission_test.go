@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetAllSubmissionsCombinedFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := database.Init(database.DriverSQLite, filepath.Join(t.TempDir(), "csoj.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	for _, u := range []models.User{
+		{ID: "u-alice", Username: "alice", Nickname: "Alice"},
+		{ID: "u-bob", Username: "bob", Nickname: "Bob"},
+	} {
+		if err := db.Create(&u).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 12, 0, 0, 0, time.UTC) }
+	for _, s := range []models.Submission{
+		{ID: "s1", UserID: "u-alice", ProblemID: "p1", Status: models.StatusSuccess, Score: 100, IsValid: true, CreatedAt: day(1)},
+		{ID: "s2", UserID: "u-alice", ProblemID: "p1", Status: models.StatusSuccess, Score: 40, IsValid: true, CreatedAt: day(2)},
+		{ID: "s3", UserID: "u-alice", ProblemID: "p1", Status: models.StatusSuccess, Score: 80, IsValid: false, CreatedAt: day(3)},
+		{ID: "s4", UserID: "u-alice", ProblemID: "p2", Status: models.StatusSuccess, Score: 90, IsValid: true, CreatedAt: day(3)},
+		{ID: "s5", UserID: "u-bob", ProblemID: "p1", Status: models.StatusSuccess, Score: 95, IsValid: true, CreatedAt: day(3)},
+		{ID: "s6", UserID: "u-alice", ProblemID: "p1", Status: models.StatusFailed, Score: 0, IsValid: true, CreatedAt: day(4)},
+		{ID: "s7", UserID: "u-alice", ProblemID: "p1", Status: models.StatusSuccess, Score: 70, IsValid: true, CreatedAt: day(5)},
+	} {
+		if err := db.Omit("User").Create(&s).Error; err != nil {
+			t.Fatalf("create submission: %v", err)
+		}
+	}
+	h := &Handler{db: db}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"no filters", "", []string{"s1", "s2", "s3", "s4", "s5", "s6", "s7"}},
+		{"user and problem", "user_query=alice&problem_id=p1", []string{"s1", "s2", "s3", "s6", "s7"}},
+		{"score range and validity", "score_min=50&score_max=95&is_valid=true", []string{"s4", "s5", "s7"}},
+		{"date range and status", "created_after=2025-03-02T00:00:00Z&created_before=2025-03-05T00:00:00Z&status=Success", []string{"s2", "s3", "s4", "s5"}},
+		{"every filter", "user_query=Alice&problem_id=p1&status=Success&score_min=50&is_valid=true&created_after=2025-03-02T00:00:00Z", []string{"s7"}},
+		{"disjoint filters", "user_query=bob&problem_id=p2", []string{}},
+		{"invalid and low score", "is_valid=false&score_max=50", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/submissions?limit=100&"+tt.query, nil)
+			h.getAllSubmissions(c)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var resp struct {
+				Data struct {
+					Items []struct {
+						ID string `json:"id"`
+					} `json:"items"`
+					TotalItems int `json:"total_items"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			got := make([]string, len(resp.Data.Items))
+			for i, item := range resp.Data.Items {
+				got[i] = item.ID
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if resp.Data.TotalItems != len(tt.want) {
+				t.Errorf("total_items = %d, want %d", resp.Data.TotalItems, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestGetAllSubmissionsRejectsInvalidFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := database.Init(database.DriverSQLite, filepath.Join(t.TempDir(), "csoj.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	h := &Handler{db: db}
+	for _, query := range []string{"score_min=high", "created_after=yesterday", "is_valid=maybe"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/submissions?"+query, nil)
+		h.getAllSubmissions(c)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, w.Code)
+		}
+	}
+}
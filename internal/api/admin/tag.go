@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var tagNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func (h *Handler) getTags(c *gin.Context) {
+	usage, err := database.GetTagUsage(h.db)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	util.Success(c, usage, "Tags retrieved successfully")
+}
+
+func (h *Handler) createTag(c *gin.Context) {
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	if !tagNamePattern.MatchString(req.Name) {
+		util.Error(c, http.StatusBadRequest, "tag names may only contain letters, digits, '.', '_' and '-'")
+		return
+	}
+
+	tag := models.Tag{Name: req.Name, Description: req.Description}
+	var existing models.Tag
+	if err := h.db.First(&existing, "name = ?", tag.Name).Error; err == nil {
+		util.Error(c, http.StatusConflict, "tag already exists")
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := database.CreateTag(h.db, &tag); err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	h.audit(c, "tag.create", tag.Name, nil)
+	util.Success(c, tag, "Tag created successfully")
+}
+
+func (h *Handler) deleteTag(c *gin.Context) {
+	name := c.Param("name")
+	var count int64
+	if err := database.WhereHasTag(h.db.Model(&models.User{}), name).Count(&count).Error; err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	if count > 0 {
+		util.Error(c, http.StatusConflict, "tag is still assigned to users")
+		return
+	}
+	if err := database.DeleteTag(h.db, name); err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	h.audit(c, "tag.delete", name, nil)
+	util.Success(c, nil, "Tag deleted successfully")
+}
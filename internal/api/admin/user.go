@@ -2,15 +2,12 @@ package admin
 
 import (
 	"archive/zip"
-	"bytes"
 	"errors"
 	"fmt"
-	"io"
-	"io/fs"
+	"math"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/ZJUSCT/CSOJ/internal/auth"
@@ -19,7 +16,6 @@ import (
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
@@ -91,7 +87,12 @@ func (h *Handler) updateUser(c *gin.Context) {
 		user.DisableRank = *reqBody.DisableRank
 	}
 	if reqBody.Tags != nil {
-		user.Tags = *reqBody.Tags // Store as comma-separated string
+		tags, err := database.NormalizeTags(h.db, *reqBody.Tags)
+		if err != nil {
+			util.Error(c, http.StatusBadRequest, err)
+			return
+		}
+		user.Tags = tags // Store as comma-separated string
 	}
 
 	// Handle ban logic
@@ -121,6 +122,7 @@ func (h *Handler) updateUser(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
+	h.audit(c, "user.update", user.ID, gin.H{"username": user.Username, "changes": reqBody})
 	util.Success(c, user, "User profile updated successfully")
 }
 
@@ -131,11 +133,19 @@ func (h *Handler) createUser(c *gin.Context) {
 		return
 	}
 
+	tags, err := database.NormalizeTags(h.db, user.Tags)
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	user.Tags = tags
+
 	user.ID = uuid.NewString()
 	if err := database.CreateUser(h.db, &user); err != nil {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
+	h.audit(c, "user.create", user.ID, gin.H{"username": user.Username})
 	util.Success(c, user, "User created successfully")
 }
 
@@ -145,6 +155,7 @@ func (h *Handler) deleteUser(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
+	h.audit(c, "user.delete", userID, nil)
 	util.Success(c, nil, "User deleted successfully")
 }
 
@@ -178,6 +189,55 @@ func (h *Handler) getUserContestHistory(c *gin.Context) {
 	util.Success(c, history, "User score history retrieved successfully")
 }
 
+// getUserTimeline lists everything a user did across contests, newest first and paginated:
+// submissions with the node they ran on, registrations, score changes and bans.
+func (h *Handler) getUserTimeline(c *gin.Context) {
+	userID := c.Param("id")
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	if _, err := database.GetUserByID(h.db, userID); err != nil {
+		util.Error(c, http.StatusNotFound, "user not found")
+		return
+	}
+	events, err := database.GetUserTimeline(h.db, userID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	totalItems := len(events)
+	start := min((page-1)*limit, totalItems)
+	events = events[start:min(start+limit, totalItems)]
+
+	h.appState.RLock()
+	for i := range events {
+		if events[i].Type == database.TimelineSubmission {
+			if contest, ok := h.appState.ProblemToContestMap[events[i].ProblemID]; ok {
+				events[i].ContestID = contest.ID
+			}
+		}
+	}
+	h.appState.RUnlock()
+
+	util.Success(c, gin.H{
+		"items":        events,
+		"total_items":  totalItems,
+		"total_pages":  int(math.Ceil(float64(totalItems) / float64(limit))),
+		"current_page": page,
+		"per_page":     limit,
+	}, "User timeline retrieved successfully")
+}
+
 func (h *Handler) resetUserPassword(c *gin.Context) {
 	userID := c.Param("id")
 	user, err := database.GetUserByID(h.db, userID)
@@ -186,8 +246,8 @@ func (h *Handler) resetUserPassword(c *gin.Context) {
 		return
 	}
 
-	if user.GitLabID != nil {
-		util.Error(c, http.StatusBadRequest, "cannot reset password for GitLab user")
+	if user.OIDCProvider != nil {
+		util.Error(c, http.StatusBadRequest, "cannot reset password for OIDC user")
 		return
 	}
 
@@ -211,10 +271,48 @@ func (h *Handler) resetUserPassword(c *gin.Context) {
 		return
 	}
 
-	zap.S().Warnf("admin reset password for user %s (%s)", user.Username, user.ID)
+	util.Logger(c).Warnf("admin reset password for user %s (%s)", user.Username, user.ID)
+	h.audit(c, "user.reset_password", user.ID, gin.H{"username": user.Username})
 	util.Success(c, nil, "User password reset successfully")
 }
 
+// createImpersonationToken mints a short-lived, read-only token to view the user API as the user.
+func (h *Handler) createImpersonationToken(c *gin.Context) {
+	userID := c.Param("id")
+	user, err := database.GetUserByID(h.db, userID)
+	if err != nil {
+		util.Error(c, http.StatusNotFound, "user not found")
+		return
+	}
+
+	var req struct {
+		TTLMinutes int `json:"ttl_minutes"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			util.Error(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if req.TTLMinutes <= 0 {
+		req.TTLMinutes = 15
+	}
+	if req.TTLMinutes > 60 {
+		util.Error(c, http.StatusBadRequest, "ttl_minutes must not exceed 60")
+		return
+	}
+
+	token, expiresAt, err := auth.GenerateImpersonationJWT(user.ID, h.cfg.Auth.JWT.Secret, time.Duration(req.TTLMinutes)*time.Minute)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+
+	util.Logger(c).Warnf("admin issued impersonation token for user %s (%s), valid until %s", user.Username, user.ID, expiresAt.Format(time.RFC3339))
+	h.audit(c, "user.impersonate", user.ID, gin.H{"username": user.Username, "expires_at": expiresAt})
+	util.Success(c, gin.H{"token": token, "expires_at": expiresAt}, "Impersonation token created")
+}
+
 func (h *Handler) registerUserForContest(c *gin.Context) {
 	userID := c.Param("id")
 	var req struct {
@@ -246,7 +344,8 @@ func (h *Handler) registerUserForContest(c *gin.Context) {
 		return
 	}
 
-	zap.S().Infof("admin registered user %s for contest %s", userID, req.ContestID)
+	util.Logger(c).Infof("admin registered user %s for contest %s", userID, req.ContestID)
+	h.audit(c, "user.register_contest", userID, gin.H{"contest_id": req.ContestID})
 	util.Success(c, nil, "Successfully registered user for contest")
 }
 
@@ -266,6 +365,56 @@ func (h *Handler) getUserScores(c *gin.Context) {
 	util.Success(c, scores, "User best scores retrieved successfully")
 }
 
+func (h *Handler) setUserScore(c *gin.Context) {
+	userID := c.Param("id")
+	var req struct {
+		ContestID   string   `json:"contest_id" binding:"required"`
+		ProblemID   string   `json:"problem_id" binding:"required"`
+		Score       *int     `json:"score"`
+		Performance *float64 `json:"performance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.Score == nil && req.Performance == nil {
+		util.Error(c, http.StatusBadRequest, "either score or performance must be provided")
+		return
+	}
+
+	if _, err := database.GetUserByID(h.db, userID); err != nil {
+		util.Error(c, http.StatusNotFound, "user not found")
+		return
+	}
+
+	h.appState.RLock()
+	problem, ok := h.appState.Problems[req.ProblemID]
+	contest, contestOk := h.appState.ProblemToContestMap[req.ProblemID]
+	h.appState.RUnlock()
+	if !ok {
+		util.Error(c, http.StatusNotFound, "problem not found")
+		return
+	}
+	if !contestOk || contest.ID != req.ContestID {
+		util.Error(c, http.StatusBadRequest, "problem does not belong to this contest")
+		return
+	}
+	if problem.Score.Mode == "performance" && req.Score != nil && req.Performance != nil {
+		util.Error(c, http.StatusBadRequest, database.ErrScoreWithPerformance)
+		return
+	}
+
+	err := database.SetUserProblemScore(h.db, userID, req.ContestID, req.ProblemID, req.Score, req.Performance, problem.Score.Mode, problem.Score.MaxPerformanceScore, problem.Score.Weighted())
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to set user score: %w", err))
+		return
+	}
+
+	util.Logger(c).Warnf("admin manually set score for user %s on problem %s", userID, req.ProblemID)
+	h.audit(c, "score.set", userID, gin.H{"contest_id": req.ContestID, "problem_id": req.ProblemID, "score": req.Score, "performance": req.Performance})
+	util.Success(c, nil, "User score updated successfully")
+}
+
 func (h *Handler) handleDownloadSolutions(c *gin.Context) {
 	userID := c.Param("id")
 	contestID := c.Param("contest_id")
@@ -303,7 +452,7 @@ func (h *Handler) handleDownloadSolutions(c *gin.Context) {
 	for i, problemID := range problemIDs {
 		problem, probOk := h.appState.Problems[problemID]
 		if !probOk {
-			zap.S().Warnf("Problem %s in contest %s not found in appState, skipping", problemID, contestID)
+			util.Logger(c).Warnf("Problem %s in contest %s not found in appState, skipping", problemID, contestID)
 			continue
 		}
 
@@ -339,75 +488,37 @@ func (h *Handler) handleDownloadSolutions(c *gin.Context) {
 		return
 	}
 
-	buf := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(buf)
+	fullFileName := fmt.Sprintf("%s-%s-%s.zip", user.Nickname, user.Username, contestID)
+	encodedFileName := url.PathEscape(fullFileName)
+	disposition := fmt.Sprintf("attachment; filename*=UTF-8''%s", encodedFileName)
+
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", disposition)
+	c.Status(http.StatusOK)
 
+	// The archive is streamed as it is built, so errors past this point can only be logged.
+	zipWriter := zip.NewWriter(c.Writer)
 	for _, bestSub := range bestSubmissions {
 		subID := bestSub.Submission.ID
-		submissionPath := filepath.Join(h.cfg.Storage.SubmissionContent, subID)
-
-		info, err := os.Stat(submissionPath)
-		if os.IsNotExist(err) || !info.IsDir() {
-			zap.S().Warnf("Submission content for %s not found on disk at %s, skipping", subID, submissionPath)
+		submissionPath, cleanup, err := util.OpenContent(h.cfg.Storage.SubmissionContent, subID)
+		if err != nil {
+			util.Logger(c).Warnf("Submission content for %s not available in %s, skipping: %v", subID, h.cfg.Storage.SubmissionContent, err)
 			continue
 		}
 
 		zipFolderName := fmt.Sprintf("%d-%s-%s", bestSub.ProblemIdx, bestSub.ProblemID, subID)
-
-		err = filepath.Walk(submissionPath, func(path string, info fs.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if info.IsDir() {
-				return nil
-			}
-
-			header, err := zip.FileInfoHeader(info)
-			if err != nil {
-				return err
-			}
-
-			relPath, err := filepath.Rel(submissionPath, path)
-			if err != nil {
-				return err
-			}
-
-			header.Name = filepath.Join(zipFolderName, relPath)
-			header.Name = filepath.ToSlash(header.Name)
-			header.Method = zip.Deflate
-
-			writer, err := zipWriter.CreateHeader(header)
-			if err != nil {
-				return err
-			}
-
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(writer, file)
-			return err
-		})
-
+		err = util.AddDirToZip(zipWriter, submissionPath, zipFolderName)
+		cleanup()
 		if err != nil {
-			zipWriter.Close()
-			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to add submission %s to zip: %w", subID, err))
+			util.Logger(c).Errorf("failed to add submission %s to solutions zip of user %s: %v", subID, userID, err)
+			c.Abort()
 			return
 		}
 	}
 
 	if err := zipWriter.Close(); err != nil {
-		util.Error(c, http.StatusInternalServerError, "failed to finalize zip archive")
-		return
+		util.Logger(c).Errorf("failed to finalize solutions zip of user %s: %v", userID, err)
+		c.Abort()
 	}
-
-	fullFileName := fmt.Sprintf("%s-%s-%s.zip", user.Nickname, user.Username, contestID)
-	encodedFileName := url.PathEscape(fullFileName)
-	disposition := fmt.Sprintf("attachment; filename*=UTF-8''%s", encodedFileName)
-
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Type", "application/zip")
-	c.Header("Content-Disposition", disposition)
-	c.Data(http.StatusOK, "application/zip", buf.Bytes())
 }
@@ -0,0 +1,254 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/ZJUSCT/CSOJ/internal/auth"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxUserImportSize = 10 << 20
+
+type importedUser struct {
+	Line     int    `json:"line"`
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"` // only set for generated passwords
+}
+
+type importIssue struct {
+	Line     int    `json:"line"`
+	Username string `json:"username,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+type userImportRow struct {
+	line              int
+	user              models.User
+	password          string
+	generatedPassword bool
+}
+
+// importUsers creates users from a CSV with the columns username, nickname, password, tags.
+// The password and tags columns are optional; a header row is detected and skipped.
+// Rows with existing usernames are skipped, invalid rows are reported as errors.
+func (h *Handler) importUsers(c *gin.Context) {
+	var reader io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			util.Error(c, http.StatusBadRequest, "a CSV file is required in the 'file' field")
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			util.Error(c, http.StatusInternalServerError, err)
+			return
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	csvReader := csv.NewReader(io.LimitReader(reader, maxUserImportSize))
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, fmt.Errorf("invalid CSV: %w", err))
+		return
+	}
+
+	var rows []*userImportRow
+	var skipped, errored []importIssue
+	seen := make(map[string]bool)
+	for i, record := range records {
+		line := i + 1
+		if i == 0 && len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "username") {
+			continue
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue // blank line
+		}
+		if len(record) > 4 {
+			errored = append(errored, importIssue{Line: line, Reason: "too many columns"})
+			continue
+		}
+
+		field := func(n int) string {
+			if n < len(record) {
+				return strings.TrimSpace(record[n])
+			}
+			return ""
+		}
+		username := field(0)
+		if username == "" {
+			errored = append(errored, importIssue{Line: line, Reason: "username is empty"})
+			continue
+		}
+		if seen[username] {
+			skipped = append(skipped, importIssue{Line: line, Username: username, Reason: "duplicate username in file"})
+			continue
+		}
+
+		row := &userImportRow{
+			line:     line,
+			password: field(2),
+			user: models.User{
+				ID:       uuid.NewString(),
+				Username: username,
+				Nickname: field(1),
+			},
+		}
+		tags, err := database.NormalizeTags(h.db, normalizeImportTags(field(3)))
+		if err != nil {
+			errored = append(errored, importIssue{Line: line, Username: username, Reason: err.Error()})
+			continue
+		}
+		row.user.Tags = tags
+		seen[username] = true
+		if row.user.Nickname == "" {
+			row.user.Nickname = username
+		}
+		if row.password == "" {
+			if row.password, err = generatePassword(12); err != nil {
+				util.Error(c, http.StatusInternalServerError, "failed to generate password")
+				return
+			}
+			row.generatedPassword = true
+		}
+		rows = append(rows, row)
+	}
+
+	// Skip usernames that already exist, including soft-deleted users.
+	if len(rows) > 0 {
+		usernames := make([]string, 0, len(rows))
+		for _, row := range rows {
+			usernames = append(usernames, row.user.Username)
+		}
+		var existing []string
+		if err := h.db.Unscoped().Model(&models.User{}).Where("username IN ?", usernames).Pluck("username", &existing).Error; err != nil {
+			util.Error(c, http.StatusInternalServerError, err)
+			return
+		}
+		taken := make(map[string]bool, len(existing))
+		for _, name := range existing {
+			taken[name] = true
+		}
+		kept := rows[:0]
+		for _, row := range rows {
+			if taken[row.user.Username] {
+				skipped = append(skipped, importIssue{Line: row.line, Username: row.user.Username, Reason: "username already exists"})
+				continue
+			}
+			kept = append(kept, row)
+		}
+		rows = kept
+	}
+
+	// bcrypt is deliberately slow, so hash in parallel before opening the transaction.
+	if err := hashImportPasswords(rows); err != nil {
+		util.Error(c, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+
+	created := make([]importedUser, 0, len(rows))
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			if err := database.CreateUser(tx, &row.user); err != nil {
+				return fmt.Errorf("line %d (%s): %w", row.line, row.user.Username, err)
+			}
+			imported := importedUser{Line: row.line, ID: row.user.ID, Username: row.user.Username}
+			if row.generatedPassword {
+				imported.Password = row.password
+			}
+			created = append(created, imported)
+		}
+		return nil
+	})
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("import aborted, no users were created: %w", err))
+		return
+	}
+
+	util.Logger(c).Infof("imported %d users (%d skipped, %d errors)", len(created), len(skipped), len(errored))
+	if len(created) > 0 {
+		usernames := make([]string, 0, len(created))
+		for _, u := range created {
+			usernames = append(usernames, u.Username)
+		}
+		h.audit(c, "user.import", "", gin.H{"created": usernames, "skipped": len(skipped), "errors": len(errored)})
+	}
+	util.Success(c, gin.H{
+		"created": created,
+		"skipped": nonNilIssues(skipped),
+		"errors":  nonNilIssues(errored),
+	}, fmt.Sprintf("Imported %d users, skipped %d, %d errors", len(created), len(skipped), len(errored)))
+}
+
+func hashImportPasswords(rows []*userImportRow) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	work := make(chan *userImportRow)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range work {
+				hash, err := auth.HashPassword(row.password)
+				if err != nil {
+					mu.Lock()
+					firstErr = errors.Join(firstErr, err)
+					mu.Unlock()
+					continue
+				}
+				row.user.PasswordHash = hash
+			}
+		}()
+	}
+	for _, row := range rows {
+		work <- row
+	}
+	close(work)
+	wg.Wait()
+	return firstErr
+}
+
+// normalizeImportTags accepts tags separated by semicolons as well as commas.
+func normalizeImportTags(raw string) string {
+	return strings.ReplaceAll(raw, ";", ",")
+}
+
+func generatePassword(length int) (string, error) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+func nonNilIssues(issues []importIssue) []importIssue {
+	if issues == nil {
+		return []importIssue{}
+	}
+	return issues
+}
@@ -4,8 +4,23 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/ZJUSCT/CSOJ/internal/util"
 )
 
+// copySubmissionContent copies the content of one submission to another, keeping the form,
+// directory or compressed archive, it is stored in.
+func copySubmissionContent(root, srcID, dstID string) error {
+	srcDir, srcArchive := util.ContentPaths(root, srcID)
+	dstDir, dstArchive := util.ContentPaths(root, dstID)
+	if _, err := os.Stat(srcDir); os.IsNotExist(err) {
+		if _, err := os.Stat(srcArchive); err == nil {
+			return copyFile(srcArchive, dstArchive)
+		}
+	}
+	return copyDir(srcDir, dstDir)
+}
+
 func copyDir(src, dst string) error {
 	if err := os.MkdirAll(dst, 0755); err != nil {
 		return err
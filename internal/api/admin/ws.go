@@ -5,26 +5,29 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/ZJUSCT/CSOJ/internal/api"
 	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/database/models"
 	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
-var adminUpgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 func (h *Handler) handleAdminContainerWs(c *gin.Context) {
+	if !api.IsOriginAllowed(h.cfg.CORS, c.Request) {
+		c.String(http.StatusForbidden, "origin not allowed")
+		return
+	}
+
 	submissionID := c.Param("id")
 	containerID := c.Param("conID")
+	stripANSI, err := pubsub.ParseANSIMode(c.Query("ansi"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
 
 	con, err := database.GetContainer(h.db, containerID)
 	if err != nil {
@@ -41,25 +44,41 @@ func (h *Handler) handleAdminContainerWs(c *gin.Context) {
 		return
 	}
 
-	conn, err := adminUpgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		zap.S().Errorf("failed to upgrade admin websocket: %v", err)
+		util.Logger(c).Errorf("failed to upgrade admin websocket: %v", err)
 		return
 	}
 	defer conn.Close()
 
 	if con.Status == models.StatusRunning {
 		// Real-time streaming for a running container
-		msgChan, unsubscribe := pubsub.GetBroker().Subscribe(containerID)
-		defer unsubscribe()
+		topic := pubsub.GetBroker().Subscribe(containerID)
+		defer topic.Close()
+
+		stopHeartbeat := api.StartHeartbeat(conn, h.cfg.Websocket.PingInterval())
+		defer stopHeartbeat()
 
 		// Goroutine to pump messages from pubsub to websocket
 		clientClosed := make(chan struct{})
 		go func() {
 			defer close(clientClosed)
-			for msg := range msgChan {
-				if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-					zap.S().Warnf("error writing to admin websocket: %v", err)
+			for {
+				select {
+				case msg, ok := <-topic.C:
+					if !ok {
+						return
+					}
+					if stripANSI {
+						msg = pubsub.StripMessageANSI(msg)
+					}
+					if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+						util.Logger(c).Warnf("error writing to admin websocket: %v", err)
+						return
+					}
+				case <-topic.Lagged():
+					util.Logger(c).Warnf("admin websocket client fell behind container %s, closing", containerID)
+					api.CloseLagged(conn)
 					return
 				}
 			}
@@ -69,7 +88,7 @@ func (h *Handler) handleAdminContainerWs(c *gin.Context) {
 		for {
 			if _, _, err := conn.ReadMessage(); err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					zap.S().Infof("admin websocket unexpected close error: %v", err)
+					util.Logger(c).Infof("admin websocket unexpected close error: %v", err)
 				}
 				break
 			}
@@ -99,17 +118,21 @@ func (h *Handler) handleAdminContainerWs(c *gin.Context) {
 		scanner := bufio.NewScanner(file)
 		for scanner.Scan() {
 			// The file content is already NDJSON, send it directly
-			if err := conn.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
+			msg := scanner.Bytes()
+			if stripANSI {
+				msg = pubsub.StripMessageANSI(msg)
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				return // Client disconnected
 			}
 		}
 
 		if err := scanner.Err(); err != nil {
-			zap.S().Errorf("error reading log file for container %s: %v", con.ID, err)
+			util.Logger(c).Errorf("error reading log file for container %s: %v", con.ID, err)
 		}
 
 		msg := pubsub.FormatMessage("info", "Log stream finished.")
 		conn.WriteMessage(websocket.TextMessage, msg)
 	}
-	zap.S().Infof("admin websocket connection closed for container %s", containerID)
+	util.Logger(c).Infof("admin websocket connection closed for container %s", containerID)
 }
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Admin roles. Viewers may only use read-only endpoints; operators may use all endpoints.
+const (
+	AdminRoleViewer   = "viewer"
+	AdminRoleOperator = "operator"
+)
+
+// AdminNameKey and AdminRoleKey are set in the gin context for authenticated admin requests.
+const (
+	AdminNameKey = "adminName"
+	AdminRoleKey = "adminRole"
+)
+
+// AdminAuthMiddleware authenticates admin API requests with the API keys from admin.keys, given
+// as "Authorization: Bearer <key>" or "X-API-Key: <key>" (or the api_key query parameter for
+// websockets). Read-only methods need at least the viewer role, everything else needs operator.
+// If no keys are configured, the admin API stays unauthenticated as before.
+func AdminAuthMiddleware(cfg config.Admin) gin.HandlerFunc {
+	if len(cfg.Keys) == 0 {
+		zap.S().Warn("admin.keys is empty, the admin API is not authenticated")
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		provided := c.GetHeader("X-API-Key")
+		if provided == "" {
+			provided, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if provided == "" && c.IsWebsocket() {
+			provided = c.Query("api_key")
+		}
+		if provided == "" {
+			util.Error(c, http.StatusUnauthorized, "admin API key is required")
+			c.Abort()
+			return
+		}
+
+		var matched *config.AdminKey
+		for i := range cfg.Keys {
+			if subtle.ConstantTimeCompare([]byte(cfg.Keys[i].Key), []byte(provided)) == 1 {
+				matched = &cfg.Keys[i]
+			}
+		}
+		if matched == nil {
+			util.Error(c, http.StatusUnauthorized, "invalid admin API key")
+			c.Abort()
+			return
+		}
+
+		c.Set(AdminNameKey, matched.Name)
+		c.Set(AdminRoleKey, matched.Role)
+		c.Set(util.LoggerKey, util.Logger(c).With("admin", matched.Name))
+
+		if !isReadOnlyMethod(c.Request.Method) && matched.Role != AdminRoleOperator {
+			util.Error(c, http.StatusForbidden, "this action requires the operator role")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -2,7 +2,6 @@ package api
 
 import (
 	"crypto/hmac"
-	"crypto/sha512"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -12,12 +11,75 @@ import (
 	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"gorm.io/gorm"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
+// RequestIDHeader is the header used to propagate the request ID to and from clients.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds the length of a request ID accepted from a client.
+const maxRequestIDLen = 64
+
+// validRequestID reports whether a client-supplied request ID is short and made only of
+// letters, digits, '.', '_' and '-', so it can be logged and echoed back as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestLoggerMiddleware attaches a request ID to every request and stores a
+// logger carrying that ID in the context, so handlers can log through util.Logger.
+// A request ID sent by the client is reused if it passes validRequestID; otherwise a new
+// one is generated.
+func RequestLoggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Writer.Header().Set(RequestIDHeader, requestID)
+		c.Set("requestID", requestID)
+		c.Set(util.LoggerKey, zap.S().With("request_id", requestID))
+		c.Next()
+	}
+}
+
+// MultipartMemory is how much of a multipart form the engines keep in memory; the rest of the
+// uploaded files is spooled to temporary files.
+const MultipartMemory = 8 << 20
+
+// BodyLimitMiddleware rejects requests whose body is larger than maxBytes with 413 before any
+// handler reads it. Bodies without a Content-Length are cut off at maxBytes, and util.Error turns
+// the resulting read error into a 413 as well. A maxBytes of 0 disables the limit.
+func BodyLimitMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > maxBytes {
+			util.Error(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds the limit of %d bytes", maxBytes))
+			c.Abort()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
 // CORSMiddleware provides a configurable CORS middleware.
 func CORSMiddleware(cfg config.CORS) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -46,7 +108,7 @@ func CORSMiddleware(cfg config.CORS) gin.HandlerFunc {
 		if allowOrigin != "" {
 			c.Writer.Header().Set("Access-Control-Allow-Origin", allowOrigin)
 			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, accept, origin, Cache-Control, X-Requested-With")
 			c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 			if c.Request.Method == "OPTIONS" {
@@ -58,6 +120,18 @@ func CORSMiddleware(cfg config.CORS) gin.HandlerFunc {
 	}
 }
 
+// Context keys set for requests authenticated with a personal access token.
+const (
+	TokenIDKey    = "tokenID"
+	TokenScopeKey = "tokenScope"
+)
+
+// tokenTouchInterval throttles how often a token's last use is written to the database.
+const tokenTouchInterval = time.Minute
+
+// AuthMiddleware authenticates users with "Authorization: Bearer <jwt>" or with a personal
+// access token given as "Authorization: Token <value>". Read-scoped tokens may only use
+// read-only methods.
 func AuthMiddleware(secret string, db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -68,21 +142,40 @@ func AuthMiddleware(secret string, db *gorm.DB) gin.HandlerFunc {
 		}
 
 		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			util.Error(c, http.StatusUnauthorized, "Authorization header format must be Bearer {token}")
+		if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "Token") {
+			util.Error(c, http.StatusUnauthorized, "Authorization header format must be Bearer {token} or Token {token}")
 			c.Abort()
 			return
 		}
 
-		tokenString := parts[1]
-		claims, err := auth.ValidateJWT(tokenString, secret)
-		if err != nil {
-			util.Error(c, http.StatusUnauthorized, err.Error())
-			c.Abort()
-			return
+		var userID string
+		var impersonation bool
+		var token *models.PersonalAccessToken
+		if parts[0] == "Token" {
+			var err error
+			token, err = database.GetPersonalAccessTokenByHash(db, auth.HashAccessToken(parts[1]))
+			if err != nil {
+				util.Error(c, http.StatusUnauthorized, "Invalid or revoked access token")
+				c.Abort()
+				return
+			}
+			if token.Scope == auth.TokenScopeRead && !isReadOnlyMethod(c.Request.Method) {
+				util.Error(c, http.StatusForbidden, "this access token is read-only")
+				c.Abort()
+				return
+			}
+			userID = token.UserID
+		} else {
+			claims, err := auth.ValidateJWT(parts[1], secret)
+			if err != nil {
+				util.Error(c, http.StatusUnauthorized, err.Error())
+				c.Abort()
+				return
+			}
+			userID = claims.Subject
+			impersonation = claims.Impersonation
 		}
 
-		userID := claims.Subject
 		user, err := database.GetUserByID(db, userID)
 		if err != nil {
 			util.Error(c, http.StatusUnauthorized, "User not found")
@@ -103,11 +196,85 @@ func AuthMiddleware(secret string, db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		c.Set("userID", claims.Subject)
+		c.Set("userID", userID)
+		logger := util.Logger(c).With("user_id", userID)
+		if impersonation {
+			c.Set(ImpersonationKey, true)
+			logger = logger.With("impersonation", true)
+		}
+		if token != nil {
+			c.Set(TokenIDKey, token.ID)
+			c.Set(TokenScopeKey, token.Scope)
+			logger = logger.With("token_id", token.ID)
+			logger.Infof("authenticated %s %s with access token %q", c.Request.Method, c.Request.URL.Path, token.Name)
+			now := time.Now()
+			if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenTouchInterval || token.LastUsedIP != c.ClientIP() {
+				if err := database.TouchPersonalAccessToken(db, token.ID, now, c.ClientIP()); err != nil {
+					logger.Warnf("failed to record use of access token: %v", err)
+				}
+			}
+		}
+		c.Set(util.LoggerKey, logger)
+		c.Next()
+	}
+}
+
+// IsTokenAuth reports whether the request was authenticated with a personal access token.
+func IsTokenAuth(c *gin.Context) bool {
+	return c.GetString(TokenIDKey) != ""
+}
+
+// ForbidTokenAuth rejects account management actions made with a personal access token, so a
+// leaked token cannot be used to mint further tokens or change the profile.
+func ForbidTokenAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IsTokenAuth(c) {
+			util.Error(c, http.StatusForbidden, "this action is not allowed with an access token")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// OptionalUserID returns the ID of the user in a valid Authorization header, or "" for anonymous requests.
+func OptionalUserID(c *gin.Context, secret string) string {
+	tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	claims, err := auth.ValidateJWT(tokenString, secret)
+	if err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// ImpersonationKey is set in the gin context when the request uses an admin impersonation token.
+const ImpersonationKey = "impersonation"
+
+// IsImpersonating reports whether the request was authenticated with an impersonation token.
+func IsImpersonating(c *gin.Context) bool {
+	return c.GetBool(ImpersonationKey)
+}
+
+// ForbidImpersonation rejects state-changing actions made with an impersonation token.
+func ForbidImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IsImpersonating(c) {
+			util.Error(c, http.StatusForbidden, "this action is not allowed with an impersonation token")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
-func AssetsAuthMiddleware(secret string, db *gorm.DB) gin.HandlerFunc {
+
+// AssetsAuthMiddleware verifies signed asset URLs issued by queryAssetURL, including the user
+// binding and single-use nonce of the current token scheme. URLs signed with the current or, during
+// a rotation, the previous asset secret are accepted.
+func AssetsAuthMiddleware(cfg config.Auth, nonces *auth.NonceStore, keys *auth.AssetKeyring) gin.HandlerFunc {
+	jwtSecret := cfg.JWT.Secret
 	return func(c *gin.Context) {
 		token := c.Query("token")
 		expires := c.Query("expires")
@@ -126,18 +293,54 @@ func AssetsAuthMiddleware(secret string, db *gorm.DB) gin.HandlerFunc {
 		}
 
 		assetPath := c.Request.URL.Path
-		message := fmt.Sprintf("%s|%d", assetPath, expireTime)
-
-		mac := hmac.New(sha512.New, []byte(secret))
-		mac.Write([]byte(message))
-		expectedMAC := fmt.Sprintf("%x", mac.Sum(nil))
+		if c.Query("v") != auth.AssetTokenVersion {
+			if !cfg.AssetURL.Legacy || !signedByAny(keys, token, func(secret string) string {
+				return auth.LegacyAssetToken(secret, assetPath, expireTime)
+			}) {
+				util.Error(c, http.StatusUnauthorized, "Invalid token")
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
 
-		if !hmac.Equal([]byte(expectedMAC), []byte(token)) {
+		userID := c.Query("uid")
+		nonce := c.Query("nonce")
+		if !signedByAny(keys, token, func(secret string) string {
+			return auth.AssetToken(secret, assetPath, expireTime, userID, nonce)
+		}) {
 			util.Error(c, http.StatusUnauthorized, "Invalid token")
 			c.Abort()
 			return
 		}
 
+		if userID != "" {
+			tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			claims, err := auth.ValidateJWT(tokenString, jwtSecret)
+			if err != nil || claims.Subject != userID {
+				util.Error(c, http.StatusForbidden, "This asset URL was issued to another user")
+				c.Abort()
+				return
+			}
+		}
+
+		if nonce != "" && !nonces.Consume(nonce) {
+			util.Error(c, http.StatusUnauthorized, "This asset URL has already been used")
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
+
+// signedByAny reports whether token matches the MAC computed by sign with any accepted secret.
+func signedByAny(keys *auth.AssetKeyring, token string, sign func(secret string) string) bool {
+	for _, secret := range keys.Secrets() {
+		if hmac.Equal([]byte(sign(secret)), []byte(token)) {
+			return true
+		}
+	}
+	return false
+}
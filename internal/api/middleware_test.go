@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestLoggerMiddlewareRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"missing", "", false},
+		{"uuid", "3f2b8c1e-6a4d-4a8e-9c1f-0d2e5b7a9c31", true},
+		{"safe characters", "trace_01.abc-XYZ", true},
+		{"longest allowed", strings.Repeat("a", maxRequestIDLen), true},
+		{"too long", strings.Repeat("a", maxRequestIDLen+1), false},
+		{"space", "abc def", false},
+		{"newline", "abc\nfake log line", false},
+		{"quote", `abc"def`, false},
+		{"non-ascii", "ïd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.Use(RequestLoggerMiddleware())
+			var seen string
+			engine.GET("/", func(c *gin.Context) { seen = c.GetString("requestID") })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			echoed := w.Header().Get(RequestIDHeader)
+			if echoed != seen {
+				t.Errorf("echoed %q but stored %q", echoed, seen)
+			}
+			if tt.keep && echoed != tt.header {
+				t.Errorf("got %q, want the client's %q", echoed, tt.header)
+			}
+			if !tt.keep && (echoed == tt.header || !validRequestID(echoed)) {
+				t.Errorf("got %q, want a newly generated ID", echoed)
+			}
+		})
+	}
+}
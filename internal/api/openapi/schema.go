@@ -0,0 +1,147 @@
+package openapi
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Schema is the subset of the OpenAPI 3.0 schema object the generator produces.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	deletedAtType  = reflect.TypeOf(gorm.DeletedAt{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaGenerator derives schemas from Go types the way encoding/json serializes them. Named
+// structs become components referenced by name; everything else is inlined.
+type schemaGenerator struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		components: make(map[string]*Schema),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+func (g *schemaGenerator) schemaOf(v any) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	return g.schemaFor(reflect.TypeOf(v))
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case deletedAtType:
+		return &Schema{Type: "string", Format: "date-time", Nullable: true}
+	case rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schemaFor(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return g.componentRef(t)
+	}
+	// Interfaces and anything else encoding/json decides at runtime.
+	return &Schema{}
+}
+
+// componentRef registers a named struct as a component and returns a reference to it. The name
+// is registered before the properties are walked so that recursive types terminate.
+func (g *schemaGenerator) componentRef(t reflect.Type) *Schema {
+	name, ok := g.names[t]
+	if !ok {
+		name = t.Name()
+		if _, taken := g.components[name]; taken {
+			name = path.Base(t.PkgPath()) + "." + name
+		}
+		g.names[t] = name
+		g.components[name] = &Schema{}
+		*g.components[name] = *g.structSchema(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds the JSON-visible fields of t to s, flattening untagged embedded structs as
+// encoding/json does.
+func (g *schemaGenerator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType && ft != deletedAtType {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = g.schemaFor(field.Type)
+	}
+}
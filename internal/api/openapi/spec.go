@@ -0,0 +1,272 @@
+// Package openapi builds OpenAPI 3.0 documents from the routes registered on a gin engine.
+// Every /api route appears in the document; the Docs of a Spec add summaries, bodies and
+// response types to the routes that have them.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// Operation describes one route. Request and Response are zero values of the JSON request body
+// and of the data field of a successful response; nil leaves them undescribed.
+type Operation struct {
+	Summary     string
+	Description string
+	Query       []string // query parameter names
+	Request     any
+	Multipart   []string // multipart file fields, for upload routes
+	Response    any
+	Public      bool // the route needs no credentials
+}
+
+// Docs maps "METHOD /full/path" (in gin syntax, e.g. "GET /api/v1/problems/:id") to the
+// description of that route.
+type Docs map[string]Operation
+
+// SecurityScheme is an OpenAPI security scheme object.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// Spec is everything besides the routes that goes into a document. Any of the security
+// schemes is accepted by routes that are not Public.
+type Spec struct {
+	Title           string
+	Version         string
+	SecuritySchemes map[string]SecurityScheme
+	Docs            Docs
+}
+
+// Document is the OpenAPI document served as /openapi.json.
+type Document struct {
+	OpenAPI    string                    `json:"openapi"`
+	Info       Info                      `json:"info"`
+	Paths      map[string]map[string]*Op `json:"paths"`
+	Components Components                `json:"components"`
+	Security   []map[string][]string     `json:"security,omitempty"`
+	Tags       []map[string]string       `json:"tags,omitempty"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// Op is an OpenAPI operation object.
+type Op struct {
+	OperationID string                 `json:"operationId"`
+	Summary     string                 `json:"summary,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Tags        []string               `json:"tags,omitempty"`
+	Parameters  []Parameter            `json:"parameters,omitempty"`
+	RequestBody *RequestBody           `json:"requestBody,omitempty"`
+	Responses   map[string]ResponseObj `json:"responses"`
+	Security    *[]map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type ResponseObj struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+const envelopeRef = "#/components/schemas/Response"
+
+// Build creates the document for the /api routes among routes.
+func Build(routes gin.RoutesInfo, spec Spec) *Document {
+	gen := newSchemaGenerator()
+	gen.schemaOf(util.Response{})
+
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: spec.Title, Version: spec.Version},
+		Paths:   make(map[string]map[string]*Op),
+	}
+	for _, name := range slices.Sorted(maps.Keys(spec.SecuritySchemes)) {
+		doc.Security = append(doc.Security, map[string][]string{name: {}})
+	}
+
+	usedIDs := make(map[string]int)
+	seenTags := make(map[string]bool)
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		path, params := convertPath(route.Path)
+		tag := routeTag(route.Path)
+		if !seenTags[tag] {
+			seenTags[tag] = true
+			doc.Tags = append(doc.Tags, map[string]string{"name": tag})
+		}
+
+		op := &Op{Tags: []string{tag}, Responses: make(map[string]ResponseObj)}
+		for _, p := range params {
+			op.Parameters = append(op.Parameters, Parameter{Name: p, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+
+		handler := handlerName(route.Handler)
+		op.OperationID = handler
+		if n := usedIDs[handler]; n > 0 {
+			op.OperationID = fmt.Sprintf("%s_%d", handler, n+1)
+		}
+		usedIDs[handler]++
+		op.Summary = humanize(handler)
+
+		data := &Schema{}
+		if desc, ok := spec.Docs[route.Method+" "+route.Path]; ok {
+			if desc.Summary != "" {
+				op.Summary = desc.Summary
+			}
+			op.Description = desc.Description
+			for _, q := range desc.Query {
+				op.Parameters = append(op.Parameters, Parameter{Name: q, In: "query", Schema: &Schema{Type: "string"}})
+			}
+			if desc.Request != nil {
+				op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+					"application/json": {Schema: gen.schemaOf(desc.Request)},
+				}}
+			}
+			if len(desc.Multipart) > 0 {
+				form := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+				for _, field := range desc.Multipart {
+					form.Properties[field] = &Schema{Type: "string", Format: "binary"}
+				}
+				op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+					"multipart/form-data": {Schema: form},
+				}}
+			}
+			if desc.Response != nil {
+				data = gen.schemaOf(desc.Response)
+			}
+			if desc.Public {
+				op.Security = &[]map[string][]string{}
+			}
+		}
+
+		op.Responses["200"] = ResponseObj{
+			Description: "Success (code 0)",
+			Content: map[string]MediaType{"application/json": {Schema: &Schema{AllOf: []*Schema{
+				{Ref: envelopeRef},
+				{Type: "object", Properties: map[string]*Schema{"data": data}},
+			}}}},
+		}
+		op.Responses["default"] = ResponseObj{
+			Description: "Error (code -1, message describes the failure)",
+			Content:     map[string]MediaType{"application/json": {Schema: &Schema{Ref: envelopeRef}}},
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*Op)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	doc.Components = Components{Schemas: gen.components, SecuritySchemes: spec.SecuritySchemes}
+	return doc
+}
+
+// Handler serves the document for the routes of r. It is built on the first request, once all
+// routes have been registered.
+func Handler(r *gin.Engine, spec Spec) gin.HandlerFunc {
+	var (
+		once sync.Once
+		body []byte
+		err  error
+	)
+	return func(c *gin.Context) {
+		once.Do(func() {
+			body, err = json.Marshal(Build(r.Routes(), spec))
+		})
+		if err != nil {
+			util.Error(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+// convertPath turns gin's :param and *param segments into OpenAPI {param} templates.
+func convertPath(ginPath string) (string, []string) {
+	var params []string
+	segments := strings.Split(ginPath, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// routeTag groups routes by the first path segment after the API version.
+func routeTag(ginPath string) string {
+	rest := strings.TrimPrefix(ginPath, "/api/")
+	if _, after, ok := strings.Cut(rest, "/"); ok {
+		rest = after
+	}
+	tag, _, _ := strings.Cut(rest, "/")
+	return tag
+}
+
+// handlerName extracts the method name from a handler's function name, e.g.
+// "github.com/.../user.(*Handler).getProblem-fm" becomes "getProblem".
+func handlerName(fn string) string {
+	fn = strings.TrimSuffix(fn, "-fm")
+	if i := strings.LastIndex(fn, "."); i >= 0 {
+		fn = fn[i+1:]
+	}
+	return fn
+}
+
+// humanize splits a camelCase name into a sentence: "getContestLeaderboard" becomes
+// "Get contest leaderboard".
+func humanize(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i == 0 {
+			b.WriteRune(unicode.ToUpper(r))
+			continue
+		}
+		if unicode.IsUpper(r) {
+			b.WriteRune(' ')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -1,15 +1,16 @@
 package user
 
 import (
-	"crypto/hmac"
-	"crypto/sha512"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 )
@@ -24,14 +25,26 @@ func (h *Handler) serveAvatar(c *gin.Context) {
 	}
 
 	fullPath := filepath.Join(h.cfg.Storage.UserAvatar, cleanFilename)
+	if cleanFilename == defaultAvatarName {
+		fullPath = h.cfg.Avatar.Default
+	}
 
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		util.Error(c, http.StatusNotFound, "avatar not found")
-		return
+	if fullPath == "" || isMissing(fullPath) {
+		// Stale or missing avatars fall back to the default one, if configured.
+		if h.cfg.Avatar.Default == "" || isMissing(h.cfg.Avatar.Default) {
+			util.Error(c, http.StatusNotFound, "avatar not found")
+			return
+		}
+		fullPath = h.cfg.Avatar.Default
 	}
 	c.File(fullPath)
 }
 
+func isMissing(path string) bool {
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
+
 func (h *Handler) queryAssetURL(c *gin.Context) {
 	asset := c.Query("asset")
 
@@ -39,16 +52,48 @@ func (h *Handler) queryAssetURL(c *gin.Context) {
 		util.Error(c, http.StatusBadRequest, "invalid asset path")
 		return
 	}
+	if rest, ok := strings.CutPrefix(asset, "/api/v1/assets/problems/"); ok {
+		problemID, _, _ := strings.Cut(rest, "/")
+		if !h.isPublicArchive(problemID) {
+			h.appState.RLock()
+			contest, ok := h.appState.ProblemToContestMap[problemID]
+			h.appState.RUnlock()
+			if ok && !h.checkRegisteredToView(c, contest, c.GetString("userID")) {
+				return
+			}
+		}
+	}
 
-	timeout := time.Now().Add(15 * time.Minute).Unix()
+	expiresAt := time.Now().Add(auth.AssetURLTTL)
+	timeout := expiresAt.Unix()
+	secret := h.assetKeys.Current()
+	assetCfg := h.cfg.Auth.AssetURL
 
-	message := fmt.Sprintf("%s|%d", asset, timeout)
+	if assetCfg.Legacy {
+		token := auth.LegacyAssetToken(secret, asset, timeout)
+		util.Success(c, gin.H{"url": fmt.Sprintf("%s?token=%s&expires=%d", asset, token, timeout)}, "Asset URL generated")
+		return
+	}
 
-	mac := hmac.New(sha512.New, []byte(h.cfg.Auth.JWT.Secret))
-	mac.Write([]byte(message))
-	token := fmt.Sprintf("%x", mac.Sum(nil))
+	query := url.Values{}
+	var userID, nonce string
+	if assetCfg.BindUser {
+		userID = c.GetString("userID")
+		query.Set("uid", userID)
+	}
+	if assetCfg.SingleUse {
+		var err error
+		if nonce, err = h.assetNonces.Issue(expiresAt); err != nil {
+			util.Error(c, http.StatusInternalServerError, "failed to generate asset token")
+			return
+		}
+		query.Set("nonce", nonce)
+	}
+	query.Set("v", auth.AssetTokenVersion)
+	query.Set("expires", strconv.FormatInt(timeout, 10))
+	query.Set("token", auth.AssetToken(secret, asset, timeout, userID, nonce))
 
-	signedURL := fmt.Sprintf("%s?token=%s&expires=%d", asset, token, timeout)
+	signedURL := asset + "?" + query.Encode()
 
 	util.Success(c, gin.H{"url": signedURL}, "Asset URL generated")
 }
@@ -95,6 +140,30 @@ func (h *Handler) serveContestAsset(c *gin.Context) {
 	c.File(safeRequested)
 }
 
+// isPublicArchive reports whether the problem's contest has ended and the problem is public.
+func (h *Handler) isPublicArchive(problemID string) bool {
+	h.appState.RLock()
+	defer h.appState.RUnlock()
+	problem, ok := h.appState.Problems[problemID]
+	if !ok {
+		return false
+	}
+	contest, ok := h.appState.ProblemToContestMap[problemID]
+	return ok && contest.IsPublicArchive(problem, time.Now())
+}
+
+// publicArchiveOr serves assets of publicly archived problems without a signed URL and
+// applies the given asset authorization middleware to everything else.
+func (h *Handler) publicArchiveOr(authMiddleware gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.isPublicArchive(c.Param("id")) {
+			c.Next()
+			return
+		}
+		authMiddleware(c)
+	}
+}
+
 func (h *Handler) serveProblemAsset(c *gin.Context) {
 	problemID := c.Param("id")
 	assetPath := c.Param("assetpath")
@@ -125,7 +194,17 @@ func (h *Handler) serveProblemAsset(c *gin.Context) {
 		util.Error(c, http.StatusForbidden, "problem has not started yet")
 		return
 	}
+	if !parentContest.IsProblemUnlocked(problemID, now) {
+		h.appState.RUnlock()
+		util.Error(c, http.StatusForbidden, "problem has not been unlocked yet")
+		return
+	}
+	archived := parentContest.IsPublicArchive(problem, now)
 	h.appState.RUnlock()
+	// URLs are only issued to users allowed to view the problem; bound URLs are re-checked here.
+	if uid := c.Query("uid"); uid != "" && !archived && !h.checkRegisteredToView(c, parentContest, uid) {
+		return
+	}
 	// --- End Authorization ---
 
 	// --- Security Logic (same as contest assets) ---
@@ -143,7 +222,7 @@ func (h *Handler) serveProblemAsset(c *gin.Context) {
 		return
 	}
 
-	if !strings.HasPrefix(safeRequested, safeBase) {
+	if !strings.HasPrefix(safeRequested, safeBase+string(filepath.Separator)) || problem.IsPrivatePath(safeRequested) {
 		util.Error(c, http.StatusForbidden, "access denied")
 		return
 	}
@@ -11,13 +11,13 @@ import (
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
 func (h *Handler) getAuthStatus(c *gin.Context) {
 	util.Success(c, gin.H{
 		"local_auth_enabled": h.cfg.Auth.Local.Enabled,
+		"oidc_providers":     h.oidcAuthHandler.Providers(),
 	}, "Auth status retrieved")
 }
 
@@ -63,7 +63,7 @@ func (h *Handler) localRegister(c *gin.Context) {
 		return
 	}
 
-	zap.S().Infof("new local user registered: %s", newUser.Username)
+	util.Logger(c).Infof("new local user registered: %s", newUser.Username)
 	util.Success(c, gin.H{"id": newUser.ID, "username": newUser.Username}, "User registered successfully")
 }
 
@@ -100,7 +100,7 @@ func (h *Handler) localLogin(c *gin.Context) {
 	}
 
 	if user.PasswordHash == "" {
-		util.Error(c, http.StatusUnauthorized, "user registered via GitLab, please use GitLab login")
+		util.Error(c, http.StatusUnauthorized, "user registered via external login, please use that provider to log in")
 		return
 	}
 
@@ -109,6 +109,8 @@ func (h *Handler) localLogin(c *gin.Context) {
 		return
 	}
 
+	h.autoRegister(c, user.ID)
+
 	jwtToken, err := auth.GenerateJWT(user.ID, h.cfg.Auth.JWT.Secret, h.cfg.Auth.JWT.ExpireHours)
 	if err != nil {
 		util.Error(c, http.StatusInternalServerError, "failed to generate JWT")
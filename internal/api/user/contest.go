@@ -3,6 +3,7 @@ package user
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ZJUSCT/CSOJ/internal/database"
@@ -30,17 +31,24 @@ func (h *Handler) getAllContests(c *gin.Context) {
 	for id, contest := range h.appState.Contests {
 		contestCopy := *contest
 		contestCopy.ProblemIDs = []string{} // Always hide problem IDs in the list view
+		contestCopy.Phases = nil
 		responseContests[id] = contestCopy
 	}
 
 	util.Success(c, responseContests, "Contests loaded")
 }
 
+// contestResponse is a contest with the display metadata of its visible problems.
+type contestResponse struct {
+	judger.Contest
+	Problems []judger.LeaderboardColumn `json:"problems"`
+}
+
 func (h *Handler) getContest(c *gin.Context) {
 	contestID := c.Param("id")
 	h.appState.RLock()
+	defer h.appState.RUnlock()
 	contest, ok := h.appState.Contests[contestID]
-	h.appState.RUnlock()
 
 	if !ok {
 		util.Error(c, http.StatusNotFound, fmt.Errorf("contest not found"))
@@ -49,14 +57,16 @@ func (h *Handler) getContest(c *gin.Context) {
 
 	now := time.Now()
 	// For contests that haven't started, hide the problem list.
+	// Create a copy to avoid modifying the original map entry.
+	// Problems of phases that have not opened yet are hidden.
+	contestCopy := contest.VisibleCopy(now)
 	if now.Before(contest.StartTime) {
-		// Create a copy to avoid modifying the original map entry
-		contestCopy := *contest
 		contestCopy.ProblemIDs = []string{} // Empty the problem list
-		util.Success(c, contestCopy, "Contest found, but is not currently active")
+		util.Success(c, contestResponse{Contest: contestCopy, Problems: []judger.LeaderboardColumn{}}, "Contest found, but is not currently active")
 		return
 	}
-	util.Success(c, contest, "Contest found")
+	problems := contestCopy.LeaderboardColumns(h.appState.Problems, now, true)
+	util.Success(c, contestResponse{Contest: contestCopy, Problems: problems}, "Contest found")
 }
 
 func (h *Handler) getContestAnnouncements(c *gin.Context) {
@@ -79,20 +89,201 @@ func (h *Handler) getContestAnnouncements(c *gin.Context) {
 	util.Success(c, contest.Announcements, "Announcements retrieved successfully")
 }
 
+// checkRegisteredToView enforces a contest's require_registration_to_view flag for the given user
+// ("" for anonymous). It writes the error response and returns false if access is denied.
+func (h *Handler) checkRegisteredToView(c *gin.Context, contest *judger.Contest, userID string) bool {
+	if !contest.RequireRegistrationToView {
+		return true
+	}
+	if userID == "" {
+		util.Error(c, http.StatusUnauthorized, "login required to view this problem")
+		return false
+	}
+	registered, err := database.IsUserRegisteredForContest(h.db, userID, contest.ID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return false
+	}
+	if !registered {
+		util.Error(c, http.StatusForbidden, "you must register for the contest to view this problem")
+		return false
+	}
+	return true
+}
+
+// getContestLeaderboard returns the leaderboard entries together with the problem columns in
+// contest order, since the per-user problem scores are an unordered map.
 func (h *Handler) getContestLeaderboard(c *gin.Context) {
 	contestID := c.Param("id")
-	tags := c.Query("tags") // Comma-separated string of tags
-	leaderboard, err := database.GetLeaderboard(h.db, contestID, tags)
+	leaderboard, err := h.contestLeaderboard(contestID, c.Query("tags")) // tags: comma-separated
 	if err != nil {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
-	util.Success(c, leaderboard, "Leaderboard retrieved")
+
+	columns := []judger.LeaderboardColumn{}
+	hidden := false
+	now := time.Now()
+	h.appState.RLock()
+	if contest, ok := h.appState.Contests[contestID]; ok {
+		columns = contest.LeaderboardColumns(h.appState.Problems, now, false)
+		hidden = contest.TotalsHidden(h.appState.Problems, now)
+	}
+	h.appState.RUnlock()
+	if hidden {
+		util.Success(c, gin.H{"problems": columns, "entries": []database.LeaderboardEntry{}}, "Scores are hidden until the contest ends")
+		return
+	}
+	util.Success(c, gin.H{"problems": columns, "entries": leaderboard}, "Leaderboard retrieved")
+}
+
+// contestLeaderboard returns the leaderboard users are shown: the final standing of a contest
+// whose scores are locked, or else the live scores.
+func (h *Handler) contestLeaderboard(contestID, tags string) ([]database.LeaderboardEntry, error) {
+	h.appState.RLock()
+	contest, ok := h.appState.Contests[contestID]
+	locked := ok && contest.ScoresLocked(time.Now())
+	var scoring *database.Scoring
+	if ok {
+		scoring = contest.LeaderboardScoring()
+	}
+	h.appState.RUnlock()
+
+	var leaderboard []database.LeaderboardEntry
+	found := false
+	var err error
+	if locked {
+		// Serve the standing frozen at the end, unless the finalizer has not saved it yet.
+		leaderboard, found, err = database.GetFinalStanding(h.db, contestID, tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		leaderboard, err = database.GetLeaderboard(h.db, contestID, tags, scoring)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range leaderboard {
+		if leaderboard[i].AvatarURL == "" {
+			leaderboard[i].AvatarURL = h.avatarURL("")
+		}
+	}
+	return leaderboard, nil
+}
+
+const (
+	defaultRankWindow = 3
+	maxRankWindow     = 20
+)
+
+type rankedEntry struct {
+	Rank int `json:"rank"`
+	database.LeaderboardEntry
+}
+
+// getMyRank returns the current user's rank in a contest and the ranked users right above and
+// below them, as many on each side as the window query parameter asks for. Users with
+// disable_rank are left out of the ranking.
+func (h *Handler) getMyRank(c *gin.Context) {
+	userID := c.GetString("userID")
+	contestID := c.Param("id")
+
+	window := defaultRankWindow
+	if v := c.Query("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxRankWindow {
+			util.Error(c, http.StatusBadRequest, fmt.Sprintf("window must be an integer between 0 and %d", maxRankWindow))
+			return
+		}
+		window = n
+	}
+
+	h.appState.RLock()
+	contest, ok := h.appState.Contests[contestID]
+	h.appState.RUnlock()
+	if !ok {
+		util.Error(c, http.StatusNotFound, "contest not found")
+		return
+	}
+	registered, err := database.IsUserRegisteredForContest(h.db, userID, contestID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	if !registered {
+		util.Error(c, http.StatusNotFound, "You are not registered for this contest")
+		return
+	}
+
+	leaderboard, err := h.contestLeaderboard(contestID, "")
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	var ranked []rankedEntry
+	var self *database.LeaderboardEntry
+	selfIdx := -1
+	for i := range leaderboard {
+		entry := &leaderboard[i]
+		if entry.UserID == userID {
+			self = entry
+			if !entry.DisableRank {
+				selfIdx = len(ranked)
+			}
+		}
+		if !entry.DisableRank {
+			ranked = append(ranked, rankedEntry{Rank: len(ranked) + 1, LeaderboardEntry: *entry})
+		}
+	}
+
+	if self == nil {
+		// Registered after the final standing was taken.
+		util.Error(c, http.StatusNotFound, "You are not on the leaderboard of this contest")
+		return
+	}
+	response := gin.H{
+		"rank":         nil,
+		"total_score":  self.TotalScore,
+		"ranked_users": len(ranked),
+		"above":        []rankedEntry{},
+		"below":        []rankedEntry{},
+	}
+	if h.totalsHidden(contest, time.Now()) {
+		response["total_score"] = nil
+		util.Success(c, response, "Scores are hidden until the contest ends")
+		return
+	}
+	if selfIdx < 0 {
+		util.Success(c, response, "Ranking is disabled for your account")
+		return
+	}
+	if self.TotalScore == 0 {
+		util.Success(c, response, "You have not scored in this contest yet")
+		return
+	}
+	response["rank"] = selfIdx + 1
+	response["above"] = ranked[max(0, selfIdx-window):selfIdx]
+	response["below"] = ranked[selfIdx+1 : min(len(ranked), selfIdx+1+window)]
+	util.Success(c, response, "Rank retrieved")
 }
 
 func (h *Handler) getContestTrend(c *gin.Context) {
 	contestID := c.Param("id")
-	leaderboard, err := database.GetLeaderboard(h.db, contestID, "")
+	h.appState.RLock()
+	var scoring *database.Scoring
+	hidden := false
+	if contest, ok := h.appState.Contests[contestID]; ok {
+		scoring = contest.LeaderboardScoring()
+		hidden = contest.TotalsHidden(h.appState.Problems, time.Now())
+	}
+	h.appState.RUnlock()
+	if hidden {
+		util.Success(c, make([]interface{}, 0), "Scores are hidden until the contest ends")
+		return
+	}
+	leaderboard, err := database.GetLeaderboard(h.db, contestID, "", scoring)
 	if err != nil {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
@@ -198,18 +389,46 @@ func (h *Handler) registerForContest(c *gin.Context) {
 	util.Success(c, nil, "Successfully registered for contest")
 }
 
+// autoRegister registers a user who just logged in for the running contests marked
+// auto_register. Failures are logged and never prevent the login.
+func (h *Handler) autoRegister(c *gin.Context, userID string) {
+	now := time.Now()
+	var contestIDs []string
+	h.appState.RLock()
+	for id, contest := range h.appState.Contests {
+		if contest.AutoRegister && !now.Before(contest.StartTime) && !now.After(contest.EndTime) {
+			contestIDs = append(contestIDs, id)
+		}
+	}
+	h.appState.RUnlock()
+
+	for _, contestID := range contestIDs {
+		if err := database.RegisterForContest(h.db, userID, contestID); err != nil {
+			if err.Error() != "already registered" {
+				util.Logger(c).Errorf("failed to auto-register user %s for contest %s: %v", userID, contestID, err)
+			}
+			continue
+		}
+		util.Logger(c).Infof("auto-registered user %s for contest %s", userID, contestID)
+	}
+}
+
 func (h *Handler) getContestHistory(c *gin.Context) {
 	userID := c.GetString("userID")
 	contestID := c.Param("id")
 
 	h.appState.RLock()
-	_, ok := h.appState.Contests[contestID]
+	contest, ok := h.appState.Contests[contestID]
 	h.appState.RUnlock()
 
 	if !ok {
 		util.Error(c, http.StatusNotFound, "contest not found")
 		return
 	}
+	if h.totalsHidden(contest, time.Now()) {
+		util.Success(c, []database.UserScoreHistoryPoint{}, "Scores are hidden until the contest ends")
+		return
+	}
 
 	history, err := database.GetScoreHistoryForUser(h.db, contestID, userID)
 	if err != nil {
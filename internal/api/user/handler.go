@@ -1,19 +1,25 @@
 package user
 
 import (
+	"github.com/ZJUSCT/CSOJ/internal/api"
 	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
+	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
 
 // Handler holds all dependencies for the user API handlers.
 type Handler struct {
-	cfg               *config.Config
-	db                *gorm.DB
-	scheduler         *judger.Scheduler
-	appState          *judger.AppState
-	gitlabAuthHandler *auth.GitLabHandler
+	cfg             *config.Config
+	db              *gorm.DB
+	scheduler       *judger.Scheduler
+	appState        *judger.AppState
+	oidcAuthHandler *auth.OIDCHandler
+	upgrader        *websocket.Upgrader
+	assetNonces     *auth.NonceStore
+	assetKeys       *auth.AssetKeyring
+	uploads         *uploadSessionStore
 }
 
 // NewHandler creates a new user handler with its dependencies.
@@ -22,12 +28,19 @@ func NewHandler(
 	db *gorm.DB,
 	scheduler *judger.Scheduler,
 	appState *judger.AppState,
+	assetKeys *auth.AssetKeyring,
 ) *Handler {
-	return &Handler{
-		cfg:               cfg,
-		db:                db,
-		scheduler:         scheduler,
-		appState:          appState,
-		gitlabAuthHandler: auth.NewGitLabHandler(cfg, db),
+	h := &Handler{
+		cfg:             cfg,
+		db:              db,
+		scheduler:       scheduler,
+		appState:        appState,
+		assetKeys:       assetKeys,
+		oidcAuthHandler: auth.NewOIDCHandler(cfg, db),
+		upgrader:        api.NewWebsocketUpgrader(cfg.CORS),
+		assetNonces:     auth.NewNonceStore(),
+		uploads:         newUploadSessionStore(cfg.Storage.UploadSessions),
 	}
+	h.oidcAuthHandler.OnLogin = h.autoRegister
+	return h
 }
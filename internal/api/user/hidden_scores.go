@@ -0,0 +1,73 @@
+package user
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/judger"
+	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+)
+
+// Results shown instead of the score of a submission to a problem that hides its scores.
+const (
+	resultPassed = "passed" // judged with a score above zero
+	resultFailed = "failed" // judged with a score of zero, or the judge failed
+)
+
+// scoresHidden reports whether the problem's scores are kept from users right now, see
+// judger.Contest.ScoresHidden.
+func (h *Handler) scoresHidden(problemID string, now time.Time) bool {
+	h.appState.RLock()
+	defer h.appState.RUnlock()
+	problem, ok := h.appState.Problems[problemID]
+	contest, inContest := h.appState.ProblemToContestMap[problemID]
+	return ok && inContest && contest.ScoresHidden(problem, now)
+}
+
+// totalsHidden reports whether leaderboard totals and score histories of the contest are kept
+// from users right now, see judger.Contest.TotalsHidden.
+func (h *Handler) totalsHidden(contest *judger.Contest, now time.Time) bool {
+	h.appState.RLock()
+	defer h.appState.RUnlock()
+	return contest.TotalsHidden(h.appState.Problems, now)
+}
+
+// hideScore clears the score, performance and subtasks of a submission and returns the result
+// shown in their place, empty while it has not finished.
+func hideScore(sub *models.Submission) string {
+	result := ""
+	switch {
+	case sub.Status == models.StatusSuccess && sub.Score > 0:
+		result = resultPassed
+	case sub.Status == models.StatusSuccess || sub.Status == models.StatusFailed:
+		result = resultFailed
+	}
+	sub.Score = 0
+	sub.Performance = 0
+	sub.Subtasks = nil
+	return result
+}
+
+// hideStatusScore clears the score of a status event sent on a submission's topic. Other
+// messages are returned unchanged.
+func hideStatusScore(msg []byte) []byte {
+	var wsMsg pubsub.WsMessage
+	if err := json.Unmarshal(msg, &wsMsg); err != nil || wsMsg.Stream != "status" {
+		return msg
+	}
+	var event judger.SubmissionStatusEvent
+	if err := json.Unmarshal([]byte(wsMsg.Data), &event); err != nil || event.Score == 0 {
+		return msg
+	}
+	event.Score = 0
+	data, err := json.Marshal(event)
+	if err != nil {
+		return msg
+	}
+	wsMsg.Data = string(data)
+	if masked, err := json.Marshal(wsMsg); err == nil {
+		return masked
+	}
+	return msg
+}
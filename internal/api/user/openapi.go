@@ -0,0 +1,149 @@
+package user
+
+import (
+	"github.com/ZJUSCT/CSOJ/internal/api/openapi"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/judger"
+)
+
+// apiSpec describes the user API for /openapi.json. Routes missing from the docs are still
+// listed, with a summary derived from the handler name.
+var apiSpec = openapi.Spec{
+	Title:   "CSOJ User API",
+	Version: "v1",
+	SecuritySchemes: map[string]openapi.SecurityScheme{
+		"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+		"token": {Type: "apiKey", In: "header", Name: "Authorization",
+			Description: `Personal access token, sent as "Authorization: Token <value>"`},
+	},
+	Docs: openapi.Docs{
+		"GET /api/v1/auth/status": {Summary: "Get the enabled login methods", Public: true},
+		"POST /api/v1/auth/local/register": {
+			Summary: "Register a local account",
+			Request: struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+				Nickname string `json:"nickname"`
+			}{},
+			Response: struct {
+				ID       string `json:"id"`
+				Username string `json:"username"`
+			}{},
+			Public: true,
+		},
+		"POST /api/v1/auth/local/login": {
+			Summary: "Log in with a local account",
+			Request: struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+			}{},
+			Response: struct {
+				Token string `json:"token"`
+			}{},
+			Public: true,
+		},
+		"GET /api/v1/auth/oidc/:provider/login":    {Summary: "Redirect to an OIDC provider", Public: true},
+		"GET /api/v1/auth/oidc/:provider/callback": {Summary: "Finish an OIDC login", Public: true},
+		"GET /api/v1/auth/gitlab/login":            {Summary: "Redirect to GitLab (alias of /auth/oidc/gitlab)", Public: true},
+		"GET /api/v1/auth/gitlab/callback":         {Summary: "Finish a GitLab login", Public: true},
+
+		"GET /api/v1/ws/submissions/:subID/containers/:conID/logs": {
+			Summary: "Stream container logs (websocket)", Query: []string{"token", "ansi"}, Public: true,
+		},
+		"GET /api/v1/ws/submissions/:subID/status": {
+			Summary: "Stream submission status changes (websocket)", Query: []string{"token"}, Public: true,
+		},
+		"GET /api/v1/ws/contests/:id/announcements": {
+			Summary: "Stream contest announcements (websocket)", Query: []string{"token"}, Public: true,
+		},
+
+		"GET /api/v1/links":    {Summary: "List the configured navigation links", Public: true},
+		"GET /api/v1/contests": {Summary: "List contests", Response: map[string]judger.Contest{}, Public: true},
+		"GET /api/v1/contests/:id": {
+			Summary: "Get a contest", Response: contestResponse{}, Public: true,
+		},
+		"GET /api/v1/contests/:id/leaderboard": {
+			Summary: "Get the contest leaderboard",
+			Query:   []string{"tags"},
+			Response: struct {
+				Problems []judger.LeaderboardColumn  `json:"problems"`
+				Entries  []database.LeaderboardEntry `json:"entries"`
+			}{},
+			Public: true,
+		},
+		"GET /api/v1/contests/:id/trend":         {Summary: "Get the score trend of the top users", Public: true},
+		"GET /api/v1/contests/:id/announcements": {Summary: "List contest announcements", Response: []judger.Announcement{}, Public: true},
+		"GET /api/v1/problems/:id":               {Summary: "Get a problem", Response: ProblemResponse{}, Public: true},
+		"GET /api/v1/users/:id":                  {Summary: "Get a public user profile", Response: PublicProfileResponse{}, Public: true},
+		"GET /api/v1/assets/avatars/:filename":   {Summary: "Download an avatar", Public: true},
+		"GET /api/v1/assets/contests/:id/*assetpath": {
+			Summary: "Download a contest asset with a signed URL", Query: []string{"token", "expires", "uid", "nonce", "v"}, Public: true,
+		},
+		"GET /api/v1/assets/problems/:id/*assetpath": {
+			Summary: "Download a problem asset with a signed URL", Query: []string{"token", "expires", "uid", "nonce", "v"}, Public: true,
+		},
+
+		"GET /api/v1/user/profile": {Summary: "Get the current user", Response: models.User{}},
+		"PATCH /api/v1/user/profile": {
+			Summary: "Update the current user's profile",
+			Request: struct {
+				Nickname  string `json:"nickname"`
+				Signature string `json:"signature"`
+			}{},
+			Response: models.User{},
+		},
+		"POST /api/v1/user/avatar": {Summary: "Upload an avatar", Multipart: []string{"avatar"}, Response: models.User{}},
+		"GET /api/v1/user/tokens":  {Summary: "List personal access tokens", Response: []models.PersonalAccessToken{}},
+		"POST /api/v1/user/tokens": {
+			Summary: "Create a personal access token",
+			Request: struct {
+				Name  string `json:"name"`
+				Scope string `json:"scope"`
+			}{},
+			Response: createTokenResponse{},
+		},
+		"DELETE /api/v1/user/tokens/:id": {Summary: "Revoke a personal access token"},
+
+		"POST /api/v1/contests/:id/register": {Summary: "Register for a contest"},
+		"GET /api/v1/contests/:id/my-rank":   {Summary: "Get the current user's rank and nearby users", Query: []string{"window"}},
+
+		"POST /api/v1/problems/:id/submit": {
+			Summary:   "Submit files to a problem",
+			Query:     []string{"dry_run"},
+			Multipart: []string{"files"},
+			Response: struct {
+				SubmissionID string `json:"submission_id"`
+				DryRun       bool   `json:"dry_run,omitempty"`
+				Practice     bool   `json:"practice,omitempty"`
+			}{},
+		},
+		"GET /api/v1/problems/:id/queue-estimate": {Summary: "Estimate the queue wait of a new submission", Response: queueEstimateResponse{}},
+		"POST /api/v1/problems/:id/check-files": {
+			Summary: "Check files against the problem's upload rules without submitting",
+			Request: struct {
+				Files []uploadFile `json:"files"`
+			}{},
+			Response: fileCheckResponse{},
+		},
+		"POST /api/v1/problems/:id/upload/init": {
+			Summary: "Start a chunked upload",
+			Request: struct {
+				Files  []uploadFile `json:"files"`
+				DryRun bool         `json:"dry_run"`
+			}{},
+		},
+
+		"GET /api/v1/submissions":                           {Summary: "List the current user's submissions", Response: []models.Submission{}},
+		"GET /api/v1/submissions/:id":                       {Summary: "Get a submission", Response: submissionResponse{}},
+		"GET /api/v1/submissions/:id/content":               {Summary: "Download the submitted files", Query: []string{"format"}},
+		"GET /api/v1/submissions/:id/artifacts":             {Summary: "List the files the judge left for the submitter", Response: []judger.Artifact{}},
+		"GET /api/v1/submissions/:id/artifacts/*path":       {Summary: "Download an artifact"},
+		"POST /api/v1/submissions/:id/interrupt":            {Summary: "Interrupt a submission"},
+		"DELETE /api/v1/submissions/:id":                    {Summary: "Delete a submission"},
+		"GET /api/v1/submissions/:id/queue_position":        {Summary: "Get a submission's queue position"},
+		"GET /api/v1/submissions/:id/containers/:conID/log": {Summary: "Get a container's log as NDJSON", Query: []string{"ansi"}},
+
+		"GET /api/v1/assets/query_url": {Summary: "Get a signed URL for an asset", Query: []string{"asset"}},
+	},
+}
@@ -2,9 +2,13 @@ package user
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/ZJUSCT/CSOJ/internal/api"
+	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
@@ -18,28 +22,59 @@ type WorkflowStepResponse struct {
 type ProblemResponse struct {
 	ID             string                 `json:"id"`
 	Name           string                 `json:"name"`
+	Label          string                 `json:"label,omitempty"`
+	Icon           string                 `json:"icon,omitempty"`
 	Level          string                 `yaml:"level" json:"level"`
 	StartTime      time.Time              `json:"starttime"`
 	EndTime        time.Time              `json:"endtime"`
 	MaxSubmissions int                    `json:"max_submissions"`
 	Cluster        string                 `json:"cluster"`
-	CPU            int                    `json:"cpu"`
+	CPU            float64                `json:"cpu"`
 	Memory         int64                  `json:"memory"`
 	Upload         judger.UploadLimit     `json:"upload"`
 	Workflow       []WorkflowStepResponse `json:"workflow"`
 	Score          judger.ScoreConfig     `json:"score"`
 	Description    string                 `json:"description"`
+	Archived       bool                   `json:"archived"` // the contest has ended and the problem is public
+	Prerequisites  []string               `json:"prerequisites,omitempty"`
+	Unmet          []string               `json:"unmet_prerequisites,omitempty"` // prerequisites the user has not scored on yet
+}
+
+// unmetPrerequisites returns the prerequisites of the problem the user has not scored on yet.
+// Anonymous users have met none of them.
+func (h *Handler) unmetPrerequisites(userID, contestID string, problem *judger.Problem) ([]string, error) {
+	if len(problem.Prerequisites) == 0 {
+		return nil, nil
+	}
+	scored := map[string]bool{}
+	if userID != "" {
+		var err error
+		if scored, err = database.GetScoredProblemIDs(h.db, userID, contestID, problem.Prerequisites); err != nil {
+			return nil, err
+		}
+	}
+	var unmet []string
+	for _, id := range problem.Prerequisites {
+		if !scored[id] {
+			unmet = append(unmet, id)
+		}
+	}
+	return unmet, nil
 }
 
 func (h *Handler) getProblem(c *gin.Context) {
 	problemID := c.Param("id")
 	h.appState.RLock()
 	problem, ok := h.appState.Problems[problemID]
+	var parentContest *judger.Contest
+	archived := false
 	if ok {
-		parentContest, parentOk := h.appState.ProblemToContestMap[problemID]
+		var parentOk bool
+		parentContest, parentOk = h.appState.ProblemToContestMap[problemID]
 		ok = parentOk
 		if ok {
 			now := time.Now()
+			archived = parentContest.IsPublicArchive(problem, now)
 			if now.Before(parentContest.StartTime) {
 				util.Error(c, http.StatusForbidden, fmt.Errorf("contest has not started yet"))
 				h.appState.RUnlock()
@@ -50,6 +85,11 @@ func (h *Handler) getProblem(c *gin.Context) {
 				h.appState.RUnlock()
 				return
 			}
+			if !parentContest.IsProblemUnlocked(problemID, now) {
+				util.Error(c, http.StatusForbidden, fmt.Errorf("problem has not been unlocked yet"))
+				h.appState.RUnlock()
+				return
+			}
 		} else {
 			util.Error(c, http.StatusInternalServerError, fmt.Errorf("internal server error: problem has no parent contest"))
 			h.appState.RUnlock()
@@ -62,15 +102,42 @@ func (h *Handler) getProblem(c *gin.Context) {
 		util.Error(c, http.StatusNotFound, fmt.Errorf("problem not found"))
 		return
 	}
+	userID := api.OptionalUserID(c, h.cfg.Auth.JWT.Secret)
+	if !archived && !h.checkRegisteredToView(c, parentContest, userID) {
+		return
+	}
+	if !archived {
+		unmet, err := h.unmetPrerequisites(userID, parentContest.ID, problem)
+		if err != nil {
+			util.Error(c, http.StatusInternalServerError, err)
+			return
+		}
+		if len(unmet) > 0 {
+			// Only describe the problem far enough for the UI to show it as locked.
+			locked := ProblemResponse{
+				ID:            problem.ID,
+				Name:          problem.Name,
+				Level:         problem.Level,
+				StartTime:     problem.StartTime,
+				EndTime:       problem.EndTime,
+				Prerequisites: problem.Prerequisites,
+				Unmet:         unmet,
+			}
+			util.ErrorWithData(c, http.StatusForbidden, fmt.Errorf("unmet prerequisites: %s", strings.Join(unmet, ", ")), locked)
+			return
+		}
+	}
 
 	workflowResponse := make([]WorkflowStepResponse, len(problem.Workflow))
 	for i, step := range problem.Workflow {
-		workflowResponse[i] = WorkflowStepResponse{Name: step.Name, Show: step.Show}
+		workflowResponse[i] = WorkflowStepResponse{Name: step.Name, Show: parentContest.StepLogVisible(problem, i, time.Now())}
 	}
 
 	response := ProblemResponse{
 		ID:             problem.ID,
 		Name:           problem.Name,
+		Label:          problem.Label,
+		Icon:           problem.Icon,
 		Level:          problem.Level,
 		StartTime:      problem.StartTime,
 		EndTime:        problem.EndTime,
@@ -78,11 +145,76 @@ func (h *Handler) getProblem(c *gin.Context) {
 		Cluster:        problem.Cluster,
 		CPU:            problem.CPU,
 		Memory:         problem.Memory,
-		Upload:         problem.Upload,
+		Upload:         judger.EffectiveUploadLimit(problem, parentContest, h.cfg.UploadLimits),
 		Workflow:       workflowResponse,
 		Score:  	    problem.Score,
 		Description:    problem.Description,
+		Archived:       archived,
+		Prerequisites:  problem.Prerequisites,
 	}
 
 	util.Success(c, response, "Problem found")
 }
+
+// queueEstimateSample is how many recent submissions the run time estimate is based on.
+const queueEstimateSample = 20
+
+type queueEstimateResponse struct {
+	Cluster              string `json:"cluster"`
+	QueueLength          int    `json:"queue_length"`           // submissions waiting for the cluster
+	FreeSlots            int    `json:"free_slots"`             // submissions of this problem that could start now
+	Capacity             int    `json:"capacity"`               // submissions of this problem the idle cluster runs at once
+	AverageRunSeconds    *int   `json:"average_run_seconds"`    // nil until a submission has finished
+	SampleSize           int    `json:"sample_size"`            // finished submissions the average is based on
+	EstimatedWaitSeconds *int   `json:"estimated_wait_seconds"` // nil if it cannot be estimated
+}
+
+// getQueueEstimate roughly estimates how long a new submission to the problem would wait before
+// it starts. Queued submissions are assumed to start in waves of the cluster's capacity, each
+// taking the problem's recent average run time.
+func (h *Handler) getQueueEstimate(c *gin.Context) {
+	problemID := c.Param("id")
+	now := time.Now()
+	h.appState.RLock()
+	problem, ok := h.appState.Problems[problemID]
+	parentContest, parentOk := h.appState.ProblemToContestMap[problemID]
+	h.appState.RUnlock()
+	if !ok || !parentOk || now.Before(parentContest.StartTime) || now.Before(problem.StartTime) {
+		util.Error(c, http.StatusNotFound, fmt.Errorf("problem not found"))
+		return
+	}
+
+	free, capacity, ok := h.scheduler.EstimateSlots(problem)
+	if !ok {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("problem has an invalid cluster"))
+		return
+	}
+	avg, sampleSize, err := database.GetAverageRunDuration(h.db, problem.ID, queueEstimateSample)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to compute average run time: %w", err))
+		return
+	}
+
+	resp := queueEstimateResponse{
+		Cluster:     problem.Cluster,
+		QueueLength: h.scheduler.GetQueueLengths()[problem.Cluster],
+		FreeSlots:   free,
+		Capacity:    capacity,
+		SampleSize:  sampleSize,
+	}
+	if sampleSize > 0 {
+		avgSeconds := int(math.Ceil(avg.Seconds()))
+		resp.AverageRunSeconds = &avgSeconds
+	}
+	switch {
+	case free > resp.QueueLength:
+		wait := 0
+		resp.EstimatedWaitSeconds = &wait
+	case capacity > 0 && resp.AverageRunSeconds != nil:
+		waves := (resp.QueueLength-free)/capacity + 1
+		wait := waves * *resp.AverageRunSeconds
+		resp.EstimatedWaitSeconds = &wait
+	}
+
+	util.Success(c, resp, "Queue wait estimated (approximate)")
+}
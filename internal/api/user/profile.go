@@ -12,10 +12,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
@@ -36,13 +36,28 @@ func (h *Handler) getUserProfile(c *gin.Context) {
 		util.Error(c, http.StatusNotFound, err)
 		return
 	}
-	// Prepend API path to avatar filename if it's not a full URL
-	if user.AvatarURL != "" && !strings.HasPrefix(user.AvatarURL, "http") {
-		user.AvatarURL = fmt.Sprintf("/api/v1/assets/avatars/%s", user.AvatarURL)
-	}
+	user.AvatarURL = h.avatarURL(user.AvatarURL)
 	util.Success(c, user, "ok")
 }
 
+// defaultAvatarName is the reserved avatar filename that serves the configured default avatar.
+const defaultAvatarName = "default"
+
+// avatarURL turns a stored avatar into the URL returned to clients. Local filenames are prefixed
+// with the API path, and users without an avatar get the default avatar if one is configured.
+func (h *Handler) avatarURL(stored string) string {
+	if stored == "" {
+		if h.cfg.Avatar.Default == "" {
+			return ""
+		}
+		stored = defaultAvatarName
+	}
+	if strings.HasPrefix(stored, "http") {
+		return stored
+	}
+	return fmt.Sprintf("/api/v1/assets/avatars/%s", stored)
+}
+
 func (h *Handler) getPublicUserProfile(c *gin.Context) {
 	userID := c.Param("id")
 	user, err := database.GetUserByID(h.db, userID)
@@ -55,17 +70,12 @@ func (h *Handler) getPublicUserProfile(c *gin.Context) {
 		return
 	}
 
-	avatarURL := user.AvatarURL
-	if user.AvatarURL != "" && !strings.HasPrefix(user.AvatarURL, "http") {
-		avatarURL = fmt.Sprintf("/api/v1/assets/avatars/%s", user.AvatarURL)
-	}
-
 	response := PublicProfileResponse{
 		ID:        user.ID,
 		Username:  user.Username,
 		Nickname:  user.Nickname,
 		Signature: user.Signature,
-		AvatarURL: avatarURL,
+		AvatarURL: h.avatarURL(user.AvatarURL),
 		Tags:      user.Tags,
 	}
 
@@ -110,7 +120,7 @@ func (h *Handler) updateUserProfile(c *gin.Context) {
 			util.Error(c, http.StatusInternalServerError, err)
 			return
 		}
-		zap.S().Warnf("user %s (%s) auto-banned for 24 hours due to suspicious nickname/signature", user.Username, user.ID)
+		util.Logger(c).Warnf("user %s (%s) auto-banned for 24 hours due to suspicious nickname/signature", user.Username, user.ID)
 		util.Error(c, http.StatusForbidden, "Your account has been temporarily banned due to suspicious input.")
 		return
 	}
@@ -201,7 +211,7 @@ func (h *Handler) uploadAvatar(c *gin.Context) {
 		ext = ".jpg"
 	}
 
-	if user.AvatarURL != "" {
+	if user.AvatarURL != "" && !auth.IsExternalAvatar(user.AvatarURL) {
 		oldAvatarPath := filepath.Join(h.cfg.Storage.UserAvatar, filepath.Base(user.AvatarURL))
 		_ = os.Remove(oldAvatarPath)
 	}
@@ -2,6 +2,8 @@ package user
 
 import (
 	"github.com/ZJUSCT/CSOJ/internal/api"
+	"github.com/ZJUSCT/CSOJ/internal/api/openapi"
+	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/embedui"
 	"github.com/ZJUSCT/CSOJ/internal/judger"
@@ -14,13 +16,17 @@ func NewUserRouter(
 	cfg *config.Config,
 	db *gorm.DB,
 	scheduler *judger.Scheduler,
-	appState *judger.AppState) *gin.Engine {
+	appState *judger.AppState,
+	assetKeys *auth.AssetKeyring) *gin.Engine {
 
 	r := gin.Default()
+	r.MaxMultipartMemory = api.MultipartMemory
 
+	r.Use(api.RequestLoggerMiddleware())
 	r.Use(api.CORSMiddleware(cfg.CORS))
+	r.Use(api.BodyLimitMiddleware(cfg.MaxBodyBytes()))
 
-	h := NewHandler(cfg, db, scheduler, appState)
+	h := NewHandler(cfg, db, scheduler, appState, assetKeys)
 
 	v1 := r.Group("/api/v1")
 	{
@@ -28,10 +34,14 @@ func NewUserRouter(
 		authGroup := v1.Group("/auth")
 		{
 			authGroup.GET("/status", h.getAuthStatus)
-			// GitLab Auth
+			// OIDC Auth
+			oidcGroup := authGroup.Group("/oidc/:provider")
+			oidcGroup.GET("/login", h.oidcAuthHandler.Login)
+			oidcGroup.GET("/callback", h.oidcAuthHandler.Callback)
+			// GitLab Auth, kept as an alias of /oidc/gitlab
 			gitlabGroup := authGroup.Group("/gitlab")
-			gitlabGroup.GET("/login", h.gitlabAuthHandler.Login)
-			gitlabGroup.GET("/callback", h.gitlabAuthHandler.Callback)
+			gitlabGroup.GET("/login", h.oidcAuthHandler.ProviderAlias("gitlab"), h.oidcAuthHandler.Login)
+			gitlabGroup.GET("/callback", h.oidcAuthHandler.ProviderAlias("gitlab"), h.oidcAuthHandler.Callback)
 
 			// Local Username/Password Auth (if enabled)
 			if cfg.Auth.Local.Enabled {
@@ -45,6 +55,8 @@ func NewUserRouter(
 
 		// Websocket for container logs with authorization
 		v1.GET("/ws/submissions/:subID/containers/:conID/logs", h.handleUserContainerWs)
+		v1.GET("/ws/submissions/:subID/status", h.handleSubmissionStatusWs)
+		v1.GET("/ws/contests/:id/announcements", h.handleContestAnnouncementsWs)
 
 		// Publicly accessible info
 		v1.GET("/links", h.getLinks)
@@ -67,26 +79,49 @@ func NewUserRouter(
 			profile := authed.Group("/user")
 			{
 				profile.GET("/profile", h.getUserProfile)
-				profile.PATCH("/profile", h.updateUserProfile)
-				profile.POST("/avatar", h.uploadAvatar)
+				profile.PATCH("/profile", api.ForbidImpersonation(), api.ForbidTokenAuth(), h.updateUserProfile)
+				profile.POST("/avatar", api.ForbidImpersonation(), api.ForbidTokenAuth(), h.uploadAvatar)
+
+				// Personal access tokens can not be managed with an access token.
+				tokens := profile.Group("/tokens", api.ForbidImpersonation(), api.ForbidTokenAuth())
+				tokens.GET("", h.getAccessTokens)
+				tokens.POST("", h.createAccessToken)
+				tokens.DELETE("/:id", h.deleteAccessToken)
 			}
 
 			// Contest
-			authed.POST("/contests/:id/register", h.registerForContest)
+			authed.POST("/contests/:id/register", api.ForbidImpersonation(), h.registerForContest)
 			authed.GET("/contests/:id/history", h.getContestHistory)
+			authed.GET("/contests/:id/my-rank", h.getMyRank)
 
 			// Problems & Submissions
-			authed.POST("/problems/:id/submit", h.submitToProblem)
+			authed.POST("/problems/:id/submit", api.ForbidImpersonation(), h.submitToProblem)
 			authed.GET("/problems/:id/attempts", h.getProblemAttempts)
+			authed.POST("/problems/:id/check-files", h.checkProblemFiles)
+			authed.GET("/problems/:id/queue-estimate", h.getQueueEstimate)
+
+			// Chunked uploads for large submissions
+			upload := authed.Group("/problems/:id/upload")
+			{
+				upload.POST("/init", api.ForbidImpersonation(), h.initUpload)
+				upload.GET("/:session", h.getUpload)
+				upload.DELETE("/:session", api.ForbidImpersonation(), h.abortUpload)
+				upload.PUT("/:session/chunk/:n", api.ForbidImpersonation(), h.putUploadChunk)
+				upload.POST("/:session/complete", api.ForbidImpersonation(), h.completeUpload)
+			}
 
 			submissions := authed.Group("/submissions")
 			{
 				submissions.GET("", h.getUserSubmissions)
 				submissions.GET("/:id", h.getUserSubmission)
 				submissions.GET("/:id/content", h.getUserSubmissionContent)
-				submissions.POST("/:id/interrupt", h.interruptSubmission)
+				submissions.GET("/:id/artifacts", h.getSubmissionArtifacts)
+				submissions.GET("/:id/artifacts/*path", h.downloadSubmissionArtifact)
+				submissions.POST("/:id/interrupt", api.ForbidImpersonation(), h.interruptSubmission)
+				submissions.DELETE("/:id", api.ForbidImpersonation(), h.deleteSubmission)
 				submissions.GET("/:id/queue_position", h.getSubmissionQueuePosition)
 				submissions.GET("/:id/containers/:conID/log", h.getContainerLog)
+				submissions.GET("/:id/containers/:conID/log.json", h.getContainerLogJSON)
 			}
 
 			// Authenticated assets
@@ -96,12 +131,14 @@ func NewUserRouter(
 			}
 		}
 
-		assetsAuthed := v1.Group("/assets")
-		assetsAuthed.Use(api.AssetsAuthMiddleware(cfg.Auth.JWT.Secret, db))
-		assetsAuthed.GET("/contests/:id/*assetpath", h.serveContestAsset)
-		assetsAuthed.GET("/problems/:id/*assetpath", h.serveProblemAsset)
+		assetsAuth := api.AssetsAuthMiddleware(cfg.Auth, h.assetNonces, h.assetKeys)
+		v1.GET("/assets/contests/:id/*assetpath", assetsAuth, h.serveContestAsset)
+		// Assets of publicly archived problems need no signed URL.
+		v1.GET("/assets/problems/:id/*assetpath", h.publicArchiveOr(assetsAuth), h.serveProblemAsset)
 	}
 
+	r.GET("/openapi.json", openapi.Handler(r, apiSpec))
+
 	embedui.RegisterUIHandlers(r, "user")
 
 	return r
@@ -1,19 +1,19 @@
 package user
 
 import (
-	"archive/zip"
-	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
-	"io/fs"
+	"math"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/ZJUSCT/CSOJ/internal/config"
 	"github.com/ZJUSCT/CSOJ/internal/database"
@@ -23,7 +23,6 @@ import (
 	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
@@ -37,6 +36,9 @@ type containerResponse struct {
 	ExitCode   int           `json:"exit_code"`
 	StartedAt  time.Time     `json:"started_at"`
 	FinishedAt time.Time     `json:"finished_at"`
+
+	CurrentCommand int `json:"current_command"`
+	TotalCommands  int `json:"total_commands"`
 }
 
 // submissionResponse defines the structure for a submission API response, using containerResponse.
@@ -55,18 +57,119 @@ type submissionResponse struct {
 	Score          int                 `json:"score"`
 	Performance    float64             `json:"performance"`
 	Info           models.JSONMap      `json:"info"`
+	Subtasks       models.Subtasks     `json:"subtasks"`
 	IsValid        bool                `json:"is_valid"`
+	DryRun         bool                `json:"dry_run"`
+	Practice       bool                `json:"practice"`
+	ContentHash    string              `json:"content_hash"`
+	DuplicateOf    string              `json:"duplicate_of,omitempty"`
 	Containers     []containerResponse `json:"containers"`
+	// ScoreHidden is set while the problem hides scores until the contest ends. Score,
+	// Performance and Subtasks are then zeroed and Result tells whether the submission passed.
+	ScoreHidden bool   `json:"score_hidden,omitempty"`
+	Result      string `json:"result,omitempty"`
 }
 
-func (h *Handler) submitToProblem(c *gin.Context) {
-	userID := c.GetString("userID")
-	problemID := c.Param("id")
+// listedSubmission is a submission in the user's submission list, masked like submissionResponse
+// while its problem hides scores.
+type listedSubmission struct {
+	models.Submission
+	ScoreHidden bool   `json:"score_hidden,omitempty"`
+	Result      string `json:"result,omitempty"`
+}
+
+// hasAllowedExtension reports whether the file's extension is in the allowed list, ignoring case.
+// Files without an extension are only allowed if "" is listed.
+func hasAllowedExtension(name string, allowed []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a != "" && !strings.HasPrefix(a, ".") {
+			a = "." + a
+		}
+		if a == ext {
+			return true
+		}
+	}
+	return false
+}
+
+const defaultUploadMaxDepth = 8
+
+// Archive limits applied when neither the problem, the contest nor the global config set one.
+const (
+	defaultMaxExpandedSize   = 1024 // MB
+	defaultMaxArchiveEntries = 10000
+)
+
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// validateUploadPath checks a decoded, slash-separated upload path for traversal, absolute or
+// Windows-style paths, excessive nesting and names that are unsafe to store or serve.
+func validateUploadPath(p string, maxDepth int) error {
+	if p == "" {
+		return errors.New("path is empty")
+	}
+	if len(p) > 1024 {
+		return errors.New("path is too long")
+	}
+	for _, r := range p {
+		if r == 0 || unicode.IsControl(r) {
+			return errors.New("path contains control characters")
+		}
+	}
+	if strings.Contains(p, "\\") {
+		return errors.New("backslashes are not allowed, use '/' as separator")
+	}
+	if strings.HasPrefix(p, "/") || (len(p) >= 2 && p[1] == ':') {
+		return errors.New("absolute paths are not allowed")
+	}
+
+	parts := strings.Split(path.Clean(p), "/")
+	if len(parts) > maxDepth {
+		return fmt.Errorf("path is nested deeper than %d levels", maxDepth)
+	}
+	for _, part := range parts {
+		if part == ".." {
+			return errors.New("path must not leave the submission directory")
+		}
+		if len(part) > 255 {
+			return errors.New("file name is too long")
+		}
+		if strings.HasSuffix(part, ".") || strings.HasSuffix(part, " ") {
+			return errors.New("file names must not end with a dot or space")
+		}
+		base, _, _ := strings.Cut(part, ".")
+		if windowsReservedNames[strings.ToUpper(base)] {
+			return fmt.Errorf("%q is a reserved file name", part)
+		}
+	}
+	return nil
+}
 
+// submitTarget is a problem the current user is allowed to submit to.
+type submitTarget struct {
+	user     *models.User
+	problem  *judger.Problem
+	contest  *judger.Contest
+	upload   judger.UploadLimit // problem limits with contest and global limits applied
+	dryRun   bool
+	practice bool // the contest has ended but allows practice
+}
+
+// checkSubmitAllowed verifies contest registration, time windows and the submission limit.
+// Dry runs do not count toward the limit but require dry_run_safe workflow steps. After the end
+// of a contest that allows practice, submissions are accepted as practice and do not count either.
+// It writes the error response and returns false if the user may not submit right now.
+func (h *Handler) checkSubmitAllowed(c *gin.Context, userID, problemID string, dryRun bool) (*submitTarget, bool) {
 	user, err := database.GetUserByID(h.db, userID)
 	if err != nil {
 		util.Error(c, http.StatusNotFound, err)
-		return
+		return nil, false
 	}
 
 	h.appState.RLock()
@@ -74,14 +177,14 @@ func (h *Handler) submitToProblem(c *gin.Context) {
 	if !ok {
 		h.appState.RUnlock()
 		util.Error(c, http.StatusNotFound, fmt.Errorf("problem not found"))
-		return
+		return nil, false
 	}
 
 	parentContest, ok := h.appState.ProblemToContestMap[problemID]
 	if !ok {
 		h.appState.RUnlock()
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("internal server error: problem has no parent contest"))
-		return
+		return nil, false
 	}
 
 	// Check if user is registered for the contest
@@ -89,147 +192,417 @@ func (h *Handler) submitToProblem(c *gin.Context) {
 	if err != nil {
 		h.appState.RUnlock()
 		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to check contest registration: %w", err))
-		return
+		return nil, false
 	}
 	if !isRegistered {
 		h.appState.RUnlock()
 		util.Error(c, http.StatusForbidden, fmt.Errorf("you must register for the contest before submitting"))
-		return
+		return nil, false
 	}
 
 	// Check time restrictions for submission
 	now := time.Now()
-	if now.Before(parentContest.StartTime) || now.After(parentContest.EndTime) {
+	practice := parentContest.InPractice(now)
+	if now.Before(parentContest.StartTime) || (now.After(parentContest.EndTime) && !practice) {
 		h.appState.RUnlock()
 		util.Error(c, http.StatusForbidden, fmt.Errorf("cannot submit because the contest is not active"))
-		return
+		return nil, false
 	}
-	if now.Before(problem.StartTime) || now.After(problem.EndTime) {
+	if now.Before(problem.StartTime) || (now.After(problem.EndTime) && !practice) || !parentContest.IsProblemUnlocked(problemID, now) {
 		h.appState.RUnlock()
 		util.Error(c, http.StatusForbidden, fmt.Errorf("cannot submit because the problem is not active"))
-		return
+		return nil, false
 	}
 	h.appState.RUnlock()
 
+	if dryRun && !problem.SupportsDryRun() {
+		util.Error(c, http.StatusBadRequest, fmt.Errorf("this problem does not support dry runs"))
+		return nil, false
+	}
+
+	unmet, err := h.unmetPrerequisites(userID, parentContest.ID, problem)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to check prerequisites: %w", err))
+		return nil, false
+	}
+	if len(unmet) > 0 {
+		util.Error(c, http.StatusForbidden, fmt.Errorf("unmet prerequisites: %s", strings.Join(unmet, ", ")))
+		return nil, false
+	}
+
+	if !dryRun {
+		remaining, err := h.cooldownRemaining(userID, problem)
+		if err != nil {
+			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to check submission cooldown: %w", err))
+			return nil, false
+		}
+		if remaining > 0 {
+			c.Header("Retry-After", strconv.Itoa(remaining))
+			util.Error(c, http.StatusTooManyRequests, fmt.Errorf("please wait %d seconds before submitting to this problem again", remaining))
+			return nil, false
+		}
+	}
+
+	quota, err := h.quotaStatus(userID, parentContest)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to check compute quota: %w", err))
+		return nil, false
+	}
+	if quota.Exhausted {
+		util.ErrorWithData(c, http.StatusForbidden, fmt.Errorf("your compute quota for this contest is used up"), quota)
+		return nil, false
+	}
+
 	// Check submission limit
-	if problem.MaxSubmissions > 0 {
+	if problem.MaxSubmissions > 0 && !dryRun && !practice {
 		count, err := database.GetSubmissionCount(h.db, userID, parentContest.ID, problemID)
 		if err != nil {
 			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to check submission count: %w", err))
-			return
+			return nil, false
 		}
 		if count >= problem.MaxSubmissions {
 			util.Error(c, http.StatusForbidden, fmt.Errorf("maximum submission limit of %d reached", problem.MaxSubmissions))
-			return
+			return nil, false
 		}
 	}
 
-	form, err := c.MultipartForm()
+	return &submitTarget{
+		user:     user,
+		problem:  problem,
+		contest:  parentContest,
+		upload:   judger.EffectiveUploadLimit(problem, parentContest, h.cfg.UploadLimits),
+		dryRun:   dryRun,
+		practice: practice,
+	}, true
+}
+
+// quotaStatus measures the user's compute usage in the contest against its quota.
+func (h *Handler) quotaStatus(userID string, contest *judger.Contest) (judger.QuotaStatus, error) {
+	usage, err := database.GetContestUsage(h.db, userID, contest.ID)
 	if err != nil {
-		util.Error(c, http.StatusBadRequest, err)
-		return
+		return judger.QuotaStatus{}, err
 	}
-	files := form.File["files"]
+	pending, err := database.CountActiveSubmissions(h.db, userID, contest.ProblemIDs)
+	if err != nil {
+		return judger.QuotaStatus{}, err
+	}
+	return contest.QuotaStatus(usage, pending), nil
+}
 
-	if problem.Upload.MaxNum > 0 && len(files) > problem.Upload.MaxNum {
-		msg := fmt.Sprintf("too many files uploaded. The maximum is %d, but you provided %d", problem.Upload.MaxNum, len(files))
-		util.Error(c, http.StatusBadRequest, msg)
-		return
+// cooldownRemaining returns the whole seconds left until the user may submit to the problem
+// again, 0 if the problem has no cooldown or it has elapsed.
+func (h *Handler) cooldownRemaining(userID string, problem *judger.Problem) (int, error) {
+	if problem.CooldownSeconds <= 0 {
+		return 0, nil
+	}
+	last, err := database.GetLastSubmissionTime(h.db, userID, problem.ID)
+	if err != nil || last.IsZero() {
+		return 0, err
+	}
+	remaining := time.Until(last.Add(time.Duration(problem.CooldownSeconds) * time.Second))
+	if remaining <= 0 {
+		return 0, nil
+	}
+	return int(math.Ceil(remaining.Seconds())), nil
+}
+
+// validateUploadFiles checks the decoded file names and total size of an upload against the
+// effective upload limits and returns the cleaned relative paths, or an HTTP status and error.
+func validateUploadFiles(limit judger.UploadLimit, names []string, totalSize int64) ([]string, int, error) {
+	if limit.MaxNum > 0 && len(names) > limit.MaxNum {
+		return nil, http.StatusBadRequest, fmt.Errorf("too many files uploaded. The maximum is %d, but you provided %d", limit.MaxNum, len(names))
+	}
+	if limit.MaxSize > 0 {
+		maxSizeBytes := int64(limit.MaxSize) * 1024 * 1024
+		if totalSize > maxSizeBytes {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("total file size exceeds the limit of %d MB", limit.MaxSize)
+		}
 	}
 
-	if problem.Upload.MaxSize > 0 {
-		var totalSize int64
-		for _, file := range files {
-			totalSize += file.Size
+	maxDepth := limit.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultUploadMaxDepth
+	}
+	relativePaths := make([]string, len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		if err := validateUploadPath(name, maxDepth); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid file path %q: %v", name, err)
+		}
+		if len(limit.AllowedExtensions) > 0 && !hasAllowedExtension(name, limit.AllowedExtensions) {
+			return nil, http.StatusBadRequest, fmt.Errorf("file type not allowed: %s", name)
+		}
+		relativePaths[i] = filepath.Clean(filepath.FromSlash(name))
+		if seen[relativePaths[i]] {
+			return nil, http.StatusBadRequest, fmt.Errorf("duplicate file path: %s", name)
 		}
+		seen[relativePaths[i]] = true
+	}
+	return relativePaths, 0, nil
+}
 
-		maxSizeBytes := int64(problem.Upload.MaxSize) * 1024 * 1024
-		if totalSize > maxSizeBytes {
-			msg := fmt.Sprintf("total file size exceeds the limit of %d MB", problem.Upload.MaxSize)
-			util.Error(c, http.StatusRequestEntityTooLarge, msg)
-			return
+// requiredFilesReport lists the required files an upload lacks: entries of the problem's
+// editor_files and upload_files matched by no file, or only by empty ones.
+type requiredFilesReport struct {
+	Missing []string `json:"missing"`
+	Empty   []string `json:"empty"`
+}
+
+// checkRequiredFiles checks the cleaned relative paths and sizes of an upload against the files
+// the problem declares in editor_files and upload_files. Problems that declare neither, or set
+// allow_incomplete_files, accept every upload.
+func checkRequiredFiles(limit judger.UploadLimit, relativePaths []string, sizes []int64) requiredFilesReport {
+	report := requiredFilesReport{Missing: []string{}, Empty: []string{}}
+	if limit.AllowIncompleteFiles {
+		return report
+	}
+	seen := make(map[string]bool)
+	for _, pattern := range append(append([]string(nil), limit.EditorFiles...), limit.UploadFiles...) {
+		if seen[pattern] {
+			continue
+		}
+		seen[pattern] = true
+		matched, nonEmpty := false, false
+		for i, relativePath := range relativePaths {
+			if m, _ := filepath.Match(filepath.FromSlash(pattern), relativePath); m {
+				matched = true
+				nonEmpty = nonEmpty || sizes[i] > 0
+			}
+		}
+		if !matched {
+			report.Missing = append(report.Missing, pattern)
+		} else if !nonEmpty {
+			report.Empty = append(report.Empty, pattern)
 		}
 	}
+	return report
+}
 
-	submissionID := uuid.New().String()
-	submissionPath := filepath.Join(h.cfg.Storage.SubmissionContent, submissionID)
-	if err := os.MkdirAll(submissionPath, 0755); err != nil {
-		util.Error(c, http.StatusInternalServerError, err)
+// rejectIncompleteUpload writes the error response and returns true if the upload lacks a file
+// the problem requires, so no judge slot is spent on it.
+func rejectIncompleteUpload(c *gin.Context, limit judger.UploadLimit, relativePaths []string, sizes []int64) bool {
+	report := checkRequiredFiles(limit, relativePaths, sizes)
+	if len(report.Missing) == 0 && len(report.Empty) == 0 {
+		return false
+	}
+	util.ErrorWithData(c, http.StatusBadRequest, "the submission lacks required files", report)
+	return true
+}
+
+// fileCheckResponse is the result of checking an upload before submitting it.
+type fileCheckResponse struct {
+	OK bool `json:"ok"`
+	requiredFilesReport
+	Disallowed []string `json:"disallowed"` // files outside the problem's upload_files patterns
+}
+
+// checkProblemFiles checks declared file paths and sizes against a problem's upload limits and
+// required files without submitting anything, so clients can point out an incomplete submission
+// before it is uploaded. Disallowed files are only reported here, not punished.
+func (h *Handler) checkProblemFiles(c *gin.Context) {
+	var req struct {
+		Files []uploadFile `json:"files" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
 		return
 	}
 
-	for _, file := range files {
-		rawBytes, err := base64.StdEncoding.DecodeString(file.Filename)
-		var relativePath string
-		if err == nil {
-			relativePath = filepath.Clean(string(rawBytes))
-		} else {
-			util.Error(c, http.StatusBadRequest, fmt.Sprintf("failed to decode file path: %s", file.Filename))
+	problemID := c.Param("id")
+	now := time.Now()
+	h.appState.RLock()
+	problem, ok := h.appState.Problems[problemID]
+	contest, contestOk := h.appState.ProblemToContestMap[problemID]
+	visible := ok && contestOk && !now.Before(contest.StartTime) && contest.IsProblemUnlocked(problemID, now)
+	var limit judger.UploadLimit
+	if visible {
+		limit = judger.EffectiveUploadLimit(problem, contest, h.cfg.UploadLimits)
+	}
+	h.appState.RUnlock()
+	if !visible {
+		util.Error(c, http.StatusNotFound, "problem not found")
+		return
+	}
+
+	names := make([]string, len(req.Files))
+	sizes := make([]int64, len(req.Files))
+	var totalSize int64
+	for i, file := range req.Files {
+		if file.Size < 0 {
+			util.Error(c, http.StatusBadRequest, fmt.Sprintf("invalid size for file %q", file.Path))
+			return
 		}
+		names[i] = file.Path
+		sizes[i] = file.Size
+		totalSize += file.Size
+	}
+	relativePaths, status, err := validateUploadFiles(limit, names, totalSize)
+	if err != nil {
+		util.Error(c, status, err)
+		return
+	}
 
-		// Backend validation against allowed file patterns from problem.yaml
-		if len(problem.Upload.UploadFiles) > 0 {
-			matched := false
-			for _, pattern := range problem.Upload.UploadFiles {
+	resp := fileCheckResponse{requiredFilesReport: checkRequiredFiles(limit, relativePaths, sizes), Disallowed: []string{}}
+	if len(limit.UploadFiles) > 0 {
+		for _, relativePath := range relativePaths {
+			allowed := false
+			for _, pattern := range limit.UploadFiles {
 				if m, _ := filepath.Match(pattern, relativePath); m {
-					matched = true
+					allowed = true
 					break
 				}
 			}
-			if !matched {
-				// Ban the user for 24 hours for submitting a disallowed file
-				banUntil := time.Now().Add(24 * time.Hour)
-				user.BannedUntil = &banUntil
-				user.BanReason = "Hacking Detected"
-				if err := database.UpdateUser(h.db, user); err != nil {
-					util.Error(c, http.StatusInternalServerError, err)
-					return
-				}
-				zap.S().Warnf("user %s (%s) auto-banned for 24 hours for uploading disallowed file: %s", user.Username, user.ID, relativePath)
-				util.Error(c, http.StatusForbidden, "Your account has been temporarily banned due to suspicious activity.")
-				return
+			if !allowed {
+				resp.Disallowed = append(resp.Disallowed, filepath.ToSlash(relativePath))
 			}
 		}
+	}
+	resp.OK = len(resp.Missing) == 0 && len(resp.Empty) == 0 && len(resp.Disallowed) == 0
+	util.Success(c, resp, "Files checked")
+}
 
-		if filepath.IsAbs(relativePath) || strings.HasPrefix(relativePath, "..") {
-			util.Error(c, http.StatusBadRequest, fmt.Sprintf("invalid file path: %s", file.Filename))
-			return
+// checkArchives inspects the uploaded zip, tar and gzip files of a submission and rejects it if,
+// together, they would expand beyond the problem's archive limits. Build steps that unpack them
+// would otherwise fill the node's disk from a small upload.
+func checkArchives(limit judger.UploadLimit, submissionPath string, relativePaths []string) (int, error) {
+	maxExpanded := int64(limit.MaxExpandedSize)
+	if maxExpanded <= 0 {
+		maxExpanded = defaultMaxExpandedSize
+	}
+	maxExpanded *= 1024 * 1024
+	maxEntries := limit.MaxArchiveEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxArchiveEntries
+	}
+
+	var expanded int64
+	entries := 0
+	for _, relativePath := range relativePaths {
+		path, err := submissionFilePath(submissionPath, relativePath)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		stats, ok, err := util.InspectArchive(path, maxExpanded-expanded)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("%s: %v", filepath.ToSlash(relativePath), err)
+		}
+		if !ok {
+			continue
+		}
+		expanded += stats.ExpandedSize
+		entries += stats.Entries
+		if expanded > maxExpanded {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("archive %s expands beyond the limit of %d MB for uncompressed archive contents",
+				filepath.ToSlash(relativePath), maxExpanded/(1024*1024))
+		}
+		if entries > maxEntries {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("archive %s contains too many entries, at most %d archive entries are allowed",
+				filepath.ToSlash(relativePath), maxEntries)
+		}
+	}
+	return 0, nil
+}
+
+// checkUploadPatterns validates the paths against the allowed file patterns from problem.yaml.
+// Uploading a file outside of them bans the user for 24 hours.
+func (h *Handler) checkUploadPatterns(c *gin.Context, user *models.User, problem *judger.Problem, relativePaths []string) bool {
+	if len(problem.Upload.UploadFiles) == 0 {
+		return true
+	}
+	for _, relativePath := range relativePaths {
+		matched := false
+		for _, pattern := range problem.Upload.UploadFiles {
+			if m, _ := filepath.Match(pattern, relativePath); m {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
 		}
 
-		dst := filepath.Join(submissionPath, relativePath)
+		// Ban the user for 24 hours for submitting a disallowed file
+		banUntil := time.Now().Add(24 * time.Hour)
+		user.BannedUntil = &banUntil
+		user.BanReason = "Hacking Detected"
+		if err := database.UpdateUser(h.db, user); err != nil {
+			util.Error(c, http.StatusInternalServerError, err)
+			return false
+		}
+		util.Logger(c).Warnf("user %s (%s) auto-banned for 24 hours for uploading disallowed file: %s", user.Username, user.ID, relativePath)
+		util.Error(c, http.StatusForbidden, "Your account has been temporarily banned due to suspicious activity.")
+		return false
+	}
+	return true
+}
 
-		dst = filepath.Clean(dst)
+// submissionFilePath joins a validated relative path onto the submission directory.
+func submissionFilePath(submissionPath, relativePath string) (string, error) {
+	if filepath.IsAbs(relativePath) || strings.HasPrefix(relativePath, "..") {
+		return "", fmt.Errorf("invalid file path: %s", relativePath)
+	}
+	dst := filepath.Clean(filepath.Join(submissionPath, relativePath))
+	if !strings.HasPrefix(dst, submissionPath) {
+		return "", fmt.Errorf("invalid file path after join: %s", relativePath)
+	}
+	return dst, nil
+}
 
-		if !strings.HasPrefix(dst, submissionPath) {
-			util.Error(c, http.StatusBadRequest, fmt.Sprintf("invalid file path after join: %s", file.Filename))
+// createSubmission records a submission whose content is already stored and queues it.
+// Dry runs and practice submissions are stored as invalid so they never reach scores or the
+// leaderboard. A resubmission of the user's earlier files is recorded as a duplicate, or
+// rejected with its content removed if the problem refuses duplicates.
+func (h *Handler) createSubmission(c *gin.Context, target *submitTarget, submissionID string) {
+	submissionPath := filepath.Join(h.cfg.Storage.SubmissionContent, submissionID)
+	contentHash, err := util.HashDirectory(submissionPath)
+	if err != nil {
+		os.RemoveAll(submissionPath)
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to hash submission content: %w", err))
+		return
+	}
+	var duplicateOf string
+	if !target.dryRun {
+		duplicateOf, err = database.FindDuplicateSubmission(h.db, target.user.ID, target.problem.ID, contentHash)
+		if err != nil {
+			os.RemoveAll(submissionPath)
+			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to check for duplicate submissions: %w", err))
 			return
 		}
-
-		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to create directory: %w", err))
+		if duplicateOf != "" && target.problem.Duplicates == judger.DuplicatesReject {
+			os.RemoveAll(submissionPath)
+			util.ErrorWithData(c, http.StatusConflict, "these files are identical to one of your earlier submissions", gin.H{"duplicate_of": duplicateOf})
 			return
 		}
-
-		if err := c.SaveUploadedFile(file, dst); err != nil {
-			util.Error(c, http.StatusInternalServerError, err)
+	}
+	if h.cfg.Storage.CompressContent {
+		if err := util.CompressContent(h.cfg.Storage.SubmissionContent, submissionID); err != nil {
+			util.RemoveContent(h.cfg.Storage.SubmissionContent, submissionID)
+			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to compress submission content: %w", err))
 			return
 		}
 	}
 
 	sub := models.Submission{
-		ID:        submissionID,
-		ProblemID: problemID,
-		UserID:    user.ID,
-		Status:    models.StatusQueued,
-		Cluster:   problem.Cluster,
-		IsValid:   true,
+		ID:          submissionID,
+		ProblemID:   target.problem.ID,
+		UserID:      target.user.ID,
+		Status:      models.StatusQueued,
+		Cluster:     target.problem.Cluster,
+		IsValid:     !target.dryRun && !target.practice,
+		DryRun:      target.dryRun,
+		Practice:    target.practice,
+		ContentHash: contentHash,
+		DuplicateOf: duplicateOf,
 	}
 
 	err = h.db.Transaction(func(tx *gorm.DB) error {
 		if err := database.CreateSubmission(tx, &sub); err != nil {
 			return err
 		}
-		return database.IncrementSubmissionCount(tx, user.ID, parentContest.ID, problemID)
+		if target.dryRun || target.practice {
+			return nil
+		}
+		return database.IncrementSubmissionCount(tx, target.user.ID, target.contest.ID, target.problem.ID)
 	})
 
 	if err != nil {
@@ -237,10 +610,88 @@ func (h *Handler) submitToProblem(c *gin.Context) {
 		return
 	}
 
-	h.scheduler.Submit(&sub, problem)
+	h.scheduler.Submit(&sub, target.problem)
+	if target.dryRun {
+		util.Success(c, gin.H{"submission_id": submissionID, "dry_run": true}, "Dry run submission received")
+		return
+	}
+	if target.practice {
+		util.Success(c, gin.H{"submission_id": submissionID, "practice": true}, "Practice submission received, it will not be scored")
+		return
+	}
 	util.Success(c, gin.H{"submission_id": submissionID}, "Submission received")
 }
 
+func (h *Handler) submitToProblem(c *gin.Context) {
+	target, ok := h.checkSubmitAllowed(c, c.GetString("userID"), c.Param("id"), c.Query("dry_run") == "true")
+	if !ok {
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	files := form.File["files"]
+
+	names := make([]string, len(files))
+	sizes := make([]int64, len(files))
+	var totalSize int64
+	for i, file := range files {
+		rawBytes, err := base64.StdEncoding.DecodeString(file.Filename)
+		if err != nil {
+			util.Error(c, http.StatusBadRequest, fmt.Sprintf("failed to decode file path: %s", file.Filename))
+			return
+		}
+		names[i] = string(rawBytes)
+		sizes[i] = file.Size
+		totalSize += file.Size
+	}
+	relativePaths, status, err := validateUploadFiles(target.upload, names, totalSize)
+	if err != nil {
+		util.Error(c, status, err)
+		return
+	}
+	if !h.checkUploadPatterns(c, target.user, target.problem, relativePaths) {
+		return
+	}
+	if rejectIncompleteUpload(c, target.upload, relativePaths, sizes) {
+		return
+	}
+
+	submissionID := uuid.New().String()
+	submissionPath := filepath.Join(h.cfg.Storage.SubmissionContent, submissionID)
+	if err := os.MkdirAll(submissionPath, 0755); err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	for i, file := range files {
+		dst, err := submissionFilePath(submissionPath, relativePaths[i])
+		if err != nil {
+			util.Error(c, http.StatusBadRequest, err)
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to create directory: %w", err))
+			return
+		}
+		if err := c.SaveUploadedFile(file, dst); err != nil {
+			util.Error(c, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if status, err := checkArchives(target.upload, submissionPath, relativePaths); err != nil {
+		os.RemoveAll(submissionPath)
+		util.Logger(c).Warnf("rejected upload to problem %s: %v", target.problem.ID, err)
+		util.Error(c, status, err)
+		return
+	}
+
+	h.createSubmission(c, target, submissionID)
+}
+
 func (h *Handler) getProblemAttempts(c *gin.Context) {
 	userID := c.GetString("userID")
 	problemID := c.Param("id")
@@ -266,13 +717,31 @@ func (h *Handler) getProblemAttempts(c *gin.Context) {
 		return
 	}
 
+	cooldown, err := h.cooldownRemaining(userID, problem)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to check submission cooldown: %w", err))
+		return
+	}
+
 	type AttemptsResponse struct {
-		Limit     *int `json:"limit"`
-		Used      int  `json:"used"`
-		Remaining *int `json:"remaining"`
+		Limit             *int               `json:"limit"`
+		Used              int                `json:"used"`
+		Remaining         *int               `json:"remaining"`
+		CooldownRemaining int                `json:"cooldown_remaining"` // seconds until the next submission is allowed
+		ScoresHidden      bool               `json:"scores_hidden"`      // scores of the problem are hidden until the contest ends
+		Quota             judger.QuotaStatus `json:"quota"`              // compute used in the contest, see the contest's quota
+	}
+
+	quota, err := h.quotaStatus(userID, parentContest)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to check compute quota: %w", err))
+		return
 	}
 
-	resp := AttemptsResponse{Used: usedCount}
+	resp := AttemptsResponse{Used: usedCount, CooldownRemaining: cooldown, Quota: quota}
+	h.appState.RLock()
+	resp.ScoresHidden = parentContest.ScoresHidden(problem, time.Now())
+	h.appState.RUnlock()
 
 	if problem.MaxSubmissions > 0 {
 		limit := problem.MaxSubmissions
@@ -299,7 +768,22 @@ func (h *Handler) getUserSubmissions(c *gin.Context) {
 		util.Error(c, http.StatusInternalServerError, err)
 		return
 	}
-	util.Success(c, subs, "ok")
+	now := time.Now()
+	hidden := make(map[string]bool)
+	resp := make([]listedSubmission, len(subs))
+	for i, sub := range subs {
+		resp[i] = listedSubmission{Submission: sub}
+		masked, seen := hidden[sub.ProblemID]
+		if !seen {
+			masked = h.scoresHidden(sub.ProblemID, now)
+			hidden[sub.ProblemID] = masked
+		}
+		if masked {
+			resp[i].ScoreHidden = true
+			resp[i].Result = hideScore(&resp[i].Submission)
+		}
+	}
+	util.Success(c, resp, "ok")
 }
 
 func (h *Handler) getUserSubmission(c *gin.Context) {
@@ -320,6 +804,12 @@ func (h *Handler) getUserSubmission(c *gin.Context) {
 		return
 	}
 
+	scoreHidden := h.scoresHidden(sub.ProblemID, time.Now())
+	result := ""
+	if scoreHidden {
+		result = hideScore(sub)
+	}
+
 	// Build custom response to hide certain container fields
 	respContainers := make([]containerResponse, len(sub.Containers))
 	for i, cont := range sub.Containers {
@@ -331,6 +821,9 @@ func (h *Handler) getUserSubmission(c *gin.Context) {
 			ExitCode:   cont.ExitCode,
 			StartedAt:  cont.StartedAt,
 			FinishedAt: cont.FinishedAt,
+
+			CurrentCommand: cont.CurrentCommand,
+			TotalCommands:  cont.TotalCommands,
 		}
 	}
 
@@ -349,8 +842,15 @@ func (h *Handler) getUserSubmission(c *gin.Context) {
 		Score:          sub.Score,
 		Performance:    sub.Performance,
 		Info:           sub.Info,
+		Subtasks:       sub.Subtasks,
 		IsValid:        sub.IsValid,
+		DryRun:         sub.DryRun,
+		Practice:       sub.Practice,
+		ContentHash:    sub.ContentHash,
+		DuplicateOf:    sub.DuplicateOf,
 		Containers:     respContainers,
+		ScoreHidden:    scoreHidden,
+		Result:         result,
 	}
 	util.Success(c, resp, "ok")
 }
@@ -391,17 +891,10 @@ func (h *Handler) interruptSubmission(c *gin.Context) {
 		msg := pubsub.FormatMessage("error", "Submission interrupted by user.")
 		pubsub.GetBroker().Publish(subID, msg)
 		pubsub.GetBroker().CloseTopic(subID)
+		h.scheduler.RunPostSubmissionHook(sub)
 		util.Success(c, nil, "Queued submission interrupted")
 
 	case models.StatusRunning:
-		h.appState.RLock()
-		problem, ok := h.appState.Problems[sub.ProblemID]
-		h.appState.RUnlock()
-		if !ok {
-			util.Error(c, http.StatusInternalServerError, "Problem definition not found for running submission")
-			return
-		}
-
 		var dockerCfg config.DockerConfig
 		var nodeCfgFound bool
 		for _, clusterCfg := range h.cfg.Cluster {
@@ -418,16 +911,16 @@ func (h *Handler) interruptSubmission(c *gin.Context) {
 		}
 
 		if !nodeCfgFound {
-			zap.S().Errorf("node config '%s'/'%s' not found for sub %s, cannot stop container but will mark as failed", sub.Cluster, sub.Node, sub.ID)
+			util.Logger(c).Errorf("node config '%s'/'%s' not found for sub %s, cannot stop container but will mark as failed", sub.Cluster, sub.Node, sub.ID)
 		} else {
-			docker, err := judger.NewDockerManager(dockerCfg)
+			docker, err := judger.GetDockerManager(dockerCfg)
 			if err != nil {
 				util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to connect to docker on node %s: %w", sub.Node, err))
 				return
 			}
 			for _, container := range sub.Containers {
 				if container.DockerID != "" {
-					zap.S().Infof("forcefully cleaning up container %s for submission %s", container.DockerID, sub.ID)
+					util.Logger(c).Infof("forcefully cleaning up container %s for submission %s", container.DockerID, sub.ID)
 					docker.CleanupContainer(container.DockerID)
 				}
 			}
@@ -447,22 +940,15 @@ func (h *Handler) interruptSubmission(c *gin.Context) {
 			return
 		}
 
-		// Parse allocated cores from submission record to release them
-		var coresToRelease []int
-		if sub.AllocatedCores != "" {
-			coreStrs := strings.Split(sub.AllocatedCores, ",")
-			for _, s := range coreStrs {
-				coreID, err := strconv.Atoi(s)
-				if err == nil {
-					coresToRelease = append(coresToRelease, coreID)
-				}
-			}
-		}
-		h.scheduler.ReleaseResources(problem.Cluster, sub.Node, coresToRelease, problem.Memory)
+		h.scheduler.ReleaseResources(sub.ID)
 
 		msg := pubsub.FormatMessage("error", "Submission interrupted by user.")
 		pubsub.GetBroker().Publish(subID, msg)
 		pubsub.GetBroker().CloseTopic(subID)
+
+		sub.Status = models.StatusFailed
+		sub.Info = models.JSONMap{"error": "Interrupted by user while running"}
+		h.scheduler.RunPostSubmissionHook(sub)
 		util.Success(c, nil, "Running submission interrupted successfully")
 
 	case models.StatusSuccess, models.StatusFailed:
@@ -473,6 +959,87 @@ func (h *Handler) interruptSubmission(c *gin.Context) {
 	}
 }
 
+// deleteSubmission withdraws a queued submission or moves a finished one of the current user to
+// the recycle bin, where administrators can restore it until it is purged. Running submissions
+// must be interrupted first, and a submission that holds one of the user's best scores is kept so
+// scores are not affected. The attempt is not refunded.
+func (h *Handler) deleteSubmission(c *gin.Context) {
+	subID := c.Param("id")
+	userID := c.GetString("userID")
+
+	sub, err := database.GetSubmission(h.db, subID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.Error(c, http.StatusNotFound, "Submission not found")
+			return
+		}
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	if sub.UserID != userID {
+		util.Error(c, http.StatusForbidden, "You can only delete your own submissions")
+		return
+	}
+
+	switch sub.Status {
+	case models.StatusRunning:
+		util.Error(c, http.StatusConflict, "Submission is running, interrupt it first")
+		return
+	case models.StatusQueued:
+		// The row goes first: the scheduler only starts a submission that is still queued in the
+		// database, so once it is gone the job cannot start even if a worker has already picked it.
+		deleted, err := database.DeleteQueuedSubmission(h.db, sub.ID)
+		if err != nil {
+			util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to delete submission: %w", err))
+			return
+		}
+		if !deleted {
+			util.Error(c, http.StatusConflict, "Submission is starting, interrupt it first")
+			return
+		}
+		h.scheduler.RemoveQueued(sub.ID)
+		pubsub.GetBroker().Publish(sub.ID, pubsub.FormatMessage("error", "Submission withdrawn by user."))
+		pubsub.GetBroker().CloseTopic(sub.ID)
+		h.scheduler.PublishQueuePositions(sub.Cluster)
+
+		if err := util.RemoveContent(h.cfg.Storage.SubmissionContent, sub.ID); err != nil {
+			util.Logger(c).Errorf("failed to delete content of submission %s: %v", sub.ID, err)
+		}
+		util.Logger(c).Infof("user withdrew queued submission %s", sub.ID)
+		util.Success(c, nil, "Submission deleted")
+		return
+	}
+
+	var bestScores int64
+	if err := h.db.Model(&models.UserProblemBestScore{}).Where("submission_id = ?", sub.ID).Count(&bestScores).Error; err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	if bestScores > 0 {
+		util.Error(c, http.StatusConflict, "This submission holds your best score and cannot be deleted")
+		return
+	}
+
+	moved, err := util.MoveContent(h.cfg.Storage.SubmissionContent, h.cfg.Storage.RecycleBinPath(), sub.ID)
+	if err != nil {
+		util.Logger(c).Errorf("failed to move content of submission %s to the recycle bin: %v", sub.ID, err)
+		util.Error(c, http.StatusInternalServerError, "failed to delete submission content")
+		return
+	}
+	if err := database.SoftDeleteSubmission(h.db, sub.ID); err != nil {
+		if moved {
+			if _, err := util.MoveContent(h.cfg.Storage.RecycleBinPath(), h.cfg.Storage.SubmissionContent, sub.ID); err != nil {
+				util.Logger(c).Errorf("failed to move content of submission %s back from the recycle bin: %v", sub.ID, err)
+			}
+		}
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to delete submission: %w", err))
+		return
+	}
+
+	util.Logger(c).Infof("user moved submission %s (%s) to the recycle bin", sub.ID, sub.Status)
+	util.Success(c, nil, "Submission deleted")
+}
+
 func (h *Handler) getSubmissionQueuePosition(c *gin.Context) {
 	subID := c.Param("id")
 	userID := c.GetString("userID")
@@ -508,7 +1075,9 @@ func (h *Handler) getSubmissionQueuePosition(c *gin.Context) {
 	util.Success(c, gin.H{"position": count}, "Queue position retrieved successfully")
 }
 
-func (h *Handler) getContainerLog(c *gin.Context) {
+// visibleContainer looks up a container of one of the user's own submissions whose workflow
+// step is marked `show`. It writes the error response and returns false otherwise.
+func (h *Handler) visibleContainer(c *gin.Context) (*models.Container, bool) {
 	subID := c.Param("id")
 	conID := c.Param("conID")
 	userID := c.GetString("userID")
@@ -516,19 +1085,19 @@ func (h *Handler) getContainerLog(c *gin.Context) {
 	_, err := database.GetUserByID(h.db, userID)
 	if err != nil {
 		util.Error(c, http.StatusNotFound, "user not found")
-		return
+		return nil, false
 	}
 
 	sub, err := database.GetSubmission(h.db, subID)
 	if err != nil {
 		util.Error(c, http.StatusNotFound, "submission not found")
-		return
+		return nil, false
 	}
 
 	// Authorization Check : Ownership
 	if sub.UserID != userID {
 		util.Error(c, http.StatusForbidden, "you can only view your own submissions")
-		return
+		return nil, false
 	}
 
 	var targetContainer *models.Container
@@ -547,36 +1116,91 @@ func (h *Handler) getContainerLog(c *gin.Context) {
 
 	if targetContainer == nil {
 		util.Error(c, http.StatusNotFound, "container not found in this submission")
-		return
+		return nil, false
 	}
 
 	h.appState.RLock()
 	problem, ok := h.appState.Problems[sub.ProblemID]
+	contest, contestOk := h.appState.ProblemToContestMap[sub.ProblemID]
 	h.appState.RUnlock()
-	if !ok {
+	if !ok || !contestOk {
 		util.Error(c, http.StatusInternalServerError, "problem definition not found")
-		return
+		return nil, false
 	}
 
-	// Authorization Check : `show` flag in problem.yaml
-	if containerIndex >= len(problem.Workflow) || !problem.Workflow[containerIndex].Show {
+	// Authorization Check : `show` flag in problem.yaml, or reveal_logs_after_end once the contest is over
+	if !contest.StepLogVisible(problem, containerIndex, time.Now()) {
 		util.Error(c, http.StatusForbidden, "you are not allowed to view the log for this step")
-		return
+		return nil, false
 	}
+	return targetContainer, true
+}
 
-	file, err := os.Open(targetContainer.LogFilePath)
+// openContainerLog opens the stored log of a container, writing the error response on failure.
+func openContainerLog(c *gin.Context, con *models.Container) (*os.File, bool) {
+	file, err := os.Open(con.LogFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			util.Error(c, http.StatusNotFound, "log file not found on disk")
-			return
+			return nil, false
 		}
 		util.Error(c, http.StatusInternalServerError, "failed to open log file")
+		return nil, false
+	}
+	return file, true
+}
+
+func (h *Handler) getContainerLog(c *gin.Context) {
+	stripANSI, err := pubsub.ParseANSIMode(c.Query("ansi"))
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	targetContainer, ok := h.visibleContainer(c)
+	if !ok {
+		return
+	}
+
+	file, ok := openContainerLog(c, targetContainer)
+	if !ok {
 		return
 	}
 	defer file.Close()
 
 	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
-	io.Copy(c.Writer, file)
+	pubsub.CopyLog(c.Writer, file, stripANSI)
+}
+
+// getContainerLogJSON returns the stored log as an array of {stream, data, ts} entries,
+// with consecutive output of the same stream merged.
+func (h *Handler) getContainerLogJSON(c *gin.Context) {
+	stripANSI, err := pubsub.ParseANSIMode(c.Query("ansi"))
+	if err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	targetContainer, ok := h.visibleContainer(c)
+	if !ok {
+		return
+	}
+
+	file, ok := openContainerLog(c, targetContainer)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	entries, err := pubsub.ParseLog(file)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to read log file: %w", err))
+		return
+	}
+	if stripANSI {
+		for i := range entries {
+			entries[i].Data = pubsub.StripANSI(entries[i].Data)
+		}
+	}
+	util.Success(c, entries, "Container log retrieved")
 }
 
 func (h *Handler) getUserSubmissionContent(c *gin.Context) {
@@ -601,78 +1225,65 @@ func (h *Handler) getUserSubmissionContent(c *gin.Context) {
 		return
 	}
 
-	submissionPath := filepath.Join(h.cfg.Storage.SubmissionContent, subID)
-
-	// Check if the directory exists
-	info, err := os.Stat(submissionPath)
-	if os.IsNotExist(err) || !info.IsDir() {
-		util.Error(c, http.StatusNotFound, "submission content not found on disk")
-		return
-	}
-
-	// Create a buffer to write our archive to.
-	buf := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(buf)
-
-	// Walk the directory and add files to the zip.
-	err = filepath.Walk(submissionPath, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Create a proper zip header
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
-
-		// Update the header name to be relative to the submission directory
-		relPath, err := filepath.Rel(submissionPath, path)
-		if err != nil {
-			return err
-		}
-		header.Name = filepath.ToSlash(relPath) // Use forward slashes in zip
-
-		// If it's a directory, just create the header
-		if info.IsDir() {
-			header.Name += "/"
+	submissionPath, cleanup, err := util.OpenContent(h.cfg.Storage.SubmissionContent, subID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			util.Error(c, http.StatusNotFound, "submission content not found on disk")
 		} else {
-			// Set compression method
-			header.Method = zip.Deflate
+			util.Error(c, http.StatusInternalServerError, err)
 		}
+		return
+	}
+	defer cleanup()
 
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
-		}
+	util.ServeDirectoryArchive(c, submissionPath, "submission_"+subID, c.Query("format"))
+}
 
-		// If it's a file, write its content to the zip
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	})
+// ownSubmission loads the submission named in the route, writing the error response unless it
+// belongs to the current user.
+func (h *Handler) ownSubmission(c *gin.Context) (*models.Submission, bool) {
+	sub, err := database.GetSubmission(h.db, c.Param("id"))
+	if err != nil {
+		util.Error(c, http.StatusNotFound, "submission not found")
+		return nil, false
+	}
+	if sub.UserID != c.GetString("userID") {
+		util.Error(c, http.StatusForbidden, "you can only view your own submissions")
+		return nil, false
+	}
+	return sub, true
+}
 
+// getSubmissionArtifacts lists the files the judge left for the submitter.
+func (h *Handler) getSubmissionArtifacts(c *gin.Context) {
+	sub, ok := h.ownSubmission(c)
+	if !ok {
+		return
+	}
+	artifacts, err := judger.ListArtifacts(filepath.Join(h.cfg.Storage.ArtifactsPath(), sub.ID))
 	if err != nil {
-		zap.S().Errorf("failed to create zip archive for submission %s: %v", subID, err)
-		util.Error(c, http.StatusInternalServerError, "failed to create zip archive")
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to list artifacts: %w", err))
 		return
 	}
+	util.Success(c, artifacts, "Artifacts retrieved")
+}
 
-	// Close the zip writer to finalize the archive
-	zipWriter.Close()
-
-	// Set headers for file download
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Type", "application/zip")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"submission_%s.zip\"", subID))
-	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+// downloadSubmissionArtifact serves one of a submission's artifacts by its listed path.
+func (h *Handler) downloadSubmissionArtifact(c *gin.Context) {
+	sub, ok := h.ownSubmission(c)
+	if !ok {
+		return
+	}
+	rel := strings.TrimPrefix(c.Param("path"), "/")
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		util.Error(c, http.StatusBadRequest, "invalid artifact path")
+		return
+	}
+	artifactPath := filepath.Join(h.cfg.Storage.ArtifactsPath(), sub.ID, filepath.FromSlash(rel))
+	info, err := os.Lstat(artifactPath)
+	if err != nil || !info.Mode().IsRegular() {
+		util.Error(c, http.StatusNotFound, "artifact not found")
+		return
+	}
+	c.FileAttachment(artifactPath, filepath.Base(artifactPath))
 }
@@ -0,0 +1,57 @@
+package user
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ZJUSCT/CSOJ/internal/judger"
+)
+
+func TestCheckRequiredFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   judger.UploadLimit
+		paths   []string
+		sizes   []int64
+		missing []string
+		empty   []string
+	}{
+		{
+			name:  "no lists declared",
+			paths: []string{"a.txt"}, sizes: []int64{0},
+			missing: []string{}, empty: []string{},
+		},
+		{
+			name:  "complete upload",
+			limit: judger.UploadLimit{EditorFiles: []string{"main.c"}, UploadFiles: []string{"*.h"}},
+			paths: []string{"main.c", "util.h"}, sizes: []int64{10, 5},
+			missing: []string{}, empty: []string{},
+		},
+		{
+			name:  "missing and empty files",
+			limit: judger.UploadLimit{EditorFiles: []string{"main.c", "Makefile"}, UploadFiles: []string{"*.h"}},
+			paths: []string{"main.c", "util.h"}, sizes: []int64{0, 5},
+			missing: []string{"Makefile"}, empty: []string{"main.c"},
+		},
+		{
+			name:  "one non-empty match is enough",
+			limit: judger.UploadLimit{UploadFiles: []string{"src/*.c"}},
+			paths: []string{"src/a.c", "src/b.c"}, sizes: []int64{0, 3},
+			missing: []string{}, empty: []string{},
+		},
+		{
+			name:  "opted out",
+			limit: judger.UploadLimit{EditorFiles: []string{"main.c"}, UploadFiles: []string{"*.c", "*.cpp"}, AllowIncompleteFiles: true},
+			paths: []string{"x.cpp"}, sizes: []int64{0},
+			missing: []string{}, empty: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := checkRequiredFiles(tt.limit, tt.paths, tt.sizes)
+			if !reflect.DeepEqual(report.Missing, tt.missing) || !reflect.DeepEqual(report.Empty, tt.empty) {
+				t.Errorf("got missing %v empty %v, want %v and %v", report.Missing, report.Empty, tt.missing, tt.empty)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ZJUSCT/CSOJ/internal/auth"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxAccessTokens         = 20
+	maxAccessTokenNameLen   = 50
+	accessTokenPrefixLength = 12 // "csoj_" and the first random characters
+)
+
+// createTokenResponse is the only response that contains the token itself.
+type createTokenResponse struct {
+	models.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+func (h *Handler) getAccessTokens(c *gin.Context) {
+	tokens, err := database.GetPersonalAccessTokens(h.db, c.GetString("userID"))
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	util.Success(c, tokens, "Access tokens retrieved successfully")
+}
+
+// createAccessToken issues a named personal access token. The token is returned once; only its
+// hash is stored.
+func (h *Handler) createAccessToken(c *gin.Context) {
+	var req struct {
+		Name  string `json:"name" binding:"required"`
+		Scope string `json:"scope"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len([]rune(req.Name)) > maxAccessTokenNameLen {
+		util.Error(c, http.StatusBadRequest, "name must be between 1 and 50 characters")
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = auth.TokenScopeSubmit
+	}
+	if req.Scope != auth.TokenScopeRead && req.Scope != auth.TokenScopeSubmit {
+		util.Error(c, http.StatusBadRequest, "scope must be \"read\" or \"submit\"")
+		return
+	}
+
+	userID := c.GetString("userID")
+	existing, err := database.GetPersonalAccessTokens(h.db, userID)
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	if len(existing) >= maxAccessTokens {
+		util.Error(c, http.StatusBadRequest, "too many access tokens, revoke an unused one first")
+		return
+	}
+
+	value, hash, err := auth.GenerateAccessToken()
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	token := models.PersonalAccessToken{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      req.Name,
+		Scope:     req.Scope,
+		Prefix:    value[:accessTokenPrefixLength],
+		TokenHash: hash,
+	}
+	if err := database.CreatePersonalAccessToken(h.db, &token); err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	h.auditToken(c, "token.create", &token)
+	util.Logger(c).Infof("created %s access token %s (%q)", token.Scope, token.ID, token.Name)
+
+	util.Success(c, createTokenResponse{PersonalAccessToken: token, Token: value}, "Access token created, it will not be shown again")
+}
+
+func (h *Handler) deleteAccessToken(c *gin.Context) {
+	userID := c.GetString("userID")
+	tokenID := c.Param("id")
+	if err := database.DeletePersonalAccessToken(h.db, userID, tokenID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			util.Error(c, http.StatusNotFound, "access token not found")
+		} else {
+			util.Error(c, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	h.auditToken(c, "token.delete", &models.PersonalAccessToken{ID: tokenID, UserID: userID})
+	util.Logger(c).Infof("revoked access token %s", tokenID)
+	util.Success(c, nil, "Access token revoked")
+}
+
+// auditToken records token management in the audit log, with the user as the actor.
+func (h *Handler) auditToken(c *gin.Context, action string, token *models.PersonalAccessToken) {
+	detail := map[string]interface{}{"token_id": token.ID}
+	if token.Name != "" {
+		detail["name"] = token.Name
+		detail["scope"] = token.Scope
+	}
+	entry := &models.AuditLog{
+		Actor:    "user:" + token.UserID,
+		Action:   action,
+		TargetID: token.UserID,
+		ClientIP: c.ClientIP(),
+		Detail:   detail,
+	}
+	if err := database.CreateAuditLog(h.db, entry); err != nil {
+		util.Logger(c).Errorf("failed to write audit log entry for %s: %v", action, err)
+	}
+}
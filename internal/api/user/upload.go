@@ -0,0 +1,518 @@
+package user
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	uploadChunkSize           = 8 << 20
+	uploadSessionTTL          = time.Hour
+	uploadReapInterval        = 5 * time.Minute
+	maxUploadSessionsPerUser  = 4
+	defaultUploadSessionsPath = "csoj-uploads"
+)
+
+// maxUploadChunks caps the size of a chunked upload even where the problem sets no size limit.
+const (
+	maxUploadChunks      = 4096
+	maxUploadSessionSize = maxUploadChunks * uploadChunkSize
+)
+
+type uploadFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// uploadSession is a chunked upload in progress. The declared files are concatenated in order
+// and split into chunks of ChunkSize bytes; chunk n is stored as its own file in dir.
+type uploadSession struct {
+	mu            sync.Mutex
+	id            string
+	userID        string
+	problemID     string
+	files         []uploadFile
+	relativePaths []string
+	totalSize     int64
+	dryRun        bool
+	received      []bool
+	dir           string
+	expiresAt     time.Time
+	completing    bool
+}
+
+func (s *uploadSession) numChunks() int {
+	return len(s.received)
+}
+
+// chunkLength returns the expected size of chunk n.
+func (s *uploadSession) chunkLength(n int) int64 {
+	return min(uploadChunkSize, s.totalSize-int64(n)*uploadChunkSize)
+}
+
+func (s *uploadSession) chunkPath(n int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("chunk-%d", n))
+}
+
+func (s *uploadSession) missingChunks() []int {
+	missing := []int{}
+	for n, ok := range s.received {
+		if !ok {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+// uploadSessionStore keeps the open upload sessions in memory and their chunks on disk, in a
+// directory of its own under the configured one so instances sharing it do not interfere.
+// Sessions do not survive a restart; the directories of stores that stopped are removed once
+// they have been idle longer than any session could be.
+type uploadSessionStore struct {
+	mu       sync.Mutex
+	dir      string
+	sessions map[string]*uploadSession
+}
+
+// uploadStorePrefix starts the name of every store directory, the only entries of the
+// configured directory that are ever removed.
+const uploadStorePrefix = "sessions-"
+
+func newUploadSessionStore(root string) *uploadSessionStore {
+	if root == "" {
+		root = filepath.Join(os.TempDir(), defaultUploadSessionsPath)
+	}
+	removeStaleUploadStores(root)
+	dir, err := createUploadStoreDir(root)
+	if err != nil {
+		// create makes the directory on demand, so uploads may still work later.
+		dir = filepath.Join(root, uploadStorePrefix+uuid.NewString())
+		zap.S().Warnf("failed to create upload session directory under %s: %v", root, err)
+	}
+	s := &uploadSessionStore{dir: dir, sessions: make(map[string]*uploadSession)}
+	go func() {
+		ticker := time.NewTicker(uploadReapInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.reap()
+		}
+	}()
+	return s
+}
+
+func createUploadStoreDir(root string) (string, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(root, uploadStorePrefix)
+}
+
+// removeStaleUploadStores removes the store directories under root in which nothing changed for
+// longer than a session may sit idle. Those belong to stores that are gone: a running store
+// reaps its idle sessions, and receiving a chunk touches the session's directory.
+func removeStaleUploadStores(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-(uploadSessionTTL + 2*uploadReapInterval))
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), uploadStorePrefix) {
+			continue
+		}
+		path := filepath.Join(root, entry.Name())
+		if lastModified(path).After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			zap.S().Warnf("failed to remove stale upload session directory %s: %v", path, err)
+		}
+	}
+}
+
+// lastModified returns the latest modification time of a directory and its direct children.
+func lastModified(dir string) time.Time {
+	var latest time.Time
+	if info, err := os.Stat(dir); err == nil {
+		latest = info.ModTime()
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (s *uploadSessionStore) create(userID, problemID string, files []uploadFile, relativePaths []string, totalSize int64, dryRun bool) (*uploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	open := 0
+	for _, sess := range s.sessions {
+		if sess.userID == userID {
+			open++
+		}
+	}
+	if open >= maxUploadSessionsPerUser {
+		return nil, fmt.Errorf("too many unfinished uploads, complete or abort one of them first")
+	}
+
+	sess := &uploadSession{
+		id:            uuid.NewString(),
+		userID:        userID,
+		problemID:     problemID,
+		files:         files,
+		relativePaths: relativePaths,
+		totalSize:     totalSize,
+		dryRun:        dryRun,
+		received:      make([]bool, (totalSize+uploadChunkSize-1)/uploadChunkSize),
+		expiresAt:     time.Now().Add(uploadSessionTTL),
+	}
+	sess.dir = filepath.Join(s.dir, sess.id)
+	if err := os.MkdirAll(sess.dir, 0700); err != nil {
+		return nil, err
+	}
+	s.sessions[sess.id] = sess
+	return sess, nil
+}
+
+// get returns the session if it exists and belongs to the user and problem.
+func (s *uploadSessionStore) get(id, userID, problemID string) (*uploadSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok || sess.userID != userID || sess.problemID != problemID {
+		return nil, false
+	}
+	return sess, true
+}
+
+func (s *uploadSessionStore) remove(sess *uploadSession) {
+	s.mu.Lock()
+	delete(s.sessions, sess.id)
+	s.mu.Unlock()
+	if err := os.RemoveAll(sess.dir); err != nil {
+		zap.S().Warnf("failed to remove upload session %s: %v", sess.id, err)
+	}
+}
+
+// reap removes sessions that have not received a chunk within the session TTL.
+func (s *uploadSessionStore) reap() {
+	now := time.Now()
+	var expired []*uploadSession
+	s.mu.Lock()
+	for _, sess := range s.sessions {
+		sess.mu.Lock()
+		if !sess.completing && now.After(sess.expiresAt) {
+			expired = append(expired, sess)
+		}
+		sess.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	for _, sess := range expired {
+		s.remove(sess)
+	}
+	if len(expired) > 0 {
+		zap.S().Infof("removed %d abandoned upload sessions", len(expired))
+	}
+}
+
+// chunkStream reads the chunks of a session in order as one stream.
+type chunkStream struct {
+	sess    *uploadSession
+	next    int
+	current *os.File
+}
+
+func (r *chunkStream) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.next >= r.sess.numChunks() {
+				return 0, io.EOF
+			}
+			f, err := os.Open(r.sess.chunkPath(r.next))
+			if err != nil {
+				return 0, err
+			}
+			r.current = f
+			r.next++
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkStream) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+func uploadSessionResponse(sess *uploadSession) gin.H {
+	return gin.H{
+		"session_id":     sess.id,
+		"chunk_size":     uploadChunkSize,
+		"total_size":     sess.totalSize,
+		"chunks":         sess.numChunks(),
+		"missing_chunks": sess.missingChunks(),
+		"expires_at":     sess.expiresAt,
+	}
+}
+
+// initUpload starts a chunked upload. The client declares all files and their sizes up front,
+// so the problem's upload limits are enforced before any data is transferred.
+func (h *Handler) initUpload(c *gin.Context) {
+	userID := c.GetString("userID")
+	problemID := c.Param("id")
+
+	var req struct {
+		Files  []uploadFile `json:"files" binding:"required"`
+		DryRun bool         `json:"dry_run"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Error(c, http.StatusBadRequest, err)
+		return
+	}
+
+	target, ok := h.checkSubmitAllowed(c, userID, problemID, req.DryRun)
+	if !ok {
+		return
+	}
+
+	maxSize := int64(maxUploadSessionSize)
+	if target.upload.MaxSize > 0 {
+		maxSize = min(maxSize, int64(target.upload.MaxSize)*1024*1024)
+	}
+	names := make([]string, len(req.Files))
+	sizes := make([]int64, len(req.Files))
+	var totalSize int64
+	for i, file := range req.Files {
+		if file.Size < 0 {
+			util.Error(c, http.StatusBadRequest, fmt.Sprintf("invalid size for file %q", file.Path))
+			return
+		}
+		// Checked before adding, so the total never exceeds maxSize and cannot overflow.
+		if file.Size > maxSize-totalSize {
+			util.Error(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("total file size exceeds the limit of %d MB", maxSize/(1024*1024)))
+			return
+		}
+		names[i] = file.Path
+		sizes[i] = file.Size
+		totalSize += file.Size
+	}
+	relativePaths, status, err := validateUploadFiles(target.upload, names, totalSize)
+	if err != nil {
+		util.Error(c, status, err)
+		return
+	}
+	if !h.checkUploadPatterns(c, target.user, target.problem, relativePaths) {
+		return
+	}
+	if rejectIncompleteUpload(c, target.upload, relativePaths, sizes) {
+		return
+	}
+
+	sess, err := h.uploads.create(userID, problemID, req.Files, relativePaths, totalSize, req.DryRun)
+	if err != nil {
+		util.Error(c, http.StatusTooManyRequests, err)
+		return
+	}
+	util.Success(c, uploadSessionResponse(sess), "Upload session created")
+}
+
+// getUpload returns the state of an upload session, so an interrupted client can resume it.
+func (h *Handler) getUpload(c *gin.Context) {
+	sess, ok := h.uploads.get(c.Param("session"), c.GetString("userID"), c.Param("id"))
+	if !ok {
+		util.Error(c, http.StatusNotFound, "upload session not found")
+		return
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	util.Success(c, uploadSessionResponse(sess), "Upload session retrieved")
+}
+
+// putUploadChunk stores one chunk. The body must be exactly the expected chunk length;
+// re-uploading a chunk replaces it.
+func (h *Handler) putUploadChunk(c *gin.Context) {
+	sess, ok := h.uploads.get(c.Param("session"), c.GetString("userID"), c.Param("id"))
+	if !ok {
+		util.Error(c, http.StatusNotFound, "upload session not found")
+		return
+	}
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil || n < 0 || n >= sess.numChunks() {
+		util.Error(c, http.StatusBadRequest, "invalid chunk index")
+		return
+	}
+
+	sess.mu.Lock()
+	if sess.completing {
+		sess.mu.Unlock()
+		util.Error(c, http.StatusConflict, "upload is being completed")
+		return
+	}
+	sess.expiresAt = time.Now().Add(uploadSessionTTL)
+	sess.mu.Unlock()
+
+	expected := sess.chunkLength(n)
+	tmp, err := os.CreateTemp(sess.dir, "incoming-*")
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	written, err := io.Copy(tmp, io.LimitReader(c.Request.Body, expected+1))
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		util.Error(c, http.StatusBadRequest, fmt.Errorf("failed to read chunk: %w", err))
+		return
+	}
+	if written != expected {
+		os.Remove(tmp.Name())
+		util.Error(c, http.StatusBadRequest, fmt.Sprintf("chunk %d must be exactly %d bytes", n, expected))
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.completing {
+		os.Remove(tmp.Name())
+		util.Error(c, http.StatusConflict, "upload is being completed")
+		return
+	}
+	if err := os.Rename(tmp.Name(), sess.chunkPath(n)); err != nil {
+		os.Remove(tmp.Name())
+		util.Error(c, http.StatusInternalServerError, err)
+		return
+	}
+	sess.received[n] = true
+	util.Success(c, gin.H{"chunk": n, "missing_chunks": sess.missingChunks()}, "Chunk received")
+}
+
+// completeUpload assembles the chunks into the submission directory and queues the submission.
+// Contest time windows and the submission limit are checked again at this point.
+func (h *Handler) completeUpload(c *gin.Context) {
+	sess, ok := h.uploads.get(c.Param("session"), c.GetString("userID"), c.Param("id"))
+	if !ok {
+		util.Error(c, http.StatusNotFound, "upload session not found")
+		return
+	}
+
+	sess.mu.Lock()
+	if sess.completing {
+		sess.mu.Unlock()
+		util.Error(c, http.StatusConflict, "upload is already being completed")
+		return
+	}
+	if missing := sess.missingChunks(); len(missing) > 0 {
+		sess.mu.Unlock()
+		util.Error(c, http.StatusBadRequest, fmt.Sprintf("%d chunks are missing", len(missing)))
+		return
+	}
+	sess.completing = true
+	sess.mu.Unlock()
+
+	target, ok := h.checkSubmitAllowed(c, sess.userID, sess.problemID, sess.dryRun)
+	if !ok {
+		// Cooldowns and quotas pass with time, so keep the chunks for another attempt.
+		sess.mu.Lock()
+		sess.completing = false
+		sess.expiresAt = time.Now().Add(uploadSessionTTL)
+		sess.mu.Unlock()
+		return
+	}
+
+	submissionID := uuid.New().String()
+	submissionPath := filepath.Join(h.cfg.Storage.SubmissionContent, submissionID)
+	if err := h.assembleUpload(sess, submissionPath); err != nil {
+		os.RemoveAll(submissionPath)
+		sess.mu.Lock()
+		sess.completing = false
+		sess.mu.Unlock()
+		util.Error(c, http.StatusInternalServerError, fmt.Errorf("failed to assemble upload: %w", err))
+		return
+	}
+	h.uploads.remove(sess)
+	if status, err := checkArchives(target.upload, submissionPath, sess.relativePaths); err != nil {
+		os.RemoveAll(submissionPath)
+		util.Logger(c).Warnf("rejected chunked upload %s to problem %s: %v", sess.id, sess.problemID, err)
+		util.Error(c, status, err)
+		return
+	}
+
+	util.Logger(c).Infof("assembled chunked upload %s (%d bytes) into submission %s", sess.id, sess.totalSize, submissionID)
+	h.createSubmission(c, target, submissionID)
+}
+
+func (h *Handler) assembleUpload(sess *uploadSession, submissionPath string) error {
+	if err := os.MkdirAll(submissionPath, 0755); err != nil {
+		return err
+	}
+	stream := &chunkStream{sess: sess}
+	defer stream.Close()
+
+	for i, file := range sess.files {
+		dst, err := submissionFilePath(submissionPath, sess.relativePaths[i])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		out, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(out, stream, file.Size)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// abortUpload discards an upload session and its chunks.
+func (h *Handler) abortUpload(c *gin.Context) {
+	sess, ok := h.uploads.get(c.Param("session"), c.GetString("userID"), c.Param("id"))
+	if !ok {
+		util.Error(c, http.StatusNotFound, "upload session not found")
+		return
+	}
+	sess.mu.Lock()
+	completing := sess.completing
+	sess.mu.Unlock()
+	if completing {
+		util.Error(c, http.StatusConflict, "upload is being completed")
+		return
+	}
+	h.uploads.remove(sess)
+	util.Success(c, nil, "Upload session aborted")
+}
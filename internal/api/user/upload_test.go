@@ -0,0 +1,51 @@
+package user
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewUploadSessionStoreKeepsForeignFiles(t *testing.T) {
+	root := t.TempDir()
+	old := time.Now().Add(-3 * uploadSessionTTL)
+	mkdir := func(path string, mtime time.Time) {
+		t.Helper()
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "data.txt"), []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mkdir(filepath.Join(root, "other"), old)
+	// A store that stopped long ago, and one of another instance with a recent chunk.
+	mkdir(filepath.Join(root, "sessions-stale", "s1"), old)
+	mkdir(filepath.Join(root, "sessions-stale"), old)
+	mkdir(filepath.Join(root, "sessions-live", "s2"), time.Now())
+	mkdir(filepath.Join(root, "sessions-live"), old)
+
+	store := newUploadSessionStore(root)
+
+	for _, path := range []string{"data.txt", "other", "sessions-live/s2"} {
+		if _, err := os.Stat(filepath.Join(root, path)); err != nil {
+			t.Errorf("%s was removed: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "sessions-stale")); !os.IsNotExist(err) {
+		t.Errorf("stale store directory was kept: %v", err)
+	}
+	if filepath.Dir(store.dir) != root || !strings.HasPrefix(filepath.Base(store.dir), uploadStorePrefix) {
+		t.Errorf("store directory %s is not a store directory under %s", store.dir, root)
+	}
+	if other := newUploadSessionStore(root); other.dir == store.dir {
+		t.Error("two stores share a directory")
+	} else if _, err := os.Stat(store.dir); err != nil {
+		t.Errorf("starting a second store removed the first one's directory: %v", err)
+	}
+}
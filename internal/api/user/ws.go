@@ -5,28 +5,33 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"time"
 
+	"github.com/ZJUSCT/CSOJ/internal/api"
 	"github.com/ZJUSCT/CSOJ/internal/auth"
 	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/judger"
 	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+	"github.com/ZJUSCT/CSOJ/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"go.uber.org/zap"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 func (h *Handler) handleUserContainerWs(c *gin.Context) {
+	if !api.IsOriginAllowed(h.cfg.CORS, c.Request) {
+		c.String(http.StatusForbidden, "origin not allowed")
+		return
+	}
+
 	submissionID := c.Param("subID")
 	containerID := c.Param("conID")
 	tokenString := c.Query("token")
+	stripANSI, err := pubsub.ParseANSIMode(c.Query("ansi"))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if tokenString == "" {
 		c.String(http.StatusUnauthorized, "token query parameter is required")
@@ -39,6 +44,7 @@ func (h *Handler) handleUserContainerWs(c *gin.Context) {
 		return
 	}
 	userID := claims.Subject
+	c.Set(util.LoggerKey, util.Logger(c).With("user_id", userID))
 
 	// --- Authorization Checks ---
 	sub, err := database.GetSubmission(h.db, submissionID)
@@ -71,36 +77,53 @@ func (h *Handler) handleUserContainerWs(c *gin.Context) {
 
 	h.appState.RLock()
 	problem, ok := h.appState.Problems[sub.ProblemID]
+	contest, contestOk := h.appState.ProblemToContestMap[sub.ProblemID]
 	h.appState.RUnlock()
-	if !ok {
+	if !ok || !contestOk {
 		c.String(http.StatusInternalServerError, "problem definition not found")
 		return
 	}
 
-	if containerIndex >= len(problem.Workflow) || !problem.Workflow[containerIndex].Show {
+	if !contest.StepLogVisible(problem, containerIndex, time.Now()) {
 		c.String(http.StatusForbidden, "you are not allowed to view the log for this step")
 		return
 	}
 	// --- End Authorization ---
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		zap.S().Errorf("failed to upgrade websocket: %v", err)
+		util.Logger(c).Errorf("failed to upgrade websocket: %v", err)
 		return
 	}
 	defer conn.Close()
 
 	if targetContainer.Status == models.StatusRunning {
 		// Real-time streaming
-		msgChan, unsubscribe := pubsub.GetBroker().Subscribe(containerID)
-		defer unsubscribe()
+		topic := pubsub.GetBroker().Subscribe(containerID)
+		defer topic.Close()
+
+		stopHeartbeat := api.StartHeartbeat(conn, h.cfg.Websocket.PingInterval())
+		defer stopHeartbeat()
 
 		clientClosed := make(chan struct{})
 		go func() {
 			defer close(clientClosed)
-			for msg := range msgChan {
-				if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-					zap.S().Warnf("error writing to websocket: %v", err)
+			for {
+				select {
+				case msg, ok := <-topic.C:
+					if !ok {
+						return
+					}
+					if stripANSI {
+						msg = pubsub.StripMessageANSI(msg)
+					}
+					if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+						util.Logger(c).Warnf("error writing to websocket: %v", err)
+						return
+					}
+				case <-topic.Lagged():
+					util.Logger(c).Warnf("websocket client fell behind container %s, closing", containerID)
+					api.CloseLagged(conn)
 					return
 				}
 			}
@@ -109,7 +132,7 @@ func (h *Handler) handleUserContainerWs(c *gin.Context) {
 		for {
 			if _, _, err := conn.ReadMessage(); err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					zap.S().Infof("websocket unexpected close error: %v", err)
+					util.Logger(c).Infof("websocket unexpected close error: %v", err)
 				}
 				break
 			}
@@ -134,15 +157,228 @@ func (h *Handler) handleUserContainerWs(c *gin.Context) {
 		scanner := bufio.NewScanner(file)
 		for scanner.Scan() {
 			msg := scanner.Bytes() // The file content is already NDJSON
+			if stripANSI {
+				msg = pubsub.StripMessageANSI(msg)
+			}
 			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				return // Client disconnected
 			}
 		}
 		if err := scanner.Err(); err != nil {
-			zap.S().Errorf("error reading log file for container %s: %v", containerID, err)
+			util.Logger(c).Errorf("error reading log file for container %s: %v", containerID, err)
 		}
 		msg := pubsub.FormatMessage("info", "Log stream finished.")
 		conn.WriteMessage(websocket.TextMessage, msg)
 	}
-	zap.S().Infof("websocket connection closed for container %s", containerID)
+	util.Logger(c).Infof("websocket connection closed for container %s", containerID)
+}
+
+func (h *Handler) handleSubmissionStatusWs(c *gin.Context) {
+	if !api.IsOriginAllowed(h.cfg.CORS, c.Request) {
+		c.String(http.StatusForbidden, "origin not allowed")
+		return
+	}
+
+	submissionID := c.Param("subID")
+	tokenString := c.Query("token")
+
+	if tokenString == "" {
+		c.String(http.StatusUnauthorized, "token query parameter is required")
+		return
+	}
+
+	claims, err := auth.ValidateJWT(tokenString, h.cfg.Auth.JWT.Secret)
+	if err != nil {
+		c.String(http.StatusUnauthorized, "invalid token")
+		return
+	}
+	userID := claims.Subject
+	c.Set(util.LoggerKey, util.Logger(c).With("user_id", userID))
+
+	// --- Authorization Checks ---
+	sub, err := database.GetSubmission(h.db, submissionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "submission not found")
+		return
+	}
+	if sub.UserID != userID {
+		c.String(http.StatusForbidden, "you can only view your own submissions")
+		return
+	}
+	// --- End Authorization ---
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		util.Logger(c).Errorf("failed to upgrade websocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Subscribe before taking the snapshot so no transition is missed in between. The cached
+	// events are not replayed, they are all older than the snapshot.
+	topic := pubsub.GetBroker().SubscribeLive(submissionID)
+	defer topic.Close()
+
+	// Send the current state first; the submission may have changed since the check above.
+	sub, err = database.GetSubmission(h.db, submissionID)
+	if err != nil {
+		conn.WriteMessage(websocket.TextMessage, pubsub.FormatMessage("error", "submission not found"))
+		return
+	}
+	var position int64
+	if sub.Status == models.StatusQueued {
+		position, _ = database.CountQueuedSubmissionsBefore(h.db, sub.Cluster, sub.CreatedAt)
+	}
+	status := judger.FormatStatusMessage(sub, position)
+	if h.scoresHidden(sub.ProblemID, time.Now()) {
+		status = hideStatusScore(status)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, status); err != nil {
+		return
+	}
+	if sub.Status != models.StatusQueued && sub.Status != models.StatusRunning {
+		return
+	}
+	// Events published between subscribing and reading the snapshot may be older than it.
+	latest, _ := judger.ParseStatusMessage(status)
+
+	stopHeartbeat := api.StartHeartbeat(conn, h.cfg.Websocket.PingInterval())
+	defer stopHeartbeat()
+
+	clientClosed := make(chan struct{})
+	go func() {
+		defer close(clientClosed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-topic.C:
+			if !ok {
+				// The submission finished and its topic was closed.
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "submission finished"))
+				return
+			}
+			if event, ok := judger.ParseStatusMessage(msg); ok {
+				if event.Before(latest) {
+					continue
+				}
+				latest = event
+			}
+			if h.scoresHidden(sub.ProblemID, time.Now()) {
+				msg = hideStatusScore(msg)
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				util.Logger(c).Warnf("error writing to websocket: %v", err)
+				return
+			}
+		case <-topic.Lagged():
+			util.Logger(c).Warnf("websocket client fell behind, closing")
+			api.CloseLagged(conn)
+			return
+		case <-clientClosed:
+			return
+		}
+	}
+}
+
+// handleContestAnnouncementsWs pushes new and updated announcements of a contest to a registered
+// user. Events are only delivered once the contest has started.
+func (h *Handler) handleContestAnnouncementsWs(c *gin.Context) {
+	if !api.IsOriginAllowed(h.cfg.CORS, c.Request) {
+		c.String(http.StatusForbidden, "origin not allowed")
+		return
+	}
+
+	contestID := c.Param("id")
+	tokenString := c.Query("token")
+
+	if tokenString == "" {
+		c.String(http.StatusUnauthorized, "token query parameter is required")
+		return
+	}
+
+	claims, err := auth.ValidateJWT(tokenString, h.cfg.Auth.JWT.Secret)
+	if err != nil {
+		c.String(http.StatusUnauthorized, "invalid token")
+		return
+	}
+	userID := claims.Subject
+	c.Set(util.LoggerKey, util.Logger(c).With("user_id", userID))
+
+	// --- Authorization Checks ---
+	h.appState.RLock()
+	_, ok := h.appState.Contests[contestID]
+	h.appState.RUnlock()
+	if !ok {
+		c.String(http.StatusNotFound, "contest not found")
+		return
+	}
+	registered, err := database.IsUserRegisteredForContest(h.db, userID, contestID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "database error")
+		return
+	}
+	if !registered {
+		c.String(http.StatusForbidden, "you must register for the contest to receive announcements")
+		return
+	}
+	// --- End Authorization ---
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		util.Logger(c).Errorf("failed to upgrade websocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	topic := pubsub.GetBroker().Subscribe(judger.AnnouncementTopic(contestID))
+	defer topic.Close()
+
+	stopHeartbeat := api.StartHeartbeat(conn, h.cfg.Websocket.PingInterval())
+	defer stopHeartbeat()
+
+	clientClosed := make(chan struct{})
+	go func() {
+		defer close(clientClosed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-topic.C:
+			if !ok {
+				return
+			}
+			// The contest may have been rescheduled or removed by a reload since the client connected.
+			h.appState.RLock()
+			contest, ok := h.appState.Contests[contestID]
+			h.appState.RUnlock()
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "contest removed"))
+				return
+			}
+			if time.Now().Before(contest.StartTime) {
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				util.Logger(c).Warnf("error writing to websocket: %v", err)
+				return
+			}
+		case <-topic.Lagged():
+			util.Logger(c).Warnf("websocket client fell behind, closing")
+			api.CloseLagged(conn)
+			return
+		case <-clientClosed:
+			return
+		}
+	}
 }
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+	"github.com/gorilla/websocket"
+)
+
+// IsOriginAllowed reports whether a websocket request may be upgraded based on its Origin header.
+// Requests without an Origin header (non-browser clients) are allowed. If no allowed origins are
+// configured, only same-host origins are accepted; "*" allows every origin.
+func IsOriginAllowed(cfg config.CORS, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		return sameHost(origin, r.Host)
+	}
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func sameHost(origin, host string) bool {
+	for _, scheme := range []string{"http://", "https://"} {
+		if origin == scheme+host {
+			return true
+		}
+	}
+	return false
+}
+
+// NewWebsocketUpgrader creates a websocket upgrader that checks origins against the CORS config.
+func NewWebsocketUpgrader(cfg config.CORS) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return IsOriginAllowed(cfg, r)
+		},
+	}
+}
+
+// pingWriteWait bounds how long a single ping may take to write.
+const pingWriteWait = 10 * time.Second
+
+// StartHeartbeat pings the client every interval so that proxies do not close the connection
+// during quiet periods, and sets a read deadline of two intervals that every pong extends, so
+// the read loop fails once the peer is gone. The caller must keep reading from the connection
+// for pongs to be processed. The returned function stops pinging and waits for the ping
+// goroutine to exit. An interval of 0 disables the heartbeat.
+func StartHeartbeat(conn *websocket.Conn, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * interval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * interval))
+	})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// WriteControl may be called concurrently with the stream's other writes.
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingWriteWait)); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}
+}
+
+// CloseLagged tells a client that fell too far behind its stream for messages to be kept for
+// it, see pubsub.Subscription.Lagged, to reconnect and closes the stream with code 1013 (try
+// again later). Streams backed by the broker's cache are replayed in full on reconnect.
+func CloseLagged(conn *websocket.Conn) {
+	conn.WriteMessage(websocket.TextMessage, pubsub.FormatMessage("error", "You fell behind the stream and messages were dropped. Reconnect to resume."))
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "fell behind"), time.Now().Add(pingWriteWait))
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AssetTokenVersion marks signed asset URLs that use the nonce/user-bound scheme.
+const AssetTokenVersion = "2"
+
+// AssetURLTTL is how long a signed asset URL stays valid.
+const AssetURLTTL = 15 * time.Minute
+
+// AssetKeyring holds the secret that signs asset URLs and, during a rotation, the previous secret
+// that is still accepted so URLs issued just before the rotation keep working.
+type AssetKeyring struct {
+	mu            sync.RWMutex
+	current       string
+	previous      string
+	previousUntil time.Time // zero: the previous secret is accepted until it is removed from the config
+}
+
+// NewAssetKeyring creates a keyring from the configured secrets. previous may be empty.
+func NewAssetKeyring(current, previous string) *AssetKeyring {
+	return &AssetKeyring{current: current, previous: previous}
+}
+
+// Current returns the secret used to sign new asset URLs.
+func (k *AssetKeyring) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Secrets returns the secrets accepted for verification, current first.
+func (k *AssetKeyring) Secrets() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	secrets := []string{k.current}
+	if k.previous != "" && k.previous != k.current && (k.previousUntil.IsZero() || time.Now().Before(k.previousUntil)) {
+		secrets = append(secrets, k.previous)
+	}
+	return secrets
+}
+
+// Rotate replaces the signing secret with a random one and keeps accepting the old secret for
+// overlap. The new secret only lives in memory: after a restart the configured secret is used again.
+func (k *AssetKeyring) Rotate(overlap time.Duration) (time.Time, error) {
+	secret, err := randomToken()
+	if err != nil {
+		return time.Time{}, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.previous = k.current
+	k.previousUntil = time.Now().Add(overlap)
+	k.current = secret
+	return k.previousUntil, nil
+}
+
+// LegacyAssetToken signs an asset path and expiry. Such tokens are reusable until they expire.
+func LegacyAssetToken(secret, path string, expires int64) string {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%s|%d", path, expires)))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// AssetToken signs an asset path and expiry together with the user it was issued to (may be
+// empty) and a nonce (may be empty).
+func AssetToken(secret, path string, expires int64, userID, nonce string) string {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("v%s|%s|%d|%s|%s", AssetTokenVersion, path, expires, userID, nonce)))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// NonceStore records issued single-use nonces until they are consumed or expire.
+// Nonces are kept in memory, so URLs issued before a restart become invalid.
+type NonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewNonceStore() *NonceStore {
+	return &NonceStore{nonces: make(map[string]time.Time)}
+}
+
+// Issue creates a nonce valid until expiresAt.
+func (s *NonceStore) Issue(expiresAt time.Time) (string, error) {
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for n, exp := range s.nonces {
+		if now.After(exp) {
+			delete(s.nonces, n)
+		}
+	}
+	s.nonces[nonce] = expiresAt
+	return nonce, nil
+}
+
+// Consume reports whether the nonce was issued and not used yet, and marks it as used.
+func (s *NonceStore) Consume(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return time.Now().Before(exp)
+}
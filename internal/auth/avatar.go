@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	avatarFetchTimeout = 10 * time.Second
+	maxAvatarFetchSize = 2 << 20
+)
+
+var avatarExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// IsExternalAvatar reports whether a stored avatar is a remote URL rather than a local filename.
+func IsExternalAvatar(avatar string) bool {
+	return strings.HasPrefix(avatar, "http://") || strings.HasPrefix(avatar, "https://")
+}
+
+// fetchAvatar downloads an external avatar into dir as <userID><ext> and returns the filename.
+// Only JPG, PNG and WEBP images up to maxAvatarFetchSize are accepted.
+func fetchAvatar(ctx context.Context, dir, userID, avatarURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, avatarFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, avatarURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAvatarFetchSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxAvatarFetchSize {
+		return "", fmt.Errorf("avatar exceeds %d bytes", maxAvatarFetchSize)
+	}
+	ext, ok := avatarExtensions[http.DetectContentType(data)]
+	if !ok {
+		return "", fmt.Errorf("unsupported avatar type %s", http.DetectContentType(data))
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	filename := userID + ext
+	if err := os.WriteFile(filepath.Join(dir, filename), data, 0644); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
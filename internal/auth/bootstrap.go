@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// BootstrapAdmin creates the configured initial local account on the first start. Nothing is
+// done if the bootstrap is disabled or a user with the username exists, even a deleted one, so
+// later changes to the account are never overwritten.
+func BootstrapAdmin(db *gorm.DB, cfg config.BootstrapAdmin) error {
+	if cfg.Username == "" {
+		return nil
+	}
+	var count int64
+	if err := db.Unscoped().Model(&models.User{}).Where("username = ?", cfg.Username).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	hash := cfg.PasswordHash
+	if hash == "" {
+		password := os.Getenv(cfg.PasswordEnv)
+		if password == "" {
+			return fmt.Errorf("environment variable %s with the password of %q is not set", cfg.PasswordEnv, cfg.Username)
+		}
+		var err error
+		if hash, err = HashPassword(password); err != nil {
+			return err
+		}
+	}
+
+	user := models.User{
+		ID:           uuid.NewString(),
+		Username:     cfg.Username,
+		PasswordHash: hash,
+		Nickname:     cfg.Nickname,
+	}
+	if user.Nickname == "" {
+		user.Nickname = user.Username
+	}
+	if err := database.CreateUser(db, &user); err != nil {
+		return err
+	}
+	zap.S().Warnf("created the bootstrap user %q, change its password through the admin API (POST /users/%s/reset-password) and remove the password from the config", user.Username, user.ID)
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 
 type MyCustomClaims struct {
 	jwt.RegisteredClaims
+	// Impersonation marks a read-only token minted by an admin to view the site as the user.
+	Impersonation bool `json:"imp,omitempty"`
 }
 
 // HashPassword generates a bcrypt hash of the password.
@@ -37,6 +39,23 @@ func GenerateJWT(userID, secret string, expireHours int) (string, error) {
 	return token.SignedString([]byte(secret))
 }
 
+// GenerateImpersonationJWT mints a short-lived token for the user that is flagged as impersonation.
+func GenerateImpersonationJWT(userID, secret string, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	claims := MyCustomClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Subject:   userID,
+		},
+		Impersonation: true,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(secret))
+	return signed, expiresAt, err
+}
+
 func ValidateJWT(tokenString, secret string) (*MyCustomClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &MyCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
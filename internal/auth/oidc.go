@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// OIDCHandler handles login through any number of configured OpenID Connect providers.
+type OIDCHandler struct {
+	cfg       *config.Config
+	db        *gorm.DB
+	providers map[string]*oidcProvider
+	order     []string
+
+	// OnLogin, if set, is called after a user has logged in, before the token is issued.
+	OnLogin func(c *gin.Context, userID string)
+}
+
+type oidcProvider struct {
+	cfg      config.OIDCProvider
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// ProviderInfo is the public description of a login provider.
+type ProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+func NewOIDCHandler(cfg *config.Config, db *gorm.DB) *OIDCHandler {
+	ctx := context.Background()
+	h := &OIDCHandler{
+		cfg:       cfg,
+		db:        db,
+		providers: make(map[string]*oidcProvider),
+	}
+
+	for _, p := range cfg.Auth.OIDCProviders() {
+		if _, exists := h.providers[p.Name]; exists {
+			zap.S().Fatalf("duplicate OIDC provider name: %s", p.Name)
+		}
+
+		provider, err := oidc.NewProvider(ctx, p.IssuerURL)
+		if err != nil {
+			zap.S().Fatalf("failed to create OIDC provider %s: %v", p.Name, err)
+		}
+
+		scopes := []string{oidc.ScopeOpenID}
+		for _, scope := range p.Scopes {
+			if scope != oidc.ScopeOpenID {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		h.providers[p.Name] = &oidcProvider{
+			cfg: p,
+			oauth2: &oauth2.Config{
+				ClientID:     p.ClientID,
+				ClientSecret: p.ClientSecret,
+				RedirectURL:  p.RedirectURI,
+				Endpoint:     provider.Endpoint(),
+				Scopes:       scopes,
+			},
+			verifier: provider.Verifier(&oidc.Config{ClientID: p.ClientID}),
+		}
+		h.order = append(h.order, p.Name)
+		zap.S().Infof("OIDC provider %s configured", p.Name)
+	}
+
+	return h
+}
+
+// Providers lists the configured providers in config order.
+func (h *OIDCHandler) Providers() []ProviderInfo {
+	infos := make([]ProviderInfo, 0, len(h.order))
+	for _, name := range h.order {
+		p := h.providers[name].cfg
+		displayName := p.DisplayName
+		if displayName == "" {
+			displayName = p.Name
+		}
+		infos = append(infos, ProviderInfo{Name: p.Name, DisplayName: displayName})
+	}
+	return infos
+}
+
+// ProviderAlias fixes the provider route parameter, so legacy routes like /auth/gitlab keep working.
+func (h *OIDCHandler) ProviderAlias(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Params = append(c.Params, gin.Param{Key: "provider", Value: name})
+		c.Next()
+	}
+}
+
+func (h *OIDCHandler) getProvider(c *gin.Context) (*oidcProvider, bool) {
+	p, ok := h.providers[c.Param("provider")]
+	if !ok {
+		util.Error(c, http.StatusNotFound, "unknown login provider")
+		return nil, false
+	}
+	return p, true
+}
+
+func (h *OIDCHandler) Login(c *gin.Context) {
+	p, ok := h.getProvider(c)
+	if !ok {
+		return
+	}
+	state, err := randomToken()
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, "failed to generate login state")
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		util.Error(c, http.StatusInternalServerError, "failed to generate login nonce")
+		return
+	}
+	flow := &oidcFlow{
+		Provider:     p.cfg.Name,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		ExpiresAt:    time.Now().Add(oidcFlowTTL).Unix(),
+	}
+	if err := setFlowCookie(c, flow, h.cfg.Auth.JWT.Secret); err != nil {
+		util.Error(c, http.StatusInternalServerError, "failed to store login state")
+		return
+	}
+
+	url := p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(flow.CodeVerifier))
+	c.Redirect(http.StatusTemporaryRedirect, url)
+}
+
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	p, ok := h.getProvider(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	code := c.Query("code")
+
+	frontendURL := p.cfg.FrontendCallbackURL
+	if frontendURL == "" {
+		frontendURL = "/callback"
+		util.Logger(c).Warnf("frontend_callback_url not set for provider %s, using default: %s", p.cfg.Name, frontendURL)
+	}
+
+	redirectURL := frontendURL
+
+	if !strings.Contains(frontendURL, "?") {
+		frontendURL += "?"
+	} else {
+		frontendURL += "&"
+	}
+	frontendURL += "error="
+
+	// Verify the state against the signed flow cookie set by Login.
+	flow, err := popFlowCookie(c, h.cfg.Auth.JWT.Secret)
+	if err != nil {
+		util.Logger(c).Warnf("rejected OIDC callback for provider %s: %v", p.cfg.Name, err)
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"invalid_state")
+		return
+	}
+	if flow.Provider != p.cfg.Name || subtle.ConstantTimeCompare([]byte(flow.State), []byte(c.Query("state"))) != 1 {
+		util.Logger(c).Warnf("rejected OIDC callback for provider %s: state mismatch", p.cfg.Name)
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"invalid_state")
+		return
+	}
+	if c.Query("error") != "" {
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"provider_error")
+		return
+	}
+
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(flow.CodeVerifier))
+	if err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"token_exchange_failed")
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"id_token_missing")
+		return
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"id_token_verification_failed")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(flow.Nonce)) != 1 {
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"invalid_nonce")
+		return
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"claims_extraction_failed")
+		return
+	}
+
+	subject := idToken.Subject
+	user, err := database.GetUserByOIDCIdentity(h.db, p.cfg.Name, subject)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		username := claimString(claims, p.cfg.Claims.Username, "preferred_username")
+		if username == "" {
+			c.Redirect(http.StatusTemporaryRedirect, frontendURL+"username_claim_missing")
+			return
+		}
+		// Also check if the username already exists from a local account or another provider
+		_, err := database.GetUserByUsername(h.db, username)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			if err == nil {
+				c.Redirect(http.StatusTemporaryRedirect, frontendURL+"username_already_exists")
+			} else {
+				c.Redirect(http.StatusTemporaryRedirect, frontendURL+"database_error")
+			}
+			return
+		}
+
+		providerName := p.cfg.Name
+		newUser := models.User{
+			ID:           uuid.New().String(),
+			OIDCProvider: &providerName,
+			OIDCSubject:  &subject,
+			Username:     username,
+			Nickname:     claimString(claims, p.cfg.Claims.Name, "name"),
+			AvatarURL:    claimString(claims, p.cfg.Claims.Picture, "picture"),
+		}
+		if err := database.CreateUser(h.db, &newUser); err != nil {
+			c.Redirect(http.StatusTemporaryRedirect, frontendURL+"user_creation_failed")
+			return
+		}
+		user = &newUser
+		util.Logger(c).Infof("new OIDC user registered via %s: %s", providerName, user.Username)
+	} else if err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"database_error")
+		return
+	}
+
+	// Store provider pictures locally so clients never load them from the provider. This also
+	// migrates users created before proxying was enabled.
+	if h.cfg.Avatar.ProxyExternal && IsExternalAvatar(user.AvatarURL) {
+		filename, err := fetchAvatar(ctx, h.cfg.Storage.UserAvatar, user.ID, user.AvatarURL)
+		if err != nil {
+			util.Logger(c).Warnf("failed to fetch avatar of user %s, falling back to the default: %v", user.Username, err)
+		}
+		user.AvatarURL = filename
+		if err := database.UpdateUser(h.db, user); err != nil {
+			util.Logger(c).Errorf("failed to update avatar of user %s: %v", user.Username, err)
+		}
+	}
+
+	if h.OnLogin != nil {
+		h.OnLogin(c, user.ID)
+	}
+
+	jwtToken, err := GenerateJWT(user.ID, h.cfg.Auth.JWT.Secret, h.cfg.Auth.JWT.ExpireHours)
+	if err != nil {
+		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"jwt_generation_failed")
+		return
+	}
+
+	if !strings.Contains(redirectURL, "?") {
+		redirectURL += "?"
+	} else {
+		redirectURL += "&"
+	}
+	redirectURL += "token=" + jwtToken
+
+	c.Redirect(http.StatusTemporaryRedirect, redirectURL)
+}
+
+// claimString reads a string claim by its configured name, falling back to the standard claim name.
+func claimString(claims map[string]interface{}, name, fallback string) string {
+	if name == "" {
+		name = fallback
+	}
+	if v, ok := claims[name].(string); ok {
+		return v
+	}
+	return ""
+}
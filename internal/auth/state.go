@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcFlowCookie = "csoj_oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+// oidcFlow is the per-login state kept in a signed cookie between Login and Callback.
+type oidcFlow struct {
+	Provider     string `json:"p"`
+	State        string `json:"s"`
+	Nonce        string `json:"n"`
+	CodeVerifier string `json:"v"`
+	ExpiresAt    int64  `json:"e"`
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func signFlow(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(oidcFlowCookie))
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setFlowCookie stores the flow in a short-lived, signed, HTTP-only cookie.
+func setFlowCookie(c *gin.Context, flow *oidcFlow, secret string) error {
+	payload, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload) + "." + signFlow(payload, secret)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// popFlowCookie reads and verifies the flow cookie, then clears it so it cannot be replayed.
+func popFlowCookie(c *gin.Context, secret string) (*oidcFlow, error) {
+	value, err := c.Cookie(oidcFlowCookie)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if err != nil {
+		return nil, errors.New("login flow cookie missing")
+	}
+
+	encoded, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errors.New("malformed login flow cookie")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("malformed login flow cookie")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signFlow(payload, secret))) {
+		return nil, errors.New("invalid login flow signature")
+	}
+
+	var flow oidcFlow
+	if err := json.Unmarshal(payload, &flow); err != nil {
+		return nil, errors.New("malformed login flow cookie")
+	}
+	if time.Now().Unix() > flow.ExpiresAt {
+		return nil, errors.New("login flow expired")
+	}
+	return &flow, nil
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Personal access token scopes. Read tokens may only use read-only endpoints; submit tokens may
+// also submit and manage submissions.
+const (
+	TokenScopeRead   = "read"
+	TokenScopeSubmit = "submit"
+)
+
+// accessTokenPrefix marks personal access tokens so they are easy to recognize in leaked configs.
+const accessTokenPrefix = "csoj_"
+
+// GenerateAccessToken returns a new personal access token and the hash to store for it. The
+// token itself is shown to the user once and never stored.
+func GenerateAccessToken() (token, hash string, err error) {
+	random, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	token = accessTokenPrefix + random
+	return token, HashAccessToken(token), nil
+}
+
+// HashAccessToken hashes a personal access token for lookup. Tokens carry 256 bits of
+// randomness, so a fast unsalted hash is enough.
+func HashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
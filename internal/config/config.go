@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,6 +13,36 @@ type CORS struct {
 	AllowedOrigins []string `yaml:"allowed_origins"`
 }
 
+// Websocket controls the keepalive of websocket connections.
+type Websocket struct {
+	PingIntervalSeconds int `yaml:"ping_interval_seconds"` // defaults to 30, negative disables pings
+	TopicIdleMinutes    int `yaml:"topic_idle_minutes"`    // defaults to 360, negative disables the reaper
+	ClientBuffer        int `yaml:"client_buffer"`         // defaults to 128
+}
+
+// ClientBufferSize returns how many live messages a websocket client may fall behind by before
+// it is disconnected and asked to reconnect.
+func (w Websocket) ClientBufferSize() int {
+	return limitOrDefault(w.ClientBuffer, 128)
+}
+
+// TopicIdle returns how long a broker topic without subscribers may stay idle before the reaper
+// closes it, or 0 if the reaper is disabled.
+func (w Websocket) TopicIdle() time.Duration {
+	return time.Duration(limitOrDefault(w.TopicIdleMinutes, 360)) * time.Minute
+}
+
+// PingInterval returns the interval between server pings, or 0 if pings are disabled.
+func (w Websocket) PingInterval() time.Duration {
+	if w.PingIntervalSeconds < 0 {
+		return 0
+	}
+	if w.PingIntervalSeconds == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(w.PingIntervalSeconds) * time.Second
+}
+
 type Link struct {
 	Name string `yaml:"name" json:"name"`
 	URL  string `yaml:"url"  json:"url"`
@@ -24,14 +57,118 @@ type Config struct {
 	Listen       string    `yaml:"listen"`
 	Admin        Admin     `yaml:"admin"`
 	CORS         CORS      `yaml:"cors"`
+	Websocket    Websocket `yaml:"websocket"`
+	Avatar       Avatar    `yaml:"avatar"`
+	Assets       Assets    `yaml:"assets"`
 	Links        []Link    `yaml:"links"`
+
+	DockerRetry  DockerRetry  `yaml:"docker_retry"`
+	OutputLimits OutputLimits `yaml:"output_limits"`
+
+	// UploadLimits is a safety ceiling on every problem's upload limits.
+	UploadLimits UploadLimits `yaml:"upload_limits"`
+
+	// MaxConcurrentTotal caps the number of running submissions across all clusters. 0 means no limit.
+	MaxConcurrentTotal int `yaml:"max_concurrent_total"`
+
+	// Bootstrap creates initial data for a new deployment.
+	Bootstrap Bootstrap `yaml:"bootstrap"`
+
+	// MaxBodyMB caps the size of every request body on both APIs, defaults to 256, negative disables.
+	MaxBodyMB int `yaml:"max_body_mb"`
+	// Timezone is the IANA zone used for times shown to users, empty means the system zone.
+	Timezone string `yaml:"timezone"`
+
+	// PostSubmissionHook runs a local command whenever a submission finishes judging.
+	PostSubmissionHook PostSubmissionHook `yaml:"post_submission_hook"`
+}
+
+// PostSubmissionHook is a command run on the server after every submission reaches a final
+// status, including by an interrupt, e.g. to sync results to an external gradebook. It is
+// disabled unless Command is set. The command runs without a shell; ${NAME} variables in its
+// arguments are replaced with the submission's metadata.
+type PostSubmissionHook struct {
+	Command        []string `yaml:"command"`         // program and arguments
+	TimeoutSeconds int      `yaml:"timeout_seconds"` // defaults to 30
+	MaxConcurrent  int      `yaml:"max_concurrent"`  // hooks running at once, defaults to 4
+}
+
+// Enabled reports whether a hook command is configured.
+func (h PostSubmissionHook) Enabled() bool {
+	return len(h.Command) > 0
+}
+
+// Timeout returns how long the hook may run before it is killed.
+func (h PostSubmissionHook) Timeout() time.Duration {
+	return time.Duration(limitOrDefault(h.TimeoutSeconds, 30)) * time.Second
+}
+
+// Concurrency returns how many hooks may run at once.
+func (h PostSubmissionHook) Concurrency() int {
+	return limitOrDefault(h.MaxConcurrent, 4)
+}
+
+// MaxBodyBytes returns the request body size limit, 0 meaning unlimited.
+func (c *Config) MaxBodyBytes() int64 {
+	return int64(limitOrDefault(c.MaxBodyMB, 256)) * 1024 * 1024
 }
 
 type Cluster struct {
 	Name  string `yaml:"name" json:"name"`
 	Nodes []Node `yaml:"node" json:"node"`
+	// MaxConcurrent caps the running submissions of this cluster regardless of free node
+	// resources, e.g. for a shared license server. 0 means no limit.
+	MaxConcurrent int `yaml:"max_concurrent" json:"max_concurrent"`
+	// Strategy picks among the nodes a submission fits on, see the NodeStrategy constants.
+	Strategy string `yaml:"strategy" json:"strategy"`
+	// Workers is the number of scheduler workers matching queued submissions to nodes, defaults to 1.
+	Workers int `yaml:"workers" json:"workers"`
+	// Scaling tunes the node count recommended to external autoscalers.
+	Scaling ClusterScaling `yaml:"scaling" json:"scaling"`
 }
 
+// WorkerCount returns the number of scheduler workers of the cluster.
+func (c *Cluster) WorkerCount() int {
+	return max(c.Workers, 1)
+}
+
+// ClusterScaling configures the desired node count of a cluster's scaling signal: enough nodes
+// for queued submissions to start within TargetWaitSeconds while TargetUtilization of the
+// capacity is busy.
+type ClusterScaling struct {
+	TargetWaitSeconds int     `yaml:"target_wait_seconds" json:"target_wait_seconds"` // defaults to 60
+	TargetUtilization float64 `yaml:"target_utilization" json:"target_utilization"`   // fraction in (0, 1], defaults to 0.8
+	SlotsPerNode      int     `yaml:"slots_per_node" json:"slots_per_node"`           // submissions a node runs at once, 0 estimates it from the cluster's problems
+	MinNodes          int     `yaml:"min_nodes" json:"min_nodes"`
+	MaxNodes          int     `yaml:"max_nodes" json:"max_nodes"`           // 0 means no limit
+	WindowMinutes     int     `yaml:"window_minutes" json:"window_minutes"` // throughput is measured over this, defaults to 15
+}
+
+// TargetWait returns how soon queued submissions should start.
+func (s ClusterScaling) TargetWait() time.Duration {
+	return time.Duration(limitOrDefault(s.TargetWaitSeconds, 60)) * time.Second
+}
+
+// Utilization returns the fraction of the capacity the recommendation aims to keep busy.
+func (s ClusterScaling) Utilization() float64 {
+	if s.TargetUtilization <= 0 {
+		return 0.8
+	}
+	return min(s.TargetUtilization, 1)
+}
+
+// Window returns the period recent throughput is measured over.
+func (s ClusterScaling) Window() time.Duration {
+	return time.Duration(limitOrDefault(s.WindowMinutes, 15)) * time.Minute
+}
+
+// Node selection strategies of a cluster.
+const (
+	NodeStrategyFirstFit = "firstfit" // first node in configuration order (default)
+	NodeStrategySpread   = "spread"   // least loaded node, to balance load
+	NodeStrategyBinPack  = "binpack"  // most loaded node, to keep others free for big jobs
+)
+
 type DockerConfig struct {
 	Host      string `yaml:"host"`
 	TLSVerify bool   `yaml:"tls_verify"`
@@ -47,22 +184,194 @@ type Node struct {
 	Docker DockerConfig `yaml:"docker" json:"docker"`
 }
 
+// UploadLimits are submission upload limits shared by several problems. 0 leaves a limit unset.
+type UploadLimits struct {
+	MaxNum   int `yaml:"maxnum" json:"max_num,omitempty"`
+	MaxSize  int `yaml:"maxsize" json:"max_size,omitempty"` // in MB
+	MaxDepth int `yaml:"max_depth" json:"max_depth,omitempty"`
+	// Uploaded zip/tar/gzip files may expand to at most MaxExpandedSize MB and MaxArchiveEntries entries in total.
+	MaxExpandedSize   int `yaml:"max_expanded_size" json:"max_expanded_size,omitempty"`
+	MaxArchiveEntries int `yaml:"max_archive_entries" json:"max_archive_entries,omitempty"`
+}
+
+// DockerRetry controls how container setup is retried after transient Docker daemon errors.
+type DockerRetry struct {
+	MaxRetries       int `yaml:"max_retries"`        // retries after the first attempt, 0 disables retrying
+	InitialBackoffMS int `yaml:"initial_backoff_ms"` // delay before the first retry, doubled for each further retry, defaults to 500
+}
+
+// OutputLimits protects the broker, websocket clients and memory from judge containers that
+// produce huge amounts of output.
+type OutputLimits struct {
+	LinesPerSecond int `yaml:"lines_per_second"` // streamed live per container, defaults to 1000, negative disables
+	BytesPerSecond int `yaml:"bytes_per_second"` // streamed live per container, defaults to 256 KiB, negative disables
+	MaxLogMB       int `yaml:"max_log_mb"`       // stored log per container, defaults to 64, negative disables
+	MaxArtifactMB  int `yaml:"max_artifact_mb"`  // stored artifacts per submission, defaults to 16, negative disables
+}
+
+func limitOrDefault(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return max(v, 0)
+}
+
+// StreamLimits returns how many lines and bytes of a container's output are streamed live per
+// second, 0 meaning unlimited.
+func (o OutputLimits) StreamLimits() (lines, bytes int) {
+	return limitOrDefault(o.LinesPerSecond, 1000), limitOrDefault(o.BytesPerSecond, 256*1024)
+}
+
+// MaxLogBytes returns the size cap of a container's stored log, 0 meaning unlimited.
+func (o OutputLimits) MaxLogBytes() int {
+	return limitOrDefault(o.MaxLogMB, 64) * 1024 * 1024
+}
+
+// MaxArtifactBytes returns the size cap of a submission's stored artifacts, 0 meaning unlimited.
+func (o OutputLimits) MaxArtifactBytes() int64 {
+	return int64(limitOrDefault(o.MaxArtifactMB, 16)) * 1024 * 1024
+}
+
 type Logger struct {
 	Level string `yaml:"level"`
 	File  string `yaml:"file"`
 }
 
 type Storage struct {
-	UserAvatar        string `yaml:"user_avatar"`
-	SubmissionContent string `yaml:"submission_content"`
-	Database          string `yaml:"database"`
-	SubmissionLog     string `yaml:"submission_log"`
+	UserAvatar        string     `yaml:"user_avatar"`
+	SubmissionContent string     `yaml:"submission_content"`
+	CompressContent   bool       `yaml:"compress_content"` // store each submission's content as one .tar.gz instead of a directory
+	Driver            string     `yaml:"driver"`           // database backend: sqlite (default), postgres or mysql
+	Database          string     `yaml:"database"`         // SQLite file path, or the connection string of the other drivers
+	SubmissionLog     string     `yaml:"submission_log"`
+	Artifacts         string     `yaml:"artifacts"`       // files judges leave for submitters, defaults to a directory next to submission_content
+	UploadSessions    string     `yaml:"upload_sessions"` // chunks of unfinished uploads, defaults to a directory under os.TempDir()
+	Retention         Retention  `yaml:"retention"`
+	RecycleBin        RecycleBin `yaml:"recycle_bin"`
+}
+
+// Avatar controls how user avatars are stored and served.
+type Avatar struct {
+	ProxyExternal bool   `yaml:"proxy_external"` // download OIDC provider pictures into storage.user_avatar at login
+	Default       string `yaml:"default"`        // image file served for users without an avatar
+}
+
+// Assets restricts the files admins may upload to the index.assets directories of contests and
+// problems, which are served to every user.
+type Assets struct {
+	MaxSizeMB         int      `yaml:"max_size_mb"`        // per file, defaults to 10, negative disables
+	AllowedExtensions []string `yaml:"allowed_extensions"` // e.g. ".png", defaults to DefaultAssetExtensions
+	AllowedTypes      []string `yaml:"allowed_types"`      // detected MIME types such as "image/png" or "image/*", defaults to DefaultAssetTypes
+}
+
+// DefaultAssetExtensions and DefaultAssetTypes cover images, documents and archives commonly
+// linked from statements. SVG and HTML are left out as they can carry scripts.
+var (
+	DefaultAssetExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".pdf", ".txt", ".md", ".csv", ".json", ".zip", ".gz", ".mp4"}
+	DefaultAssetTypes      = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain", "application/zip", "application/x-gzip", "video/mp4"}
+)
+
+// MaxBytes returns the size limit of an uploaded asset, 0 meaning unlimited.
+func (a Assets) MaxBytes() int64 {
+	return int64(limitOrDefault(a.MaxSizeMB, 10)) * 1024 * 1024
+}
+
+// Extensions returns the allowed file extensions in lower case.
+func (a Assets) Extensions() []string {
+	if len(a.AllowedExtensions) == 0 {
+		return DefaultAssetExtensions
+	}
+	exts := make([]string, len(a.AllowedExtensions))
+	for i, ext := range a.AllowedExtensions {
+		exts[i] = strings.ToLower(ext)
+	}
+	return exts
+}
+
+// Types returns the allowed MIME types.
+func (a Assets) Types() []string {
+	if len(a.AllowedTypes) == 0 {
+		return DefaultAssetTypes
+	}
+	return a.AllowedTypes
+}
+
+// Retention defines how long submission content and logs are kept on disk.
+type Retention struct {
+	Days          int  `yaml:"days"`           // 0 disables the janitor
+	IntervalHours int  `yaml:"interval_hours"` // how often the janitor runs, defaults to 24
+	DeleteRecords bool `yaml:"delete_records"` // also delete the database rows of expired submissions
+}
+
+// RecycleBin keeps deleted submissions restorable for a while.
+type RecycleBin struct {
+	Path          string `yaml:"path"`           // defaults to a recycle_bin directory next to submission_content
+	RetentionDays int    `yaml:"retention_days"` // defaults to 7
+}
+
+// RecycleBinPath returns the directory the content of deleted submissions is moved to.
+func (s Storage) RecycleBinPath() string {
+	if s.RecycleBin.Path != "" {
+		return s.RecycleBin.Path
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(s.SubmissionContent)), "recycle_bin")
+}
+
+// ArtifactsPath returns the directory holding the judge artifacts of each submission.
+func (s Storage) ArtifactsPath() string {
+	if s.Artifacts != "" {
+		return s.Artifacts
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(s.SubmissionContent)), "artifacts")
+}
+
+// Retention returns how long deleted submissions can be restored before they are purged.
+func (r RecycleBin) Retention() time.Duration {
+	if r.RetentionDays == 0 {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(r.RetentionDays) * 24 * time.Hour
+}
+
+// Bootstrap holds data created on the first start of a new deployment.
+type Bootstrap struct {
+	Admin BootstrapAdmin `yaml:"admin"`
+}
+
+// BootstrapAdmin is a local account created at startup if no user with its username exists,
+// including deleted users, so it is created only once.
+type BootstrapAdmin struct {
+	Username     string `yaml:"username"`      // empty disables the bootstrap
+	Nickname     string `yaml:"nickname"`      // defaults to the username
+	PasswordHash string `yaml:"password_hash"` // bcrypt hash of the password
+	PasswordEnv  string `yaml:"password_env"`  // environment variable with the plain password, used without password_hash
 }
 
 type Auth struct {
-	JWT    JWT    `yaml:"jwt"`
-	GitLab GitLab `yaml:"gitlab"`
-	Local  Local  `yaml:"local"`
+	JWT      JWT            `yaml:"jwt"`
+	GitLab   GitLab         `yaml:"gitlab"`
+	OIDC     []OIDCProvider `yaml:"oidc"`
+	Local    Local          `yaml:"local"`
+	AssetURL AssetURL       `yaml:"asset_url"`
+}
+
+// AssetURL controls the signed URLs handed out for contest and problem assets.
+type AssetURL struct {
+	SingleUse bool `yaml:"single_use"` // each URL can only be fetched once
+	BindUser  bool `yaml:"bind_user"`  // fetching requires the JWT of the user the URL was issued to
+	Legacy    bool `yaml:"legacy"`     // issue and accept the old reusable path|expires tokens
+	// Secret signs asset URLs, falling back to auth.jwt.secret. PreviousSecret is still accepted
+	// while a secret rotation is rolled out.
+	Secret         string `yaml:"secret"`
+	PreviousSecret string `yaml:"previous_secret"`
+}
+
+// AssetSigningSecret returns the secret that signs asset URLs.
+func (a Auth) AssetSigningSecret() string {
+	if a.AssetURL.Secret != "" {
+		return a.AssetURL.Secret
+	}
+	return a.JWT.Secret
 }
 
 // Local defines configuration for username/password authentication.
@@ -84,9 +393,60 @@ type GitLab struct {
 	FrontendCallbackURL string `yaml:"frontend_callback_url"`
 }
 
+// OIDCProvider defines a generic OpenID Connect login provider.
+type OIDCProvider struct {
+	Name                string           `yaml:"name"`
+	DisplayName         string           `yaml:"display_name"`
+	IssuerURL           string           `yaml:"issuer_url"`
+	ClientID            string           `yaml:"client_id"`
+	ClientSecret        string           `yaml:"client_secret"`
+	RedirectURI         string           `yaml:"redirect_uri"`
+	FrontendCallbackURL string           `yaml:"frontend_callback_url"`
+	Scopes              []string         `yaml:"scopes"`
+	Claims              OIDCClaimMapping `yaml:"claims"`
+}
+
+// OIDCClaimMapping names the ID token claims used to fill in a new user's profile.
+type OIDCClaimMapping struct {
+	Username string `yaml:"username"` // defaults to "preferred_username"
+	Name     string `yaml:"name"`     // defaults to "name"
+	Picture  string `yaml:"picture"`  // defaults to "picture"
+}
+
+// OIDCProviders returns all configured OIDC providers. The legacy gitlab section is
+// included as a provider named "gitlab" unless one is configured explicitly.
+func (a Auth) OIDCProviders() []OIDCProvider {
+	providers := append([]OIDCProvider(nil), a.OIDC...)
+	if a.GitLab.URL == "" {
+		return providers
+	}
+	for _, p := range providers {
+		if p.Name == "gitlab" {
+			return providers
+		}
+	}
+	return append(providers, OIDCProvider{
+		Name:                "gitlab",
+		DisplayName:         "GitLab",
+		IssuerURL:           a.GitLab.URL,
+		ClientID:            a.GitLab.ClientID,
+		ClientSecret:        a.GitLab.ClientSecret,
+		RedirectURI:         a.GitLab.RedirectURI,
+		FrontendCallbackURL: a.GitLab.FrontendCallbackURL,
+	})
+}
+
 type Admin struct {
-	Enabled bool   `yaml:"enabled"`
-	Listen  string `yaml:"listen"`
+	Enabled bool       `yaml:"enabled"`
+	Listen  string     `yaml:"listen"`
+	Keys    []AdminKey `yaml:"keys"`
+}
+
+// AdminKey is an API key for the admin API. Role is "viewer" (read-only) or "operator" (full access).
+type AdminKey struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	Role string `yaml:"role"`
 }
 
 func Load(path string) (*Config, error) {
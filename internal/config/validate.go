@@ -0,0 +1,199 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Validate checks the configuration for missing or inconsistent values, so misconfiguration
+// is reported at startup instead of at runtime. All problems are returned as one joined error.
+func (c *Config) Validate() error {
+	var errs []error
+	addf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Listen == "" {
+		addf("listen must not be empty")
+	}
+	if c.ContestsRoot == "" {
+		addf("contests_root must not be empty")
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			addf("timezone %q is not a valid IANA time zone: %v", c.Timezone, err)
+		}
+	}
+	if c.Admin.Enabled && c.Admin.Listen == "" {
+		addf("admin.listen must not be empty when the admin API is enabled")
+	}
+	adminNames := make(map[string]bool)
+	adminKeys := make(map[string]bool)
+	for i, key := range c.Admin.Keys {
+		if key.Name == "" {
+			addf("admin.keys[%d].name must not be empty", i)
+		} else if adminNames[key.Name] {
+			addf("admin.keys: duplicate name %q", key.Name)
+		}
+		adminNames[key.Name] = true
+		if len(key.Key) < 16 {
+			addf("admin key %q must be at least 16 characters long", key.Name)
+		} else if adminKeys[key.Key] {
+			addf("admin key %q reuses the key of another entry", key.Name)
+		}
+		adminKeys[key.Key] = true
+		if key.Role != "viewer" && key.Role != "operator" {
+			addf("admin key %q: role must be \"viewer\" or \"operator\", got %q", key.Name, key.Role)
+		}
+	}
+
+	if c.Auth.JWT.Secret == "" {
+		addf("auth.jwt.secret must not be empty")
+	}
+	if c.Auth.JWT.ExpireHours <= 0 {
+		addf("auth.jwt.expire_hours must be positive, got %d", c.Auth.JWT.ExpireHours)
+	}
+	providerNames := make(map[string]bool)
+	for i, p := range c.Auth.OIDCProviders() {
+		if p.Name == "" {
+			addf("auth.oidc[%d].name must not be empty", i)
+		} else if providerNames[p.Name] {
+			addf("auth.oidc: duplicate provider name %q", p.Name)
+		}
+		providerNames[p.Name] = true
+		if p.IssuerURL == "" {
+			addf("auth.oidc provider %q: issuer_url must not be empty", p.Name)
+		}
+		if p.ClientID == "" {
+			addf("auth.oidc provider %q: client_id must not be empty", p.Name)
+		}
+		if p.RedirectURI == "" {
+			addf("auth.oidc provider %q: redirect_uri must not be empty", p.Name)
+		}
+	}
+
+	for _, path := range []struct{ key, value string }{
+		{"storage.user_avatar", c.Storage.UserAvatar},
+		{"storage.submission_content", c.Storage.SubmissionContent},
+		{"storage.submission_log", c.Storage.SubmissionLog},
+		{"storage.database", c.Storage.Database},
+	} {
+		if path.value == "" {
+			addf("%s must not be empty", path.key)
+		}
+	}
+	switch c.Storage.Driver {
+	case "", "sqlite", "postgres", "mysql":
+	default:
+		addf("storage.driver %q must be sqlite, postgres or mysql", c.Storage.Driver)
+	}
+	if c.Storage.Retention.Days < 0 {
+		addf("storage.retention.days must not be negative")
+	}
+	if c.Storage.Retention.IntervalHours < 0 {
+		addf("storage.retention.interval_hours must not be negative")
+	}
+	if c.Storage.RecycleBin.RetentionDays < 0 {
+		addf("storage.recycle_bin.retention_days must not be negative")
+	}
+
+	for _, ext := range c.Assets.AllowedExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			addf("assets.allowed_extensions: %q must start with a dot", ext)
+		}
+	}
+
+	if admin := c.Bootstrap.Admin; admin.Username != "" {
+		if !c.Auth.Local.Enabled {
+			addf("bootstrap.admin needs auth.local.enabled to log in with the account")
+		}
+		if admin.PasswordHash == "" && admin.PasswordEnv == "" {
+			addf("bootstrap.admin: password_hash or password_env must be set")
+		}
+		if admin.PasswordHash != "" {
+			if _, err := bcrypt.Cost([]byte(admin.PasswordHash)); err != nil {
+				addf("bootstrap.admin.password_hash is not a bcrypt hash: %v", err)
+			}
+		}
+	}
+
+	if len(c.Cluster) == 0 {
+		addf("at least one cluster must be configured")
+	}
+	clusterNames := make(map[string]bool)
+	for i, cluster := range c.Cluster {
+		if cluster.Name == "" {
+			addf("cluster[%d].name must not be empty", i)
+		} else if clusterNames[cluster.Name] {
+			addf("duplicate cluster name %q", cluster.Name)
+		}
+		clusterNames[cluster.Name] = true
+		if cluster.MaxConcurrent < 0 {
+			addf("cluster %q: max_concurrent must not be negative", cluster.Name)
+		}
+		if cluster.Workers < 0 {
+			addf("cluster %q: workers must not be negative", cluster.Name)
+		}
+		if s := cluster.Scaling; s.TargetWaitSeconds < 0 || s.TargetUtilization < 0 || s.TargetUtilization > 1 ||
+			s.SlotsPerNode < 0 || s.MinNodes < 0 || s.MaxNodes < 0 || s.WindowMinutes < 0 {
+			addf("cluster %q: scaling values must not be negative and target_utilization must be at most 1", cluster.Name)
+		} else if s.MaxNodes > 0 && s.MinNodes > s.MaxNodes {
+			addf("cluster %q: scaling.min_nodes must not exceed scaling.max_nodes", cluster.Name)
+		}
+		switch cluster.Strategy {
+		case "", NodeStrategyFirstFit, NodeStrategySpread, NodeStrategyBinPack:
+		default:
+			addf("cluster %q: unknown strategy %q, expected %q, %q or %q", cluster.Name, cluster.Strategy, NodeStrategyFirstFit, NodeStrategySpread, NodeStrategyBinPack)
+		}
+
+		if len(cluster.Nodes) == 0 {
+			addf("cluster %q has no nodes", cluster.Name)
+		}
+		nodeNames := make(map[string]bool)
+		for j, node := range cluster.Nodes {
+			if node.Name == "" {
+				addf("cluster %q: node[%d].name must not be empty", cluster.Name, j)
+			} else if nodeNames[node.Name] {
+				addf("cluster %q: duplicate node name %q", cluster.Name, node.Name)
+			}
+			nodeNames[node.Name] = true
+
+			if node.CPU <= 0 {
+				addf("node %s/%s: cpu must be positive, got %d", cluster.Name, node.Name, node.CPU)
+			}
+			if node.Memory <= 0 {
+				addf("node %s/%s: memory must be positive, got %d", cluster.Name, node.Name, node.Memory)
+			}
+			if node.Docker.Host == "" {
+				addf("node %s/%s: docker.host must not be empty", cluster.Name, node.Name)
+			}
+			if node.Docker.TLSVerify && (node.Docker.CACert == "" || node.Docker.Cert == "" || node.Docker.Key == "") {
+				addf("node %s/%s: docker.ca_cert, docker.cert and docker.key are required when tls_verify is enabled", cluster.Name, node.Name)
+			}
+		}
+	}
+
+	if c.MaxConcurrentTotal < 0 {
+		addf("max_concurrent_total must not be negative")
+	}
+	if c.DockerRetry.MaxRetries < 0 || c.DockerRetry.InitialBackoffMS < 0 {
+		addf("docker_retry values must not be negative")
+	}
+	if c.Websocket.ClientBuffer < 0 {
+		addf("websocket.client_buffer must not be negative")
+	}
+	if hook := c.PostSubmissionHook; hook.Enabled() {
+		if hook.Command[0] == "" {
+			addf("post_submission_hook.command must start with the program to run")
+		}
+		if hook.TimeoutSeconds < 0 || hook.MaxConcurrent < 0 {
+			addf("post_submission_hook values must not be negative")
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"gorm.io/gorm"
+)
+
+// createAll inserts rows, failing the test on the first error.
+func createAll(t *testing.T, db *gorm.DB, rows ...any) {
+	t.Helper()
+	for _, row := range rows {
+		if err := db.Omit("User").Create(row).Error; err != nil {
+			t.Fatalf("create %T: %v", row, err)
+		}
+	}
+}
+
+func TestGetLeaderboard(t *testing.T) {
+	base := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	for driver, db := range testBackends(t) {
+		t.Run(driver, func(t *testing.T) {
+			createAll(t, db,
+				&models.User{ID: "u1", Username: "first", Tags: "cs, y2024"},
+				&models.User{ID: "u2", Username: "second", Tags: "y2024"},
+				&models.User{ID: "u3", Username: "third", Tags: "cs"},
+				&models.User{ID: "u4", Username: "fourth", Tags: "ee"},
+				&models.User{ID: "u5", Username: "other-contest"},
+			)
+			// Registration is the first history row; u4 registered before u3.
+			createAll(t, db,
+				&models.ContestScoreHistory{UserID: "u1", ContestID: "c", CreatedAt: at(0)},
+				&models.ContestScoreHistory{UserID: "u2", ContestID: "c", CreatedAt: at(1)},
+				&models.ContestScoreHistory{UserID: "u4", ContestID: "c", CreatedAt: at(2)},
+				&models.ContestScoreHistory{UserID: "u3", ContestID: "c", CreatedAt: at(3)},
+				&models.ContestScoreHistory{UserID: "u1", ContestID: "c", ProblemID: "p1", CreatedAt: at(30)},
+				&models.ContestScoreHistory{UserID: "u5", ContestID: "other", CreatedAt: at(0)},
+			)
+			// u1 and u2 tie on 150; u2 reached it first.
+			createAll(t, db,
+				&models.UserProblemBestScore{UserID: "u1", ContestID: "c", ProblemID: "p1", Score: 100, LastScoreTime: at(30)},
+				&models.UserProblemBestScore{UserID: "u1", ContestID: "c", ProblemID: "p2", Score: 50, LastScoreTime: at(50)},
+				&models.UserProblemBestScore{UserID: "u2", ContestID: "c", ProblemID: "p1", Score: 150, LastScoreTime: at(40)},
+				&models.UserProblemBestScore{UserID: "u5", ContestID: "other", ProblemID: "p9", Score: 999, LastScoreTime: at(1)},
+			)
+
+			board, err := GetLeaderboard(db, "c", "", nil)
+			if err != nil {
+				t.Fatalf("leaderboard: %v", err)
+			}
+			var order []string
+			for _, entry := range board {
+				order = append(order, entry.UserID)
+			}
+			if want := []string{"u2", "u1", "u4", "u3"}; !reflect.DeepEqual(order, want) {
+				t.Errorf("order %v, want %v", order, want)
+			}
+			if board[1].TotalScore != 150 || board[1].ProblemScores["p2"] != 50 {
+				t.Errorf("u1: total %d, scores %v", board[1].TotalScore, board[1].ProblemScores)
+			}
+
+			tagged, err := GetLeaderboard(db, "c", "cs", nil)
+			if err != nil {
+				t.Fatalf("leaderboard by tag: %v", err)
+			}
+			order = order[:0]
+			for _, entry := range tagged {
+				order = append(order, entry.UserID)
+			}
+			if want := []string{"u1", "u3"}; !reflect.DeepEqual(order, want) {
+				t.Errorf("tag cs: order %v, want %v", order, want)
+			}
+		})
+	}
+}
+
+func TestGetProblemStatsBuckets(t *testing.T) {
+	for driver, db := range testBackends(t) {
+		t.Run(driver, func(t *testing.T) {
+			for i, row := range []struct {
+				score int
+				perf  float64
+			}{{0, 1}, {5, 2}, {15, 3.5}, {100, 5}} {
+				createAll(t, db, &models.UserProblemBestScore{
+					UserID: string(rune('a' + i)), ContestID: "c", ProblemID: "p", Score: row.score, Performance: row.perf,
+				})
+			}
+			stats, err := GetProblemStats(db, "p", 10, 4)
+			if err != nil {
+				t.Fatalf("stats: %v", err)
+			}
+			// Scores fall into [0,10) twice, [10,20) and [100,110).
+			wantScores := []ScoreBucket{{Min: 0, Max: 10, Count: 2}, {Min: 10, Max: 20, Count: 1}, {Min: 100, Max: 110, Count: 1}}
+			if !reflect.DeepEqual(stats.ScoreDistribution, wantScores) {
+				t.Errorf("score buckets %v, want %v", stats.ScoreDistribution, wantScores)
+			}
+			// Performances 1..5 in four buckets of width 1; the maximum lands in the last bucket.
+			var counts []int64
+			for _, b := range stats.PerformanceDistribution {
+				counts = append(counts, b.Count)
+			}
+			if want := []int64{1, 1, 1, 1}; !reflect.DeepEqual(counts, want) || len(stats.PerformanceDistribution) != 4 || stats.PerformanceDistribution[3].Min != 4 {
+				t.Errorf("performance buckets %v, want four buckets of one", stats.PerformanceDistribution)
+			}
+		})
+	}
+}
+
+func TestCopyAll(t *testing.T) {
+	src, err := Init(DriverSQLite, filepath.Join(t.TempDir(), "src.db"))
+	if err != nil {
+		t.Fatalf("open source: %v", err)
+	}
+	deleted := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	limit := 5
+	createAll(t, src,
+		&models.User{ID: "u1", Username: "alice"},
+		&models.User{ID: "u2", Username: "bob", DeletedAt: deleted},
+		&models.Submission{ID: "s1", UserID: "u1", ProblemID: "p", Status: models.StatusSuccess, Score: 80},
+		&models.Submission{ID: "s2", UserID: "u1", ProblemID: "p", DeletedAt: deleted},
+		&models.Container{ID: "k1", SubmissionID: "s1", UserID: "u1"},
+		&models.ContestScoreHistory{ID: 7, UserID: "u1", ContestID: "c"},
+		&models.ContestScoreHistory{ID: 42, UserID: "u1", ContestID: "c", ProblemID: "p"},
+		&models.UserProblemBestScore{ID: 3, UserID: "u1", ContestID: "c", ProblemID: "p", Score: 80, SubmissionID: "s1"},
+		&models.Tag{Name: "cs"},
+		&models.AuditLog{ID: 11, Actor: "admin", Action: "test"},
+		&models.FinalStanding{ID: 9, ContestID: "c", UserID: "u1", Rank: 1},
+		&models.SubmissionNote{ID: 4, SubmissionID: "s1", Content: "note"},
+		&models.PersonalAccessToken{ID: "t1", UserID: "u1", TokenHash: "hash"},
+		&models.ContestUsage{UserID: "u1", ContestID: "c", Runs: 2, RunsLimit: &limit},
+	)
+
+	for driver, dst := range testBackends(t) {
+		t.Run(driver, func(t *testing.T) {
+			if err := CopyAll(dst, src); err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+			for _, model := range allModels {
+				var want, got int64
+				src.Unscoped().Model(model).Count(&want)
+				if err := dst.Unscoped().Model(model).Count(&got).Error; err != nil {
+					t.Fatalf("count %T: %v", model, err)
+				}
+				if got != want || want == 0 {
+					t.Errorf("%T: copied %d rows of %d", model, got, want)
+				}
+			}
+
+			var ids []uint
+			dst.Model(&models.ContestScoreHistory{}).Order("id").Pluck("id", &ids)
+			if !reflect.DeepEqual(ids, []uint{7, 42}) {
+				t.Errorf("history ids %v, want [7 42]", ids)
+			}
+			var best models.UserProblemBestScore
+			if err := dst.First(&best, 3).Error; err != nil || best.SubmissionID != "s1" || best.Score != 80 {
+				t.Errorf("best score 3: %+v, %v", best, err)
+			}
+			var sub models.Submission
+			if err := dst.Unscoped().First(&sub, "id = ?", "s2").Error; err != nil || !sub.DeletedAt.Valid {
+				t.Errorf("soft-deleted submission not kept as deleted: %+v, %v", sub.DeletedAt, err)
+			}
+			var usage models.ContestUsage
+			if err := dst.First(&usage, "user_id = ? AND contest_id = ?", "u1", "c").Error; err != nil || usage.RunsLimit == nil || *usage.RunsLimit != 5 {
+				t.Errorf("usage not copied: %+v, %v", usage, err)
+			}
+
+			// New rows continue after the copied ids.
+			next := models.ContestScoreHistory{UserID: "u1", ContestID: "c"}
+			if err := dst.Create(&next).Error; err != nil {
+				t.Fatalf("insert after copy: %v", err)
+			}
+			if next.ID <= 42 {
+				t.Errorf("new history row got id %d, want above 42", next.ID)
+			}
+
+			if err := CopyAll(dst, src); err == nil {
+				t.Error("copying into a non-empty database succeeded")
+			}
+		})
+	}
+}
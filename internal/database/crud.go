@@ -42,9 +42,9 @@ func GetUserByUsername(db *gorm.DB, username string) (*models.User, error) {
 	return &user, nil
 }
 
-func GetUserByGitLabID(db *gorm.DB, gitlabID string) (*models.User, error) {
+func GetUserByOIDCIdentity(db *gorm.DB, provider, subject string) (*models.User, error) {
 	var user models.User
-	if err := db.Where("git_lab_id = ?", gitlabID).First(&user).Error; err != nil {
+	if err := db.Where("oidc_provider = ? AND oidc_subject = ?", provider, subject).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -63,7 +63,15 @@ func UpdateUser(db *gorm.DB, user *models.User) error {
 }
 
 func DeleteUser(db *gorm.DB, userID string) error {
-	return db.Delete(&models.User{}, "id = ?", userID).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.PersonalAccessToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.ContestUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, "id = ?", userID).Error
+	})
 }
 
 // Submission CRUD
@@ -79,6 +87,16 @@ func GetSubmission(db *gorm.DB, id string) (*models.Submission, error) {
 	return &sub, nil
 }
 
+// GetRunningSubmissionsOnNode returns the submissions running on a node, with their containers.
+func GetRunningSubmissionsOnNode(db *gorm.DB, cluster, node string) ([]models.Submission, error) {
+	var subs []models.Submission
+	err := db.Preload("Containers").
+		Where("status = ? AND cluster = ? AND node = ?", models.StatusRunning, cluster, node).
+		Order("created_at asc").
+		Find(&subs).Error
+	return subs, err
+}
+
 func GetSubmissionsByUserID(db *gorm.DB, userID string) ([]models.Submission, error) {
 	var subs []models.Submission
 	if err := db.Preload("User").Where("user_id = ?", userID).Order("created_at desc").Find(&subs).Error; err != nil {
@@ -87,6 +105,92 @@ func GetSubmissionsByUserID(db *gorm.DB, userID string) ([]models.Submission, er
 	return subs, nil
 }
 
+// GetLastSubmissionTime returns when the user last submitted to the problem, ignoring dry runs.
+// The zero time is returned if there is no such submission.
+func GetLastSubmissionTime(db *gorm.DB, userID, problemID string) (time.Time, error) {
+	var sub models.Submission
+	err := db.Select("created_at").
+		Where("user_id = ? AND problem_id = ? AND dry_run = ?", userID, problemID, false).
+		Order("created_at desc").
+		First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	return sub.CreatedAt, err
+}
+
+// GetAverageRunDuration averages how long the latest successful, non-dry-run submissions of a
+// problem took from their first container start to their last container finish. It returns the
+// number of submissions the average is based on, which is 0 if there are none.
+func GetAverageRunDuration(db *gorm.DB, problemID string, sample int) (time.Duration, int, error) {
+	var subs []models.Submission
+	err := db.Select("id").
+		Preload("Containers", func(tx *gorm.DB) *gorm.DB { return tx.Select("submission_id", "started_at", "finished_at") }).
+		Where("problem_id = ? AND status = ? AND dry_run = ?", problemID, models.StatusSuccess, false).
+		Order("created_at desc").
+		Limit(sample).
+		Find(&subs).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total time.Duration
+	n := 0
+	for _, sub := range subs {
+		if start, end := runSpan(sub); !start.IsZero() && end.After(start) {
+			total += end.Sub(start)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0, nil
+	}
+	return total / time.Duration(n), n, nil
+}
+
+// runSpan returns the first container start and the last container finish of a submission,
+// zero if none of its containers has finished.
+func runSpan(sub models.Submission) (start, end time.Time) {
+	for _, con := range sub.Containers {
+		if con.StartedAt.IsZero() || con.FinishedAt.IsZero() {
+			continue
+		}
+		if start.IsZero() || con.StartedAt.Before(start) {
+			start = con.StartedAt
+		}
+		if con.FinishedAt.After(end) {
+			end = con.FinishedAt
+		}
+	}
+	return start, end
+}
+
+// GetClusterThroughput returns how many submissions of a cluster finished running since the
+// given time and their average run time from first container start to last container finish.
+func GetClusterThroughput(db *gorm.DB, cluster string, since time.Time) (int, time.Duration, error) {
+	var subs []models.Submission
+	err := db.Select("id").
+		Preload("Containers", func(tx *gorm.DB) *gorm.DB { return tx.Select("submission_id", "started_at", "finished_at") }).
+		Where("cluster = ? AND status IN ? AND updated_at >= ?", cluster, []models.Status{models.StatusSuccess, models.StatusFailed}, since).
+		Find(&subs).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total time.Duration
+	n := 0
+	for _, sub := range subs {
+		if start, end := runSpan(sub); !start.IsZero() && !end.Before(since) && end.After(start) {
+			total += end.Sub(start)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0, nil
+	}
+	return n, total / time.Duration(n), nil
+}
+
 func GetAllSubmissions(db *gorm.DB) ([]models.Submission, error) {
 	var subs []models.Submission
 	if err := db.Preload("User").Order("created_at desc").Find(&subs).Error; err != nil {
@@ -103,6 +207,155 @@ func UpdateSubmissionValidity(db *gorm.DB, id string, isValid bool) error {
 	return db.Model(&models.Submission{}).Where("id = ?", id).Update("is_valid", isValid).Error
 }
 
+// GetExpiredSubmissions returns finished submissions created before the given time that are
+// not the current best score of any user.
+func GetExpiredSubmissions(db *gorm.DB, before time.Time) ([]models.Submission, error) {
+	var subs []models.Submission
+	bestScores := db.Model(&models.UserProblemBestScore{}).Select("submission_id")
+	err := db.Preload("Containers").
+		Where("created_at < ?", before).
+		Where("status NOT IN ?", []models.Status{models.StatusQueued, models.StatusRunning}).
+		Where("id NOT IN (?)", bestScores).
+		Find(&subs).Error
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// DeleteSubmissionRecord permanently deletes a submission and its containers from the database,
+// whether or not it is in the recycle bin.
+func DeleteSubmissionRecord(db *gorm.DB, id string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("submission_id = ?", id).Delete(&models.Container{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("submission_id = ?", id).Delete(&models.SubmissionNote{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id = ?", id).Delete(&models.Submission{}).Error
+	})
+}
+
+// DeleteQueuedSubmission permanently deletes a submission if it is still queued, reporting
+// whether it was. Once it returns true, the scheduler can no longer start the submission.
+func DeleteQueuedSubmission(db *gorm.DB, id string) (bool, error) {
+	deleted := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("id = ? AND status = ?", id, models.StatusQueued).Delete(&models.Submission{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		deleted = true
+		if err := tx.Where("submission_id = ?", id).Delete(&models.Container{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("submission_id = ?", id).Delete(&models.SubmissionNote{}).Error
+	})
+	return deleted && err == nil, err
+}
+
+// Recycle bin
+
+// SoftDeleteSubmission moves a submission to the recycle bin. Its containers and notes are kept
+// so it can be restored as it was.
+func SoftDeleteSubmission(db *gorm.DB, id string) error {
+	return db.Where("id = ?", id).Delete(&models.Submission{}).Error
+}
+
+// GetDeletedSubmissions returns a page of the submissions in the recycle bin, most recently
+// deleted first, and their total number.
+func GetDeletedSubmissions(db *gorm.DB, offset, limit int) ([]models.Submission, int64, error) {
+	var total int64
+	query := db.Unscoped().Model(&models.Submission{}).Where("deleted_at IS NOT NULL")
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var subs []models.Submission
+	err := query.Preload("User").Order("deleted_at DESC").Offset(offset).Limit(limit).Find(&subs).Error
+	return subs, total, err
+}
+
+// GetDeletedSubmission returns a submission in the recycle bin, or gorm.ErrRecordNotFound if
+// there is no such submission or it was not deleted.
+func GetDeletedSubmission(db *gorm.DB, id string) (*models.Submission, error) {
+	var sub models.Submission
+	err := db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&sub).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// RestoreSubmission takes a submission out of the recycle bin.
+func RestoreSubmission(db *gorm.DB, id string) error {
+	return db.Unscoped().Model(&models.Submission{}).Where("id = ?", id).Update("deleted_at", nil).Error
+}
+
+// GetSubmissionsDeletedBefore returns the submissions moved to the recycle bin before the given
+// time, with their containers.
+func GetSubmissionsDeletedBefore(db *gorm.DB, before time.Time) ([]models.Submission, error) {
+	var subs []models.Submission
+	err := db.Unscoped().Preload("Containers").
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Find(&subs).Error
+	return subs, err
+}
+
+// Submission notes
+
+func CreateSubmissionNote(db *gorm.DB, note *models.SubmissionNote) error {
+	return db.Create(note).Error
+}
+
+// GetSubmissionNotes returns the notes of a submission, oldest first.
+func GetSubmissionNotes(db *gorm.DB, submissionID string) ([]models.SubmissionNote, error) {
+	var notes []models.SubmissionNote
+	err := db.Where("submission_id = ?", submissionID).Order("id asc").Find(&notes).Error
+	return notes, err
+}
+
+// DeleteSubmissionNote deletes a note of a submission, returning gorm.ErrRecordNotFound if the
+// submission has no such note.
+func DeleteSubmissionNote(db *gorm.DB, submissionID string, noteID uint) error {
+	result := db.Where("id = ? AND submission_id = ?", noteID, submissionID).Delete(&models.SubmissionNote{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountSubmissionNotes returns the number of notes of each of the given submissions that has any.
+func CountSubmissionNotes(db *gorm.DB, submissionIDs []string) (map[string]int64, error) {
+	var rows []struct {
+		SubmissionID string
+		Count        int64
+	}
+	err := db.Model(&models.SubmissionNote{}).
+		Select("submission_id, COUNT(*) as count").
+		Where("submission_id IN ?", submissionIDs).
+		Group("submission_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		counts[r.SubmissionID] = r.Count
+	}
+	return counts, nil
+}
+
+// GetQueuedSubmissions returns all submissions waiting to be judged, oldest first.
+func GetQueuedSubmissions(db *gorm.DB) ([]models.Submission, error) {
+	var subs []models.Submission
+	err := db.Where("status = ?", models.StatusQueued).Order("created_at asc").Find(&subs).Error
+	return subs, err
+}
+
 // CountQueuedSubmissionsBefore counts the number of submissions in the queue for a specific cluster that were created before a given time.
 func CountQueuedSubmissionsBefore(db *gorm.DB, cluster string, createdAt time.Time) (int64, error) {
 	var count int64
@@ -129,6 +382,12 @@ func UpdateContainer(db *gorm.DB, container *models.Container) error {
 	return db.Save(container).Error
 }
 
+// UpdateContainerCommand records which command of its workflow step a container is running.
+// Only that column is written, so it does not race with saves of the whole record.
+func UpdateContainerCommand(db *gorm.DB, id string, command int) error {
+	return db.Model(&models.Container{}).Where("id = ?", id).Update("current_command", command).Error
+}
+
 func GetAllContainers(db *gorm.DB, filters map[string]string, limit, offset int) ([]models.Container, int64, error) {
 	var containers []models.Container
 	var totalItems int64
@@ -165,14 +424,16 @@ func GetAllContainers(db *gorm.DB, filters map[string]string, limit, offset int)
 // Score & Leaderboard
 
 type LeaderboardEntry struct {
-	UserID           string         `json:"user_id"`
-	Username         string         `json:"username"`
-	Tags             string         `json:"tags"`
-	Nickname         string         `json:"nickname"`
-	AvatarURL        string         `json:"avatar_url"`
-	DisableRank      bool           `json:"disable_rank"`
-	TotalScore       int            `json:"total_score"`
-	ProblemScores    map[string]int `json:"problem_scores"`
+	UserID           string             `json:"user_id"`
+	Username         string             `json:"username"`
+	Tags             string             `json:"tags"`
+	Nickname         string             `json:"nickname"`
+	AvatarURL        string             `json:"avatar_url"`
+	DisableRank      bool               `json:"disable_rank"`
+	TotalScore       int                `json:"total_score"` // problems solved in ICPC-style contests
+	ProblemScores    map[string]int     `json:"problem_scores"`
+	Penalty          int                `json:"penalty,omitempty"` // ICPC-style contests: total penalty minutes
+	ICPC             models.ICPCResults `json:"icpc,omitempty"`    // ICPC-style contests: results by attempted problem
 	lastScoreTime    time.Time
 	registrationTime time.Time
 }
@@ -184,31 +445,60 @@ type UserScoreHistoryPoint struct {
 	ProblemID string    `json:"problem_id"`
 }
 
+// ProblemResult is a user's result on one problem, as seen by a contest's scoring formula.
+type ProblemResult struct {
+	Score         int
+	Attempts      int       // valid, finished submissions
+	LastScoreTime time.Time // when the best score was reached, zero if the user has none
+}
+
+// Scoring computes leaderboard totals with a contest's own formula instead of the sum of the
+// problem scores: Total receives a result for every problem in ProblemIDs. If ICPC is set, the
+// contest is ranked ICPC-style instead and Total is not used.
+type Scoring struct {
+	ProblemIDs []string
+	Total      func(results map[string]ProblemResult) (int, error)
+	ICPC       *ICPCRanking
+}
+
+// ICPCProblem holds the parameters of a problem in the "icpc" score mode.
+type ICPCProblem struct {
+	FullScore      int // raw score that solves the problem
+	PenaltyMinutes int // added for every rejected attempt before the solve
+}
+
+// ICPCRanking ranks a contest by problems solved, then by total penalty time: the minutes from
+// Start to each solve plus the penalties of the rejected attempts before it.
+type ICPCRanking struct {
+	Start    time.Time
+	Until    time.Time // submissions made later are ignored, zero for no limit
+	Problems map[string]ICPCProblem
+}
+
 // GetLeaderboard retrieves the leaderboard for a contest, optionally filtered by user tags.
 // selectedTags is a comma-separated string of tags. If empty, no tag filtering is applied.
-func GetLeaderboard(db *gorm.DB, contestID string, selectedTags string) ([]LeaderboardEntry, error) {
+// Totals are the sum of the problem scores unless scoring is given.
+func GetLeaderboard(db *gorm.DB, contestID string, selectedTags string, scoring *Scoring) ([]LeaderboardEntry, error) {
 
-	// --- Step 1: Get all registered users and their registration time as a string ---
+	// --- Step 1: Get all registered users ---
 	type registeredUser struct {
-		UserID           string
-		Username         string
-		Nickname         string
-		AvatarURL        string
-		DisableRank      bool
-		Tags             string
-		RegistrationTime string // Read time as a string from DB
+		UserID      string
+		Username    string
+		Nickname    string
+		AvatarURL   string
+		DisableRank bool
+		Tags        string
 	}
 	var users []registeredUser
 	query := db.Table("contest_score_histories").
-		Select("users.id as user_id, users.username, users.nickname, users.avatar_url, users.disable_rank, users.tags, datetime(MIN(contest_score_histories.created_at)) as registration_time").
+		Select("users.id as user_id, users.username, users.nickname, users.avatar_url, users.disable_rank, users.tags").
 		Joins("join users on users.id = contest_score_histories.user_id").
 		Where("contest_score_histories.contest_id = ?", contestID)
 
 	// Apply tag filtering if tags are provided
 	if selectedTags != "" {
-		tags := strings.Split(selectedTags, ",")
-		for _, tag := range tags {
-			query = query.Where("users.tags LIKE ?", "%"+strings.TrimSpace(tag)+"%")
+		for _, tag := range SplitTags(selectedTags) {
+			query = WhereHasTag(query, tag)
 		}
 	}
 
@@ -219,6 +509,28 @@ func GetLeaderboard(db *gorm.DB, contestID string, selectedTags string) ([]Leade
 		return nil, fmt.Errorf("failed to get registered users: %w", err)
 	}
 
+	// The registration time is the time of a user's first history row. It is read from the
+	// column itself rather than through an aggregate, so the driver returns a typed time.
+	var registrations []struct {
+		UserID    string
+		CreatedAt time.Time
+	}
+	firstRows := db.Model(&models.ContestScoreHistory{}).
+		Select("MIN(id)").
+		Where("contest_id = ?", contestID).
+		Group("user_id")
+	err = db.Model(&models.ContestScoreHistory{}).
+		Select("user_id, created_at").
+		Where("id IN (?)", firstRows).
+		Scan(&registrations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registration times: %w", err)
+	}
+	registrationTimes := make(map[string]time.Time, len(registrations))
+	for _, r := range registrations {
+		registrationTimes[r.UserID] = r.CreatedAt
+	}
+
 	// --- Step 2: Get all best scores for the contest ---
 	type scoreRow struct {
 		UserID        string
@@ -240,12 +552,6 @@ func GetLeaderboard(db *gorm.DB, contestID string, selectedTags string) ([]Leade
 
 	// Initialize map with all registered users, default score 0
 	for _, user := range users {
-		// Manually parse the time string. The format from SQLite's datetime() is "2006-01-02 15:04:05"
-		regTime, parseErr := time.Parse("2006-01-02 15:04:05", user.RegistrationTime)
-		if parseErr != nil {
-			return nil, fmt.Errorf("failed to parse registration time for user %s ('%s'): %w", user.UserID, user.RegistrationTime, parseErr)
-		}
-
 		avatarURL := user.AvatarURL
 		if avatarURL != "" && !strings.HasPrefix(avatarURL, "http") {
 			avatarURL = fmt.Sprintf("/api/v1/assets/avatars/%s", avatarURL)
@@ -260,11 +566,12 @@ func GetLeaderboard(db *gorm.DB, contestID string, selectedTags string) ([]Leade
 			TotalScore:       0,
 			ProblemScores:    make(map[string]int),
 			lastScoreTime:    time.Time{}, // Zero value for time
-			registrationTime: regTime,     // Use the parsed time object
+			registrationTime: registrationTimes[user.UserID],
 		}
 	}
 
 	// Populate scores for users who have submitted
+	problemResults := make(map[string]map[string]ProblemResult)
 	for _, score := range scores {
 		if entry, ok := resultsMap[score.UserID]; ok {
 			entry.ProblemScores[score.ProblemID] = score.Score
@@ -272,6 +579,20 @@ func GetLeaderboard(db *gorm.DB, contestID string, selectedTags string) ([]Leade
 			if score.LastScoreTime.After(entry.lastScoreTime) {
 				entry.lastScoreTime = score.LastScoreTime
 			}
+			if problemResults[score.UserID] == nil {
+				problemResults[score.UserID] = make(map[string]ProblemResult)
+			}
+			problemResults[score.UserID][score.ProblemID] = ProblemResult{Score: score.Score, LastScoreTime: score.LastScoreTime}
+		}
+	}
+	icpc := scoring != nil && scoring.ICPC != nil
+	if icpc {
+		if err := applyICPCRanking(db, scoring.ICPC, resultsMap); err != nil {
+			return nil, err
+		}
+	} else if scoring != nil {
+		if err := applyScoring(db, scoring, problemResults, resultsMap); err != nil {
+			return nil, err
 		}
 	}
 
@@ -287,6 +608,10 @@ func GetLeaderboard(db *gorm.DB, contestID string, selectedTags string) ([]Leade
 		if results[i].TotalScore != results[j].TotalScore {
 			return results[i].TotalScore > results[j].TotalScore
 		}
+		// ICPC-style: equal solves rank by penalty time (asc), then by the last solve like scores.
+		if icpc && results[i].Penalty != results[j].Penalty {
+			return results[i].Penalty < results[j].Penalty
+		}
 
 		// Scores are equal.
 		// If score is 0, tie-break by registration time (asc - earlier is better).
@@ -307,6 +632,205 @@ func GetLeaderboard(db *gorm.DB, contestID string, selectedTags string) ([]Leade
 	return results, nil
 }
 
+// applyScoring replaces the summed totals of the entries with those of the scoring formula.
+// results holds the best scores by user and problem.
+func applyScoring(db *gorm.DB, scoring *Scoring, results map[string]map[string]ProblemResult, entries map[string]*LeaderboardEntry) error {
+	var attempts []struct {
+		UserID    string
+		ProblemID string
+		Count     int
+	}
+	err := db.Model(&models.Submission{}).
+		Select("user_id, problem_id, COUNT(*) as count").
+		Where("problem_id IN ? AND is_valid = ? AND status IN ?", scoring.ProblemIDs, true, []models.Status{models.StatusSuccess, models.StatusFailed}).
+		Group("user_id, problem_id").
+		Scan(&attempts).Error
+	if err != nil {
+		return fmt.Errorf("failed to count attempts: %w", err)
+	}
+	for _, a := range attempts {
+		if results[a.UserID] == nil {
+			results[a.UserID] = make(map[string]ProblemResult)
+		}
+		r := results[a.UserID][a.ProblemID]
+		r.Attempts = a.Count
+		results[a.UserID][a.ProblemID] = r
+	}
+
+	for userID, entry := range entries {
+		userResults := make(map[string]ProblemResult, len(scoring.ProblemIDs))
+		for _, problemID := range scoring.ProblemIDs {
+			userResults[problemID] = results[userID][problemID]
+		}
+		total, err := scoring.Total(userResults)
+		if err != nil {
+			return fmt.Errorf("scoring formula failed for user %s: %w", userID, err)
+		}
+		entry.TotalScore = total
+	}
+	return nil
+}
+
+// applyICPCRanking replaces the summed totals of the entries with their number of solved problems
+// and fills in their penalty times. A problem is solved by the first valid submission reaching its
+// full score; finished valid submissions before it count as rejected attempts. The last score
+// time of an entry becomes the time of its last solve.
+func applyICPCRanking(db *gorm.DB, ranking *ICPCRanking, entries map[string]*LeaderboardEntry) error {
+	problemIDs := make([]string, 0, len(ranking.Problems))
+	for problemID := range ranking.Problems {
+		problemIDs = append(problemIDs, problemID)
+	}
+	query := db.Model(&models.Submission{}).
+		Select("user_id, problem_id, score, created_at").
+		Where("problem_id IN ? AND is_valid = ? AND status IN ?", problemIDs, true, []models.Status{models.StatusSuccess, models.StatusFailed})
+	if !ranking.Until.IsZero() {
+		query = query.Where("created_at <= ?", ranking.Until)
+	}
+	var subs []struct {
+		UserID    string
+		ProblemID string
+		Score     int
+		CreatedAt time.Time
+	}
+	if err := query.Order("created_at asc").Scan(&subs).Error; err != nil {
+		return fmt.Errorf("failed to get submissions: %w", err)
+	}
+
+	for _, entry := range entries {
+		entry.TotalScore = 0
+		entry.lastScoreTime = time.Time{}
+	}
+	for _, sub := range subs {
+		entry, ok := entries[sub.UserID]
+		if !ok {
+			continue
+		}
+		if entry.ICPC == nil {
+			entry.ICPC = make(models.ICPCResults)
+		}
+		result := entry.ICPC[sub.ProblemID]
+		if result.Solved {
+			continue
+		}
+		problem := ranking.Problems[sub.ProblemID]
+		if sub.Score < problem.FullScore {
+			result.Attempts++
+			entry.ICPC[sub.ProblemID] = result
+			continue
+		}
+		result.Solved = true
+		result.SolveMinutes = int(max(sub.CreatedAt.Sub(ranking.Start), 0) / time.Minute)
+		result.Penalty = result.SolveMinutes + result.Attempts*problem.PenaltyMinutes
+		entry.ICPC[sub.ProblemID] = result
+		entry.TotalScore++
+		entry.Penalty += result.Penalty
+		if sub.CreatedAt.After(entry.lastScoreTime) {
+			entry.lastScoreTime = sub.CreatedAt
+		}
+	}
+	return nil
+}
+
+// SaveFinalStanding replaces the final standing of a contest with a snapshot of its current leaderboard.
+func SaveFinalStanding(db *gorm.DB, contestID string, scoring *Scoring) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		board, err := GetLeaderboard(tx, contestID, "", scoring)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("contest_id = ?", contestID).Delete(&models.FinalStanding{}).Error; err != nil {
+			return err
+		}
+		if len(board) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		rows := make([]models.FinalStanding, len(board))
+		for i, entry := range board {
+			problemScores := make(models.JSONMap, len(entry.ProblemScores))
+			for problemID, score := range entry.ProblemScores {
+				problemScores[problemID] = score
+			}
+			rows[i] = models.FinalStanding{
+				ContestID:     contestID,
+				Rank:          i + 1,
+				UserID:        entry.UserID,
+				Username:      entry.Username,
+				Nickname:      entry.Nickname,
+				AvatarURL:     entry.AvatarURL,
+				Tags:          entry.Tags,
+				DisableRank:   entry.DisableRank,
+				TotalScore:    entry.TotalScore,
+				ProblemScores: problemScores,
+				Penalty:       entry.Penalty,
+				ICPC:          entry.ICPC,
+				FinalizedAt:   now,
+			}
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+// HasFinalStanding reports whether a final standing has been saved for the contest.
+func HasFinalStanding(db *gorm.DB, contestID string) (bool, error) {
+	var count int64
+	err := db.Model(&models.FinalStanding{}).Where("contest_id = ?", contestID).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// GetFinalStanding returns the frozen leaderboard of a contest in rank order, optionally filtered
+// by user tags like GetLeaderboard. It returns false if no final standing has been saved.
+func GetFinalStanding(db *gorm.DB, contestID string, selectedTags string) ([]LeaderboardEntry, bool, error) {
+	var rows []models.FinalStanding
+	if err := db.Where("contest_id = ?", contestID).Order(clause.OrderByColumn{Column: clause.Column{Name: "rank"}}).Find(&rows).Error; err != nil {
+		return nil, false, err
+	}
+	if len(rows) == 0 {
+		return nil, false, nil
+	}
+
+	required := SplitTags(selectedTags)
+	results := make([]LeaderboardEntry, 0, len(rows))
+	for _, row := range rows {
+		if !hasAllTags(row.Tags, required) {
+			continue
+		}
+		problemScores := make(map[string]int, len(row.ProblemScores))
+		for problemID, score := range row.ProblemScores {
+			if v, ok := score.(float64); ok {
+				problemScores[problemID] = int(v)
+			}
+		}
+		results = append(results, LeaderboardEntry{
+			UserID:        row.UserID,
+			Username:      row.Username,
+			Tags:          row.Tags,
+			Nickname:      row.Nickname,
+			AvatarURL:     row.AvatarURL,
+			DisableRank:   row.DisableRank,
+			TotalScore:    row.TotalScore,
+			ProblemScores: problemScores,
+			Penalty:       row.Penalty,
+			ICPC:          row.ICPC,
+		})
+	}
+	return results, true, nil
+}
+
+func hasAllTags(userTags string, required []string) bool {
+	have := make(map[string]bool)
+	for _, tag := range SplitTags(userTags) {
+		have[tag] = true
+	}
+	for _, tag := range required {
+		if !have[tag] {
+			return false
+		}
+	}
+	return true
+}
+
 // GetScoreHistoriesForUsers retrieves the score change history for a given list of users in a specific contest.
 func GetScoreHistoriesForUsers(db *gorm.DB, contestID string, userIDs []string) (map[string][]UserScoreHistoryPoint, error) {
 	var results []models.ContestScoreHistory
@@ -399,6 +923,23 @@ func GetBestScoresByUserID(db *gorm.DB, userID string) ([]models.UserProblemBest
 	return scores, err
 }
 
+// GetScoredProblemIDs returns which of the given problems the user has a positive score on.
+func GetScoredProblemIDs(db *gorm.DB, userID, contestID string, problemIDs []string) (map[string]bool, error) {
+	var ids []string
+	err := db.Model(&models.UserProblemBestScore{}).
+		Where("user_id = ? AND contest_id = ? AND problem_id IN ?", userID, contestID, problemIDs).
+		Where("(score > 0 OR raw_score > 0)").
+		Pluck("problem_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	scored := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		scored[id] = true
+	}
+	return scored, nil
+}
+
 func IncrementSubmissionCount(db *gorm.DB, userID, contestID, problemID string) error {
 	record := models.UserProblemBestScore{
 		UserID:          userID,
@@ -414,6 +955,63 @@ func IncrementSubmissionCount(db *gorm.DB, userID, contestID, problemID string)
 	}).Create(&record).Error
 }
 
+// HistoryThreshold suppresses score history records when a performance-mode rescore changes a
+// user's score by less than Absolute points or less than Relative (a fraction) of the old score.
+// The stored score is always updated, and a zero threshold records every change.
+type HistoryThreshold struct {
+	Absolute int
+	Relative float64
+}
+
+// Records reports whether a rescore from oldScore to newScore is recorded in the score history.
+// A user's first nonzero score is always recorded.
+func (t HistoryThreshold) Records(oldScore, newScore int) bool {
+	if oldScore == 0 {
+		return newScore != 0
+	}
+	delta := newScore - oldScore
+	if delta < 0 {
+		delta = -delta
+	}
+	if t.Absolute > 0 && delta < t.Absolute {
+		return false
+	}
+	if t.Relative > 0 && float64(delta) < t.Relative*math.Abs(float64(oldScore)) {
+		return false
+	}
+	return delta != 0
+}
+
+// WeightedScoring holds the parameters of the "weighted" score mode. A problem is worth Points,
+// decaying towards MinPoints as more users solve it, and each user earns the current value scaled
+// by their best raw score over FullScore.
+type WeightedScoring struct {
+	Points    int
+	MinPoints int
+	Decay     int // number of solves after which the value reaches MinPoints, 0 disables decay
+	FullScore int // raw score that counts as a solve
+}
+
+// PointValue returns the problem's value for the given solve count. The first solver does not
+// lower the value, and the value falls quadratically until it reaches MinPoints after Decay solves.
+func (w WeightedScoring) PointValue(solves int64) int {
+	if w.Decay <= 0 || w.MinPoints >= w.Points || solves <= 1 {
+		return w.Points
+	}
+	n := min(float64(solves-1), float64(w.Decay))
+	drop := float64(w.Points-w.MinPoints) * n * n / float64(w.Decay*w.Decay)
+	return w.Points - int(math.Round(drop))
+}
+
+// Contribution returns the points earned for a raw score given the problem's current value.
+func (w WeightedScoring) Contribution(rawScore, value int) int {
+	if w.FullScore <= 0 || rawScore <= 0 {
+		return 0
+	}
+	rawScore = min(rawScore, w.FullScore)
+	return int(math.Round(float64(value) * float64(rawScore) / float64(w.FullScore)))
+}
+
 func UpdateScoresForNewSubmission(db *gorm.DB, sub *models.Submission, contestID string, newScore int) error {
 	return db.Transaction(func(tx *gorm.DB) error {
 		// Get current best score for the problem
@@ -421,8 +1019,8 @@ func UpdateScoresForNewSubmission(db *gorm.DB, sub *models.Submission, contestID
 		err := tx.Where("user_id = ? AND contest_id = ? AND problem_id = ?", sub.UserID, contestID, sub.ProblemID).
 			First(&bestScore).Error
 
-		// If no record exists or the new score is higher
-		if errors.Is(err, gorm.ErrRecordNotFound) || newScore > bestScore.Score {
+		// If no record exists or the new score is higher, unless an admin pinned the scoring submission
+		if errors.Is(err, gorm.ErrRecordNotFound) || (!bestScore.Pinned && newScore > bestScore.Score) {
 			// Update or create the best score record
 			bestScore.UserID = sub.UserID
 			bestScore.ContestID = contestID
@@ -443,6 +1041,67 @@ func UpdateScoresForNewSubmission(db *gorm.DB, sub *models.Submission, contestID
 	})
 }
 
+// UpdateScoresForWeightedSubmission records a new best raw score in "weighted" mode and re-scores
+// every user on the problem, since a new solve can lower the problem's value for everyone.
+// The submission keeps its raw score.
+func UpdateScoresForWeightedSubmission(db *gorm.DB, sub *models.Submission, contestID string, weighted WeightedScoring) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var bestScore models.UserProblemBestScore
+		err := tx.Where("user_id = ? AND contest_id = ? AND problem_id = ?", sub.UserID, contestID, sub.ProblemID).
+			First(&bestScore).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Like score mode, only a higher raw score replaces the best and moves the score time.
+		if err == nil && (bestScore.Pinned || sub.Score <= bestScore.RawScore) {
+			return nil
+		}
+		bestScore.UserID = sub.UserID
+		bestScore.ContestID = contestID
+		bestScore.ProblemID = sub.ProblemID
+		bestScore.RawScore = sub.Score
+		bestScore.SubmissionID = sub.ID
+		bestScore.LastScoreTime = sub.CreatedAt
+		if err := tx.Save(&bestScore).Error; err != nil {
+			return err
+		}
+		return recalculateWeightedScores(tx, contestID, sub.ProblemID, weighted, sub.ID, sub.UserID)
+	})
+}
+
+// recalculateWeightedScores recomputes the problem's current value from its solve count and
+// updates every user's points on it, creating a history record for each user whose total changed.
+// changedUserID always gets a history record, as their raw score has just changed.
+func recalculateWeightedScores(tx *gorm.DB, contestID, problemID string, weighted WeightedScoring, sourceSubmissionID, changedUserID string) error {
+	var solves int64
+	if err := tx.Model(&models.UserProblemBestScore{}).
+		Where("contest_id = ? AND problem_id = ? AND raw_score >= ?", contestID, problemID, weighted.FullScore).
+		Count(&solves).Error; err != nil {
+		return err
+	}
+	value := weighted.PointValue(solves)
+
+	var allUserScores []models.UserProblemBestScore
+	if err := tx.Where("contest_id = ? AND problem_id = ?", contestID, problemID).Find(&allUserScores).Error; err != nil {
+		return err
+	}
+	for _, userScore := range allUserScores {
+		newScore := weighted.Contribution(userScore.RawScore, value)
+		if userScore.Score == newScore && userScore.UserID != changedUserID {
+			continue
+		}
+		// LastScoreTime is left alone: a falling value must not reorder ties.
+		if err := tx.Model(&userScore).Update("score", newScore).Error; err != nil {
+			return err
+		}
+		if err := createScoreHistory(tx, userScore.UserID, contestID, problemID, sourceSubmissionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Helper function to create score history to avoid repetition.
 func createScoreHistory(tx *gorm.DB, userID, contestID, problemID, submissionID string) error {
 	var totalScore struct {
@@ -465,58 +1124,117 @@ func createScoreHistory(tx *gorm.DB, userID, contestID, problemID, submissionID
 	return tx.Create(&history).Error
 }
 
+// scoringSubmission returns the submission that counts for the user on the problem: the one an
+// admin pinned while it is still valid, otherwise the best valid one by order. A pin on a
+// submission that was invalidated or deleted is cleared. gorm.ErrRecordNotFound is returned if the
+// user has no valid submission.
+func scoringSubmission(tx *gorm.DB, userID, contestID, problemID, order string) (models.Submission, error) {
+	var bestScore models.UserProblemBestScore
+	err := tx.Where("user_id = ? AND contest_id = ? AND problem_id = ? AND pinned = ?", userID, contestID, problemID, true).
+		First(&bestScore).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Submission{}, err
+	}
+	var sub models.Submission
+	if err == nil {
+		err = tx.Where("id = ? AND is_valid = ?", bestScore.SubmissionID, true).First(&sub).Error
+		if err == nil {
+			return sub, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Submission{}, err
+		}
+		if err := tx.Model(&bestScore).Update("pinned", false).Error; err != nil {
+			return models.Submission{}, err
+		}
+	}
+	err = tx.Where("user_id = ? AND problem_id = ? AND is_valid = ?", userID, problemID, true).
+		Order(order).
+		First(&sub).Error
+	return sub, err
+}
+
 // RecalculateScoresForUserProblem recalculates scores after a submission's validity has changed.
-// It implements distinct, comprehensive logic for both "score" and "performance" modes.
+// It implements distinct, comprehensive logic for the "score", "performance" and "weighted" modes.
+// A scoring submission pinned by an admin is kept as long as it is valid.
 // sourceSubmissionID is the ID of the submission whose validity was just changed.
-func RecalculateScoresForUserProblem(db *gorm.DB, userID, problemID, contestID, sourceSubmissionID string, scoreMode string, maxPerformanceScore int) error {
+func RecalculateScoresForUserProblem(db *gorm.DB, userID, problemID, contestID, sourceSubmissionID string, scoreMode string, maxPerformanceScore int, weighted WeightedScoring) error {
 	return db.Transaction(func(tx *gorm.DB) error {
-		// --- SCORE MODE LOGIC ---
-		// Recalculates score only for the triggering user and creates one history record for them.
-		if scoreMode != "performance" {
-			// Find the new best valid submission for this user on this problem.
-			var newBestSub models.Submission
-			err := tx.Where("user_id = ? AND problem_id = ? AND is_valid = ?", userID, problemID, true).
-				Order("score desc, created_at asc").
-				First(&newBestSub).Error
+		// --- WEIGHTED MODE LOGIC ---
+		// Finds the user's new best raw score, then re-scores all users as the solve count may have changed.
+		if scoreMode == "weighted" {
+			newBestSub, err := scoringSubmission(tx, userID, contestID, problemID, "score desc, created_at asc")
 
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				// No valid submissions left for this user. Delete their best score record.
 				if err := tx.Where("user_id = ? AND contest_id = ? AND problem_id = ?", userID, contestID, problemID).
 					Delete(&models.UserProblemBestScore{}).Error; err != nil {
 					return err
 				}
+				if err := createScoreHistory(tx, userID, contestID, problemID, sourceSubmissionID); err != nil {
+					return err
+				}
 			} else if err != nil {
-				return err // A different database error.
+				return err
 			} else {
-				// A new best valid submission was found. Update or create the user's best score entry.
 				bestScore := models.UserProblemBestScore{
 					UserID:        userID,
 					ContestID:     contestID,
 					ProblemID:     problemID,
-					Score:         newBestSub.Score,
+					RawScore:      newBestSub.Score,
 					SubmissionID:  newBestSub.ID,
 					LastScoreTime: newBestSub.CreatedAt,
 				}
 				if err := tx.Clauses(clause.OnConflict{
 					Columns:   []clause.Column{{Name: "user_id"}, {Name: "contest_id"}, {Name: "problem_id"}},
-					DoUpdates: clause.AssignmentColumns([]string{"score", "submission_id", "last_score_time"}),
+					DoUpdates: clause.AssignmentColumns([]string{"raw_score", "submission_id", "last_score_time"}),
 				}).Create(&bestScore).Error; err != nil {
 					return err
 				}
 			}
-
-			// Unconditionally create a new score history record for the user.
-			return createScoreHistory(tx, userID, contestID, problemID, sourceSubmissionID)
+			return recalculateWeightedScores(tx, contestID, problemID, weighted, sourceSubmissionID, userID)
+		}
+
+		// --- SCORE MODE LOGIC ---
+		// Recalculates score only for the triggering user and creates one history record for them.
+		if scoreMode != "performance" {
+			// Find the new best valid submission for this user on this problem.
+			newBestSub, err := scoringSubmission(tx, userID, contestID, problemID, "score desc, created_at asc")
+
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// No valid submissions left for this user. Delete their best score record.
+				if err := tx.Where("user_id = ? AND contest_id = ? AND problem_id = ?", userID, contestID, problemID).
+					Delete(&models.UserProblemBestScore{}).Error; err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err // A different database error.
+			} else {
+				// A new best valid submission was found. Update or create the user's best score entry.
+				bestScore := models.UserProblemBestScore{
+					UserID:        userID,
+					ContestID:     contestID,
+					ProblemID:     problemID,
+					Score:         newBestSub.Score,
+					SubmissionID:  newBestSub.ID,
+					LastScoreTime: newBestSub.CreatedAt,
+				}
+				if err := tx.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "user_id"}, {Name: "contest_id"}, {Name: "problem_id"}},
+					DoUpdates: clause.AssignmentColumns([]string{"score", "submission_id", "last_score_time"}),
+				}).Create(&bestScore).Error; err != nil {
+					return err
+				}
+			}
+
+			// Unconditionally create a new score history record for the user.
+			return createScoreHistory(tx, userID, contestID, problemID, sourceSubmissionID)
 		}
 
 		// --- PERFORMANCE MODE LOGIC ---
 		// Recalculates scores for ALL users on this problem and creates a history record for EACH of them.
 		if scoreMode == "performance" {
 			// First, update the best performance record for the triggering user specifically.
-			var newBestPerfSub models.Submission
-			err := tx.Where("user_id = ? AND problem_id = ? AND is_valid = ?", userID, problemID, true).
-				Order("performance desc, created_at asc").
-				First(&newBestPerfSub).Error
+			newBestPerfSub, err := scoringSubmission(tx, userID, contestID, problemID, "performance desc, created_at asc")
 
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				// No valid submissions left. Delete their best score record.
@@ -593,7 +1311,48 @@ func RecalculateScoresForUserProblem(db *gorm.DB, userID, problemID, contestID,
 	})
 }
 
-func UpdateScoresForPerformanceSubmission(db *gorm.DB, sub *models.Submission, contestID string, maxPerformanceScore int) error {
+// PinScoringSubmission makes a valid submission the one that counts for its user on the problem,
+// overriding the automatic best-score selection until the pin is cleared, and recalculates the
+// scores with a history record.
+func PinScoringSubmission(db *gorm.DB, sub *models.Submission, contestID string, scoreMode string, maxPerformanceScore int, weighted WeightedScoring) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var bestScore models.UserProblemBestScore
+		err := tx.Where("user_id = ? AND contest_id = ? AND problem_id = ?", sub.UserID, contestID, sub.ProblemID).
+			First(&bestScore).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		bestScore.UserID = sub.UserID
+		bestScore.ContestID = contestID
+		bestScore.ProblemID = sub.ProblemID
+		bestScore.SubmissionID = sub.ID
+		bestScore.Pinned = true
+		if err := tx.Save(&bestScore).Error; err != nil {
+			return err
+		}
+		return RecalculateScoresForUserProblem(tx, sub.UserID, sub.ProblemID, contestID, sub.ID, scoreMode, maxPerformanceScore, weighted)
+	})
+}
+
+// UnpinScoringSubmission clears the pin of a submission set by PinScoringSubmission and returns
+// its user to the automatic best-score selection. It reports false if the submission was not
+// pinned.
+func UnpinScoringSubmission(db *gorm.DB, sub *models.Submission, contestID string, scoreMode string, maxPerformanceScore int, weighted WeightedScoring) (bool, error) {
+	unpinned := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UserProblemBestScore{}).
+			Where("user_id = ? AND contest_id = ? AND problem_id = ? AND submission_id = ? AND pinned = ?", sub.UserID, contestID, sub.ProblemID, sub.ID, true).
+			Update("pinned", false)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		unpinned = true
+		return RecalculateScoresForUserProblem(tx, sub.UserID, sub.ProblemID, contestID, sub.ID, scoreMode, maxPerformanceScore, weighted)
+	})
+	return unpinned, err
+}
+
+func UpdateScoresForPerformanceSubmission(db *gorm.DB, sub *models.Submission, contestID string, maxPerformanceScore int, threshold HistoryThreshold) error {
 	// Performance score of 0 is ignored for initial scoring.
 	if sub.Performance == 0 {
 		return db.Model(sub).Update("performance", sub.Performance).Error
@@ -623,8 +1382,8 @@ func UpdateScoresForPerformanceSubmission(db *gorm.DB, sub *models.Submission, c
 			First(&userBestScore).Error
 		isFirstSubmissionForUser := errors.Is(err, gorm.ErrRecordNotFound)
 
-		// Only proceed if this is a new best performance for the user.
-		if isFirstSubmissionForUser || sub.Performance > userBestScore.Performance {
+		// Only proceed if this is a new best performance for the user and their scoring submission is not pinned.
+		if isFirstSubmissionForUser || (!userBestScore.Pinned && sub.Performance > userBestScore.Performance) {
 			// Update or create the user's best performance record.
 			// Score will be updated later. LastScoreTime is only updated on a score *increase*.
 			userBestScore.UserID = sub.UserID
@@ -688,6 +1447,10 @@ func UpdateScoresForPerformanceSubmission(db *gorm.DB, sub *models.Submission, c
 					if err := tx.Model(&otherUser).Update("score", newScore).Error; err != nil {
 						return err
 					}
+					// Every new max rescales all users; small changes are left out of the trend.
+					if !threshold.Records(otherUser.Score, newScore) {
+						continue
+					}
 					if err := createScoreHistory(tx, otherUser.UserID, contestID, sub.ProblemID, sub.ID); err != nil {
 						return err
 					}
@@ -721,3 +1484,713 @@ func UpdateScoresForPerformanceSubmission(db *gorm.DB, sub *models.Submission, c
 		return nil
 	})
 }
+
+// ScoreChange is a user's score before and after a change.
+type ScoreChange struct {
+	UserID string `json:"user_id"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// ValidityPreview describes how changing a submission's validity would affect the leaderboard.
+type ValidityPreview struct {
+	ProblemScore ScoreChange   `json:"problem_score"` // the submitter's score on the problem
+	TotalScore   ScoreChange   `json:"total_score"`   // the submitter's contest total
+	RankBefore   int           `json:"rank_before"`   // 1-based leaderboard position, 0 if not on the leaderboard
+	RankAfter    int           `json:"rank_after"`
+	OtherUsers   []ScoreChange `json:"other_users"` // other users whose score on the problem changes (performance and weighted modes)
+}
+
+// PreviewValidityChange applies a validity change and the score recalculation in a transaction
+// that is always rolled back, and reports the resulting score and rank changes.
+func PreviewValidityChange(db *gorm.DB, sub *models.Submission, contestID string, isValid bool, scoreMode string, maxPerformanceScore int, weighted WeightedScoring, scoring *Scoring) (*ValidityPreview, error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	// Nothing below is ever committed.
+	defer tx.Rollback()
+
+	scoresBefore, err := problemScores(tx, contestID, sub.ProblemID)
+	if err != nil {
+		return nil, err
+	}
+	boardBefore, err := GetLeaderboard(tx, contestID, "", scoring)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := UpdateSubmissionValidity(tx, sub.ID, isValid); err != nil {
+		return nil, err
+	}
+	if err := RecalculateScoresForUserProblem(tx, sub.UserID, sub.ProblemID, contestID, sub.ID, scoreMode, maxPerformanceScore, weighted); err != nil {
+		return nil, err
+	}
+
+	scoresAfter, err := problemScores(tx, contestID, sub.ProblemID)
+	if err != nil {
+		return nil, err
+	}
+	boardAfter, err := GetLeaderboard(tx, contestID, "", scoring)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &ValidityPreview{
+		ProblemScore: ScoreChange{UserID: sub.UserID, Before: scoresBefore[sub.UserID], After: scoresAfter[sub.UserID]},
+		TotalScore:   ScoreChange{UserID: sub.UserID},
+		OtherUsers:   make([]ScoreChange, 0),
+	}
+	preview.TotalScore.Before, preview.RankBefore = leaderboardPosition(boardBefore, sub.UserID)
+	preview.TotalScore.After, preview.RankAfter = leaderboardPosition(boardAfter, sub.UserID)
+
+	for userID := range scoresAfter {
+		if _, ok := scoresBefore[userID]; !ok {
+			scoresBefore[userID] = 0
+		}
+	}
+	for userID, before := range scoresBefore {
+		if after := scoresAfter[userID]; userID != sub.UserID && after != before {
+			preview.OtherUsers = append(preview.OtherUsers, ScoreChange{UserID: userID, Before: before, After: after})
+		}
+	}
+	sort.Slice(preview.OtherUsers, func(i, j int) bool {
+		return preview.OtherUsers[i].UserID < preview.OtherUsers[j].UserID
+	})
+	return preview, nil
+}
+
+// problemScores returns every user's best score on a problem.
+func problemScores(db *gorm.DB, contestID, problemID string) (map[string]int, error) {
+	var rows []models.UserProblemBestScore
+	if err := db.Where("contest_id = ? AND problem_id = ?", contestID, problemID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	scores := make(map[string]int, len(rows))
+	for _, row := range rows {
+		scores[row.UserID] = row.Score
+	}
+	return scores, nil
+}
+
+// leaderboardPosition returns a user's total score and 1-based position on a sorted leaderboard.
+func leaderboardPosition(board []LeaderboardEntry, userID string) (int, int) {
+	for i, entry := range board {
+		if entry.UserID == userID {
+			return entry.TotalScore, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// ErrScoreWithPerformance is returned by SetUserProblemScore when both a score and a performance
+// are given for a performance mode problem, whose score would be recomputed from the performance.
+var ErrScoreWithPerformance = errors.New("score and performance cannot be set together in performance mode, the score is derived from the performance")
+
+// SetUserProblemScore manually overrides a user's best score and/or performance for a problem and
+// records the change in the score history. If a performance is given in performance mode, the scores of
+// all users on the problem are rescaled against the new maximum performance. In weighted mode the score
+// is taken as the raw score and all users are re-scored against the resulting solve count.
+func SetUserProblemScore(db *gorm.DB, userID, contestID, problemID string, score *int, performance *float64, scoreMode string, maxPerformanceScore int, weighted WeightedScoring) error {
+	const sourceID = "admin-adjust"
+	if scoreMode == "performance" && score != nil && performance != nil {
+		return ErrScoreWithPerformance
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var bestScore models.UserProblemBestScore
+		err := tx.Where("user_id = ? AND contest_id = ? AND problem_id = ?", userID, contestID, problemID).
+			First(&bestScore).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		bestScore.UserID = userID
+		bestScore.ContestID = contestID
+		bestScore.ProblemID = problemID
+		if score != nil {
+			if scoreMode == "weighted" {
+				bestScore.RawScore = *score
+			} else {
+				bestScore.Score = *score
+			}
+		}
+		if performance != nil {
+			bestScore.Performance = *performance
+		}
+		bestScore.LastScoreTime = time.Now()
+		if err := tx.Save(&bestScore).Error; err != nil {
+			return err
+		}
+
+		if scoreMode == "weighted" {
+			return recalculateWeightedScores(tx, contestID, problemID, weighted, sourceID, userID)
+		}
+
+		if scoreMode != "performance" || performance == nil {
+			return createScoreHistory(tx, userID, contestID, problemID, sourceID)
+		}
+
+		// Performance changed: rescale every user on this problem against the new maximum.
+		var maxPerformance struct {
+			Performance float64
+		}
+		if err := tx.Model(&models.UserProblemBestScore{}).
+			Select("MAX(performance) as performance").
+			Where("contest_id = ? AND problem_id = ?", contestID, problemID).
+			Scan(&maxPerformance).Error; err != nil {
+			return err
+		}
+
+		var allUserScores []models.UserProblemBestScore
+		if err := tx.Where("contest_id = ? AND problem_id = ?", contestID, problemID).Find(&allUserScores).Error; err != nil {
+			return err
+		}
+		for _, userScore := range allUserScores {
+			newScore := 0
+			if maxPerformance.Performance > 0 {
+				newScore = int(math.Round(float64(maxPerformanceScore) * userScore.Performance / maxPerformance.Performance))
+			}
+			if userScore.Score == newScore && userScore.UserID != userID {
+				continue
+			}
+			if err := tx.Model(&userScore).Update("score", newScore).Error; err != nil {
+				return err
+			}
+			if err := createScoreHistory(tx, userScore.UserID, contestID, problemID, sourceID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Tag CRUD
+
+// SplitTags parses a comma-separated tag string into trimmed, non-empty, unique tags.
+func SplitTags(raw string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(raw, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// NormalizeTags validates a comma-separated tag string against the tag vocabulary and returns it
+// in canonical form. While the vocabulary is empty, any tags are accepted.
+func NormalizeTags(db *gorm.DB, raw string) (string, error) {
+	tags := SplitTags(raw)
+	if len(tags) == 0 {
+		return "", nil
+	}
+
+	var vocabulary []string
+	if err := db.Model(&models.Tag{}).Pluck("name", &vocabulary).Error; err != nil {
+		return "", err
+	}
+	if len(vocabulary) > 0 {
+		allowed := make(map[string]bool, len(vocabulary))
+		for _, name := range vocabulary {
+			allowed[name] = true
+		}
+		var unknown []string
+		for _, tag := range tags {
+			if !allowed[tag] {
+				unknown = append(unknown, tag)
+			}
+		}
+		if len(unknown) > 0 {
+			return "", fmt.Errorf("unknown tags: %s", strings.Join(unknown, ", "))
+		}
+	}
+	return strings.Join(tags, ","), nil
+}
+
+// WhereHasTag restricts a query joined with users to users that carry the whole tag. The tag
+// may be the whole list or its first, last or a middle element; this avoids string
+// concatenation, which is spelled differently on every backend.
+func WhereHasTag(query *gorm.DB, tag string) *gorm.DB {
+	escaped := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(tag)
+	tags := "REPLACE(users.tags, ' ', '')"
+	return query.Where(
+		tags+" = ? OR "+tags+" LIKE ? ESCAPE '!' OR "+tags+" LIKE ? ESCAPE '!' OR "+tags+" LIKE ? ESCAPE '!'",
+		tag, escaped+",%", "%,"+escaped, "%,"+escaped+",%")
+}
+
+// TagUsage is a tag with the number of users carrying it.
+type TagUsage struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	UserCount   int    `json:"user_count"`
+	Defined     bool   `json:"defined"` // false for tags in use that are not part of the vocabulary
+}
+
+// GetTagUsage lists the tag vocabulary with member counts, followed by undefined tags still in use.
+func GetTagUsage(db *gorm.DB) ([]TagUsage, error) {
+	var vocabulary []models.Tag
+	if err := db.Order("name asc").Find(&vocabulary).Error; err != nil {
+		return nil, err
+	}
+	var userTags []string
+	if err := db.Model(&models.User{}).Where("tags <> ''").Pluck("tags", &userTags).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, raw := range userTags {
+		for _, tag := range SplitTags(raw) {
+			counts[tag]++
+		}
+	}
+
+	usage := make([]TagUsage, 0, len(vocabulary))
+	for _, tag := range vocabulary {
+		usage = append(usage, TagUsage{Name: tag.Name, Description: tag.Description, UserCount: counts[tag.Name], Defined: true})
+		delete(counts, tag.Name)
+	}
+	var undefined []string
+	for name := range counts {
+		undefined = append(undefined, name)
+	}
+	sort.Strings(undefined)
+	for _, name := range undefined {
+		usage = append(usage, TagUsage{Name: name, UserCount: counts[name]})
+	}
+	return usage, nil
+}
+
+func CreateTag(db *gorm.DB, tag *models.Tag) error {
+	return db.Create(tag).Error
+}
+
+func DeleteTag(db *gorm.DB, name string) error {
+	return db.Delete(&models.Tag{}, "name = ?", name).Error
+}
+
+// Problem statistics
+
+// ScoreBucket counts users whose best value falls in [Min, Max).
+type ScoreBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int64   `json:"count"`
+}
+
+// ProblemStats aggregates submissions and best scores of a problem. Invalid submissions are excluded.
+type ProblemStats struct {
+	ProblemID         string           `json:"problem_id"`
+	Attempts          int64            `json:"attempts"`
+	InvalidAttempts   int64            `json:"invalid_attempts"`
+	StatusCounts      map[string]int64 `json:"status_counts"`
+	UsersAttempted    int64            `json:"users_attempted"`
+	UsersScored       int64            `json:"users_scored"`
+	SolveRate         float64          `json:"solve_rate"` // users_scored / users_attempted
+	AverageScore      float64          `json:"average_score"`
+	MaxScore          int              `json:"max_score"`
+	ScoreDistribution []ScoreBucket    `json:"score_distribution"`
+
+	// Only filled for performance mode problems.
+	AveragePerformance      *float64      `json:"average_performance,omitempty"`
+	MaxPerformance          *float64      `json:"max_performance,omitempty"`
+	PerformanceDistribution []ScoreBucket `json:"performance_distribution,omitempty"`
+}
+
+// GetProblemStats computes problem statistics with aggregate queries. Scores are bucketed by
+// bucketSize; performance values, if requested, are split into performanceBuckets equal ranges.
+func GetProblemStats(db *gorm.DB, problemID string, bucketSize int, performanceBuckets int) (*ProblemStats, error) {
+	stats := &ProblemStats{ProblemID: problemID, StatusCounts: make(map[string]int64)}
+
+	var statusRows []struct {
+		Status  string
+		IsValid bool
+		Count   int64
+	}
+	if err := db.Model(&models.Submission{}).
+		Select("status, is_valid, COUNT(*) as count").
+		Where("problem_id = ? AND dry_run = ?", problemID, false).
+		Group("status, is_valid").
+		Scan(&statusRows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range statusRows {
+		if !row.IsValid {
+			stats.InvalidAttempts += row.Count
+			continue
+		}
+		stats.Attempts += row.Count
+		stats.StatusCounts[row.Status] += row.Count
+	}
+
+	if err := db.Model(&models.Submission{}).
+		Where("problem_id = ? AND is_valid = ?", problemID, true).
+		Distinct("user_id").
+		Count(&stats.UsersAttempted).Error; err != nil {
+		return nil, err
+	}
+
+	var agg struct {
+		Scored   int64
+		AvgScore float64
+		MaxScore int
+		AvgPerf  float64
+		MaxPerf  float64
+		MinPerf  float64
+	}
+	if err := db.Model(&models.UserProblemBestScore{}).
+		Select("SUM(CASE WHEN score > 0 THEN 1 ELSE 0 END) as scored, COALESCE(AVG(score), 0) as avg_score, COALESCE(MAX(score), 0) as max_score, "+
+			"COALESCE(AVG(performance), 0) as avg_perf, COALESCE(MAX(performance), 0) as max_perf, COALESCE(MIN(performance), 0) as min_perf").
+		Where("problem_id = ?", problemID).
+		Scan(&agg).Error; err != nil {
+		return nil, err
+	}
+	stats.UsersScored = agg.Scored
+	stats.AverageScore = agg.AvgScore
+	stats.MaxScore = agg.MaxScore
+	if stats.UsersAttempted > 0 {
+		stats.SolveRate = float64(stats.UsersScored) / float64(stats.UsersAttempted)
+	}
+
+	if bucketSize <= 0 {
+		bucketSize = 10
+	}
+	var scoreBuckets []struct {
+		Bucket int
+		Count  int64
+	}
+	if err := db.Model(&models.UserProblemBestScore{}).
+		Select(truncInt(db, "score / ?")+" as bucket, COUNT(*) as count", bucketSize).
+		Where("problem_id = ?", problemID).
+		Group("bucket").
+		Order("bucket").
+		Scan(&scoreBuckets).Error; err != nil {
+		return nil, err
+	}
+	stats.ScoreDistribution = make([]ScoreBucket, 0, len(scoreBuckets))
+	for _, b := range scoreBuckets {
+		stats.ScoreDistribution = append(stats.ScoreDistribution, ScoreBucket{
+			Min:   float64(b.Bucket * bucketSize),
+			Max:   float64((b.Bucket + 1) * bucketSize),
+			Count: b.Count,
+		})
+	}
+
+	if performanceBuckets <= 0 {
+		return stats, nil
+	}
+	stats.AveragePerformance = &agg.AvgPerf
+	stats.MaxPerformance = &agg.MaxPerf
+	width := (agg.MaxPerf - agg.MinPerf) / float64(performanceBuckets)
+	if width <= 0 {
+		width = 1
+	}
+	var perfBuckets []struct {
+		Bucket int
+		Count  int64
+	}
+	if err := db.Model(&models.UserProblemBestScore{}).
+		Select(least(db, truncInt(db, "(performance - ?) / ?"), "?")+" as bucket, COUNT(*) as count", agg.MinPerf, width, performanceBuckets-1).
+		Where("problem_id = ?", problemID).
+		Group("bucket").
+		Order("bucket").
+		Scan(&perfBuckets).Error; err != nil {
+		return nil, err
+	}
+	stats.PerformanceDistribution = make([]ScoreBucket, 0, len(perfBuckets))
+	for _, b := range perfBuckets {
+		stats.PerformanceDistribution = append(stats.PerformanceDistribution, ScoreBucket{
+			Min:   agg.MinPerf + float64(b.Bucket)*width,
+			Max:   agg.MinPerf + float64(b.Bucket+1)*width,
+			Count: b.Count,
+		})
+	}
+	return stats, nil
+}
+
+// Content hashes
+
+// FindDuplicateSubmission returns the ID of the user's latest submission to the problem with
+// the given content hash, or "" if there is none. Dry runs and failed submissions are ignored,
+// so resubmitting after a judging failure is not a duplicate.
+func FindDuplicateSubmission(db *gorm.DB, userID, problemID, contentHash string) (string, error) {
+	var sub models.Submission
+	err := db.Select("id").
+		Where("user_id = ? AND problem_id = ? AND content_hash = ? AND dry_run = ? AND status <> ?",
+			userID, problemID, contentHash, false, models.StatusFailed).
+		Order("created_at desc").
+		First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return sub.ID, err
+}
+
+// SharedContent is a content hash submitted to a problem by more than one user.
+type SharedContent struct {
+	ContentHash string              `json:"content_hash"`
+	Users       int64               `json:"users"`
+	Submissions []models.Submission `json:"submissions"`
+}
+
+// GetSharedContent finds the content hashes that several users submitted to the problem, most
+// widely shared first, with the submissions carrying them. Dry runs are ignored.
+func GetSharedContent(db *gorm.DB, problemID string) ([]SharedContent, error) {
+	var rows []struct {
+		ContentHash string
+		Users       int64
+	}
+	err := db.Model(&models.Submission{}).
+		Select("content_hash, COUNT(DISTINCT user_id) AS users").
+		Where("problem_id = ? AND content_hash <> '' AND dry_run = ?", problemID, false).
+		Group("content_hash").
+		Having("COUNT(DISTINCT user_id) > 1").
+		Order("users desc").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	shared := make([]SharedContent, len(rows))
+	for i, row := range rows {
+		shared[i] = SharedContent{ContentHash: row.ContentHash, Users: row.Users}
+		if err := db.Preload("User").
+			Where("problem_id = ? AND content_hash = ? AND dry_run = ?", problemID, row.ContentHash, false).
+			Order("created_at asc").
+			Find(&shared[i].Submissions).Error; err != nil {
+			return nil, err
+		}
+	}
+	return shared, nil
+}
+
+// Dashboard
+
+// DashboardCounts holds the site-wide totals shown on the admin dashboard.
+type DashboardCounts struct {
+	TotalUsers        int64 `json:"total_users"`
+	TotalSubmissions  int64 `json:"total_submissions"`
+	RecentSubmissions int64 `json:"recent_submissions"` // submissions created since the given time
+}
+
+// GetDashboardCounts counts users, submissions and submissions created since the given time.
+func GetDashboardCounts(db *gorm.DB, since time.Time) (*DashboardCounts, error) {
+	var counts DashboardCounts
+	if err := db.Model(&models.User{}).Count(&counts.TotalUsers).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Submission{}).Count(&counts.TotalSubmissions).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Submission{}).Where("created_at >= ?", since).Count(&counts.RecentSubmissions).Error; err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// GetRecentFailedSubmissions returns the latest failed submissions without their containers.
+func GetRecentFailedSubmissions(db *gorm.DB, limit int) ([]models.Submission, error) {
+	var subs []models.Submission
+	err := db.Preload("User").
+		Where("status = ?", models.StatusFailed).
+		Order("created_at desc").
+		Limit(limit).
+		Find(&subs).Error
+	return subs, err
+}
+
+// Personal access tokens
+
+func CreatePersonalAccessToken(db *gorm.DB, token *models.PersonalAccessToken) error {
+	return db.Create(token).Error
+}
+
+// GetPersonalAccessTokens lists a user's tokens, newest first.
+func GetPersonalAccessTokens(db *gorm.DB, userID string) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	err := db.Where("user_id = ?", userID).Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+func GetPersonalAccessTokenByHash(db *gorm.DB, hash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	if err := db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeletePersonalAccessToken revokes one of a user's tokens. It returns gorm.ErrRecordNotFound if
+// the user has no such token.
+func DeletePersonalAccessToken(db *gorm.DB, userID, tokenID string) error {
+	result := db.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&models.PersonalAccessToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TouchPersonalAccessToken records when and from where a token was last used.
+func TouchPersonalAccessToken(db *gorm.DB, tokenID string, at time.Time, ip string) error {
+	return db.Model(&models.PersonalAccessToken{}).Where("id = ?", tokenID).
+		Updates(map[string]interface{}{"last_used_at": at, "last_used_ip": ip}).Error
+}
+
+// Audit log
+
+func CreateAuditLog(db *gorm.DB, entry *models.AuditLog) error {
+	return db.Create(entry).Error
+}
+
+// User timeline
+
+// Timeline event types.
+const (
+	TimelineSubmission   = "submission"
+	TimelineRegistration = "registration"
+	TimelineScoreChange  = "score_change"
+	TimelineBan          = "ban"
+	TimelineUnban        = "unban"
+)
+
+// TimelineEvent is an entry of a user's activity timeline. Only the fields relevant to the event
+// type are set.
+type TimelineEvent struct {
+	Time         time.Time     `json:"time"`
+	Type         string        `json:"type"`
+	ContestID    string        `json:"contest_id,omitempty"`
+	ProblemID    string        `json:"problem_id,omitempty"`
+	SubmissionID string        `json:"submission_id,omitempty"`
+	Status       models.Status `json:"status,omitempty"`
+	Score        *int          `json:"score,omitempty"` // submission score, or total contest score after a score change
+	IsValid      *bool         `json:"is_valid,omitempty"`
+	DryRun       bool          `json:"dry_run,omitempty"`
+	Cluster      string        `json:"cluster,omitempty"`
+	Node         string        `json:"node,omitempty"`
+	Actor        string        `json:"actor,omitempty"` // admin that banned or unbanned the user
+	BannedUntil  string        `json:"banned_until,omitempty"`
+	BanReason    string        `json:"ban_reason,omitempty"`
+}
+
+// GetUserTimeline collects a user's submissions, contest registrations, score changes and bans,
+// newest first. Submissions have no contest ID, callers resolve it from the problem. Bans are
+// taken from the audit log of user updates.
+func GetUserTimeline(db *gorm.DB, userID string) ([]TimelineEvent, error) {
+	var events []TimelineEvent
+
+	var subs []models.Submission
+	if err := db.Select("id", "created_at", "problem_id", "status", "score", "is_valid", "dry_run", "cluster", "node").
+		Where("user_id = ?", userID).Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		score, isValid := sub.Score, sub.IsValid
+		events = append(events, TimelineEvent{
+			Time:         sub.CreatedAt,
+			Type:         TimelineSubmission,
+			ProblemID:    sub.ProblemID,
+			SubmissionID: sub.ID,
+			Status:       sub.Status,
+			Score:        &score,
+			IsValid:      &isValid,
+			DryRun:       sub.DryRun,
+			Cluster:      sub.Cluster,
+			Node:         sub.Node,
+		})
+	}
+
+	var histories []models.ContestScoreHistory
+	if err := db.Where("user_id = ?", userID).Find(&histories).Error; err != nil {
+		return nil, err
+	}
+	for _, h := range histories {
+		// Registering creates the user's initial history entry, which has no problem.
+		if h.ProblemID == "" {
+			events = append(events, TimelineEvent{Time: h.CreatedAt, Type: TimelineRegistration, ContestID: h.ContestID})
+			continue
+		}
+		total := h.TotalScoreAfterChange
+		events = append(events, TimelineEvent{
+			Time:         h.CreatedAt,
+			Type:         TimelineScoreChange,
+			ContestID:    h.ContestID,
+			ProblemID:    h.ProblemID,
+			SubmissionID: h.LastEffectiveSubmissionID,
+			Score:        &total,
+		})
+	}
+
+	var updates []models.AuditLog
+	if err := db.Where("action = ? AND target_id = ?", "user.update", userID).Find(&updates).Error; err != nil {
+		return nil, err
+	}
+	for _, entry := range updates {
+		changes, _ := entry.Detail["changes"].(map[string]interface{})
+		until, ok := changes["banned_until"].(string)
+		if !ok {
+			continue
+		}
+		event := TimelineEvent{Time: entry.CreatedAt, Type: TimelineUnban, Actor: entry.Actor}
+		if until != "" {
+			event.Type = TimelineBan
+			event.BannedUntil = until
+			event.BanReason, _ = changes["ban_reason"].(string)
+		}
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+	return events, nil
+}
+
+// AddContestUsage charges one judged submission and its CPU time to the user's usage of a contest.
+func AddContestUsage(db *gorm.DB, userID, contestID string, cpuSeconds float64) error {
+	usage := models.ContestUsage{UserID: userID, ContestID: contestID, CPUSeconds: cpuSeconds, Runs: 1}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "contest_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"cpu_seconds": gorm.Expr("contest_usages.cpu_seconds + ?", cpuSeconds),
+			"runs":        gorm.Expr("contest_usages.runs + 1"),
+			"updated_at":  time.Now(),
+		}),
+	}).Create(&usage).Error
+}
+
+// GetContestUsage returns the user's usage of a contest, zero if nothing was charged yet.
+func GetContestUsage(db *gorm.DB, userID, contestID string) (models.ContestUsage, error) {
+	var usage models.ContestUsage
+	err := db.Where("user_id = ? AND contest_id = ?", userID, contestID).First(&usage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.ContestUsage{UserID: userID, ContestID: contestID}, nil
+	}
+	return usage, err
+}
+
+// GetContestUsages lists the usage of every user charged in a contest, heaviest CPU users first.
+func GetContestUsages(db *gorm.DB, contestID string) ([]models.ContestUsage, error) {
+	var usages []models.ContestUsage
+	err := db.Where("contest_id = ?", contestID).Order("cpu_seconds desc").Find(&usages).Error
+	return usages, err
+}
+
+// SaveContestUsage creates or replaces the user's usage row of a contest.
+func SaveContestUsage(db *gorm.DB, usage *models.ContestUsage) error {
+	return db.Save(usage).Error
+}
+
+// CountActiveSubmissions counts the user's queued and running submissions to the given problems,
+// which are not charged to the contest usage until they finish. Submissions queued by an admin
+// are never charged and not counted.
+func CountActiveSubmissions(db *gorm.DB, userID string, problemIDs []string) (int, error) {
+	var count int64
+	err := db.Model(&models.Submission{}).
+		Where("user_id = ? AND problem_id IN ? AND status IN ? AND admin_queued = ?", userID, problemIDs, []models.Status{models.StatusQueued, models.StatusRunning}, false).
+		Count(&count).Error
+	return int(count), err
+}
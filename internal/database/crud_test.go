@@ -0,0 +1,110 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+)
+
+func TestSetUserProblemScorePerformanceMode(t *testing.T) {
+	db := openTestDB(t)
+	ptr := func(v float64) *float64 { return &v }
+	intPtr := func(v int) *int { return &v }
+	bestScore := func(userID string) models.UserProblemBestScore {
+		t.Helper()
+		var row models.UserProblemBestScore
+		if err := db.Where("user_id = ? AND contest_id = ? AND problem_id = ?", userID, "c", "p").First(&row).Error; err != nil {
+			t.Fatalf("load best score of %s: %v", userID, err)
+		}
+		return row
+	}
+
+	if err := SetUserProblemScore(db, "u1", "c", "p", nil, ptr(2), "performance", 100, WeightedScoring{}); err != nil {
+		t.Fatalf("set performance of u1: %v", err)
+	}
+	if err := SetUserProblemScore(db, "u2", "c", "p", nil, ptr(1), "performance", 100, WeightedScoring{}); err != nil {
+		t.Fatalf("set performance of u2: %v", err)
+	}
+	if got := bestScore("u2"); got.Score != 50 || got.Performance != 1 {
+		t.Fatalf("u2 after rescale: score %d performance %v, want 50 and 1", got.Score, got.Performance)
+	}
+
+	// Both together would have the explicit score overwritten by the rescale, so it is refused
+	// and nothing changes.
+	err := SetUserProblemScore(db, "u2", "c", "p", intPtr(90), ptr(1.5), "performance", 100, WeightedScoring{})
+	if !errors.Is(err, ErrScoreWithPerformance) {
+		t.Fatalf("got error %v, want ErrScoreWithPerformance", err)
+	}
+	if got := bestScore("u2"); got.Score != 50 || got.Performance != 1 {
+		t.Errorf("u2 after refused update: score %d performance %v, want 50 and 1", got.Score, got.Performance)
+	}
+
+	// A score alone is kept as given.
+	if err := SetUserProblemScore(db, "u2", "c", "p", intPtr(90), nil, "performance", 100, WeightedScoring{}); err != nil {
+		t.Fatalf("set score of u2: %v", err)
+	}
+	if got := bestScore("u2"); got.Score != 90 || got.Performance != 1 {
+		t.Errorf("u2 after setting the score: score %d performance %v, want 90 and 1", got.Score, got.Performance)
+	}
+	if got := bestScore("u1"); got.Score != 100 {
+		t.Errorf("u1 score %d, want 100", got.Score)
+	}
+
+	// Outside performance mode both may be set at once.
+	if err := SetUserProblemScore(db, "u3", "c", "p", intPtr(70), ptr(3), "score", 100, WeightedScoring{}); err != nil {
+		t.Fatalf("set score and performance in score mode: %v", err)
+	}
+	if got := bestScore("u3"); got.Score != 70 || got.Performance != 3 {
+		t.Errorf("u3: score %d performance %v, want 70 and 3", got.Score, got.Performance)
+	}
+}
+
+func TestCountActiveSubmissionsSkipsAdminQueued(t *testing.T) {
+	db := openTestDB(t)
+	for _, sub := range []models.Submission{
+		{ID: "s1", UserID: "u", ProblemID: "p", Status: models.StatusQueued},
+		{ID: "s2", UserID: "u", ProblemID: "p", Status: models.StatusRunning},
+		{ID: "s3", UserID: "u", ProblemID: "p", Status: models.StatusQueued, AdminQueued: true},
+		{ID: "s4", UserID: "u", ProblemID: "p", Status: models.StatusSuccess},
+		{ID: "s5", UserID: "u", ProblemID: "other", Status: models.StatusQueued},
+	} {
+		if err := db.Omit("User").Create(&sub).Error; err != nil {
+			t.Fatalf("create submission: %v", err)
+		}
+	}
+	count, err := CountActiveSubmissions(db, "u", []string{"p"})
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("got %d active submissions, want 2", count)
+	}
+}
+
+func TestDeleteQueuedSubmission(t *testing.T) {
+	db := openTestDB(t)
+	for _, sub := range []models.Submission{
+		{ID: "queued", UserID: "u", ProblemID: "p", Status: models.StatusQueued},
+		{ID: "running", UserID: "u", ProblemID: "p", Status: models.StatusRunning},
+	} {
+		if err := db.Omit("User").Create(&sub).Error; err != nil {
+			t.Fatalf("create submission: %v", err)
+		}
+	}
+
+	for id, want := range map[string]bool{"queued": true, "running": false, "missing": false} {
+		deleted, err := DeleteQueuedSubmission(db, id)
+		if err != nil {
+			t.Fatalf("delete %s: %v", id, err)
+		}
+		if deleted != want {
+			t.Errorf("delete %s: got %v, want %v", id, deleted, want)
+		}
+	}
+	var left []string
+	db.Unscoped().Model(&models.Submission{}).Order("id").Pluck("id", &left)
+	if len(left) != 1 || left[0] != "running" {
+		t.Errorf("submissions left: %v, want [running]", left)
+	}
+}
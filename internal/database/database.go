@@ -1,46 +1,86 @@
 package database
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ZJUSCT/CSOJ/internal/database/models"
 	"go.uber.org/zap"
 
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-func Init(dsn string) (*gorm.DB, error) {
-	if _, err := os.Stat(dsn); os.IsNotExist(err) {
-		zap.S().Infof("database file not found at '%s', creating directory for it.", dsn)
-		// Ensure the directory for the database file exists.
-		dbDir := filepath.Dir(dsn)
-		if err := os.MkdirAll(dbDir, 0755); err != nil {
-			return nil, err
-		}
+// Supported values of storage.driver.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// allModels lists every table, in an order where rows only refer to rows of earlier tables.
+var allModels = []any{
+	&models.User{},
+	&models.Submission{},
+	&models.Container{},
+	&models.ContestScoreHistory{},
+	&models.UserProblemBestScore{},
+	&models.Tag{},
+	&models.AuditLog{},
+	&models.FinalStanding{},
+	&models.SubmissionNote{},
+	&models.PersonalAccessToken{},
+	&models.ContestUsage{},
+}
+
+// Init opens the database with the given driver and migrates the schema. For SQLite the dsn is
+// the path of the database file; for Postgres and MySQL it is the driver's connection string.
+func Init(driver, dsn string) (*gorm.DB, error) {
+	dialector, err := openDialector(driver, dsn)
+	if err != nil {
+		return nil, err
 	}
 
-	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	// SQLite never enforced the foreign keys GORM derives from associations, and rows such as
+	// submissions of deleted users rely on that, so the constraints are not created anywhere.
+	db, err := gorm.Open(dialector, &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true})
 	if err != nil {
 		return nil, err
 	}
 
 	// Auto migrate schema
-	err = db.AutoMigrate(
-		&models.User{},
-		&models.Submission{},
-		&models.Container{},
-		&models.ContestScoreHistory{},
-		&models.UserProblemBestScore{},
-	)
+	err = db.AutoMigrate(allModels...)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := migrateGitLabIdentities(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// migrateGitLabIdentities moves identities from the legacy git_lab_id column
+// to the generic (oidc_provider, oidc_subject) pair.
+func migrateGitLabIdentities(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.User{}, "git_lab_id") {
+		return nil
+	}
+	result := db.Exec("UPDATE users SET oidc_provider = ?, oidc_subject = git_lab_id, git_lab_id = NULL WHERE git_lab_id IS NOT NULL AND oidc_subject IS NULL", "gitlab")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		zap.S().Infof("migrated %d GitLab identities to OIDC identities", result.RowsAffected)
+	}
+	return nil
+}
+
 func RecoverInterrupted(db *gorm.DB) error {
 	// Mark running submissions as failed
 	result := db.Model(&models.Submission{}).
@@ -65,3 +105,42 @@ func RecoverInterrupted(db *gorm.DB) error {
 
 	return nil
 }
+
+// openDialector picks the GORM dialector for driver. Times are always read back in time.Local,
+// the configured display zone.
+func openDialector(driver, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case "", DriverSQLite:
+		if _, err := os.Stat(dsn); os.IsNotExist(err) {
+			zap.S().Infof("database file not found at '%s', creating directory for it.", dsn)
+			// Ensure the directory for the database file exists.
+			dbDir := filepath.Dir(dsn)
+			if err := os.MkdirAll(dbDir, 0755); err != nil {
+				return nil, err
+			}
+		}
+		return sqlite.Open(withDSNParam(dsn, "_loc", "auto")), nil
+	case DriverPostgres:
+		// pgx returns timestamptz values in time.Local by itself.
+		return postgres.Open(dsn), nil
+	case DriverMySQL:
+		dsn = withDSNParam(withDSNParam(dsn, "parseTime", "true"), "loc", "Local")
+		// Indexed string columns need a bounded length on MySQL. Every string column without an
+		// index is therefore declared as text, or it would be cut to this size.
+		return mysql.New(mysql.Config{DSN: dsn, DefaultStringSize: 256}), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+// withDSNParam appends a connection parameter to a SQLite or MySQL DSN unless it is already set.
+func withDSNParam(dsn, key, value string) string {
+	if strings.Contains(dsn, key+"=") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + key + "=" + value
+}
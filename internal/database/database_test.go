@@ -0,0 +1,169 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/google/uuid"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// openTestDB opens a fresh SQLite database with the full schema.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := Init(DriverSQLite, filepath.Join(t.TempDir(), "csoj.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	return db
+}
+
+// testBackends opens an empty, migrated database on every backend available to the test:
+// SQLite always, Postgres and MySQL when CSOJ_TEST_POSTGRES_DSN or CSOJ_TEST_MYSQL_DSN name a
+// scratch database. Their tables are dropped and recreated, so never point them at real data.
+// CI sets both.
+func testBackends(t *testing.T) map[string]*gorm.DB {
+	t.Helper()
+	backends := map[string]*gorm.DB{DriverSQLite: openTestDB(t)}
+	for driver, env := range map[string]string{DriverPostgres: "CSOJ_TEST_POSTGRES_DSN", DriverMySQL: "CSOJ_TEST_MYSQL_DSN"} {
+		dsn := os.Getenv(env)
+		if dsn == "" {
+			t.Logf("%s not set, skipping %s", env, driver)
+			continue
+		}
+		db, err := Init(driver, dsn)
+		if err != nil {
+			t.Fatalf("open %s: %v", driver, err)
+		}
+		if err := db.Migrator().DropTable(allModels...); err != nil {
+			t.Fatalf("reset %s: %v", driver, err)
+		}
+		if err := db.AutoMigrate(allModels...); err != nil {
+			t.Fatalf("migrate %s: %v", driver, err)
+		}
+		if sqlDB, err := db.DB(); err == nil {
+			t.Cleanup(func() { sqlDB.Close() })
+		}
+		backends[driver] = db
+	}
+	return backends
+}
+
+func TestLongValuesRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 4000)
+	cores := strings.TrimSuffix(strings.Repeat("127,", 500), ",")
+
+	for driver, db := range testBackends(t) {
+		t.Run(driver, func(t *testing.T) {
+			user := models.User{ID: uuid.NewString(), Username: uuid.NewString(), Nickname: long, Signature: long, AvatarURL: long, BanReason: long, Tags: long}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatalf("create user: %v", err)
+			}
+			sub := models.Submission{ID: uuid.NewString(), UserID: user.ID, ProblemID: "p", AllocatedCores: cores}
+			if err := db.Omit("User").Create(&sub).Error; err != nil {
+				t.Fatalf("create submission: %v", err)
+			}
+			container := models.Container{ID: uuid.NewString(), SubmissionID: sub.ID, UserID: user.ID, Image: long, LogFilePath: long}
+			if err := db.Omit("User").Create(&container).Error; err != nil {
+				t.Fatalf("create container: %v", err)
+			}
+			standing := models.FinalStanding{ContestID: uuid.NewString(), UserID: user.ID, Nickname: long, AvatarURL: long, Tags: long}
+			if err := db.Create(&standing).Error; err != nil {
+				t.Fatalf("create standing: %v", err)
+			}
+			tag := models.Tag{Name: uuid.NewString(), Description: long}
+			if err := db.Create(&tag).Error; err != nil {
+				t.Fatalf("create tag: %v", err)
+			}
+
+			var gotUser models.User
+			var gotSub models.Submission
+			var gotContainer models.Container
+			var gotStanding models.FinalStanding
+			var gotTag models.Tag
+			for _, err := range []error{
+				db.First(&gotUser, "id = ?", user.ID).Error,
+				db.First(&gotSub, "id = ?", sub.ID).Error,
+				db.First(&gotContainer, "id = ?", container.ID).Error,
+				db.First(&gotStanding, "id = ?", standing.ID).Error,
+				db.First(&gotTag, "name = ?", tag.Name).Error,
+			} {
+				if err != nil {
+					t.Fatalf("read back: %v", err)
+				}
+			}
+			for name, got := range map[string]string{
+				"users.nickname":             gotUser.Nickname,
+				"users.signature":            gotUser.Signature,
+				"users.avatar_url":           gotUser.AvatarURL,
+				"users.ban_reason":           gotUser.BanReason,
+				"users.tags":                 gotUser.Tags,
+				"containers.image":           gotContainer.Image,
+				"containers.log_file_path":   gotContainer.LogFilePath,
+				"final_standings.nickname":   gotStanding.Nickname,
+				"final_standings.avatar_url": gotStanding.AvatarURL,
+				"final_standings.tags":       gotStanding.Tags,
+				"tags.description":           gotTag.Description,
+			} {
+				if got != long {
+					t.Errorf("%s: got %d bytes, want %d", name, len(got), len(long))
+				}
+			}
+			if gotSub.AllocatedCores != cores {
+				t.Errorf("submission.allocated_cores: got %d bytes, want %d", len(gotSub.AllocatedCores), len(cores))
+			}
+		})
+	}
+}
+
+// TestMySQLColumnTypes checks the schema MySQL would get without connecting to one: indexed
+// string columns must be bounded so they can be indexed, and the columns that hold user input
+// or paths of unknown length must not be.
+func TestMySQLColumnTypes(t *testing.T) {
+	dialector := mysql.New(mysql.Config{DefaultStringSize: 256}).(*mysql.Dialector)
+	unbounded := map[string]bool{
+		"users.nickname": true, "users.signature": true, "users.avatar_url": true, "users.ban_reason": true, "users.tags": true,
+		"submissions.allocated_cores": true,
+		"containers.image":            true, "containers.log_file_path": true,
+		"final_standings.nickname": true, "final_standings.avatar_url": true, "final_standings.tags": true,
+		"tags.description": true,
+	}
+
+	cache := &sync.Map{}
+	for _, model := range allModels {
+		s, err := schema.Parse(model, cache, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatalf("parse %T: %v", model, err)
+		}
+		indexed := make(map[string]bool)
+		for _, idx := range s.ParseIndexes() {
+			for _, f := range idx.Fields {
+				indexed[f.DBName] = true
+			}
+		}
+		for _, field := range s.Fields {
+			if field.DBName == "" || field.DataType != schema.String && field.DataType != "text" {
+				continue
+			}
+			column := s.Table + "." + field.DBName
+			dataType := dialector.DataTypeOf(field)
+			bounded := strings.HasPrefix(dataType, "varchar")
+			if (field.PrimaryKey || indexed[field.DBName]) && !bounded {
+				t.Errorf("%s is indexed but has unbounded type %s", column, dataType)
+			}
+			if unbounded[column] && bounded {
+				t.Errorf("%s would be cut to %s", column, dataType)
+			}
+			delete(unbounded, column)
+		}
+	}
+	for column := range unbounded {
+		t.Errorf("%s not found in the schema", column)
+	}
+}
@@ -0,0 +1,26 @@
+package database
+
+import "gorm.io/gorm"
+
+// The few SQL expressions below differ between the supported backends. Everything else in this
+// package sticks to constructs all of them share.
+
+// truncInt returns SQL that truncates the numeric expression expr towards zero as an integer.
+func truncInt(db *gorm.DB, expr string) string {
+	switch db.Dialector.Name() {
+	case DriverPostgres:
+		return "CAST(TRUNC(" + expr + ") AS INTEGER)"
+	case DriverMySQL:
+		return "CAST(TRUNCATE(" + expr + ", 0) AS SIGNED)"
+	default:
+		return "CAST(" + expr + " AS INTEGER)"
+	}
+}
+
+// least returns SQL for the smaller of two values. SQLite spells it as the two-argument MIN.
+func least(db *gorm.DB, a, b string) string {
+	if db.Dialector.Name() == DriverSQLite {
+		return "MIN(" + a + ", " + b + ")"
+	}
+	return "LEAST(" + a + ", " + b + ")"
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const copyBatchSize = 500
+
+// CopyAll copies every row of src into dst, which must already be migrated and empty. It is the
+// way to move a deployment between backends, e.g. from SQLite to Postgres. Soft-deleted rows are
+// copied too, and primary keys are kept so that references between tables stay intact.
+func CopyAll(dst, src *gorm.DB) error {
+	for _, model := range allModels {
+		var count int64
+		if err := dst.Unscoped().Model(model).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("destination table of %T is not empty", model)
+		}
+	}
+
+	return dst.Transaction(func(tx *gorm.DB) error {
+		for _, model := range allModels {
+			rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem())).Interface()
+			copied := 0
+			err := src.Unscoped().Model(model).FindInBatches(rows, copyBatchSize, func(batch *gorm.DB, _ int) error {
+				if batch.RowsAffected == 0 {
+					return nil
+				}
+				copied += int(batch.RowsAffected)
+				return tx.Session(&gorm.Session{SkipHooks: true}).Omit(clause.Associations).Create(rows).Error
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to copy %T: %w", model, err)
+			}
+			if err := resetSequence(tx, model); err != nil {
+				return err
+			}
+			zap.S().Infof("copied %d rows of %T", copied, model)
+		}
+		return nil
+	})
+}
+
+// resetSequence moves a Postgres serial past the copied ids. The other backends derive the next
+// id from the rows themselves.
+func resetSequence(db *gorm.DB, model any) error {
+	if db.Dialector.Name() != DriverPostgres {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if pk == nil || !pk.AutoIncrement {
+		return nil
+	}
+	return db.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%[1]s', '%[2]s'), COALESCE(MAX(%[2]s), 0) + 1, false) FROM %[1]s",
+		stmt.Schema.Table, pk.DBName)).Error
+}
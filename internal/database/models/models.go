@@ -26,11 +26,77 @@ func (m JSONMap) Value() (driver.Value, error) {
 }
 
 func (m *JSONMap) Scan(value interface{}) error {
-	bytes, ok := value.([]byte)
-	if !ok {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, &m)
+	case string: // Postgres returns text columns as strings
+		return json.Unmarshal([]byte(v), &m)
+	default:
+		return errors.New("type assertion to []byte failed")
+	}
+}
+
+// Subtask is one entry of the breakdown a judge may report next to the total score. It is only
+// displayed, the total score alone is used for ranking.
+type Subtask struct {
+	Name   string  `json:"name"`
+	Score  float64 `json:"score"`
+	Max    float64 `json:"max"`
+	Status string  `json:"status"`
+}
+
+// Subtasks is a helper type for storing a subtask breakdown as JSON in the database.
+type Subtasks []Subtask
+
+func (s Subtasks) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (s *Subtasks) Scan(value interface{}) error {
+	if value == nil {
+		*s = nil // submissions judged before subtasks were recorded
+		return nil
+	}
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return errors.New("type assertion to []byte failed")
+	}
+}
+
+// ICPCResult is a user's result on one problem of a contest ranked ICPC-style.
+type ICPCResult struct {
+	Solved       bool `json:"solved"`
+	Attempts     int  `json:"attempts"`                // rejected attempts before the solve, or all attempts while unsolved
+	SolveMinutes int  `json:"solve_minutes,omitempty"` // minutes from the contest start to the solve
+	Penalty      int  `json:"penalty,omitempty"`       // solve minutes plus the attempt penalties, 0 while unsolved
+}
+
+// ICPCResults is a helper type for storing ICPC results by problem ID as JSON in the database.
+type ICPCResults map[string]ICPCResult
+
+func (r ICPCResults) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *ICPCResults) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*r = nil // standings of contests not ranked ICPC-style
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	default:
 		return errors.New("type assertion to []byte failed")
 	}
-	return json.Unmarshal(bytes, &m)
 }
 
 type User struct {
@@ -39,14 +105,16 @@ type User struct {
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 
-	GitLabID     *string    `gorm:"uniqueIndex" json:"-"`
+	// OIDCProvider and OIDCSubject identify users that log in through an external OIDC provider.
+	OIDCProvider *string    `gorm:"uniqueIndex:idx_oidc_identity" json:"oidc_provider,omitempty"`
+	OIDCSubject  *string    `gorm:"uniqueIndex:idx_oidc_identity" json:"-"`
 	Username     string     `gorm:"uniqueIndex" json:"username"`
 	PasswordHash string     `json:"-"`
-	Nickname     string     `json:"nickname"`
-	Signature    string     `json:"signature"`
-	AvatarURL    string     `json:"avatar_url"`
+	Nickname     string     `gorm:"type:text" json:"nickname"`
+	Signature    string     `gorm:"type:text" json:"signature"`
+	AvatarURL    string     `gorm:"type:text" json:"avatar_url"`
 	BannedUntil  *time.Time `json:"banned_until"`
-	BanReason    string     `json:"ban_reason"`
+	BanReason    string     `gorm:"type:text" json:"ban_reason"`
 	DisableRank  bool       `gorm:"default:false" json:"disable_rank"`
 	Tags         string     `gorm:"type:text" json:"tags"` // Comma-separated tags
 }
@@ -55,22 +123,43 @@ type Submission struct {
 	ID        string `gorm:"primaryKey" json:"id"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// DeletedAt is set while the submission sits in the recycle bin. Such submissions are
+	// excluded from queries unless Unscoped is used.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 
 	ProblemID string `gorm:"index" json:"problem_id"`
 	UserID    string `gorm:"index" json:"user_id"`
 	User      User   `json:"user"`
 
-	Status         Status  `gorm:"index" json:"status"`
-	CurrentStep    int     `json:"current_step"` // index of the current workflow step
-	Cluster        string  `json:"cluster"`
-	Node           string  `json:"node"`
-	AllocatedCores string  `json:"allocated_cores"` // e.g., "2,3,4"
-	Score          int     `json:"score"`
-	Performance    float64 `json:"performance"`
-	Info           JSONMap `gorm:"type:text" json:"info"`
-	IsValid        bool    `json:"is_valid"`
+	Status         Status   `gorm:"index" json:"status"`
+	CurrentStep    int      `json:"current_step"` // index of the current workflow step
+	StartStep      int      `json:"start_step"`   // workflow steps before this index are skipped (re-run from step)
+	Cluster        string   `json:"cluster"`
+	Node           string   `json:"node"`
+	AllocatedCores string   `gorm:"type:text" json:"allocated_cores"` // e.g., "2,3,4"
+	Score          int      `json:"score"`
+	Performance    float64  `json:"performance"`
+	Info           JSONMap  `gorm:"type:text" json:"info"`
+	Subtasks       Subtasks `gorm:"type:text" json:"subtasks"`
+	IsValid        bool     `json:"is_valid"`
+	DryRun         bool     `json:"dry_run"`                           // compile-check only: runs dry_run_safe steps, never scored and always invalid
+	Practice       bool     `json:"practice"`                          // made after the contest ended: judged, but never scored and always invalid
+	ContentHash    string   `gorm:"index" json:"content_hash"`         // SHA-256 over the submitted file tree
+	DuplicateOf    string   `json:"duplicate_of,omitempty"`            // an earlier submission of the user with the same content
+	AdminQueued    bool     `gorm:"default:false" json:"admin_queued"` // queued by an admin (rejudge, re-run from a step, requeue), never charged to the user's quota
 
 	Containers []Container `gorm:"foreignKey:SubmissionID;constraint:OnDelete:CASCADE" json:"containers"`
+	// Notes are internal to graders and never serialized with the submission.
+	Notes []SubmissionNote `gorm:"foreignKey:SubmissionID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// SubmissionNote is a review note attached to a submission through the admin API.
+type SubmissionNote struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	SubmissionID string    `gorm:"index" json:"submission_id"`
+	Author       string    `json:"author"` // admin key name, like AuditLog.Actor
+	Content      string    `gorm:"type:text" json:"content"`
 }
 
 type Container struct {
@@ -83,12 +172,16 @@ type Container struct {
 	User         User   `gorm:"foreignKey:UserID" json:"user"`
 	DockerID     string `gorm:"docker_id" json:"docker_id"`
 
-	Image       string    `json:"image"`
+	Image       string    `gorm:"type:text" json:"image"`
 	Status      Status    `json:"status"`
 	ExitCode    int       `json:"exit_code"`
 	StartedAt   time.Time `json:"started_at"`
 	FinishedAt  time.Time `json:"finished_at"`
-	LogFilePath string    `json:"log_file_path"`
+	LogFilePath string    `gorm:"type:text" json:"log_file_path"`
+
+	// CurrentCommand is the index of the step command running or last run, out of TotalCommands.
+	CurrentCommand int `json:"current_command"`
+	TotalCommands  int `json:"total_commands"`
 }
 
 type ContestScoreHistory struct {
@@ -108,7 +201,74 @@ type UserProblemBestScore struct {
 	ProblemID       string `gorm:"uniqueIndex:idx_user_problem"`
 	Score           int
 	Performance     float64
+	RawScore        int // best judge score in "weighted" mode, Score then holds the weighted points
 	SubmissionID    string
 	SubmissionCount int
 	LastScoreTime   time.Time
+	Pinned          bool // SubmissionID was chosen by an admin and is kept until the pin is cleared
+}
+
+// FinalStanding is a row of a contest's leaderboard, frozen when the contest ended.
+type FinalStanding struct {
+	ID            uint        `gorm:"primaryKey" json:"-"`
+	ContestID     string      `gorm:"index" json:"contest_id"`
+	Rank          int         `json:"rank"` // 1-based position on the frozen leaderboard
+	UserID        string      `json:"user_id"`
+	Username      string      `json:"username"`
+	Nickname      string      `gorm:"type:text" json:"nickname"`
+	AvatarURL     string      `gorm:"type:text" json:"avatar_url"`
+	Tags          string      `gorm:"type:text" json:"tags"`
+	DisableRank   bool        `json:"disable_rank"`
+	TotalScore    int         `json:"total_score"`
+	ProblemScores JSONMap     `gorm:"type:text" json:"problem_scores"`
+	Penalty       int         `json:"penalty,omitempty"`               // ICPC-style contests: total penalty minutes
+	ICPC          ICPCResults `gorm:"type:text" json:"icpc,omitempty"` // ICPC-style contests: results by problem
+	FinalizedAt   time.Time   `json:"finalized_at"`
+}
+
+// ContestUsage is the compute a user's judged submissions have used in a contest, checked against
+// the contest's quota. Limits set by an admin replace the contest's quota for the user; 0 then
+// means unlimited.
+type ContestUsage struct {
+	UserID          string     `gorm:"primaryKey" json:"user_id"`
+	ContestID       string     `gorm:"primaryKey" json:"contest_id"`
+	CPUSeconds      float64    `json:"cpu_seconds"` // container run time times the problem's cpu
+	Runs            int        `json:"runs"`        // judged submissions, including dry runs and practice
+	CPUSecondsLimit *float64   `json:"cpu_seconds_limit"`
+	RunsLimit       *int       `json:"runs_limit"`
+	ResetAt         *time.Time `json:"reset_at"` // when an admin last cleared the usage
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// AuditLog records a state-changing action performed through the admin API, or a user managing
+// their access tokens.
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	Actor     string    `gorm:"index" json:"actor"` // admin key name, "admin" when the admin API is unauthenticated, or "user:<id>" for token management
+	Action    string    `gorm:"index" json:"action"`
+	TargetID  string    `gorm:"index" json:"target_id"`
+	ClientIP  string    `json:"client_ip"`
+	Detail    JSONMap   `gorm:"type:text" json:"detail"`
+}
+
+// PersonalAccessToken lets a user authenticate with "Authorization: Token <value>" instead of a JWT.
+// Only the hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     string     `gorm:"index" json:"user_id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`  // "read" or "submit"
+	Prefix     string     `json:"prefix"` // leading characters of the token, to tell tokens apart
+	TokenHash  string     `gorm:"uniqueIndex" json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+}
+
+// Tag is an entry of the admin-defined vocabulary of user tags.
+type Tag struct {
+	Name        string `gorm:"primaryKey" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
+	CreatedAt   time.Time
 }
@@ -0,0 +1,35 @@
+package judger
+
+import (
+	"encoding/json"
+
+	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+)
+
+// Announcement events.
+const (
+	AnnouncementCreated = "created"
+	AnnouncementUpdated = "updated"
+)
+
+// AnnouncementEvent is pushed to the contest's announcement topic with the "announcement" stream.
+type AnnouncementEvent struct {
+	Event        string        `json:"event"`
+	ContestID    string        `json:"contest_id"`
+	Announcement *Announcement `json:"announcement"`
+}
+
+// AnnouncementTopic is the pubsub topic carrying a contest's announcement events.
+func AnnouncementTopic(contestID string) string {
+	return "announcements:" + contestID
+}
+
+// PublishAnnouncement pushes an announcement event to the contest's live subscribers. The event
+// is not cached: clients load earlier announcements through the REST endpoint.
+func PublishAnnouncement(contestID, event string, ann *Announcement) {
+	data, err := json.Marshal(AnnouncementEvent{Event: event, ContestID: contestID, Announcement: ann})
+	if err != nil {
+		return
+	}
+	pubsub.GetBroker().Broadcast(AnnouncementTopic(contestID), pubsub.FormatMessage("announcement", string(data)))
+}
@@ -0,0 +1,133 @@
+package judger
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+)
+
+// ArtifactDir is where workflow steps with artifacts enabled leave files for the submitter, e.g.
+// a profiling report or a diff against the expected output.
+const ArtifactDir = "/mnt/output"
+
+// errArtifactsTooLarge is returned once copying artifacts would exceed the size cap.
+var errArtifactsTooLarge = errors.New("artifacts exceed the size limit")
+
+// CreateArtifactDir creates the world-writable ArtifactDir inside the container.
+func (m *DockerManager) CreateArtifactDir(containerID string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	name := strings.TrimPrefix(ArtifactDir, "/") + "/"
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 01777, Typeflag: tar.TypeDir}); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	return m.observe(m.cli.CopyToContainer(context.Background(), containerID, "/", &buf, container.CopyToContainerOptions{}))
+}
+
+// CopyArtifacts copies the regular files under the container's ArtifactDir into dstDir, replacing
+// files of the same name. It stops with errArtifactsTooLarge before writing more than maxBytes,
+// 0 meaning unlimited, and returns the number of bytes written.
+func (m *DockerManager) CopyArtifacts(containerID, dstDir string, maxBytes int64) (int64, error) {
+	rc, _, err := m.cli.CopyFromContainer(context.Background(), containerID, ArtifactDir)
+	if err != nil {
+		if cerrdefs.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, m.observe(err)
+	}
+	defer rc.Close()
+
+	var written int64
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, fmt.Errorf("failed to read artifact archive: %w", err)
+		}
+		// Entries are named after the copied directory, e.g. "output/report.html".
+		_, rel, _ := strings.Cut(hdr.Name, "/")
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(rel) {
+			continue
+		}
+		if maxBytes > 0 && written+hdr.Size > maxBytes {
+			return written, errArtifactsTooLarge
+		}
+
+		target := filepath.Join(dstDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return written, err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return written, err
+		}
+		n, err := io.Copy(f, io.LimitReader(tr, hdr.Size))
+		f.Close()
+		written += n
+		if err != nil {
+			return written, fmt.Errorf("failed to write artifact %s: %w", rel, err)
+		}
+	}
+}
+
+// Artifact is a file a judge left for the submitter.
+type Artifact struct {
+	Path string `json:"path"` // slash-separated, relative to the submission's artifact directory
+	Size int64  `json:"size"`
+}
+
+// ListArtifacts returns the artifacts stored in dir, which need not exist.
+func ListArtifacts(dir string) ([]Artifact, error) {
+	artifacts := []Artifact{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, Artifact{Path: filepath.ToSlash(rel), Size: info.Size()})
+		return nil
+	})
+	return artifacts, err
+}
+
+// artifactsSize returns the total size of the artifacts stored in dir.
+func artifactsSize(dir string) int64 {
+	artifacts, err := ListArtifacts(dir)
+	if err != nil {
+		return 0
+	}
+	var size int64
+	for _, a := range artifacts {
+		size += a.Size
+	}
+	return size
+}
@@ -3,9 +3,8 @@ package judger
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -16,6 +15,7 @@ import (
 	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/database/models"
 	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+	"github.com/ZJUSCT/CSOJ/internal/util"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -27,18 +27,21 @@ type Dispatcher struct {
 	db        *gorm.DB
 	scheduler *Scheduler
 	appState  *AppState
+	hook      *hookRunner
 }
 
 type JudgeResult struct {
 	Score       int                    `json:"score"`
 	Performance float64                `json:"performance"`
 	Info        map[string]interface{} `json:"info"`
+	Subtasks    models.Subtasks        `json:"subtasks"` // optional breakdown for display, Score still ranks
 }
 
 type tempJudgeResult struct {
 	Score       float64                `json:"score"`
 	Performance float64                `json:"performance"`
 	Info        map[string]interface{} `json:"info"`
+	Subtasks    models.Subtasks        `json:"subtasks"`
 }
 
 func NewDispatcher(cfg *config.Config, db *gorm.DB, scheduler *Scheduler, appState *AppState) *Dispatcher {
@@ -47,39 +50,63 @@ func NewDispatcher(cfg *config.Config, db *gorm.DB, scheduler *Scheduler, appSta
 		db:        db,
 		scheduler: scheduler,
 		appState:  appState,
+		hook:      newHookRunner(cfg.PostSubmissionHook),
 	}
 }
 
 func (d *Dispatcher) Dispatch(sub *models.Submission, prob *Problem, node *NodeState, allocatedCores []int) {
-	zap.S().Infof("dispatching submission %s to node %s", sub.ID, node.Name)
+	log := submissionLogger(sub)
+	log.Infof("dispatching submission %s to node %s", sub.ID, node.Name)
 
-	docker, err := NewDockerManager(node.Docker)
+	// However dispatching ends, even by a panic, the submission's topic is closed so watchers
+	// are not left waiting and its cache is freed, its usage is charged to the user's quota and
+	// the post-submission hook is started.
+	started := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("recovered from panic while dispatching submission %s: %v", sub.ID, r)
+			d.failSubmission(sub, fmt.Sprintf("internal error while judging: %v", r))
+			d.scheduler.ReleaseResources(sub.ID)
+		}
+		pubsub.GetBroker().CloseTopic(sub.ID)
+		contestID := d.findContestIDForProblem(sub.ProblemID)
+		d.chargeUsage(sub, prob, contestID, started)
+		d.runHook(sub, contestID)
+	}()
+
+	docker, err := GetDockerManager(node.Docker)
 	if err != nil {
 		d.failSubmission(sub, fmt.Sprintf("failed to create docker client: %v", err))
-		pubsub.GetBroker().CloseTopic(sub.ID)
 		return
 	}
 
+	// Compressed content is extracted once and provisioned into every step from there.
+	contentDir, cleanupContent, err := util.OpenContent(d.cfg.Storage.SubmissionContent, sub.ID)
+	if err != nil {
+		d.failSubmission(sub, fmt.Sprintf("failed to open submission content: %v", err))
+		return
+	}
+	defer cleanupContent()
+
 	// Create a Docker volume for the submission.
 	submissionVolumeName := sub.ID
 	if err := docker.CreateVolume(submissionVolumeName); err != nil {
 		d.failSubmission(sub, fmt.Sprintf("failed to create docker volume: %v", err))
-		pubsub.GetBroker().CloseTopic(sub.ID)
 		return
 	}
-	zap.S().Infof("created docker volume '%s' for submission %s", submissionVolumeName, sub.ID)
+	log.Infof("created docker volume '%s' for submission %s", submissionVolumeName, sub.ID)
 
 	// Ensure resources are released and the volume is cleaned up.
 	defer func() {
 		// Remove the Docker volume for the submission.
 		if err := docker.RemoveVolume(submissionVolumeName); err != nil {
-			zap.S().Errorf("failed to remove docker volume '%s': %v", submissionVolumeName, err)
+			log.Errorf("failed to remove docker volume '%s': %v", submissionVolumeName, err)
 		} else {
-			zap.S().Infof("removed docker volume '%s' for submission %s", submissionVolumeName, sub.ID)
+			log.Infof("removed docker volume '%s' for submission %s", submissionVolumeName, sub.ID)
 		}
 
-		d.scheduler.ReleaseResources(prob.Cluster, node.Name, allocatedCores, prob.Memory)
-		zap.S().Infof("finished dispatching submission %s", sub.ID)
+		d.scheduler.ReleaseResources(sub.ID)
+		log.Infof("finished dispatching submission %s", sub.ID)
 	}()
 
 	var lastStdout string
@@ -89,78 +116,146 @@ func (d *Dispatcher) Dispatch(sub *models.Submission, prob *Problem, node *NodeS
 	}
 	cpusetCpus := strings.Join(coreStrs, ",")
 
-	for i, flow := range prob.Workflow {
+	// Step timeouts are derived from this context, so whichever limit fires first wins.
+	ctx, cancel := context.WithCancel(context.Background())
+	if prob.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(prob.Timeout)*time.Second)
+	}
+	defer cancel()
+
+	if sub.StartStep < 0 || sub.StartStep >= len(prob.Workflow) {
+		// The workflow changed since the re-run was requested; run it in full.
+		sub.StartStep = 0
+	}
+	if sub.StartStep > 0 {
+		log.Infof("skipping workflow steps before step %d for submission %s", sub.StartStep+1, sub.ID)
+	} else if err := os.RemoveAll(filepath.Join(d.cfg.Storage.ArtifactsPath(), sub.ID)); err != nil {
+		log.Warnf("failed to remove previous artifacts of submission %s: %v", sub.ID, err)
+	}
+	if sub.DryRun {
+		log.Infof("dry run of submission %s, only running dry_run_safe steps", sub.ID)
+	}
+
+	stepStdout := make(map[int]string) // captured stdout of each step run so far, for stdin_from
+	steps := workflowSteps(prob, sub)
+	lastExitCode := 0
+	for _, i := range steps {
+		flow := prob.Workflow[i]
 		sub.CurrentStep = i
 		database.UpdateSubmission(d.db, sub)
+		PublishSubmissionStatus(sub, 0)
 
-		_, stdout, _, err := d.runWorkflowStep(docker, sub, prob, flow, cpusetCpus, i)
+		var stdin []byte
+		if flow.StdinFrom != "" {
+			out, ok := stepStdout[flow.stdinStep]
+			if !ok {
+				d.failSubmission(sub, fmt.Sprintf("workflow step %d reads the output of step %q, which was not run", i+1, flow.StdinFrom))
+				return
+			}
+			stdin = []byte(out)
+		}
+
+		_, stdout, _, err := d.runWorkflowStep(ctx, log, docker, sub, prob, flow, cpusetCpus, contentDir, i, stdin)
+
+		// With the exit_code result format, the final step failing is the verdict, not an error.
+		var exitErr *exitCodeError
+		if err != nil && prob.ResultFormat == ResultFormatExitCode && !sub.DryRun && i == steps[len(steps)-1] &&
+			errors.As(err, &exitErr) && exitErr.err == nil {
+			lastExitCode = exitErr.code
+			err = nil
+		}
 
 		if err != nil {
 			// runWorkflowStep cleans its own container; we just need to fail the submission.
-			d.failSubmission(sub, fmt.Sprintf("workflow step %d failed: %v", i+1, err))
-			pubsub.GetBroker().CloseTopic(sub.ID)
+			if ctx.Err() == context.DeadlineExceeded {
+				d.failSubmission(sub, fmt.Sprintf("submission exceeded the total time limit of %d seconds during workflow step %d", prob.Timeout, i+1))
+			} else {
+				d.failSubmission(sub, fmt.Sprintf("workflow step %d failed: %v", i+1, err))
+			}
 			return // The main defer will handle volume and resource cleanup.
 		}
 
 		lastStdout = stdout
+		stepStdout[i] = stdout
 	}
 
-	var tempResult tempJudgeResult
-	if err := json.Unmarshal([]byte(lastStdout), &tempResult); err != nil {
-		d.failSubmission(sub, fmt.Sprintf("failed to parse judge result: %v. Raw output: %s", err, lastStdout))
-		pubsub.GetBroker().CloseTopic(sub.ID)
+	if sub.DryRun {
+		// Dry runs never produce a score, so the judge output is not parsed.
+		sub.Status = models.StatusSuccess
+		sub.Info = map[string]interface{}{"dry_run": true, "message": "Dry run finished, no score was recorded"}
+		if err := database.UpdateSubmission(d.db, sub); err != nil {
+			log.Errorf("failed to update dry run submission %s: %v", sub.ID, err)
+			return
+		}
+		log.Infof("dry run of submission %s finished successfully", sub.ID)
+		PublishSubmissionStatus(sub, 0)
 		return
 	}
 
-	result := JudgeResult{
-		Score:       int(math.Round((tempResult.Score))),
-		Performance: tempResult.Performance,
-		Info:        tempResult.Info,
+	result, err := parseJudgeResult(prob, lastStdout, lastExitCode)
+	if err != nil {
+		d.failSubmission(sub, fmt.Sprintf("failed to parse judge result: %v. Raw output: %s", err, lastStdout))
+		return
 	}
 
 	contestID := d.findContestIDForProblem(prob.ID)
 	if contestID == "" {
-		zap.S().Warnf("cannot find contest for problem %s, skipping score update", prob.ID)
+		log.Warnf("cannot find contest for problem %s, skipping score update", prob.ID)
+	} else if sub.Practice {
+		log.Infof("submission %s is a practice submission and is not scored", sub.ID)
+		contestID = ""
+		sub.Performance = result.Performance
+	} else if d.scoresLocked(contestID) {
+		log.Infof("contest %s has ended and its scores are locked, submission %s is not scored", contestID, sub.ID)
+		contestID = ""
+		sub.Performance = result.Performance
 	}
 
 	sub.Info = result.Info // common for both modes
+	sub.Subtasks = result.Subtasks
 
 	if prob.Score.Mode == "performance" && contestID != "" {
 		sub.Performance = result.Performance
 		// Score will be calculated by the DB function
-		if err := database.UpdateScoresForPerformanceSubmission(d.db, sub, contestID, prob.Score.MaxPerformanceScore); err != nil {
-			zap.S().Errorf("failed to update performance scores for submission %s: %v", sub.ID, err)
+		if err := database.UpdateScoresForPerformanceSubmission(d.db, sub, contestID, prob.Score.MaxPerformanceScore, prob.Score.HistoryThreshold()); err != nil {
+			log.Errorf("failed to update performance scores for submission %s: %v", sub.ID, err)
 		}
 		// After the transaction, the submission score in the DB is updated. Let's retrieve it to put it in the final object.
 		var updatedSub models.Submission
 		if errDb := d.db.Select("score").Where("id = ?", sub.ID).First(&updatedSub).Error; errDb == nil {
 			sub.Score = updatedSub.Score
 		} else {
-			zap.S().Errorf("failed to retrieve updated score for submission %s: %v", sub.ID, errDb)
+			log.Errorf("failed to retrieve updated score for submission %s: %v", sub.ID, errDb)
 		}
 
+	} else if prob.Score.Mode == "weighted" && contestID != "" {
+		// The submission keeps its raw score, the leaderboard gets the weighted points
+		sub.Score = result.Score
+		if err := database.UpdateScoresForWeightedSubmission(d.db, sub, contestID, prob.Score.Weighted()); err != nil {
+			log.Errorf("failed to update weighted scores for submission %s: %v", sub.ID, err)
+		}
 	} else { // Default score mode or no contest found
 		sub.Score = result.Score
 		if contestID != "" {
 			if err := database.UpdateScoresForNewSubmission(d.db, sub, contestID, sub.Score); err != nil {
-				zap.S().Errorf("failed to update scores for submission %s: %v", sub.ID, err)
+				log.Errorf("failed to update scores for submission %s: %v", sub.ID, err)
 			}
 		}
 	}
 
 	sub.Status = models.StatusSuccess
 	if err := database.UpdateSubmission(d.db, sub); err != nil {
-		zap.S().Errorf("failed to update successful submission %s: %v", sub.ID, err)
+		log.Errorf("failed to update successful submission %s: %v", sub.ID, err)
 		return
 	}
 
-	zap.S().Infof("submission %s finished successfully with score %d", sub.ID, sub.Score)
-	pubsub.GetBroker().CloseTopic(sub.ID)
+	log.Infof("submission %s finished successfully with score %d", sub.ID, sub.Score)
+	PublishSubmissionStatus(sub, 0)
 }
 
-func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submission, prob *Problem, flow WorkflowStep, cpusetCpus string, step int) (containerID, stdout, stderr string, err error) {
-	zap.S().Debugf("Creating timeout context for step. Raw timeout value from config: %d seconds", flow.Timeout)
-	stepCtx, cancel := context.WithTimeout(context.Background(), time.Duration(flow.Timeout)*time.Second)
+func (d *Dispatcher) runWorkflowStep(ctx context.Context, log *zap.SugaredLogger, docker *DockerManager, sub *models.Submission, prob *Problem, flow WorkflowStep, cpusetCpus, contentDir string, step int, stdin []byte) (containerID, stdout, stderr string, err error) {
+	log.Debugf("Creating timeout context for step. Raw timeout value from config: %d seconds", flow.Timeout)
+	stepCtx, cancel := context.WithTimeout(ctx, time.Duration(flow.Timeout)*time.Second)
 	defer cancel()
 
 	if err := os.MkdirAll(d.cfg.Storage.SubmissionLog, 0755); err != nil {
@@ -170,13 +265,14 @@ func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submissi
 	logFilePath := filepath.Join(d.cfg.Storage.SubmissionLog, logFileName)
 
 	cont := &models.Container{
-		ID:           uuid.New().String(),
-		SubmissionID: sub.ID,
-		UserID:       sub.UserID,
-		Image:        flow.Image,
-		Status:       models.StatusRunning,
-		StartedAt:    time.Now(),
-		LogFilePath:  logFilePath,
+		ID:            uuid.New().String(),
+		SubmissionID:  sub.ID,
+		UserID:        sub.UserID,
+		Image:         flow.Image,
+		Status:        models.StatusRunning,
+		TotalCommands: len(flow.Steps),
+		StartedAt:     time.Now(),
+		LogFilePath:   logFilePath,
 	}
 	database.CreateContainer(d.db, cont)
 	defer pubsub.GetBroker().CloseTopic(cont.ID)
@@ -193,7 +289,7 @@ func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submissi
 	user, err := database.GetUserByID(d.db, sub.UserID)
 
 	if err != nil {
-		zap.S().Errorf("failed to get user %s: %v", sub.UserID, err)
+		log.Errorf("failed to get user %s: %v", sub.UserID, err)
 		msg := pubsub.FormatMessage("error", fmt.Sprintf("Failed to fetch user: %v", err))
 		d.failContainer(cont, -1, string(msg))
 		cont.FinishedAt = time.Now()
@@ -201,9 +297,15 @@ func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submissi
 		return "", "", "", fmt.Errorf("failed to get user: %w", err)
 	}
 
-	var containerEnvs = []string{
+	containerEnvs := append(append([]string(nil), flow.Env...),
 		"CSOJ_SUBMIT_DIR=/mnt/work",
-		"CSOJ_USERNAME=" + user.Username,
+		"CSOJ_USERNAME="+user.Username,
+	)
+	if flow.FreshWorkdir {
+		containerEnvs = append(containerEnvs, "CSOJ_SCRATCH_DIR=/mnt/scratch")
+	}
+	if flow.Artifacts {
+		containerEnvs = append(containerEnvs, "CSOJ_OUTPUT_DIR="+ArtifactDir)
 	}
 
 	go func() {
@@ -213,43 +315,149 @@ func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submissi
 
 		defer func() {
 			if r := recover(); r != nil {
-				zap.S().Errorf("Recovered from panic in dispatcher goroutine: %v", r)
+				log.Errorf("Recovered from panic in dispatcher goroutine: %v", r)
 				doneChan <- result{ContainerID: cid, Err: fmt.Errorf("panic recovered: %v", r)}
 			}
 		}()
 
 		var containerName = sub.ID + "-" + strconv.Itoa(step)
 		submissionVolumeName := sub.ID
-		var err error
-		cid, err = docker.CreateContainer(flow.Image, submissionVolumeName, prob.CPU, cpusetCpus, prob.Memory, flow.Root, flow.Mounts, flow.Network, containerName, containerEnvs)
+		if flow.FreshWorkdir {
+			submissionVolumeName = ""
+		}
+		mounts, err := ResolveMounts(prob, flow.Mounts)
 		if err != nil {
-			logMsg := pubsub.FormatMessage("error", fmt.Sprintf("Failed to create container: %v", err))
-			d.failContainer(cont, -1, string(logMsg)) // Set exit code to -1 for system errors
+			logMsg := pubsub.FormatMessage("error", fmt.Sprintf("Failed to resolve mounts: %v", err))
+			d.failContainer(cont, -1, string(logMsg))
+			doneChan <- result{Err: fmt.Errorf("failed to resolve mounts: %w", err)}
+			return
+		}
+		labels := map[string]string{
+			LabelSubmissionID: sub.ID,
+			LabelContainerID:  cont.ID,
+			LabelProblemID:    prob.ID,
+			LabelUserID:       sub.UserID,
+			LabelStep:         strconv.Itoa(step),
+		}
+		retryCfg := d.cfg.DockerRetry
+		backoff := time.Duration(retryCfg.InitialBackoffMS) * time.Millisecond
+		if backoff <= 0 {
+			backoff = 500 * time.Millisecond
+		}
+		for attempt := 0; ; attempt++ {
+			cid, err = d.setupContainer(docker, flow, prob, submissionVolumeName, cpusetCpus, mounts, containerName, containerEnvs, labels)
+			if err == nil {
+				break
+			}
+			if !IsTransientError(err) || attempt >= retryCfg.MaxRetries || stepCtx.Err() != nil {
+				if cid == "" {
+					logMsg := pubsub.FormatMessage("error", fmt.Sprintf("Failed to create container: %v", err))
+					d.failContainer(cont, -1, string(logMsg)) // Set exit code to -1 for system errors
+					doneChan <- result{Err: fmt.Errorf("failed to create container: %w", err)}
+					return
+				}
+				cidChan <- cid
+				doneChan <- result{ContainerID: cid, Err: fmt.Errorf("failed to start container: %w", err)}
+				return
+			}
 
-			doneChan <- result{Err: fmt.Errorf("failed to create container: %w", err)}
+			// Remove whatever the failed attempt left behind before trying again.
+			if cid != "" {
+				docker.CleanupContainer(cid)
+			} else {
+				docker.CleanupContainer(containerName)
+			}
+			retryMsg := pubsub.FormatMessage("info", fmt.Sprintf("\n--- Transient Docker error: %v. Retrying in %s (%d/%d) ---\n", err, backoff, attempt+1, retryCfg.MaxRetries))
+			jsonLogBuffer.Write(retryMsg)
+			jsonLogBuffer.WriteString("\n")
+			pubsub.GetBroker().Publish(cont.ID, retryMsg)
+			pubsub.GetBroker().Publish(sub.ID, retryMsg)
+			log.Warnf("transient docker error for submission %s step %d, retrying in %s (%d/%d): %v", sub.ID, step, backoff, attempt+1, retryCfg.MaxRetries, err)
+
+			select {
+			case <-time.After(backoff):
+			case <-stepCtx.Done():
+			}
+			backoff *= 2
+		}
+		if stepCtx.Err() != nil {
+			// The step timed out while we were retrying; nobody will clean this container up.
+			docker.CleanupContainer(cid)
+			doneChan <- result{Err: stepCtx.Err()}
 			return
 		}
-		zap.S().Infof("created container %s for submission %s step %d", cid, sub.ID, step)
+		log.Infof("created container %s for submission %s step %d", cid, sub.ID, step)
 
 		cidChan <- cid
 		cont.DockerID = cid
 		database.UpdateContainer(d.db, cont)
 
-		if err := docker.StartContainer(cid); err != nil {
-			doneChan <- result{ContainerID: cid, Err: fmt.Errorf("failed to start container: %w", err)}
-			return
-		}
-
-		if step == 0 {
-			localWorkDir := filepath.Join(d.cfg.Storage.SubmissionContent, sub.ID)
-			zap.S().Infof("copying files from %s to container %s:/mnt/work/", localWorkDir, cid)
-			if err := docker.CopyToContainer(cid, localWorkDir, "/mnt/work/"); err != nil {
+		provision := flow.FreshWorkdir || step == firstSharedStep(prob.Workflow, workflowSteps(prob, sub))
+		if flow.FreshWorkdir {
+			log.Infof("provisioning fresh workdir from %s in container %s:/mnt/work/", contentDir, cid)
+			if err := docker.ProvisionWorkdir(cid, contentDir, "/mnt/work"); err != nil {
+				doneChan <- result{ContainerID: cid, Err: fmt.Errorf("failed to copy files to container: %w", err)}
+				return
+			}
+		} else if provision {
+			log.Infof("copying files from %s to container %s:/mnt/work/", contentDir, cid)
+			if err := docker.CopyToContainer(cid, contentDir, "/mnt/work/"); err != nil {
 				doneChan <- result{ContainerID: cid, Err: fmt.Errorf("failed to copy files to container: %w", err)}
 				return
 			}
 		}
+		if provision {
+			if err := docker.CopyFixtures(cid, prob.Fixtures, contentDir, "/mnt/work/"); err != nil {
+				doneChan <- result{ContainerID: cid, Err: fmt.Errorf("failed to copy fixtures to container: %w", err)}
+				return
+			}
+		}
+		if flow.Artifacts {
+			if err := docker.CreateArtifactDir(cid); err != nil {
+				doneChan <- result{ContainerID: cid, Err: fmt.Errorf("failed to create artifact directory: %w", err)}
+				return
+			}
+		}
+		// collectArtifacts copies what the step left in ArtifactDir, also after a failing command
+		// since a diff or report is most useful then. Problems are noted in the log, not fatal.
+		collectArtifacts := func() {
+			if !flow.Artifacts {
+				return
+			}
+			dstDir := filepath.Join(d.cfg.Storage.ArtifactsPath(), sub.ID)
+			limit := d.cfg.OutputLimits.MaxArtifactBytes()
+			if limit > 0 {
+				limit = max(limit-artifactsSize(dstDir), 1)
+			}
+			n, err := docker.CopyArtifacts(cid, dstDir, limit)
+			var msg []byte
+			switch {
+			case errors.Is(err, errArtifactsTooLarge):
+				msg = pubsub.FormatMessage("info", fmt.Sprintf("\n--- Artifacts exceed %d MB, only %d bytes were kept ---\n", d.cfg.OutputLimits.MaxArtifactBytes()/(1024*1024), n))
+			case err != nil:
+				log.Warnf("failed to copy artifacts of submission %s step %d: %v", sub.ID, step, err)
+				msg = pubsub.FormatMessage("error", "\n--- Failed to collect artifacts ---\n")
+			case n > 0:
+				log.Infof("copied %d bytes of artifacts of submission %s step %d", n, sub.ID, step)
+			}
+			if msg != nil {
+				jsonLogBuffer.Write(msg)
+				jsonLogBuffer.WriteString("\n")
+				pubsub.GetBroker().Publish(cont.ID, msg)
+			}
+		}
 
+		// Noisy output is throttled on the broker and capped in the stored log.
+		limiter := newOutputLimiter(d.cfg.OutputLimits.StreamLimits())
+		maxLogBytes := d.cfg.OutputLimits.MaxLogBytes()
+		logTruncated := false
 		for j, stepCmd := range flow.Steps {
+			cont.CurrentCommand = j
+			if err := database.UpdateContainerCommand(d.db, cont.ID, j); err != nil {
+				log.Warnf("failed to record command %d of container %s: %v", j+1, cont.ID, err)
+			}
+			PublishCommandProgress(sub, cont)
+
 			startMsg := pubsub.FormatMessage("info", fmt.Sprintf("\n--- Executing Command %d ---\n", j+1))
 			jsonLogBuffer.Write(startMsg)
 			jsonLogBuffer.WriteString("\n")
@@ -257,12 +465,28 @@ func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submissi
 
 			outputCallback := func(streamType string, data []byte) {
 				msg := pubsub.FormatMessage(streamType, string(data))
-				pubsub.GetBroker().Publish(cont.ID, msg)
+				ok, notice := limiter.allow(data)
+				if notice != "" {
+					pubsub.GetBroker().Publish(cont.ID, pubsub.FormatMessage("info", notice))
+				}
+				if ok {
+					pubsub.GetBroker().Publish(cont.ID, msg)
+				}
+				if logTruncated {
+					return
+				}
+				if maxLogBytes > 0 && jsonLogBuffer.Len()+len(msg) > maxLogBytes {
+					logTruncated = true
+					msg = pubsub.FormatMessage("info", fmt.Sprintf("\n[log truncated: output exceeded %d MB]\n", maxLogBytes/(1024*1024)))
+				}
 				jsonLogBuffer.Write(msg)
 				jsonLogBuffer.WriteString("\n")
 			}
 
-			execResult, err := docker.ExecInContainer(stepCtx, cid, stepCmd, outputCallback)
+			execResult, err := docker.ExecInContainer(stepCtx, cid, stepCmd, stdin, outputCallback)
+			if notice := limiter.flush(); notice != "" {
+				pubsub.GetBroker().Publish(cont.ID, pubsub.FormatMessage("info", notice))
+			}
 
 			exitMsg := pubsub.FormatMessage("info", fmt.Sprintf("\n--- Exit Code: %d ---\n", execResult.ExitCode))
 			jsonLogBuffer.Write(exitMsg)
@@ -270,14 +494,30 @@ func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submissi
 			pubsub.GetBroker().Publish(cont.ID, exitMsg)
 
 			if err != nil || execResult.ExitCode != 0 {
+				var errMsg error = &exitCodeError{code: execResult.ExitCode, err: err}
+				// Exit code 137 alone does not tell students why the step died; check the OOM flag
+				// before the container is cleaned up.
+				if oom, inspectErr := docker.IsOOMKilled(cid); inspectErr != nil {
+					log.Warnf("failed to inspect container %s for OOM kill: %v", cid, inspectErr)
+				} else if oom {
+					errMsg = fmt.Errorf("step exceeded its memory limit of %d MB", prob.Memory)
+					if prob.Memory <= 0 {
+						errMsg = fmt.Errorf("step was killed after running out of memory")
+					}
+					oomMsg := pubsub.FormatMessage("error", fmt.Sprintf("\n--- %s ---\n", errMsg))
+					jsonLogBuffer.Write(oomMsg)
+					jsonLogBuffer.WriteString("\n")
+					pubsub.GetBroker().Publish(cont.ID, oomMsg)
+				}
+				collectArtifacts()
 				d.failContainer(cont, execResult.ExitCode, jsonLogBuffer.String())
-				errMsg := fmt.Errorf("exec failed with exit code %d: %w", execResult.ExitCode, err)
 				doneChan <- result{ContainerID: cid, Stdout: execResult.Stdout, Stderr: execResult.Stderr, Err: errMsg}
 				return
 			}
 			execStdout = execResult.Stdout
 			execStderr = execResult.Stderr
 		}
+		collectArtifacts()
 		os.WriteFile(logFilePath, jsonLogBuffer.Bytes(), 0644)
 		doneChan <- result{ContainerID: cid, Stdout: execStdout, Stderr: execStderr, Err: nil}
 	}()
@@ -285,26 +525,30 @@ func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submissi
 	var finalRes result
 	var cidForCleanup string
 
-	zap.S().Debugf("Entering select block for submission %s, waiting for completion or timeout...", sub.ID)
+	log.Debugf("Entering select block for submission %s, waiting for completion or timeout...", sub.ID)
 	select {
 	case cidForCleanup = <-cidChan:
 		select {
 		case <-stepCtx.Done():
-			zap.S().Warnf("TIMEOUT branch selected for submission %s. Cleaning up container %s.", sub.ID, cidForCleanup)
+			log.Warnf("TIMEOUT branch selected for submission %s. Cleaning up container %s.", sub.ID, cidForCleanup)
 			docker.CleanupContainer(cidForCleanup)
-			d.failContainer(cont, -1, string(pubsub.FormatMessage("error", "Timeout exceeded")))
+			reason := "Timeout exceeded"
+			if ctx.Err() != nil {
+				reason = "Submission time limit exceeded"
+			}
+			d.failContainer(cont, -1, string(pubsub.FormatMessage("error", reason)))
 			return cidForCleanup, "", "Timeout exceeded", stepCtx.Err()
 
 		case finalRes = <-doneChan:
-			zap.S().Debugf("DONE_CHAN branch selected for submission %s. Error from goroutine: %v", sub.ID, finalRes.Err)
+			log.Debugf("DONE_CHAN branch selected for submission %s. Error from goroutine: %v", sub.ID, finalRes.Err)
 		}
 	case <-stepCtx.Done():
-		zap.S().Warnf("TIMEOUT branch selected for submission %s. Container was not even created.", sub.ID)
+		log.Warnf("TIMEOUT branch selected for submission %s. Container was not even created.", sub.ID)
 		d.failContainer(cont, -1, string(pubsub.FormatMessage("error", "Timeout exceeded before container creation")))
 		return "", "", "Timeout exceeded", stepCtx.Err()
 
 	case finalRes = <-doneChan:
-		zap.S().Debugf("DONE_CHAN (early) branch selected for submission %s. Error from goroutine: %v", sub.ID, finalRes.Err)
+		log.Debugf("DONE_CHAN (early) branch selected for submission %s. Error from goroutine: %v", sub.ID, finalRes.Err)
 	}
 
 	// Always clean up the container if it was created, regardless of the outcome.
@@ -320,6 +564,45 @@ func (d *Dispatcher) runWorkflowStep(docker *DockerManager, sub *models.Submissi
 	return finalRes.ContainerID, finalRes.Stdout, finalRes.Stderr, finalRes.Err
 }
 
+// setupContainer creates and starts a step's container. It returns the container ID even when
+// starting fails, so the caller can clean it up.
+func (d *Dispatcher) setupContainer(docker *DockerManager, flow WorkflowStep, prob *Problem, volumeName, cpusetCpus string, mounts []Mount, name string, envs []string, labels map[string]string) (string, error) {
+	if err := docker.EnsureImage(flow.Image); err != nil {
+		return "", err
+	}
+	cid, err := docker.CreateContainer(flow.Image, volumeName, prob.CPU, cpusetCpus, prob.Memory, flow.Root, mounts, flow.Network, flow.NetworkName, name, envs, flow.containerSecurity(), labels)
+	if err != nil {
+		return "", err
+	}
+	if err := docker.StartContainer(cid); err != nil {
+		return cid, err
+	}
+	return cid, nil
+}
+
+// workflowSteps returns the indices of the workflow steps to run for a submission: the steps
+// from StartStep on, or only the dry_run_safe steps for a dry run.
+func workflowSteps(prob *Problem, sub *models.Submission) []int {
+	var steps []int
+	for i := sub.StartStep; i < len(prob.Workflow); i++ {
+		if i >= 0 && (!sub.DryRun || prob.Workflow[i].DryRunSafe) {
+			steps = append(steps, i)
+		}
+	}
+	return steps
+}
+
+// firstSharedStep returns the index of the first of the given steps that uses the shared
+// submission volume, which is where the submission content gets copied in.
+func firstSharedStep(workflow []WorkflowStep, steps []int) int {
+	for _, i := range steps {
+		if !workflow[i].FreshWorkdir {
+			return i
+		}
+	}
+	return -1
+}
+
 func (d *Dispatcher) findContestIDForProblem(problemID string) string {
 	d.appState.RLock()
 	defer d.appState.RUnlock()
@@ -330,24 +613,56 @@ func (d *Dispatcher) findContestIDForProblem(problemID string) string {
 	return ""
 }
 
+// scoresLocked reports whether the contest refuses scoring writes because it has ended.
+func (d *Dispatcher) scoresLocked(contestID string) bool {
+	d.appState.RLock()
+	defer d.appState.RUnlock()
+	contest, ok := d.appState.Contests[contestID]
+	return ok && contest.ScoresLocked(time.Now())
+}
+
+// runHook starts the post-submission hook if the submission reached a final status. The status is
+// read back from the database, since the submission may have been interrupted while it ran.
+func (d *Dispatcher) runHook(sub *models.Submission, contestID string) {
+	if d.hook == nil {
+		return
+	}
+	var current models.Submission
+	if err := d.db.First(&current, "id = ?", sub.ID).Error; err != nil {
+		submissionLogger(sub).Errorf("post-submission hook not run, failed to read back submission %s: %v", sub.ID, err)
+		return
+	}
+	if current.Status == models.StatusSuccess || current.Status == models.StatusFailed {
+		d.hook.run(&current, contestID)
+	}
+}
+
 func (d *Dispatcher) failSubmission(sub *models.Submission, reason string) {
-	zap.S().Errorf("submission %s failed: %s", sub.ID, reason)
+	log := submissionLogger(sub)
+	log.Errorf("submission %s failed: %s", sub.ID, reason)
 	msg := pubsub.FormatMessage("error", reason)
 	pubsub.GetBroker().Publish(sub.ID, msg)
 	sub.Status = models.StatusFailed
 	sub.Info = map[string]interface{}{"error": reason}
 	if err := database.UpdateSubmission(d.db, sub); err != nil {
-		zap.S().Errorf("failed to update failed submission status for %s: %v", sub.ID, err)
+		log.Errorf("failed to update failed submission status for %s: %v", sub.ID, err)
 	}
+	PublishSubmissionStatus(sub, 0)
 }
 
 func (d *Dispatcher) failContainer(cont *models.Container, exitCode int, logContent string) {
+	log := zap.S().With("submission_id", cont.SubmissionID, "container_id", cont.ID)
 	cont.Status = models.StatusFailed
 	cont.ExitCode = exitCode
 	cont.FinishedAt = time.Now()
 	// On failure, write the log content to the file
 	if err := os.WriteFile(cont.LogFilePath, []byte(logContent), 0644); err != nil {
-		zap.S().Errorf("failed to write error log for container %s: %v", cont.ID, err)
+		log.Errorf("failed to write error log for container %s: %v", cont.ID, err)
 	}
 	database.UpdateContainer(d.db, cont)
 }
+
+// submissionLogger returns a logger that tags every line with the submission's correlation IDs.
+func submissionLogger(sub *models.Submission) *zap.SugaredLogger {
+	return zap.S().With("submission_id", sub.ID, "user_id", sub.UserID, "problem_id", sub.ProblemID)
+}
@@ -4,23 +4,41 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/ZJUSCT/CSOJ/internal/config"
+	cerrdefs "github.com/containerd/errdefs"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"go.uber.org/zap"
 )
 
+// Labels set on every judge container, so containers on a node can be traced back to their
+// submission without the database.
+const (
+	LabelSubmissionID = "csoj.submission_id"
+	LabelContainerID  = "csoj.container_id"
+	LabelProblemID    = "csoj.problem_id"
+	LabelUserID       = "csoj.user_id"
+	LabelStep         = "csoj.step"
+)
+
 type DockerManager struct {
-	cli *client.Client
+	cli    *client.Client
+	broken atomic.Bool // the connection failed; GetDockerManager creates a new client
 }
 
 type ExecResult struct {
@@ -29,6 +47,8 @@ type ExecResult struct {
 	ExitCode int
 }
 
+// NewDockerManager connects to a Docker daemon. Most callers should use GetDockerManager, which
+// reuses the connection to a node.
 func NewDockerManager(cfg config.DockerConfig) (*DockerManager, error) {
 	opts := []client.Opt{
 		client.WithHost(cfg.Host),
@@ -53,7 +73,7 @@ func (m *DockerManager) CreateVolume(name string) error {
 	_, err := m.cli.VolumeCreate(context.Background(), volume.CreateOptions{
 		Name: name,
 	})
-	return err
+	return m.observe(err)
 }
 
 func (m *DockerManager) RemoveVolume(name string) error {
@@ -61,7 +81,7 @@ func (m *DockerManager) RemoveVolume(name string) error {
 	return m.cli.VolumeRemove(context.Background(), name, true)
 }
 
-func (m *DockerManager) CreateContainer(image, volumeName string, cpu int, cpusetCpus string, memory int64, asRoot bool, customMounts []Mount, networkEnabled bool, name string, envs []string) (string, error) {
+func (m *DockerManager) CreateContainer(image, volumeName string, cpu float64, cpusetCpus string, memory int64, asRoot bool, customMounts []Mount, networkEnabled bool, networkName string, name string, envs []string, security ContainerSecurity, labels map[string]string) (string, error) {
 	ctx := context.Background()
 
 	config := &container.Config{
@@ -71,29 +91,56 @@ func (m *DockerManager) CreateContainer(image, volumeName string, cpu int, cpuse
 		AttachStdin:     true,
 		AttachStdout:    true,
 		AttachStderr:    true,
-		NetworkDisabled: !networkEnabled,
+		NetworkDisabled: !networkEnabled && networkName == "",
 		Env:             envs,
+		Labels:          labels,
 	}
 
 	if !asRoot {
 		config.User = "1000:1000"
 	}
 
-	// Initialize dockerMounts with the main submission volume
-	dockerMounts := []mount.Mount{
-		{
+	var dockerMounts []mount.Mount
+	if volumeName != "" {
+		// Mount the shared submission volume
+		dockerMounts = append(dockerMounts, mount.Mount{
 			Type:   mount.TypeVolume,
 			Source: volumeName,
 			Target: "/mnt/work",
-		},
+		})
+	} else {
+		// Fresh workdir: /mnt/work is provisioned inside the container itself,
+		// so only a writable scratch space is mounted.
+		dockerMounts = append(dockerMounts, mount.Mount{
+			Type:         mount.TypeTmpfs,
+			Target:       "/mnt/scratch",
+			TmpfsOptions: &mount.TmpfsOptions{Mode: 01777},
+		})
 	}
 
 	hostConfig := &container.HostConfig{
 		Resources: container.Resources{
-			NanoCPUs:   int64(cpu) * 1e9,
+			NanoCPUs:   int64(cpu * 1e9),
 			Memory:     memory * 1024 * 1024,
 			CpusetCpus: cpusetCpus,
 		},
+		SecurityOpt: security.SecurityOpt,
+	}
+	if networkName != "" {
+		if _, err := m.cli.NetworkInspect(ctx, networkName, network.InspectOptions{}); err != nil {
+			if cerrdefs.IsNotFound(err) {
+				return "", fmt.Errorf("docker network %q does not exist on this node: %w", networkName, err)
+			}
+			return "", err
+		}
+		hostConfig.NetworkMode = container.NetworkMode(networkName)
+	}
+	if security.PidsLimit != 0 {
+		pidsLimit := security.PidsLimit
+		hostConfig.PidsLimit = &pidsLimit
+	}
+	for _, u := range security.Ulimits {
+		hostConfig.Ulimits = append(hostConfig.Ulimits, &container.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
 	}
 
 	// Append custom mounts from problem.yaml
@@ -130,26 +177,68 @@ func (m *DockerManager) CreateContainer(image, volumeName string, cpu int, cpuse
 
 	resp, err := m.cli.ContainerCreate(ctx, config, hostConfig, nil, nil, name)
 	if err != nil {
-		return "", err
+		return "", m.observe(err)
 	}
 
 	return resp.ID, nil
 }
 
+// IsTransientError reports whether a Docker API error is likely a temporary daemon or connection
+// problem worth retrying, as opposed to a real failure such as a missing image.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if cerrdefs.IsNotFound(err) || cerrdefs.IsInvalidArgument(err) || cerrdefs.IsPermissionDenied(err) ||
+		cerrdefs.IsUnauthorized(err) || cerrdefs.IsNotImplemented(err) {
+		return false
+	}
+	if cerrdefs.IsUnavailable(err) || cerrdefs.IsResourceExhausted(err) || cerrdefs.IsAborted(err) ||
+		cerrdefs.IsInternal(err) || cerrdefs.IsConflict(err) || client.IsErrConnectionFailed(err) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "connection reset") || strings.Contains(msg, "daemon is busy") ||
+		strings.Contains(msg, "server is busy")
+}
+
 func (m *DockerManager) StartContainer(containerID string) error {
-	return m.cli.ContainerStart(context.Background(), containerID, container.StartOptions{})
+	return m.observe(m.cli.ContainerStart(context.Background(), containerID, container.StartOptions{}))
 }
 
-func (m *DockerManager) ExecInContainer(ctx context.Context, containerID string, cmd []string, outputCallback func(streamType string, data []byte)) (ExecResult, error) {
+// IsOOMKilled reports whether the kernel OOM killer terminated a process in the container.
+func (m *DockerManager) IsOOMKilled(containerID string) (bool, error) {
+	info, err := m.cli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return false, err
+	}
+	return info.State != nil && info.State.OOMKilled, nil
+}
+
+// ExecInContainer runs a command in the container and streams its output to outputCallback.
+// A non-nil stdin is written to the command's standard input, which is then closed.
+func (m *DockerManager) ExecInContainer(ctx context.Context, containerID string, cmd []string, stdin []byte, outputCallback func(streamType string, data []byte)) (ExecResult, error) {
 	execConfig := container.ExecOptions{
 		Cmd:          cmd,
+		AttachStdin:  stdin != nil,
 		AttachStdout: true,
 		AttachStderr: true,
 	}
 
 	execCreateResp, err := m.cli.ContainerExecCreate(ctx, containerID, execConfig)
 	if err != nil {
-		return ExecResult{}, err
+		return ExecResult{}, m.observe(err)
 	}
 	execID := execCreateResp.ID
 
@@ -159,6 +248,17 @@ func (m *DockerManager) ExecInContainer(ctx context.Context, containerID string,
 	}
 	defer resp.Close()
 
+	if stdin != nil {
+		// Written concurrently with reading the output, so a command that produces output
+		// before consuming all of its input cannot deadlock.
+		go func() {
+			if _, err := resp.Conn.Write(stdin); err != nil {
+				zap.S().Warnf("error writing stdin to container exec: %v", err)
+			}
+			resp.CloseWrite()
+		}()
+	}
+
 	var stdoutBuf, stderrBuf bytes.Buffer
 	stdoutWriter := newCallbackWriter("stdout", &stdoutBuf, outputCallback)
 	stderrWriter := newCallbackWriter("stderr", &stderrBuf, outputCallback)
@@ -237,21 +337,67 @@ func (m *DockerManager) CleanupContainer(containerID string) {
 }
 
 func (m *DockerManager) CopyToContainer(containerID string, srcDir string, dstDir string) error {
+	buf, err := tarDirectory(srcDir, "", 0644, nil)
+	if err != nil {
+		return err
+	}
+	return m.cli.CopyToContainer(context.Background(), containerID, dstDir, bytes.NewReader(buf.Bytes()), container.CopyToContainerOptions{})
+}
+
+// ProvisionWorkdir copies srcDir into dstDir inside the container, creating dstDir if needed.
+// The files are owned by root, so the directory is read-only for unprivileged steps.
+func (m *DockerManager) ProvisionWorkdir(containerID string, srcDir string, dstDir string) error {
+	prefix := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(dstDir)), "/")
+	buf, err := tarDirectory(srcDir, prefix, 0644, nil)
+	if err != nil {
+		return err
+	}
+	return m.cli.CopyToContainer(context.Background(), containerID, "/", bytes.NewReader(buf.Bytes()), container.CopyToContainerOptions{})
+}
+
+// tarDirectory packs the regular files under srcDir into a tar archive with the given file mode,
+// leaving out those for which skip, if set, returns true. If prefix is set, entries are placed
+// under it and the directories along the way are included.
+func tarDirectory(srcDir string, prefix string, mode int64, skip func(relPath string) bool) (*bytes.Buffer, error) {
 	var buf bytes.Buffer
 	tw := tar.NewWriter(&buf)
 
+	if prefix != "" {
+		parts := strings.Split(prefix, "/")
+		for i := range parts {
+			hdr := &tar.Header{
+				Name:     strings.Join(parts[:i+1], "/") + "/",
+				Mode:     0755,
+				Typeflag: tar.TypeDir,
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return nil, fmt.Errorf("failed to write tar header: %w", err)
+			}
+		}
+	}
+
 	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.Mode().IsRegular() {
-			return nil
-		}
-
 		relPath, err := filepath.Rel(srcDir, path)
 		if err != nil {
 			return err
 		}
+		name := filepath.ToSlash(relPath)
+		if prefix != "" {
+			name = prefix + "/" + name
+		}
+
+		if info.IsDir() {
+			if prefix == "" || relPath == "." {
+				return nil
+			}
+			return tw.WriteHeader(&tar.Header{Name: name + "/", Mode: 0755, Typeflag: tar.TypeDir})
+		}
+		if !info.Mode().IsRegular() || (skip != nil && skip(relPath)) {
+			return nil
+		}
 
 		fr, err := os.Open(path)
 		if err != nil {
@@ -260,8 +406,8 @@ func (m *DockerManager) CopyToContainer(containerID string, srcDir string, dstDi
 		defer fr.Close()
 
 		hdr := &tar.Header{
-			Name: relPath,
-			Mode: 0644,
+			Name: name,
+			Mode: mode,
 			Size: info.Size(),
 		}
 		if err := tw.WriteHeader(hdr); err != nil {
@@ -274,13 +420,11 @@ func (m *DockerManager) CopyToContainer(containerID string, srcDir string, dstDi
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to walk source directory: %w", err)
+		return nil, fmt.Errorf("failed to walk source directory: %w", err)
 	}
 
 	if err := tw.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
+		return nil, fmt.Errorf("failed to close tar writer: %w", err)
 	}
-
-	tarReader := bytes.NewReader(buf.Bytes())
-	return m.cli.CopyToContainer(context.Background(), containerID, dstDir, tarReader, container.CopyToContainerOptions{})
+	return &buf, nil
 }
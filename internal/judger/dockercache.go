@@ -0,0 +1,59 @@
+package judger
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+// dockerManagers caches one DockerManager per Docker host configuration, so API version
+// negotiation and TLS setup happen once per node instead of on every dispatch. Docker clients are
+// safe for concurrent use and are shared by all goroutines talking to the node.
+var dockerManagers = struct {
+	sync.Mutex
+	byConfig map[config.DockerConfig]*DockerManager
+}{byConfig: make(map[config.DockerConfig]*DockerManager)}
+
+// GetDockerManager returns the cached manager for a node's Docker configuration, creating it on
+// first use. A manager whose connection failed is replaced by a fresh one.
+func GetDockerManager(cfg config.DockerConfig) (*DockerManager, error) {
+	dockerManagers.Lock()
+	defer dockerManagers.Unlock()
+
+	if m, ok := dockerManagers.byConfig[cfg]; ok {
+		if !m.broken.Load() {
+			return m, nil
+		}
+		zap.S().Infof("reconnecting to Docker host %s after a connection failure", cfg.Host)
+		delete(dockerManagers.byConfig, cfg)
+		// Only idle connections are closed; requests still in flight on the old client finish.
+		m.cli.Close()
+	}
+
+	m, err := NewDockerManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dockerManagers.byConfig[cfg] = m
+	return m, nil
+}
+
+// observe marks the manager for recreation if err shows that the connection to the daemon
+// failed, and returns err unchanged.
+func (m *DockerManager) observe(err error) error {
+	if err != nil && isConnectionFailure(err) {
+		m.broken.Store(true)
+	}
+	return err
+}
+
+// isConnectionFailure reports whether err means the daemon could not be reached, as opposed to
+// the daemon rejecting a request.
+func isConnectionFailure(err error) bool {
+	return client.IsErrConnectionFailed(err) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,123 @@
+package judger
+
+import (
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+
+	"go.uber.org/zap"
+)
+
+// defaultFallbackAfter is how long a job waits on a cluster that cannot host it before it is
+// moved to a fallback cluster, unless the problem sets fallback_after_seconds.
+const defaultFallbackAfter = 5 * time.Minute
+
+// FallbackAfter returns how long a queued submission waits for its cluster to have a node able
+// to run it before it is moved to one of the fallback clusters.
+func (p *Problem) FallbackAfter() time.Duration {
+	if p.FallbackAfterSeconds > 0 {
+		return time.Duration(p.FallbackAfterSeconds) * time.Second
+	}
+	return defaultFallbackAfter
+}
+
+// routeCluster returns the queue a submission belongs to: the fallback cluster it was already
+// moved to, or the problem's own cluster.
+func routeCluster(submission *models.Submission, problem *Problem) string {
+	for _, name := range problem.FallbackClusters {
+		if submission.Cluster == name {
+			return name
+		}
+	}
+	return problem.Cluster
+}
+
+// canHost reports whether the cluster has an active node large enough for the problem, however
+// busy it is right now.
+func (s *Scheduler) canHost(clusterName string, problem *Problem) bool {
+	cluster, ok := s.clusters[clusterName]
+	if !ok {
+		return false
+	}
+	cluster.Lock()
+	defer cluster.Unlock()
+	for _, node := range cluster.Nodes {
+		node.Lock()
+		ok := !node.IsPaused && problem.PinnedCores() <= len(node.UsedCores) &&
+			problem.MilliCPU() <= node.capacityMilliCPU() && problem.Memory <= node.Memory
+		node.Unlock()
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rerouteStalled moves jobs that have had no node able to run them on the cluster for longer
+// than their problem's fallback_after_seconds to the first fallback cluster that can host them.
+func (s *Scheduler) rerouteStalled(clusterName string, queue *clusterQueue) {
+	now := time.Now()
+	hostable := make(map[string]bool) // problem ID -> whether this cluster can run it
+	moved := false
+	for _, job := range queue.snapshot() {
+		problem := job.Problem
+		if len(problem.FallbackClusters) == 0 || clusterName != problem.Cluster {
+			// Jobs already on a fallback cluster stay there.
+			continue
+		}
+		ok, seen := hostable[problem.ID]
+		if !seen {
+			ok = s.canHost(clusterName, problem)
+			hostable[problem.ID] = ok
+		}
+		since := queue.markStalled(job.Submission.ID, !ok, now)
+		if ok || now.Sub(since) < problem.FallbackAfter() {
+			continue
+		}
+
+		target := ""
+		for _, name := range problem.FallbackClusters {
+			if _, exists := s.queues[name]; exists && s.canHost(name, problem) {
+				target = name
+				break
+			}
+		}
+		if target == "" {
+			continue
+		}
+		if s.moveJob(clusterName, queue, job, target) {
+			moved = true
+		}
+	}
+	if moved {
+		s.publishQueuePositions(clusterName)
+	}
+}
+
+// moveJob transfers a queued job to another cluster's queue and records the new cluster on the
+// submission. It reports whether the job was moved.
+func (s *Scheduler) moveJob(clusterName string, queue *clusterQueue, job QueuedSubmission, target string) bool {
+	if !queue.claim(job.Submission.ID) {
+		return false
+	}
+	defer queue.unclaim(job.Submission.ID)
+
+	result := s.db.Model(&models.Submission{}).
+		Where("id = ? AND status = ?", job.Submission.ID, models.StatusQueued).
+		Update("cluster", target)
+	if result.Error != nil {
+		zap.S().Errorf("failed to move submission %s to cluster '%s': %v", job.Submission.ID, target, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 || !queue.remove(job.Submission.ID) {
+		// Started, interrupted or withdrawn in the meantime.
+		return false
+	}
+
+	moved := *job.Submission
+	moved.Cluster = target
+	position := s.queues[target].push(QueuedSubmission{Submission: &moved, Problem: job.Problem})
+	PublishSubmissionStatus(&moved, int64(position))
+	zap.S().Warnf("submission %s moved from cluster '%s' to fallback cluster '%s' as no node there can run it", moved.ID, clusterName, target)
+	return true
+}
@@ -0,0 +1,69 @@
+package judger
+
+import (
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// finalizerPollInterval bounds how long the finalizer sleeps, so contests added or changed by a
+// reload are picked up.
+const finalizerPollInterval = time.Minute
+
+// StartFinalizer saves the final standing of every contest with lock_scores_at_end once it ends.
+// Contests that ended while the server was down are finalized at startup.
+func StartFinalizer(db *gorm.DB, appState *AppState) {
+	finalized := make(map[string]bool)
+	for {
+		next := finalizeEndedContests(db, appState, finalized, time.Now())
+		wait := finalizerPollInterval
+		if !next.IsZero() {
+			wait = min(wait, time.Until(next))
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+}
+
+// finalizeEndedContests saves the final standing of ended locked contests that do not have one
+// yet, and returns the earliest end time still to come (zero if none).
+func finalizeEndedContests(db *gorm.DB, appState *AppState, finalized map[string]bool, now time.Time) time.Time {
+	ended := make(map[string]*database.Scoring)
+	var next time.Time
+	appState.RLock()
+	for id, contest := range appState.Contests {
+		if !contest.LockScoresAtEnd || finalized[id] {
+			continue
+		}
+		if contest.ScoresLocked(now) {
+			ended[id] = contest.LeaderboardScoring()
+		} else if next.IsZero() || contest.EndTime.Before(next) {
+			next = contest.EndTime
+		}
+	}
+	appState.RUnlock()
+
+	for id, scoring := range ended {
+		exists, err := database.HasFinalStanding(db, id)
+		if err != nil {
+			zap.S().Errorf("failed to check final standing of contest %s: %v", id, err)
+			continue
+		}
+		if !exists {
+			if err := database.SaveFinalStanding(db, id, scoring); err != nil {
+				zap.S().Errorf("failed to save final standing of contest %s: %v", id, err)
+				continue
+			}
+			zap.S().Infof("contest %s ended, final standing saved", id)
+		}
+		finalized[id] = true
+	}
+	// Wake up just after the next end time, since a contest is locked only once now is after it.
+	if !next.IsZero() {
+		next = next.Add(time.Second)
+	}
+	return next
+}
@@ -0,0 +1,105 @@
+package judger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// fixturesDirName is the problem subdirectory whose files are placed in /mnt/work next to the
+// submission.
+const fixturesDirName = "fixtures"
+
+// Fixture orders: with FixturesBefore the fixtures are laid down first and the submission's files
+// replace them, with FixturesAfter the fixtures replace the submission's files.
+const (
+	FixturesBefore = "before"
+	FixturesAfter  = "after"
+)
+
+// Fixtures configures how the files of a problem's fixtures/ directory are provided.
+type Fixtures struct {
+	Order string `yaml:"order" json:"order"`
+	// ReadOnly fixtures always replace the submission's files and cannot be modified by
+	// unprivileged steps.
+	ReadOnly bool `yaml:"read_only" json:"read_only"`
+
+	dir string // absolute path of the fixtures directory, empty if the problem has none
+}
+
+// resolveFixtures validates the fixture settings and finds the problem's fixtures directory.
+func resolveFixtures(p *Problem) error {
+	f := &p.Fixtures
+	switch f.Order {
+	case "":
+		f.Order = FixturesBefore
+		if f.ReadOnly {
+			f.Order = FixturesAfter
+		}
+	case FixturesBefore:
+		if f.ReadOnly {
+			return fmt.Errorf("read_only fixtures must be copied after the submission")
+		}
+	case FixturesAfter:
+	default:
+		return fmt.Errorf("unknown fixtures order %q, expected %q or %q", f.Order, FixturesBefore, FixturesAfter)
+	}
+
+	dir, err := filepath.Abs(filepath.Join(p.BasePath, fixturesDirName))
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read fixtures: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", fixturesDirName)
+	}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return fmt.Errorf("fixture %s is not a regular file", path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid fixtures: %w", err)
+	}
+	f.dir = dir
+	return nil
+}
+
+// CopyFixtures copies the problem's fixtures into dstDir inside the container, after the
+// submission in submissionDir has been copied there. Fixtures that the submission already
+// provides are left out unless they are copied after it.
+func (m *DockerManager) CopyFixtures(containerID string, f Fixtures, submissionDir, dstDir string) error {
+	if f.dir == "" {
+		return nil
+	}
+	mode := int64(0644)
+	if f.ReadOnly {
+		mode = 0444
+	}
+	var skip func(string) bool
+	if f.Order == FixturesBefore {
+		skip = func(relPath string) bool {
+			_, err := os.Lstat(filepath.Join(submissionDir, relPath))
+			return err == nil
+		}
+	}
+	buf, err := tarDirectory(f.dir, "", mode, skip)
+	if err != nil {
+		return err
+	}
+	return m.cli.CopyToContainer(context.Background(), containerID, dstDir, bytes.NewReader(buf.Bytes()), container.CopyToContainerOptions{})
+}
@@ -0,0 +1,184 @@
+package judger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"go.uber.org/zap"
+)
+
+// maxHookOutput caps how much of a hook's combined output is kept for the log.
+const maxHookOutput = 4096
+
+// hookVars returns the variables available to the post-submission hook command.
+func hookVars(sub *models.Submission, contestID string) map[string]string {
+	return map[string]string{
+		"SUBMISSION_ID": sub.ID,
+		"USER_ID":       sub.UserID,
+		"PROBLEM_ID":    sub.ProblemID,
+		"CONTEST_ID":    contestID,
+		"STATUS":        string(sub.Status),
+		"SCORE":         strconv.Itoa(sub.Score),
+		"PERFORMANCE":   strconv.FormatFloat(sub.Performance, 'f', -1, 64),
+		"CLUSTER":       sub.Cluster,
+		"NODE":          sub.Node,
+		"DRY_RUN":       strconv.FormatBool(sub.DryRun),
+		"PRACTICE":      strconv.FormatBool(sub.Practice),
+	}
+}
+
+// ValidatePostSubmissionHook checks that the hook command only refers to known variables, so a
+// typo is reported at startup instead of on every submission.
+func ValidatePostSubmissionHook(hook config.PostSubmissionHook) error {
+	vars := hookVars(&models.Submission{}, "")
+	for _, arg := range hook.Command {
+		if _, err := expandTemplate(arg, vars); err != nil {
+			return fmt.Errorf("post_submission_hook.command: %w", err)
+		}
+	}
+	return nil
+}
+
+// maxHookBacklog is how many hooks may wait for a free worker. Hooks of submissions finishing
+// while the backlog is full are dropped with a warning.
+const maxHookBacklog = 256
+
+// hookClaimTTL is how long a submission is remembered after its hook was started, so an
+// interrupt and the dispatcher noticing it afterwards do not both run the hook.
+const hookClaimTTL = 24 * time.Hour
+
+// hookRunner runs the post-submission hook out of band on hook.Concurrency() workers, at most
+// once per submission. A nil runner runs nothing.
+type hookRunner struct {
+	hook  config.PostSubmissionHook
+	queue chan hookJob
+
+	mu         sync.Mutex
+	claimed    map[string]time.Time // submission ID -> when its hook was started
+	lastPruned time.Time
+}
+
+type hookJob struct {
+	log  *zap.SugaredLogger
+	args []string
+}
+
+func newHookRunner(hook config.PostSubmissionHook) *hookRunner {
+	if !hook.Enabled() {
+		return nil
+	}
+	r := &hookRunner{hook: hook, queue: make(chan hookJob, maxHookBacklog), claimed: make(map[string]time.Time)}
+	for range hook.Concurrency() {
+		go r.worker()
+	}
+	return r
+}
+
+// run queues the hook for a submission that reached a final status and returns at once. The
+// outcome is only logged; it never delays or changes the submission.
+func (r *hookRunner) run(sub *models.Submission, contestID string) {
+	if r == nil {
+		return
+	}
+	log := submissionLogger(sub)
+	if !r.claim(sub.ID) {
+		log.Debugf("post-submission hook already started for submission %s", sub.ID)
+		return
+	}
+	// Expand now, the submission may be reused once dispatching returns.
+	vars := hookVars(sub, contestID)
+	args := make([]string, len(r.hook.Command))
+	for i, arg := range r.hook.Command {
+		expanded, err := expandTemplate(arg, vars)
+		if err != nil {
+			log.Errorf("post-submission hook not run: %v", err)
+			return
+		}
+		args[i] = expanded
+	}
+
+	select {
+	case r.queue <- hookJob{log: log, args: args}:
+	default:
+		log.Warnf("post-submission hook dropped for submission %s, %d hooks are already waiting", sub.ID, maxHookBacklog)
+	}
+}
+
+// claim reports whether the hook has not been started for the submission yet, and marks it as
+// started.
+func (r *hookRunner) claim(submissionID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.lastPruned) > time.Hour {
+		for id, at := range r.claimed {
+			if now.Sub(at) > hookClaimTTL {
+				delete(r.claimed, id)
+			}
+		}
+		r.lastPruned = now
+	}
+	if _, ok := r.claimed[submissionID]; ok {
+		return false
+	}
+	r.claimed[submissionID] = now
+	return true
+}
+
+func (r *hookRunner) worker() {
+	for job := range r.queue {
+		r.exec(job)
+	}
+}
+
+func (r *hookRunner) exec(job hookJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.hook.Timeout())
+	defer cancel()
+	output := &cappedBuffer{limit: maxHookOutput}
+	cmd := exec.CommandContext(ctx, job.args[0], job.args[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Children left holding the output open must not keep the worker after the hook is killed.
+	cmd.WaitDelay = 5 * time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		job.log.Warnf("post-submission hook killed after %s timeout, output: %s", r.hook.Timeout(), output)
+	case err != nil:
+		job.log.Warnf("post-submission hook failed after %s: %v, output: %s", elapsed, err, output)
+	default:
+		job.log.Infof("post-submission hook finished in %s, output: %s", elapsed, output)
+	}
+}
+
+// cappedBuffer keeps the first limit bytes written to it and counts the rest.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	limit   int
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	keep := min(len(p), b.limit-b.buf.Len())
+	b.buf.Write(p[:keep])
+	b.dropped += len(p) - keep
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	s := string(bytes.TrimSpace(b.buf.Bytes()))
+	if b.dropped > 0 {
+		s += fmt.Sprintf(" ... (%d more bytes)", b.dropped)
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,41 @@
+package judger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+)
+
+func TestHookRunnerQueue(t *testing.T) {
+	// No workers, so queued hooks stay in the backlog.
+	r := &hookRunner{
+		hook:    config.PostSubmissionHook{Command: []string{"true", "${SUBMISSION_ID}"}},
+		queue:   make(chan hookJob, 2),
+		claimed: make(map[string]time.Time),
+	}
+	for _, id := range []string{"s1", "s1", "s2", "s3"} {
+		r.run(&models.Submission{ID: id, Status: models.StatusFailed}, "")
+	}
+
+	// s1 runs once although it finished twice, and s3 is dropped because the backlog is full.
+	if len(r.queue) != 2 {
+		t.Fatalf("got %d queued hooks, want 2", len(r.queue))
+	}
+	for _, want := range []string{"s1", "s2"} {
+		if job := <-r.queue; job.args[1] != want {
+			t.Errorf("queued hook for %s, want %s", job.args[1], want)
+		}
+	}
+}
+
+func TestHookRunnerForgetsOldClaims(t *testing.T) {
+	r := &hookRunner{claimed: map[string]time.Time{"old": time.Now().Add(-hookClaimTTL - time.Minute)}}
+	if !r.claim("old") {
+		t.Error("claim older than hookClaimTTL was kept")
+	}
+	if r.claim("old") {
+		t.Error("fresh claim was not kept")
+	}
+}
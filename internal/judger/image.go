@@ -0,0 +1,73 @@
+package judger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/image"
+)
+
+var imageDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// imageDigest returns the digest an image reference is pinned to, such as
+// "sha256:..." for "alpine@sha256:...", or "" if it is referenced by tag.
+func imageDigest(ref string) string {
+	_, digest, _ := strings.Cut(ref, "@")
+	return digest
+}
+
+// validateImage checks the digest of a pinned image reference and reports whether the
+// reference is a mutable tag: ":latest", or no tag at all.
+func validateImage(ref string) (mutable bool, err error) {
+	name, digest, pinned := strings.Cut(ref, "@")
+	if pinned {
+		if !imageDigestPattern.MatchString(digest) {
+			return false, fmt.Errorf("image %q: digest must be sha256: followed by 64 lowercase hex digits", ref)
+		}
+		return false, nil
+	}
+	tag := ""
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		tag = name[i+1:]
+	}
+	return tag == "" || tag == "latest", nil
+}
+
+// EnsureImage verifies that an image pinned by digest is present on the node with that
+// digest, pulling it if it is missing. Images referenced by tag are left to ContainerCreate.
+func (m *DockerManager) EnsureImage(ref string) error {
+	digest := imageDigest(ref)
+	if digest == "" {
+		return nil
+	}
+	ctx := context.Background()
+
+	info, err := m.cli.ImageInspect(ctx, ref)
+	m.observe(err)
+	if cerrdefs.IsNotFound(err) {
+		reader, pullErr := m.cli.ImagePull(ctx, ref, image.PullOptions{})
+		if pullErr != nil {
+			return fmt.Errorf("failed to pull image %s: %w", ref, pullErr)
+		}
+		_, copyErr := io.Copy(io.Discard, reader)
+		reader.Close()
+		if copyErr != nil {
+			return fmt.Errorf("failed to pull image %s: %w", ref, copyErr)
+		}
+		info, err = m.cli.ImageInspect(ctx, ref)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect image %s: %w", ref, err)
+	}
+
+	for _, repoDigest := range info.RepoDigests {
+		if imageDigest(repoDigest) == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %s does not match its pinned digest: the node has %s with digests %v", ref, info.ID, info.RepoDigests)
+}
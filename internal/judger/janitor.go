@@ -0,0 +1,174 @@
+package judger
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/util"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// CleanupResult summarizes a single retention cleanup run.
+type CleanupResult struct {
+	SubmissionsCleaned int   `json:"submissions_cleaned"`
+	FilesRemoved       int   `json:"files_removed"`
+	RecordsDeleted     int   `json:"records_deleted"`
+	BytesFreed         int64 `json:"bytes_freed"`
+}
+
+// StartJanitor periodically removes expired submission content and logs.
+// It does nothing if retention is disabled.
+func StartJanitor(db *gorm.DB, cfg *config.Config) {
+	if cfg.Storage.Retention.Days <= 0 {
+		zap.S().Info("submission retention disabled, janitor not started")
+		return
+	}
+	interval := time.Duration(cfg.Storage.Retention.IntervalHours) * time.Hour
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	zap.S().Infof("janitor started: retention %d days, interval %s", cfg.Storage.Retention.Days, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := CleanupExpiredSubmissions(db, cfg, cfg.Storage.Retention.Days)
+		if err != nil {
+			zap.S().Errorf("janitor run failed: %v", err)
+		} else {
+			zap.S().Infof("janitor cleaned %d submissions, removed %d files, freed %d bytes",
+				result.SubmissionsCleaned, result.FilesRemoved, result.BytesFreed)
+		}
+		<-ticker.C
+	}
+}
+
+// CleanupExpiredSubmissions removes on-disk content and logs of finished submissions older than
+// the given number of days. Submissions that are still the best score of a user are kept.
+func CleanupExpiredSubmissions(db *gorm.DB, cfg *config.Config, days int) (*CleanupResult, error) {
+	cutoff := time.Now().AddDate(0, 0, -days)
+	subs, err := database.GetExpiredSubmissions(db, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CleanupResult{}
+	for _, sub := range subs {
+		cleaned := false
+
+		contentDir, contentArchive := util.ContentPaths(cfg.Storage.SubmissionContent, sub.ID)
+		for _, path := range []string{contentDir, contentArchive, filepath.Join(cfg.Storage.ArtifactsPath(), sub.ID)} {
+			if n, size := removePath(path); n > 0 {
+				result.FilesRemoved += n
+				result.BytesFreed += size
+				cleaned = true
+			}
+		}
+		for _, cont := range sub.Containers {
+			if cont.LogFilePath == "" {
+				continue
+			}
+			if n, size := removePath(cont.LogFilePath); n > 0 {
+				result.FilesRemoved += n
+				result.BytesFreed += size
+				cleaned = true
+			}
+		}
+
+		if cfg.Storage.Retention.DeleteRecords {
+			if err := database.DeleteSubmissionRecord(db, sub.ID); err != nil {
+				zap.S().Errorf("failed to delete expired submission record %s: %v", sub.ID, err)
+			} else {
+				result.RecordsDeleted++
+				cleaned = true
+			}
+		}
+
+		if cleaned {
+			result.SubmissionsCleaned++
+		}
+	}
+	return result, nil
+}
+
+// recycleBinPurgeInterval is how often the recycle bin is checked for expired submissions.
+const recycleBinPurgeInterval = time.Hour
+
+// StartRecycleBinPurger periodically deletes submissions that have been in the recycle bin
+// for longer than its retention window.
+func StartRecycleBinPurger(db *gorm.DB, cfg *config.Config) {
+	ticker := time.NewTicker(recycleBinPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := PurgeRecycleBin(db, cfg)
+		if err != nil {
+			zap.S().Errorf("recycle bin purge failed: %v", err)
+		} else if purged > 0 {
+			zap.S().Infof("purged %d submissions from the recycle bin", purged)
+		}
+		<-ticker.C
+	}
+}
+
+// PurgeRecycleBin permanently deletes the records, content and logs of the submissions whose
+// retention window in the recycle bin has passed, returning how many were deleted.
+func PurgeRecycleBin(db *gorm.DB, cfg *config.Config) (int, error) {
+	subs, err := database.GetSubmissionsDeletedBefore(db, time.Now().Add(-cfg.Storage.RecycleBin.Retention()))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, sub := range subs {
+		if err := database.DeleteSubmissionRecord(db, sub.ID); err != nil {
+			zap.S().Errorf("failed to purge submission %s: %v", sub.ID, err)
+			continue
+		}
+		recycledDir, recycledArchive := util.ContentPaths(cfg.Storage.RecycleBinPath(), sub.ID)
+		removePath(recycledDir)
+		removePath(recycledArchive)
+		removePath(filepath.Join(cfg.Storage.ArtifactsPath(), sub.ID))
+		for _, cont := range sub.Containers {
+			if cont.LogFilePath != "" {
+				removePath(cont.LogFilePath)
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// removePath deletes a file or directory tree and reports how many files and bytes were removed.
+func removePath(path string) (int, int64) {
+	var count int
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		if !os.IsNotExist(err) {
+			zap.S().Warnf("failed to inspect %s: %v", path, err)
+		}
+		return 0, 0
+	}
+	if err := os.RemoveAll(path); err != nil {
+		zap.S().Errorf("failed to remove %s: %v", path, err)
+		return 0, 0
+	}
+	return count, size
+}
@@ -0,0 +1,48 @@
+package judger
+
+import "time"
+
+// LeaderboardColumn describes the column of a problem on a contest's leaderboard. It is also
+// the problem summary of the contest detail.
+type LeaderboardColumn struct {
+	ProblemID string `json:"problem_id"`
+	Name      string `json:"name"`
+	Label     string `json:"label,omitempty"`
+	Icon      string `json:"icon,omitempty"`
+	ScoreMode string `json:"score_mode"`
+	MaxScore  int    `json:"max_score,omitempty"` // 0 when the problem has no fixed maximum
+}
+
+// LeaderboardColumns returns the columns of the contest's problems in contest order. Unless all
+// is set, problems of phases that have not opened yet are left out. The caller must hold the
+// app state lock.
+func (c *Contest) LeaderboardColumns(problems map[string]*Problem, now time.Time, all bool) []LeaderboardColumn {
+	columns := make([]LeaderboardColumn, 0, len(c.ProblemIDs))
+	for _, id := range c.ProblemIDs {
+		p, ok := problems[id]
+		if !ok || (!all && !c.IsProblemUnlocked(id, now)) {
+			continue
+		}
+		columns = append(columns, LeaderboardColumn{
+			ProblemID: id,
+			Name:      p.Name,
+			Label:     p.Label,
+			Icon:      p.Icon,
+			ScoreMode: p.Score.Mode,
+			MaxScore:  p.Score.MaxScore(),
+		})
+	}
+	return columns
+}
+
+// MaxScore returns the most points a problem can give, or 0 if that is up to its judge.
+func (s ScoreConfig) MaxScore() int {
+	switch s.Mode {
+	case "performance":
+		return s.MaxPerformanceScore
+	case "weighted":
+		return s.Points
+	default:
+		return s.FullScore
+	}
+}
@@ -2,11 +2,18 @@ package judger
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/expr-lang/expr/vm"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 )
@@ -29,15 +36,195 @@ type Contest struct {
 	Description   string          `yaml:"-" json:"description"`
 	BasePath      string          `yaml:"-" json:"-"`             // Store the base path to find assets, hide from both
 	Announcements []*Announcement `yaml:"-" json:"announcements"` // Loaded from announcements.yaml, hidden from contest.yaml
+	Phases        []Phase         `yaml:"phases,omitempty" json:"phases,omitempty"`
+	// RequireRegistrationToView hides problem statements and assets from users not registered for the contest.
+	RequireRegistrationToView bool `yaml:"require_registration_to_view,omitempty" json:"require_registration_to_view"`
+	// PublicAfterEnd makes problem statements and assets readable by anyone once the contest has ended.
+	PublicAfterEnd bool `yaml:"public_after_end,omitempty" json:"public_after_end"`
+	// LockScoresAtEnd freezes the leaderboard when the contest ends: results finishing later are
+	// not scored, and users are served the final standing saved at the end time.
+	LockScoresAtEnd bool `yaml:"lock_scores_at_end,omitempty" json:"lock_scores_at_end"`
+	// HideScoresUntilEnd shows users only whether their submissions passed, not their scores,
+	// rank or score history, until the contest ends. Problems may override it.
+	HideScoresUntilEnd bool `yaml:"hide_scores_until_end,omitempty" json:"hide_scores_until_end"`
+	// AutoRegister registers users for the contest when they log in while it is running.
+	AutoRegister bool `yaml:"auto_register,omitempty" json:"auto_register"`
+	// AllowPracticeAfterEnd keeps accepting submissions after the contest ends. They are judged
+	// as usual but marked practice, so they never reach scores or the leaderboard.
+	AllowPracticeAfterEnd bool `yaml:"allow_practice_after_end,omitempty" json:"allow_practice_after_end"`
+	// Upload holds default upload limits for problems that leave them unset.
+	Upload config.UploadLimits `yaml:"upload,omitempty" json:"upload"`
+	// Quota caps the compute each user's submissions may use in the contest.
+	Quota ContestQuota `yaml:"quota,omitempty" json:"quota"`
+	// LevelWeights gives the point value of "weighted" mode problems by difficulty level.
+	LevelWeights map[string]int `yaml:"level_weights,omitempty" json:"level_weights,omitempty"`
+	// Defaults holds values for problem fields that the contest's problems leave unset.
+	Defaults ProblemDefaults `yaml:"defaults,omitempty" json:"-"`
+	// Scoring is an expression computing a user's leaderboard total, see scoringEnv. Empty sums
+	// the problem scores.
+	Scoring        string      `yaml:"scoring,omitempty" json:"scoring,omitempty"`
+	scoringProgram *vm.Program // compiled Scoring
+
+	icpcProblems map[string]database.ICPCProblem // set when the contest is ranked ICPC-style, see resolveICPC
+}
+
+// ProblemDefaults are contest-wide values inherited by problems that do not set them.
+type ProblemDefaults struct {
+	Cluster string   `yaml:"cluster"`
+	CPU     float64  `yaml:"cpu"`
+	Memory  int64    `yaml:"memory"`
+	Image   string   `yaml:"image"`   // for workflow steps without an image
+	Timeout int      `yaml:"timeout"` // for workflow steps without a timeout
+	Env     []string `yaml:"env"`     // prepended to every workflow step's env
+}
+
+// Phase unlocks a set of problems of a contest at a given time.
+type Phase struct {
+	Name      string    `yaml:"name" json:"name"`
+	StartTime time.Time `yaml:"starttime" json:"starttime"`
+	Problems  []string  `yaml:"problems" json:"problems"` // Problem IDs unlocked by this phase
+}
+
+// ProblemUnlockTime returns the start time of the phase containing the problem.
+// Problems that belong to no phase are not gated and return false.
+func (c *Contest) ProblemUnlockTime(problemID string) (time.Time, bool) {
+	for _, phase := range c.Phases {
+		for _, id := range phase.Problems {
+			if id == problemID {
+				return phase.StartTime, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// IsProblemUnlocked reports whether the problem's phase (if any) has opened.
+func (c *Contest) IsProblemUnlocked(problemID string, now time.Time) bool {
+	unlockTime, ok := c.ProblemUnlockTime(problemID)
+	return !ok || !now.Before(unlockTime)
+}
+
+// IsPublicArchive reports whether the problem is publicly readable because the contest has
+// ended and public_after_end is set. The problem's own setting overrides the contest's.
+func (c *Contest) IsPublicArchive(p *Problem, now time.Time) bool {
+	if !now.After(c.EndTime) {
+		return false
+	}
+	if p.PublicAfterEnd != nil {
+		return *p.PublicAfterEnd
+	}
+	return c.PublicAfterEnd
+}
+
+// ScoresHidden reports whether users may not see their scores on the problem yet, because
+// hide_scores_until_end is set and the contest has not ended. The problem's own setting overrides
+// the contest's.
+func (c *Contest) ScoresHidden(p *Problem, now time.Time) bool {
+	if !now.Before(c.EndTime) {
+		return false
+	}
+	if p.HideScoresUntilEnd != nil {
+		return *p.HideScoresUntilEnd
+	}
+	return c.HideScoresUntilEnd
+}
+
+// TotalsHidden reports whether any problem of the contest hides its scores right now, in which
+// case leaderboard totals and score histories would give them away and are hidden too.
+func (c *Contest) TotalsHidden(problems map[string]*Problem, now time.Time) bool {
+	for _, id := range c.ProblemIDs {
+		if p, ok := problems[id]; ok && c.ScoresHidden(p, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// StepLogVisible reports whether submitters may read the logs of the problem's workflow step:
+// always for steps with show set, and for the other steps once the contest has ended if the
+// problem sets reveal_logs_after_end.
+func (c *Contest) StepLogVisible(p *Problem, step int, now time.Time) bool {
+	if step < 0 || step >= len(p.Workflow) {
+		return false
+	}
+	return p.Workflow[step].Show || (p.RevealLogsAfterEnd && now.After(c.EndTime))
+}
+
+// ScoresLocked reports whether scoring writes are refused because the contest has ended and
+// lock_scores_at_end is set.
+func (c *Contest) ScoresLocked(now time.Time) bool {
+	return c.LockScoresAtEnd && now.After(c.EndTime)
+}
+
+// InPractice reports whether submissions made at now are practice submissions.
+func (c *Contest) InPractice(now time.Time) bool {
+	return c.AllowPracticeAfterEnd && now.After(c.EndTime)
+}
+
+// VisibleCopy returns a copy of the contest with problems of phases that have not opened yet hidden.
+func (c *Contest) VisibleCopy(now time.Time) Contest {
+	contestCopy := *c
+	contestCopy.ProblemIDs = make([]string, 0, len(c.ProblemIDs))
+	for _, id := range c.ProblemIDs {
+		if c.IsProblemUnlocked(id, now) {
+			contestCopy.ProblemIDs = append(contestCopy.ProblemIDs, id)
+		}
+	}
+	contestCopy.Phases = make([]Phase, len(c.Phases))
+	for i, phase := range c.Phases {
+		contestCopy.Phases[i] = phase
+		if now.Before(phase.StartTime) {
+			contestCopy.Phases[i].Problems = []string{}
+		}
+	}
+	return contestCopy
 }
 
 type UploadLimit struct {
-	MaxNum      int      `yaml:"maxnum" json:"max_num"`
-	MaxSize     int      `yaml:"maxsize" json:"max_size"`
-	UploadForm  bool     `yaml:"upload_form" json:"upload_form"`
-	UploadFiles []string `yaml:"upload_files" json:"upload_files"`
-	Editor      bool     `yaml:"editor" json:"editor"`
-	EditorFiles []string `yaml:"editor_files" json:"editor_files"`
+	MaxNum   int `yaml:"maxnum" json:"max_num"`
+	MaxSize  int `yaml:"maxsize" json:"max_size"`
+	MaxDepth int `yaml:"max_depth" json:"max_depth,omitempty"` // max directory nesting of uploaded paths, defaults to 8
+	// Uploaded archives may expand to at most MaxExpandedSize MB and MaxArchiveEntries entries in total.
+	MaxExpandedSize   int      `yaml:"max_expanded_size" json:"max_expanded_size,omitempty"`
+	MaxArchiveEntries int      `yaml:"max_archive_entries" json:"max_archive_entries,omitempty"`
+	UploadForm        bool     `yaml:"upload_form" json:"upload_form"`
+	UploadFiles       []string `yaml:"upload_files" json:"upload_files"`
+	Editor            bool     `yaml:"editor" json:"editor"`
+	EditorFiles       []string `yaml:"editor_files" json:"editor_files"`
+	// Submissions are rejected unless every EditorFiles name and every UploadFiles pattern
+	// matches a non-empty file. AllowIncompleteFiles turns the check off for problems whose
+	// UploadFiles patterns are alternatives or optional, e.g. "*.c" and "*.cpp".
+	AllowIncompleteFiles bool `yaml:"allow_incomplete_files" json:"allow_incomplete_files,omitempty"`
+	// AllowedExtensions restricts uploaded files by extension (case-insensitive).
+	// An empty string entry allows files without an extension.
+	AllowedExtensions []string `yaml:"allowed_extensions" json:"allowed_extensions,omitempty"`
+}
+
+// EffectiveUploadLimit resolves a problem's upload limits. A limit the problem leaves unset is
+// inherited from the contest, then from the global config, and the global limit caps the result.
+func EffectiveUploadLimit(p *Problem, contest *Contest, global config.UploadLimits) UploadLimit {
+	var contestLimits config.UploadLimits
+	if contest != nil {
+		contestLimits = contest.Upload
+	}
+	limit := p.Upload
+	limit.MaxNum = resolveUploadLimit(p.Upload.MaxNum, contestLimits.MaxNum, global.MaxNum)
+	limit.MaxSize = resolveUploadLimit(p.Upload.MaxSize, contestLimits.MaxSize, global.MaxSize)
+	limit.MaxDepth = resolveUploadLimit(p.Upload.MaxDepth, contestLimits.MaxDepth, global.MaxDepth)
+	limit.MaxExpandedSize = resolveUploadLimit(p.Upload.MaxExpandedSize, contestLimits.MaxExpandedSize, global.MaxExpandedSize)
+	limit.MaxArchiveEntries = resolveUploadLimit(p.Upload.MaxArchiveEntries, contestLimits.MaxArchiveEntries, global.MaxArchiveEntries)
+	return limit
+}
+
+func resolveUploadLimit(problem, contest, global int) int {
+	limit := problem
+	if limit <= 0 {
+		limit = contest
+	}
+	if limit <= 0 || (global > 0 && limit > global) {
+		limit = global
+	}
+	return limit
 }
 
 type TmpfsOptions struct {
@@ -63,28 +250,172 @@ type WorkflowStep struct {
 	Steps   [][]string `yaml:"steps" json:"steps"`
 	Mounts  []Mount    `yaml:"mounts" json:"mounts"`
 	Network bool       `yaml:"network" json:"network"`
+	// NetworkName attaches the container to a pre-created Docker network instead of the default
+	// bridge, e.g. an internal network that only reaches a package mirror. Implies Network.
+	NetworkName string `yaml:"network_name" json:"network_name,omitempty"`
+	// FreshWorkdir starts the step from the original submission content instead of the
+	// shared volume, with a tmpfs scratch directory at /mnt/scratch.
+	FreshWorkdir bool `yaml:"fresh_workdir" json:"fresh_workdir"`
+	// StdinFrom names an earlier step whose captured stdout (of its last command) is fed to the
+	// stdin of each of this step's commands, e.g. to pass a solution's output to a checker.
+	StdinFrom string `yaml:"stdin_from" json:"stdin_from,omitempty"`
+	// DryRunSafe marks the step to be run for dry-run (compile-check only) submissions.
+	DryRunSafe bool `yaml:"dry_run_safe" json:"dry_run_safe"`
+	// Artifacts copies the files the step leaves in ArtifactDir back for the submitter to download.
+	Artifacts bool `yaml:"artifacts" json:"artifacts"`
+	// Container hardening, merged over restrictive defaults (see containerSecurity).
+	Ulimits     []Ulimit `yaml:"ulimits" json:"ulimits,omitempty"`
+	SecurityOpt []string `yaml:"security_opt" json:"security_opt,omitempty"`
+	PidsLimit   int64    `yaml:"pids_limit" json:"pids_limit,omitempty"`
+	// Env holds extra KEY=value environment variables for the step's container.
+	Env []string `yaml:"env" json:"env,omitempty"`
+
+	resolvedSecurityOpt []string // SecurityOpt with seccomp profiles inlined, set at load time
+	stdinStep           int      // index of the StdinFrom step, set at load time
+}
+
+// SupportsDryRun reports whether any workflow step is marked dry_run_safe.
+func (p *Problem) SupportsDryRun() bool {
+	for _, step := range p.Workflow {
+		if step.DryRunSafe {
+			return true
+		}
+	}
+	return false
 }
 
 type ScoreConfig struct {
 	Mode                string `yaml:"mode" json:"mode"`
 	MaxPerformanceScore int    `yaml:"max_performance_score" json:"max_performance_score"`
+	// Weighted mode: Points falls towards MinPoints over Decay solves, a raw score of FullScore is a solve.
+	// Points defaults to the contest's level_weights entry for the problem's level, then to FullScore.
+	Points    int `yaml:"points" json:"points,omitempty"`
+	MinPoints int `yaml:"min_points" json:"min_points,omitempty"`
+	Decay     int `yaml:"decay" json:"decay,omitempty"`
+	FullScore int `yaml:"full_score" json:"full_score,omitempty"`
+	// Performance mode: rescoring other users by less than HistoryMinDelta points or
+	// HistoryMinDeltaRatio of their score is not recorded in the score history.
+	HistoryMinDelta      int     `yaml:"history_min_delta" json:"history_min_delta,omitempty"`
+	HistoryMinDeltaRatio float64 `yaml:"history_min_delta_ratio" json:"history_min_delta_ratio,omitempty"`
+	// ICPC mode: a raw score of FullScore is a solve, and every rejected attempt before it adds
+	// PenaltyMinutes to the solve time.
+	PenaltyMinutes int `yaml:"penalty_minutes" json:"penalty_minutes,omitempty"`
+}
+
+// HistoryThreshold returns the minimum rescoring change recorded in the score history.
+func (s ScoreConfig) HistoryThreshold() database.HistoryThreshold {
+	return database.HistoryThreshold{Absolute: s.HistoryMinDelta, Relative: s.HistoryMinDeltaRatio}
+}
+
+// ICPC returns the parameters of the "icpc" score mode.
+func (s ScoreConfig) ICPC() database.ICPCProblem {
+	return database.ICPCProblem{FullScore: s.FullScore, PenaltyMinutes: s.PenaltyMinutes}
+}
+
+// Weighted returns the parameters of the "weighted" score mode.
+func (s ScoreConfig) Weighted() database.WeightedScoring {
+	return database.WeightedScoring{
+		Points:    s.Points,
+		MinPoints: s.MinPoints,
+		Decay:     s.Decay,
+		FullScore: s.FullScore,
+	}
 }
 
 type Problem struct {
-	ID             string         `yaml:"id" json:"id"`
-	Name           string         `yaml:"name" json:"name"`
-	Level          string         `yaml:"level" json:"level"`
-	StartTime      time.Time      `yaml:"starttime" json:"starttime"`
-	EndTime        time.Time      `yaml:"endtime" json:"endtime"`
-	MaxSubmissions int            `yaml:"max_submissions" json:"max_submissions"`
-	Cluster        string         `yaml:"cluster" json:"cluster"`
-	CPU            int            `yaml:"cpu" json:"cpu"`
-	Memory         int64          `yaml:"memory" json:"memory"`
-	Upload         UploadLimit    `yaml:"upload" json:"upload"`
-	Workflow       []WorkflowStep `yaml:"workflow" json:"workflow"`
-	Score          ScoreConfig    `yaml:"score" json:"score"`
-	Description    string         `json:"description"`
-	BasePath       string         `yaml:"-" json:"-"` // Store the base path to find assets, hide from both
+	ID                   string         `yaml:"id" json:"id"`
+	Name                 string         `yaml:"name" json:"name"`
+	Label                string         `yaml:"label" json:"label,omitempty"` // short scoreboard name such as "N3"
+	Icon                 string         `yaml:"icon" json:"icon,omitempty"`   // emoji or image URL shown next to the label
+	Level                string         `yaml:"level" json:"level"`
+	StartTime            time.Time      `yaml:"starttime" json:"starttime"`
+	EndTime              time.Time      `yaml:"endtime" json:"endtime"`
+	MaxSubmissions       int            `yaml:"max_submissions" json:"max_submissions"`
+	CooldownSeconds      int            `yaml:"cooldown_seconds" json:"cooldown_seconds"`           // minimum time between a user's submissions, 0 = none
+	Duplicates           string         `yaml:"duplicate_submissions" json:"duplicate_submissions"` // what happens to a resubmission of identical files, see DuplicatesAllow
+	Cluster              string         `yaml:"cluster" json:"cluster"`
+	FallbackClusters     []string       `yaml:"fallback_clusters" json:"fallback_clusters,omitempty"` // tried in order when no node of cluster can run the problem
+	FallbackAfterSeconds int            `yaml:"fallback_after_seconds" json:"fallback_after_seconds"` // how long to wait before moving to a fallback, 0 = 300
+	CPU                  float64        `yaml:"cpu" json:"cpu"`                                       // cores, may be fractional when not pinned
+	PinCores             *bool          `yaml:"pin_cores,omitempty" json:"pin_cores,omitempty"`       // defaults to pinning whole-number cpu requests
+	Memory               int64          `yaml:"memory" json:"memory"`
+	Timeout              int            `yaml:"timeout" json:"timeout"`                                                 // wall-clock limit in seconds for the whole workflow, 0 = none
+	PublicAfterEnd       *bool          `yaml:"public_after_end,omitempty" json:"public_after_end,omitempty"`           // overrides the contest's public_after_end
+	HideScoresUntilEnd   *bool          `yaml:"hide_scores_until_end,omitempty" json:"hide_scores_until_end,omitempty"` // overrides the contest's hide_scores_until_end
+	Prerequisites        []string       `yaml:"prerequisites" json:"prerequisites,omitempty"`                           // problems of the same contest to score on first
+	RevealLogsAfterEnd   bool           `yaml:"reveal_logs_after_end" json:"reveal_logs_after_end"`                     // show hidden step logs to submitters after the contest
+	Upload               UploadLimit    `yaml:"upload" json:"upload"`
+	Workflow             []WorkflowStep `yaml:"workflow" json:"workflow"`
+	Score                ScoreConfig    `yaml:"score" json:"score"`
+	ResultFormat         string         `yaml:"result_format" json:"result_format"` // how the final step reports its result, see ResultFormatJSON
+	Fixtures             Fixtures       `yaml:"fixtures" json:"fixtures"`           // files from fixtures/ placed in /mnt/work with the submission
+	Description          string         `json:"description"`
+	BasePath             string         `yaml:"-" json:"-"` // Store the base path to find assets, hide from both
+}
+
+// Policies for a user resubmitting the files of their own earlier submission, set per problem
+// with duplicate_submissions.
+const (
+	DuplicatesAllow  = "allow"  // judge it, recording the earlier submission in duplicate_of
+	DuplicatesReject = "reject" // refuse it without using up an attempt
+)
+
+// Pinned reports whether the problem's containers are pinned to a dedicated block of cores.
+// Whole-number CPU requests are pinned unless pin_cores is false; fractional ones never are.
+func (p *Problem) Pinned() bool {
+	if p.PinCores != nil {
+		return *p.PinCores
+	}
+	return p.CPU == math.Trunc(p.CPU)
+}
+
+// PinnedCores is the number of cores to reserve exclusively, 0 if the problem is not pinned.
+func (p *Problem) PinnedCores() int {
+	if !p.Pinned() {
+		return 0
+	}
+	return int(p.CPU)
+}
+
+// MilliCPU is the CPU request in thousandths of a core.
+func (p *Problem) MilliCPU() int64 {
+	return int64(math.Round(p.CPU * 1000))
+}
+
+// validateNetworkName rejects network_name values that are Docker network modes rather than
+// user-defined networks. Whether the network exists is only known on the node running the step.
+func validateNetworkName(name string) error {
+	switch {
+	case name == "":
+		return nil
+	case name == "host" || name == "none" || name == "default" || strings.HasPrefix(name, "container:"):
+		return fmt.Errorf("network_name %q is a network mode, not a user-defined network", name)
+	}
+	return nil
+}
+
+// resolveStdinFrom finds the step named by stdin_from of the step at index i. It must be a single,
+// earlier step, and a dry_run_safe step may only read from another dry_run_safe step.
+func resolveStdinFrom(workflow []WorkflowStep, i int) error {
+	flow := &workflow[i]
+	found := -1
+	for j := 0; j < i; j++ {
+		if workflow[j].Name != flow.StdinFrom {
+			continue
+		}
+		if found >= 0 {
+			return fmt.Errorf("stdin_from %q matches more than one step", flow.StdinFrom)
+		}
+		found = j
+	}
+	if found < 0 {
+		return fmt.Errorf("stdin_from %q does not name an earlier step", flow.StdinFrom)
+	}
+	if flow.DryRunSafe && !workflow[found].DryRunSafe {
+		return fmt.Errorf("dry_run_safe step reads stdin_from %q, which is not dry_run_safe", flow.StdinFrom)
+	}
+	flow.stdinStep = found
+	return nil
 }
 
 // FindContestDirs scans a root directory and returns a slice of all its immediate subdirectories.
@@ -108,18 +439,31 @@ func FindContestDirs(rootPath string) ([]string, error) {
 	return dirs, nil
 }
 
-func LoadAllContestsAndProblems(contestDirs []string) (map[string]*Contest, map[string]*Problem, error) {
+// LoadError describes a contest or problem directory that failed to load.
+type LoadError struct {
+	Kind  string `json:"kind"` // "contest" or "problem"
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// LoadAllContestsAndProblems loads every contest directory. Directories that fail to load are
+// skipped and reported in the returned load errors.
+func LoadAllContestsAndProblems(contestDirs []string) (map[string]*Contest, map[string]*Problem, []LoadError, error) {
 	contests := make(map[string]*Contest)
 	problems := make(map[string]*Problem)
+	var loadErrs []LoadError
 
 	for _, dir := range contestDirs {
-		contest, contestProblems, err := loadContest(dir)
+		contest, contestProblems, problemErrs, err := loadContest(dir)
 		if err != nil {
 			zap.S().Warnf("failed to load contest from %s: %v", dir, err)
+			loadErrs = append(loadErrs, LoadError{Kind: "contest", Path: dir, Error: err.Error()})
 			continue
 		}
+		loadErrs = append(loadErrs, problemErrs...)
 		if _, exists := contests[contest.ID]; exists {
 			zap.S().Warnf("duplicate contest ID %s found, skipping", dir)
+			loadErrs = append(loadErrs, LoadError{Kind: "contest", Path: dir, Error: fmt.Sprintf("duplicate contest ID %s", contest.ID)})
 			continue
 		}
 		contests[contest.ID] = contest
@@ -127,25 +471,29 @@ func LoadAllContestsAndProblems(contestDirs []string) (map[string]*Contest, map[
 		for _, p := range contestProblems {
 			if _, exists := problems[p.ID]; exists {
 				zap.S().Warnf("duplicate problem ID %s found, overwriting", p.ID)
+				loadErrs = append(loadErrs, LoadError{Kind: "problem", Path: p.BasePath, Error: fmt.Sprintf("duplicate problem ID %s, overwriting previous definition", p.ID)})
 			}
 			problems[p.ID] = p
 		}
 	}
-	return contests, problems, nil
+	return contests, problems, loadErrs, nil
 }
 
-func loadContest(dir string) (*Contest, []*Problem, error) {
+func loadContest(dir string) (*Contest, []*Problem, []LoadError, error) {
 	// Load contest.yaml
 	contestPath := filepath.Join(dir, "contest.yaml")
 	data, err := os.ReadFile(contestPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	var contest Contest
 	if err := yaml.Unmarshal(data, &contest); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	contest.BasePath = dir // Set the base path
+	if contest.Quota.CPUSeconds < 0 || contest.Quota.Runs < 0 {
+		return nil, nil, nil, fmt.Errorf("quota values must not be negative")
+	}
 
 	// Load contest description
 	desc, _ := os.ReadFile(filepath.Join(dir, "index.md"))
@@ -167,19 +515,224 @@ func loadContest(dir string) (*Contest, []*Problem, error) {
 	}
 
 	var loadedProblems []*Problem
+	var loadErrs []LoadError
 	for _, problemDirName := range contest.ProblemDirs {
-		problem, err := loadProblem(filepath.Join(dir, problemDirName))
+		problemDir := filepath.Join(dir, problemDirName)
+		problem, err := loadProblem(problemDir, &contest)
 		if err != nil {
 			zap.S().Warnf("failed to load problem %s in contest %s: %v", problemDirName, contest.ID, err)
+			loadErrs = append(loadErrs, LoadError{Kind: "problem", Path: problemDir, Error: err.Error()})
 			continue
 		}
-		contest.ProblemIDs = append(contest.ProblemIDs, problem.ID)
+		if problem.Score.Mode == "weighted" && problem.Score.Points == 0 {
+			problem.Score.Points = problem.Score.FullScore
+			if weight, ok := contest.LevelWeights[problem.Level]; ok {
+				problem.Score.Points = weight
+			}
+		}
 		loadedProblems = append(loadedProblems, problem)
 	}
-	return &contest, loadedProblems, nil
+	loadedProblems, prereqErrs := validatePrerequisites(loadedProblems)
+	for _, e := range prereqErrs {
+		zap.S().Warnf("failed to load problem %s in contest %s: %s", e.Path, contest.ID, e.Error)
+	}
+	loadErrs = append(loadErrs, prereqErrs...)
+	for _, problem := range loadedProblems {
+		contest.ProblemIDs = append(contest.ProblemIDs, problem.ID)
+	}
+	if err := resolveICPC(&contest, loadedProblems); err != nil {
+		return nil, nil, nil, err
+	}
+	if contest.Scoring != "" {
+		program, err := compileScoring(contest.Scoring)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		contest.scoringProgram = program
+	}
+
+	// Warn about phases that reference problems outside this contest
+	for _, phase := range contest.Phases {
+		for _, id := range phase.Problems {
+			found := false
+			for _, pid := range contest.ProblemIDs {
+				if pid == id {
+					found = true
+					break
+				}
+			}
+			if !found {
+				zap.S().Warnf("phase %q in contest %s references unknown problem %s", phase.Name, contest.ID, id)
+			}
+		}
+	}
+	return &contest, loadedProblems, loadErrs, nil
+}
+
+// validatePrerequisites drops problems whose prerequisites are not other loaded problems of the
+// same contest or form a cycle, which would lock them forever. Problems that depend on a dropped
+// problem are dropped as well.
+func validatePrerequisites(problems []*Problem) ([]*Problem, []LoadError) {
+	var loadErrs []LoadError
+	drop := func(p *Problem, reason string) {
+		loadErrs = append(loadErrs, LoadError{Kind: "problem", Path: p.BasePath, Error: reason})
+	}
+
+	for {
+		ids := make(map[string]bool, len(problems))
+		for _, p := range problems {
+			ids[p.ID] = true
+		}
+		kept := problems[:0:0]
+		for _, p := range problems {
+			var reason string
+			for _, id := range p.Prerequisites {
+				if id == p.ID || !ids[id] {
+					reason = fmt.Sprintf("prerequisite %q is not another problem of the contest", id)
+					break
+				}
+			}
+			if reason != "" {
+				drop(p, reason)
+				continue
+			}
+			kept = append(kept, p)
+		}
+		if len(kept) == len(problems) {
+			break
+		}
+		problems = kept
+	}
+
+	// Repeatedly resolve problems whose prerequisites are all resolved; what remains is part of
+	// or depends on a cycle.
+	resolved := make(map[string]bool, len(problems))
+	for progress := true; progress; {
+		progress = false
+		for _, p := range problems {
+			if resolved[p.ID] {
+				continue
+			}
+			ready := true
+			for _, id := range p.Prerequisites {
+				if !resolved[id] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				resolved[p.ID] = true
+				progress = true
+			}
+		}
+	}
+	kept := problems[:0:0]
+	for _, p := range problems {
+		if !resolved[p.ID] {
+			drop(p, "prerequisites form a cycle")
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept, loadErrs
 }
 
-func loadProblem(dir string) (*Problem, error) {
+// templateVar matches ${NAME} references in mount sources and env values.
+var templateVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandTemplate replaces ${NAME} references with their values, failing on unknown names.
+// Other uses of $ are left alone.
+func expandTemplate(s string, vars map[string]string) (string, error) {
+	var unknown string
+	expanded := templateVar.ReplaceAllStringFunc(s, func(ref string) string {
+		name := templateVar.FindStringSubmatch(ref)[1]
+		value, ok := vars[name]
+		if !ok && unknown == "" {
+			unknown = name
+		}
+		return value
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown variable ${%s} in %q", unknown, s)
+	}
+	return expanded, nil
+}
+
+// applyDefaults fills the problem fields left unset from the contest defaults, expands template
+// variables in mount sources and env, and checks that the merged problem is complete.
+func applyDefaults(p *Problem, contest *Contest) error {
+	d := contest.Defaults
+	if p.Cluster == "" {
+		p.Cluster = d.Cluster
+	}
+	if p.CPU == 0 {
+		p.CPU = d.CPU
+	}
+	if p.Memory == 0 {
+		p.Memory = d.Memory
+	}
+	if p.Cluster == "" {
+		return fmt.Errorf("cluster is not set and the contest has no default")
+	}
+	for i, name := range p.FallbackClusters {
+		if name == p.Cluster || slices.Contains(p.FallbackClusters[:i], name) {
+			return fmt.Errorf("fallback cluster %q is listed twice or is the problem's own cluster", name)
+		}
+	}
+	if p.FallbackAfterSeconds < 0 {
+		return fmt.Errorf("fallback_after_seconds must not be negative")
+	}
+
+	vars := map[string]string{"CONTEST_ID": contest.ID, "PROBLEM_ID": p.ID}
+	for i := range p.Workflow {
+		flow := &p.Workflow[i]
+		if flow.Image == "" {
+			flow.Image = d.Image
+		}
+		if flow.Timeout == 0 {
+			flow.Timeout = d.Timeout
+		}
+		if flow.Image == "" {
+			return fmt.Errorf("workflow step %q: image is not set and the contest has no default", flow.Name)
+		}
+		mutable, err := validateImage(flow.Image)
+		if err != nil {
+			return fmt.Errorf("workflow step %q: %w", flow.Name, err)
+		}
+		if mutable {
+			zap.S().Warnf("problem %s, workflow step %q: image %s uses a mutable tag, pin it by digest for reproducible judging", p.ID, flow.Name, flow.Image)
+		}
+		if flow.Timeout <= 0 {
+			return fmt.Errorf("workflow step %q: timeout is not set and the contest has no default", flow.Name)
+		}
+
+		flow.Env = append(append([]string(nil), d.Env...), flow.Env...)
+		for j, env := range flow.Env {
+			key, _, ok := strings.Cut(env, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("workflow step %q: env entry %q is not KEY=value", flow.Name, env)
+			}
+			if strings.HasPrefix(key, "CSOJ_") {
+				return fmt.Errorf("workflow step %q: env variable %s is reserved", flow.Name, key)
+			}
+			expanded, err := expandTemplate(env, vars)
+			if err != nil {
+				return fmt.Errorf("workflow step %q: %w", flow.Name, err)
+			}
+			flow.Env[j] = expanded
+		}
+		for j := range flow.Mounts {
+			expanded, err := expandTemplate(flow.Mounts[j].Source, vars)
+			if err != nil {
+				return fmt.Errorf("workflow step %q: %w", flow.Name, err)
+			}
+			flow.Mounts[j].Source = expanded
+		}
+	}
+	return nil
+}
+
+func loadProblem(dir string, contest *Contest) (*Problem, error) {
 	problemPath := filepath.Join(dir, "problem.yaml")
 	data, err := os.ReadFile(problemPath)
 	if err != nil {
@@ -190,11 +743,93 @@ func loadProblem(dir string) (*Problem, error) {
 		return nil, err
 	}
 	problem.BasePath = dir // Set the base path
+	if err := applyDefaults(&problem, contest); err != nil {
+		return nil, err
+	}
 
 	// Set default score mode if not provided
 	if problem.Score.Mode == "" {
 		problem.Score.Mode = "score"
 	}
+	if problem.Score.Mode == "weighted" {
+		if problem.Score.FullScore == 0 {
+			problem.Score.FullScore = 100
+		}
+		if problem.Score.FullScore < 0 || problem.Score.Points < 0 || problem.Score.MinPoints < 0 || problem.Score.Decay < 0 {
+			return nil, fmt.Errorf("weighted score parameters must not be negative")
+		}
+	}
+	if problem.Score.Mode == "icpc" {
+		if problem.Score.FullScore == 0 {
+			problem.Score.FullScore = 100
+		}
+		if problem.Score.PenaltyMinutes == 0 {
+			problem.Score.PenaltyMinutes = 20
+		}
+		if problem.Score.FullScore < 0 || problem.Score.PenaltyMinutes < 0 {
+			return nil, fmt.Errorf("icpc score parameters must not be negative")
+		}
+	}
+
+	switch problem.ResultFormat {
+	case "":
+		problem.ResultFormat = ResultFormatJSON
+	case ResultFormatJSON, ResultFormatKeyValue:
+	case ResultFormatExitCode:
+		if problem.Score.Mode == "performance" {
+			return nil, fmt.Errorf("result_format %q reports no performance, it cannot be used with the performance score mode", problem.ResultFormat)
+		}
+		if problem.Score.FullScore == 0 {
+			problem.Score.FullScore = 100
+		}
+	default:
+		return nil, fmt.Errorf("unknown result_format %q, expected %q, %q or %q", problem.ResultFormat, ResultFormatJSON, ResultFormatExitCode, ResultFormatKeyValue)
+	}
+
+	if problem.Score.HistoryMinDelta < 0 || problem.Score.HistoryMinDeltaRatio < 0 {
+		return nil, fmt.Errorf("score history thresholds must not be negative")
+	}
+
+	switch problem.Duplicates {
+	case "":
+		problem.Duplicates = DuplicatesAllow
+	case DuplicatesAllow, DuplicatesReject:
+	default:
+		return nil, fmt.Errorf("unknown duplicate_submissions %q, expected %q or %q", problem.Duplicates, DuplicatesAllow, DuplicatesReject)
+	}
+
+	if problem.CooldownSeconds < 0 {
+		return nil, fmt.Errorf("cooldown_seconds must not be negative")
+	}
+	if problem.CPU < 0 {
+		return nil, fmt.Errorf("cpu must not be negative")
+	}
+	if problem.PinCores != nil && *problem.PinCores && problem.CPU != math.Trunc(problem.CPU) {
+		return nil, fmt.Errorf("cpu must be a whole number of cores when pin_cores is true, got %g", problem.CPU)
+	}
+
+	if err := resolveFixtures(&problem); err != nil {
+		return nil, err
+	}
+
+	// Make sure private mounts resolve inside the problem directory and security options are valid
+	for i := range problem.Workflow {
+		flow := &problem.Workflow[i]
+		if _, err := ResolveMounts(&problem, flow.Mounts); err != nil {
+			return nil, fmt.Errorf("workflow step %q: %w", flow.Name, err)
+		}
+		if err := resolveSecurity(&problem, flow); err != nil {
+			return nil, fmt.Errorf("workflow step %q: %w", flow.Name, err)
+		}
+		if err := validateNetworkName(flow.NetworkName); err != nil {
+			return nil, fmt.Errorf("workflow step %q: %w", flow.Name, err)
+		}
+		if flow.StdinFrom != "" {
+			if err := resolveStdinFrom(problem.Workflow, i); err != nil {
+				return nil, fmt.Errorf("workflow step %q: %w", flow.Name, err)
+			}
+		}
+	}
 
 	desc, _ := os.ReadFile(filepath.Join(dir, "index.md"))
 	problem.Description = string(desc)
@@ -0,0 +1,98 @@
+package judger
+
+import (
+	"testing"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+)
+
+func TestEffectiveUploadLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		problem UploadLimit
+		contest *config.UploadLimits
+		global  config.UploadLimits
+		want    UploadLimit
+	}{
+		{
+			name: "nothing set",
+			want: UploadLimit{},
+		},
+		{
+			name:    "problem only",
+			problem: UploadLimit{MaxNum: 3, MaxSize: 10, MaxDepth: 2},
+			want:    UploadLimit{MaxNum: 3, MaxSize: 10, MaxDepth: 2},
+		},
+		{
+			name:    "problem overrides contest",
+			problem: UploadLimit{MaxNum: 3, MaxSize: 10, MaxDepth: 2},
+			contest: &config.UploadLimits{MaxNum: 5, MaxSize: 50, MaxDepth: 4},
+			want:    UploadLimit{MaxNum: 3, MaxSize: 10, MaxDepth: 2},
+		},
+		{
+			name:    "unset problem limits inherit from contest",
+			problem: UploadLimit{MaxSize: 10},
+			contest: &config.UploadLimits{MaxNum: 5, MaxSize: 50, MaxDepth: 4},
+			want:    UploadLimit{MaxNum: 5, MaxSize: 10, MaxDepth: 4},
+		},
+		{
+			name:    "negative problem limits inherit like zero",
+			problem: UploadLimit{MaxNum: -1},
+			contest: &config.UploadLimits{MaxNum: 5},
+			want:    UploadLimit{MaxNum: 5},
+		},
+		{
+			name:    "contest overrides global",
+			contest: &config.UploadLimits{MaxNum: 5, MaxSize: 50},
+			global:  config.UploadLimits{MaxNum: 20, MaxSize: 100, MaxDepth: 6},
+			want:    UploadLimit{MaxNum: 5, MaxSize: 50, MaxDepth: 6},
+		},
+		{
+			name:    "unset at problem and contest inherit from global",
+			problem: UploadLimit{},
+			contest: &config.UploadLimits{},
+			global:  config.UploadLimits{MaxNum: 20, MaxSize: 100, MaxDepth: 6},
+			want:    UploadLimit{MaxNum: 20, MaxSize: 100, MaxDepth: 6},
+		},
+		{
+			name:    "no contest falls back to global",
+			problem: UploadLimit{MaxNum: 3},
+			global:  config.UploadLimits{MaxNum: 20, MaxSize: 100},
+			want:    UploadLimit{MaxNum: 3, MaxSize: 100},
+		},
+		{
+			name:    "global caps problem",
+			problem: UploadLimit{MaxNum: 50, MaxSize: 500, MaxDepth: 10},
+			global:  config.UploadLimits{MaxNum: 20, MaxSize: 100, MaxDepth: 6},
+			want:    UploadLimit{MaxNum: 20, MaxSize: 100, MaxDepth: 6},
+		},
+		{
+			name:    "global caps inherited contest limit",
+			contest: &config.UploadLimits{MaxSize: 500},
+			global:  config.UploadLimits{MaxSize: 100},
+			want:    UploadLimit{MaxSize: 100},
+		},
+		{
+			name:    "other fields are kept",
+			problem: UploadLimit{MaxExpandedSize: 64, AllowedExtensions: []string{".c"}},
+			global:  config.UploadLimits{MaxSize: 100},
+			want:    UploadLimit{MaxSize: 100, MaxExpandedSize: 64, AllowedExtensions: []string{".c"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contest *Contest
+			if tt.contest != nil {
+				contest = &Contest{Upload: *tt.contest}
+			}
+			got := EffectiveUploadLimit(&Problem{Upload: tt.problem}, contest, tt.global)
+			if got.MaxNum != tt.want.MaxNum || got.MaxSize != tt.want.MaxSize || got.MaxDepth != tt.want.MaxDepth {
+				t.Errorf("got max_num=%d max_size=%d max_depth=%d, want %d %d %d",
+					got.MaxNum, got.MaxSize, got.MaxDepth, tt.want.MaxNum, tt.want.MaxSize, tt.want.MaxDepth)
+			}
+			if got.MaxExpandedSize != tt.want.MaxExpandedSize || len(got.AllowedExtensions) != len(tt.want.AllowedExtensions) {
+				t.Errorf("other limits changed: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package judger
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ProblemPrivateToken is a mount source placeholder for the problem's private directory.
+	ProblemPrivateToken = "$PROBLEM_PRIVATE"
+	// ProblemPrivateDir is the problem subdirectory holding hidden data, such as reference outputs.
+	ProblemPrivateDir = "private"
+)
+
+// PrivateDir returns the absolute path of the problem's private directory.
+func (p *Problem) PrivateDir() (string, error) {
+	base, err := filepath.Abs(p.BasePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, ProblemPrivateDir), nil
+}
+
+// IsPrivatePath reports whether the given path lies inside the problem's private directory.
+func (p *Problem) IsPrivatePath(path string) bool {
+	privateDir, err := p.PrivateDir()
+	if err != nil {
+		return true
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+	return absPath == privateDir || strings.HasPrefix(absPath, privateDir+string(filepath.Separator))
+}
+
+// ResolveMounts expands the $PROBLEM_PRIVATE placeholder in mount sources. Private mounts are
+// always read-only bind mounts that must stay inside the problem's private directory.
+func ResolveMounts(p *Problem, mounts []Mount) ([]Mount, error) {
+	resolved := make([]Mount, 0, len(mounts))
+	for _, mnt := range mounts {
+		if !strings.HasPrefix(mnt.Source, ProblemPrivateToken) {
+			resolved = append(resolved, mnt)
+			continue
+		}
+
+		rest := strings.TrimPrefix(mnt.Source, ProblemPrivateToken)
+		if rest != "" && !strings.HasPrefix(rest, "/") {
+			return nil, fmt.Errorf("invalid private mount source %q", mnt.Source)
+		}
+		privateDir, err := p.PrivateDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve private directory: %w", err)
+		}
+		source := filepath.Join(privateDir, filepath.FromSlash(rest))
+		if source != privateDir && !strings.HasPrefix(source, privateDir+string(filepath.Separator)) {
+			return nil, fmt.Errorf("private mount source %q escapes the problem directory", mnt.Source)
+		}
+
+		readOnly := true
+		mnt.Type = "bind"
+		mnt.Source = source
+		mnt.ReadOnly = &readOnly
+		resolved = append(resolved, mnt)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,59 @@
+package judger
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+const rateLimitedNotice = "\n[output rate-limited]\n"
+
+// outputLimiter decides which chunks of a container's output are streamed live. It counts
+// lines and bytes in one-second windows; a chunk that would exceed either limit is dropped, and
+// the first drop of a window is replaced by a notice. A zero limit means unlimited.
+type outputLimiter struct {
+	lines, bytes int
+
+	windowStart              time.Time
+	windowLines, windowBytes int
+	dropped                  int // bytes dropped in the current window
+}
+
+func newOutputLimiter(lines, bytes int) *outputLimiter {
+	return &outputLimiter{lines: lines, bytes: bytes}
+}
+
+// allow reports whether a chunk may be streamed. notice, if not empty, is streamed before it
+// or in its place.
+func (l *outputLimiter) allow(data []byte) (ok bool, notice string) {
+	now := time.Now()
+	if now.Sub(l.windowStart) >= time.Second {
+		notice = l.flush()
+		l.windowStart = now
+		l.windowLines, l.windowBytes = 0, 0
+	}
+
+	lines := bytes.Count(data, []byte{'\n'})
+	// The first chunk of a window always passes, so chunks larger than a limit still get through.
+	empty := l.windowLines == 0 && l.windowBytes == 0
+	if !empty && ((l.lines > 0 && l.windowLines+lines > l.lines) || (l.bytes > 0 && l.windowBytes+len(data) > l.bytes)) {
+		if l.dropped == 0 {
+			notice += rateLimitedNotice
+		}
+		l.dropped += len(data)
+		return false, notice
+	}
+	l.windowLines += lines
+	l.windowBytes += len(data)
+	return true, notice
+}
+
+// flush returns a summary of the output dropped since the last flush, if any.
+func (l *outputLimiter) flush() string {
+	if l.dropped == 0 {
+		return ""
+	}
+	notice := fmt.Sprintf("\n[output rate-limited: %d bytes not streamed, see the stored log]\n", l.dropped)
+	l.dropped = 0
+	return notice
+}
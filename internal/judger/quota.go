@@ -0,0 +1,84 @@
+package judger
+
+import (
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+)
+
+// ContestQuota caps the compute each user's submissions may use in a contest. 0 leaves a limit
+// unset.
+type ContestQuota struct {
+	CPUSeconds float64 `yaml:"cpu_seconds" json:"cpu_seconds,omitempty"` // container run time times the problem's cpu
+	Runs       int     `yaml:"runs" json:"runs,omitempty"`               // judged submissions
+}
+
+// QuotaStatus is a user's usage measured against a contest's quota. Nil limits are unlimited.
+type QuotaStatus struct {
+	CPUSeconds          float64  `json:"cpu_seconds"`
+	CPUSecondsLimit     *float64 `json:"cpu_seconds_limit"`
+	CPUSecondsRemaining *float64 `json:"cpu_seconds_remaining"`
+	Runs                int      `json:"runs"` // charged runs plus the submissions still queued or running
+	RunsLimit           *int     `json:"runs_limit"`
+	RunsRemaining       *int     `json:"runs_remaining"`
+	Exhausted           bool     `json:"exhausted"` // no further submissions are accepted
+}
+
+// QuotaStatus applies the contest's quota, or the limits an admin set for the user, to the user's
+// usage. pending is the number of their submissions still queued or running, which are counted as
+// runs already so a burst of submissions cannot overshoot the quota.
+func (c *Contest) QuotaStatus(usage models.ContestUsage, pending int) QuotaStatus {
+	status := QuotaStatus{CPUSeconds: usage.CPUSeconds, Runs: usage.Runs + pending}
+	cpuLimit := c.Quota.CPUSeconds
+	if usage.CPUSecondsLimit != nil {
+		cpuLimit = *usage.CPUSecondsLimit
+	}
+	runsLimit := c.Quota.Runs
+	if usage.RunsLimit != nil {
+		runsLimit = *usage.RunsLimit
+	}
+	if cpuLimit > 0 {
+		remaining := max(cpuLimit-status.CPUSeconds, 0)
+		status.CPUSecondsLimit, status.CPUSecondsRemaining = &cpuLimit, &remaining
+		status.Exhausted = remaining == 0
+	}
+	if runsLimit > 0 {
+		remaining := max(runsLimit-status.Runs, 0)
+		status.RunsLimit, status.RunsRemaining = &runsLimit, &remaining
+		status.Exhausted = status.Exhausted || remaining == 0
+	}
+	return status
+}
+
+// chargeUsage adds a dispatch of the submission to its user's usage of the contest: one run and
+// the run time of the containers started since the dispatch began, times the problem's cpu.
+// Problems without a cpu request are charged as one core. Dispatches that never started a
+// container, and submissions an admin queued, are not charged.
+func (d *Dispatcher) chargeUsage(sub *models.Submission, prob *Problem, contestID string, since time.Time) {
+	if contestID == "" || sub.AdminQueued {
+		return
+	}
+	log := submissionLogger(sub)
+	var containers []models.Container
+	if err := d.db.Where("submission_id = ? AND created_at >= ?", sub.ID, since).Find(&containers).Error; err != nil {
+		log.Errorf("failed to load containers of submission %s to charge its usage: %v", sub.ID, err)
+		return
+	}
+	if len(containers) == 0 {
+		return
+	}
+	var seconds float64
+	for _, cont := range containers {
+		if !cont.StartedAt.IsZero() && cont.FinishedAt.After(cont.StartedAt) {
+			seconds += cont.FinishedAt.Sub(cont.StartedAt).Seconds()
+		}
+	}
+	cpu := prob.CPU
+	if cpu <= 0 {
+		cpu = 1
+	}
+	if err := database.AddContestUsage(d.db, sub.UserID, contestID, seconds*cpu); err != nil {
+		log.Errorf("failed to charge usage of submission %s: %v", sub.ID, err)
+	}
+}
@@ -0,0 +1,42 @@
+package judger
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+)
+
+func TestChargeUsageSkipsAdminQueued(t *testing.T) {
+	db, err := database.Init(database.DriverSQLite, filepath.Join(t.TempDir(), "csoj.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	d := &Dispatcher{db: db}
+	since := time.Now().Add(-time.Minute)
+	start := time.Now().Add(-30 * time.Second)
+
+	for _, sub := range []*models.Submission{
+		{ID: "user-run", UserID: "u", ProblemID: "p"},
+		{ID: "rejudge", UserID: "u", ProblemID: "p", AdminQueued: true},
+	} {
+		if err := db.Omit("User").Create(sub).Error; err != nil {
+			t.Fatalf("create submission: %v", err)
+		}
+		cont := models.Container{ID: sub.ID + "-c", SubmissionID: sub.ID, UserID: "u", StartedAt: start, FinishedAt: start.Add(10 * time.Second)}
+		if err := db.Omit("User").Create(&cont).Error; err != nil {
+			t.Fatalf("create container: %v", err)
+		}
+		d.chargeUsage(sub, &Problem{CPU: 2}, "c", since)
+	}
+
+	usage, err := database.GetContestUsage(db, "u", "c")
+	if err != nil {
+		t.Fatalf("get usage: %v", err)
+	}
+	if usage.Runs != 1 || usage.CPUSeconds != 20 {
+		t.Errorf("got %d runs and %v cpu seconds, want only the user's run: 1 and 20", usage.Runs, usage.CPUSeconds)
+	}
+}
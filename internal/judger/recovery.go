@@ -74,7 +74,7 @@ func RecoverAndCleanup(db *gorm.DB, cfg *config.Config) error {
 	for dockerCfg, containers := range containersByDockerConfig {
 		host := dockerCfg.Host
 		zap.S().Infof("connecting to Docker host %s to clean up %d containers", host, len(containers))
-		docker, err := NewDockerManager(dockerCfg)
+		docker, err := GetDockerManager(dockerCfg)
 		if err != nil {
 			zap.S().Errorf("failed to create Docker manager for host %s: %v. Skipping cleanup for this host.", host, err)
 			continue
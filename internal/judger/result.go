@@ -0,0 +1,104 @@
+package judger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Judge result formats, set per problem with result_format.
+const (
+	ResultFormatJSON     = "json"      // the final step prints a JudgeResult as JSON
+	ResultFormatExitCode = "exit_code" // exit code 0 scores score.full_score, anything else scores 0
+	ResultFormatKeyValue = "keyvalue"  // the final step prints key=value lines
+)
+
+// exitCodeError reports a step command that exited with a non-zero code.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("exec failed with exit code %d: %v", e.code, e.err)
+	}
+	return fmt.Sprintf("exec failed with exit code %d", e.code)
+}
+
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+// parseJudgeResult derives the judge result from the output and exit code of the final step.
+func parseJudgeResult(prob *Problem, stdout string, exitCode int) (JudgeResult, error) {
+	switch prob.ResultFormat {
+	case ResultFormatExitCode:
+		if exitCode != 0 {
+			return JudgeResult{Score: 0, Info: map[string]interface{}{"exit_code": exitCode, "message": "Wrong answer"}}, nil
+		}
+		return JudgeResult{Score: prob.Score.FullScore, Info: map[string]interface{}{"exit_code": 0, "message": "Accepted"}}, nil
+	case ResultFormatKeyValue:
+		return parseKeyValueResult(stdout)
+	default:
+		var tempResult tempJudgeResult
+		if err := json.Unmarshal([]byte(stdout), &tempResult); err != nil {
+			return JudgeResult{}, err
+		}
+		return JudgeResult{
+			Score:       int(math.Round((tempResult.Score))),
+			Performance: tempResult.Performance,
+			Info:        tempResult.Info,
+			Subtasks:    tempResult.Subtasks,
+		}, nil
+	}
+}
+
+// parseKeyValueResult reads "key=value" lines. score is required and performance optional; all
+// other keys go to info, as numbers where they parse as one. Blank lines and lines starting with
+// "#" are ignored, and a repeated key keeps its last value.
+func parseKeyValueResult(stdout string) (JudgeResult, error) {
+	result := JudgeResult{Info: make(map[string]interface{})}
+	hasScore := false
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" {
+			return JudgeResult{}, fmt.Errorf("line %d is not a key=value pair: %q", line, text)
+		}
+		switch key {
+		case "score":
+			score, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return JudgeResult{}, fmt.Errorf("invalid score %q", value)
+			}
+			result.Score = int(math.Round(score))
+			hasScore = true
+		case "performance":
+			performance, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return JudgeResult{}, fmt.Errorf("invalid performance %q", value)
+			}
+			result.Performance = performance
+		default:
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				result.Info[key] = n
+			} else {
+				result.Info[key] = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return JudgeResult{}, err
+	}
+	if !hasScore {
+		return JudgeResult{}, fmt.Errorf("no score line in judge output")
+	}
+	return result, nil
+}
@@ -0,0 +1,131 @@
+package judger
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database"
+)
+
+// ScalingSignal summarizes the load of a cluster for external autoscalers, together with the
+// node count they should aim for.
+type ScalingSignal struct {
+	Cluster              string   `json:"cluster"`
+	Nodes                int      `json:"nodes"`        // configured nodes
+	ActiveNodes          int      `json:"active_nodes"` // configured nodes that are not paused
+	Queued               int      `json:"queued"`
+	Running              int64    `json:"running"`
+	Capacity             int      `json:"capacity"`          // submissions the active nodes run at once
+	SlotsPerNode         float64  `json:"slots_per_node"`    // configured, or estimated from the cluster's problems
+	FinishedRecently     int      `json:"finished_recently"` // submissions that finished within the window
+	WindowSeconds        int      `json:"window_seconds"`
+	AverageRunSeconds    *float64 `json:"average_run_seconds"`    // null without recently finished submissions
+	EstimatedWaitSeconds *float64 `json:"estimated_wait_seconds"` // null if it cannot be estimated
+	DesiredNodes         int      `json:"desired_nodes"`
+}
+
+// ScalingSignals returns the scaling signal of every cluster, sorted by name.
+func (s *Scheduler) ScalingSignals(now time.Time) ([]ScalingSignal, error) {
+	s.appState.RLock()
+	problemsByCluster := make(map[string][]*Problem)
+	for _, p := range s.appState.Problems {
+		problemsByCluster[p.Cluster] = append(problemsByCluster[p.Cluster], p)
+	}
+	s.appState.RUnlock()
+
+	queueLengths := s.GetQueueLengths()
+	signals := make([]ScalingSignal, 0, len(s.clusters))
+	for name, cluster := range s.clusters {
+		cfg := cluster.Scaling
+		signal := ScalingSignal{
+			Cluster:       name,
+			Nodes:         len(cluster.Nodes),
+			Queued:        queueLengths[name],
+			Running:       atomic.LoadInt64(&cluster.Running),
+			WindowSeconds: int(cfg.Window().Seconds()),
+		}
+
+		capacity := 0.0
+		cluster.Lock()
+		for _, node := range cluster.Nodes {
+			node.Lock()
+			if !node.IsPaused {
+				signal.ActiveNodes++
+				capacity += node.averageSlots(problemsByCluster[name], cfg.SlotsPerNode)
+			}
+			node.Unlock()
+		}
+		cluster.Unlock()
+		signal.Capacity = int(capacity)
+		signal.SlotsPerNode = float64(cfg.SlotsPerNode)
+		if cfg.SlotsPerNode == 0 {
+			signal.SlotsPerNode = 1
+			if signal.ActiveNodes > 0 && capacity > 0 {
+				signal.SlotsPerNode = capacity / float64(signal.ActiveNodes)
+			}
+		}
+
+		finished, avgRun, err := database.GetClusterThroughput(s.db, name, now.Add(-cfg.Window()))
+		if err != nil {
+			return nil, err
+		}
+		signal.FinishedRecently = finished
+		if finished > 0 {
+			seconds := avgRun.Seconds()
+			signal.AverageRunSeconds = &seconds
+			if signal.Capacity > 0 {
+				wait := float64(signal.Queued) * seconds / float64(signal.Capacity)
+				signal.EstimatedWaitSeconds = &wait
+			}
+		}
+		signal.DesiredNodes = desiredNodes(cfg, cluster.MaxConcurrent, signal.Queued, signal.Running, avgRun, signal.SlotsPerNode)
+		signals = append(signals, signal)
+	}
+	sort.Slice(signals, func(i, j int) bool { return signals[i].Cluster < signals[j].Cluster })
+	return signals, nil
+}
+
+// averageSlots returns how many submissions the idle node runs at once: the configured slots, or
+// the average over the given problems, 1 if there are none. The caller must hold the node lock.
+func (n *NodeState) averageSlots(problems []*Problem, configured int) float64 {
+	if configured > 0 {
+		return float64(configured)
+	}
+	if len(problems) == 0 {
+		return 1
+	}
+	total := 0
+	for _, p := range problems {
+		total += n.fitCount(p, make([]bool, len(n.UsedCores)), 0, 0)
+	}
+	return float64(total) / float64(len(problems))
+}
+
+// desiredNodes recommends a node count for the running submissions plus enough slots for the
+// queued ones to start within the target wait, keeping the target utilization. With avgRun
+// unknown, every queued submission gets a slot. Slots beyond the cluster's max_concurrent are
+// never requested.
+func desiredNodes(cfg config.ClusterScaling, maxConcurrent, queued int, running int64, avgRun time.Duration, slotsPerNode float64) int {
+	demand := float64(running)
+	if queued > 0 {
+		if avgRun > 0 {
+			// Each slot runs about wait/avgRun submissions within the target wait.
+			demand += max(float64(queued)*avgRun.Seconds()/cfg.TargetWait().Seconds(), 1)
+		} else {
+			demand += float64(queued)
+		}
+	}
+	if maxConcurrent > 0 {
+		demand = min(demand, float64(maxConcurrent))
+	}
+	// The epsilon keeps float noise from adding a node when the demand fits exactly.
+	nodes := int(math.Ceil(demand/cfg.Utilization()/slotsPerNode - 1e-9))
+	nodes = max(nodes, cfg.MinNodes)
+	if cfg.MaxNodes > 0 {
+		nodes = min(nodes, cfg.MaxNodes)
+	}
+	return nodes
+}
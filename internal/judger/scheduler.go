@@ -2,12 +2,17 @@ package judger
 
 import (
 	"fmt"
+	"math"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database"
 	"github.com/ZJUSCT/CSOJ/internal/database/models"
 
 	"go.uber.org/zap"
@@ -25,27 +30,55 @@ type AppState struct {
 type NodeState struct {
 	sync.Mutex
 	*config.Node
-	UsedMemory int64  `json:"used_memory"`
-	UsedCores  []bool `json:"used_cores"`
-	IsPaused   bool   `json:"is_paused"`
+	UsedMemory   int64  `json:"used_memory"`
+	UsedMilliCPU int64  `json:"used_milli_cpu"` // pinned cores count as 1000 each
+	UsedCores    []bool `json:"used_cores"`
+	IsPaused     bool   `json:"is_paused"`
+
+	running map[string]*allocation // submission ID -> resources held on this node
+}
+
+// allocation records the resources held by a running submission and when it is expected to finish.
+type allocation struct {
+	cores       []int
+	milliCPU    int64
+	memory      int64
+	expectedEnd time.Time
 }
 
 type NodeDetail struct {
 	*config.Node
-	UsedMemory int64  `json:"used_memory"`
-	UsedCores  []bool `json:"used_cores"`
-	IsPaused   bool   `json:"is_paused"`
+	UsedMemory   int64  `json:"used_memory"`
+	UsedMilliCPU int64  `json:"used_milli_cpu"`
+	UsedCores    []bool `json:"used_cores"`
+	IsPaused     bool   `json:"is_paused"`
 }
 
 type ClusterState struct {
 	sync.Mutex
 	*config.Cluster
-	Nodes map[string]*NodeState `json:"nodes"`
+	Nodes   map[string]*NodeState `json:"nodes"`
+	Running int64                 `json:"running"` // running submissions, guarded by atomic ops
 }
 
 type QueuedSubmission struct {
 	Submission *models.Submission
 	Problem    *Problem
+	EnqueuedAt time.Time // when the job joined its current queue, set by push
+}
+
+// QueueEntry describes a job waiting in a cluster queue, see Scheduler.QueueSnapshot.
+type QueueEntry struct {
+	Position     int        `json:"position"` // jobs ahead of it in scheduling order
+	SubmissionID string     `json:"submission_id"`
+	UserID       string     `json:"user_id"`
+	ProblemID    string     `json:"problem_id"`
+	CPU          float64    `json:"cpu"`
+	Memory       int64      `json:"memory"`
+	CreatedAt    time.Time  `json:"created_at"`
+	EnqueuedAt   time.Time  `json:"enqueued_at"`
+	Starting     bool       `json:"starting"`                // a worker is trying to start it right now
+	StalledSince *time.Time `json:"stalled_since,omitempty"` // no node of the cluster can run it
 }
 
 type Scheduler struct {
@@ -53,13 +86,15 @@ type Scheduler struct {
 	db         *gorm.DB
 	clusters   map[string]*ClusterState
 	appState   *AppState
-	queues     map[string]chan QueuedSubmission
+	queues     map[string]*clusterQueue
 	dispatcher *Dispatcher
+
+	runningTotal int64 // running submissions across all clusters, guarded by atomic ops
 }
 
 func NewScheduler(cfg *config.Config, db *gorm.DB, appState *AppState) *Scheduler {
 	clusters := make(map[string]*ClusterState)
-	queues := make(map[string]chan QueuedSubmission)
+	queues := make(map[string]*clusterQueue)
 	for i := range cfg.Cluster {
 		cluster := cfg.Cluster[i]
 		clusterState := &ClusterState{
@@ -75,10 +110,11 @@ func NewScheduler(cfg *config.Config, db *gorm.DB, appState *AppState) *Schedule
 				UsedMemory: 0,
 				UsedCores:  nodeCores,
 				IsPaused:   false,
+				running:    make(map[string]*allocation),
 			}
 		}
 		clusters[cluster.Name] = clusterState
-		queues[cluster.Name] = make(chan QueuedSubmission, 1024)
+		queues[cluster.Name] = newClusterQueue()
 	}
 
 	scheduler := &Scheduler{
@@ -95,8 +131,8 @@ func NewScheduler(cfg *config.Config, db *gorm.DB, appState *AppState) *Schedule
 // RequeuePendingSubmissions loads submissions with 'Queued' status from the DB
 // and adds them back to the scheduler's queue on startup.
 func RequeuePendingSubmissions(db *gorm.DB, s *Scheduler, appState *AppState) error {
-	var pendingSubs []models.Submission
-	if err := db.Model(&models.Submission{}).Where("status = ?", models.StatusQueued).Order("created_at asc").Find(&pendingSubs).Error; err != nil {
+	pendingSubs, err := database.GetQueuedSubmissions(db)
+	if err != nil {
 		return err
 	}
 
@@ -131,10 +167,11 @@ func (s *Scheduler) GetClusterStates() map[string]ClusterState {
 			// Create a copy to avoid exposing internal state directly
 			nodeStateCopy := *node.Node
 			nodeSnapshots[nodeName] = &NodeState{
-				Node:       &nodeStateCopy,
-				UsedMemory: node.UsedMemory,
-				IsPaused:   node.IsPaused,
-				UsedCores:  append([]bool(nil), node.UsedCores...),
+				Node:         &nodeStateCopy,
+				UsedMemory:   node.UsedMemory,
+				UsedMilliCPU: node.UsedMilliCPU,
+				IsPaused:     node.IsPaused,
+				UsedCores:    append([]bool(nil), node.UsedCores...),
 			}
 			node.Unlock()
 		}
@@ -142,6 +179,7 @@ func (s *Scheduler) GetClusterStates() map[string]ClusterState {
 		snapshot[name] = ClusterState{
 			Cluster: &clusterConfigCopy,
 			Nodes:   nodeSnapshots,
+			Running: atomic.LoadInt64(&cluster.Running),
 		}
 		cluster.Unlock()
 	}
@@ -164,10 +202,11 @@ func (s *Scheduler) GetNodeDetails(clusterName, nodeName string) (*NodeDetail, e
 
 	nodeConfigCopy := *node.Node
 	details := &NodeDetail{
-		Node:       &nodeConfigCopy,
-		UsedMemory: node.UsedMemory,
-		IsPaused:   node.IsPaused,
-		UsedCores:  append([]bool(nil), node.UsedCores...), // Return a copy
+		Node:         &nodeConfigCopy,
+		UsedMemory:   node.UsedMemory,
+		UsedMilliCPU: node.UsedMilliCPU,
+		IsPaused:     node.IsPaused,
+		UsedCores:    append([]bool(nil), node.UsedCores...), // Return a copy
 	}
 
 	return details, nil
@@ -209,18 +248,99 @@ func (s *Scheduler) ResumeNode(clusterName, nodeName string) error {
 	return nil
 }
 
+// GetRunningCounts returns the number of running submissions per cluster.
+func (s *Scheduler) GetRunningCounts() map[string]int64 {
+	counts := make(map[string]int64, len(s.clusters))
+	for name, cluster := range s.clusters {
+		counts[name] = atomic.LoadInt64(&cluster.Running)
+	}
+	return counts
+}
+
 func (s *Scheduler) GetQueueLengths() map[string]int {
 	lengths := make(map[string]int)
 	for name, queue := range s.queues {
-		lengths[name] = len(queue)
+		lengths[name] = queue.len()
 	}
 	return lengths
 }
 
+// QueueSnapshot returns the jobs waiting in a cluster's queue in the order the workers consider
+// them. Jobs further back may still start first by backfilling around the head.
+func (s *Scheduler) QueueSnapshot(clusterName string) ([]QueueEntry, error) {
+	queue, ok := s.queues[clusterName]
+	if !ok {
+		return nil, fmt.Errorf("cluster '%s' not found", clusterName)
+	}
+	return queue.entries(), nil
+}
+
+// EstimateSlots returns how many more submissions of the problem could start right now and how
+// many could run at once on the idle cluster, both capped by the concurrency limits. A problem
+// that requests neither CPU nor memory counts as one per node. ok is false if the problem's
+// cluster does not exist.
+func (s *Scheduler) EstimateSlots(problem *Problem) (free, total int, ok bool) {
+	cluster, ok := s.clusters[problem.Cluster]
+	if !ok {
+		return 0, 0, false
+	}
+	cluster.Lock()
+	for _, node := range cluster.Nodes {
+		node.Lock()
+		if !node.IsPaused {
+			free += node.fitCount(problem, node.UsedCores, node.UsedMemory, node.UsedMilliCPU)
+			total += node.fitCount(problem, make([]bool, len(node.UsedCores)), 0, 0)
+		}
+		node.Unlock()
+	}
+	cluster.Unlock()
+
+	if limit := cluster.MaxConcurrent; limit > 0 {
+		free = min(free, max(limit-int(atomic.LoadInt64(&cluster.Running)), 0))
+		total = min(total, limit)
+	}
+	if limit := s.cfg.MaxConcurrentTotal; limit > 0 {
+		free = min(free, max(limit-int(atomic.LoadInt64(&s.runningTotal)), 0))
+		total = min(total, limit)
+	}
+	return free, total, true
+}
+
+// fitCount returns how many copies of the problem fit next to the given usage of the node.
+// The caller must hold the node lock.
+func (n *NodeState) fitCount(problem *Problem, usedCores []bool, usedMemory, usedMilliCPU int64) int {
+	count := -1 // unlimited
+	limit := func(c int) {
+		if count < 0 || c < count {
+			count = c
+		}
+	}
+	if problem.Memory > 0 {
+		limit(int(max(n.Memory-usedMemory, 0) / problem.Memory))
+	}
+	if milli := problem.MilliCPU(); milli > 0 {
+		limit(int(max(n.capacityMilliCPU()-usedMilliCPU, 0) / milli))
+	}
+	if k := problem.PinnedCores(); k > 0 {
+		blocks := 0
+		for i := 0; i+k <= len(usedCores); i += k {
+			if !slices.Contains(usedCores[i:i+k], true) {
+				blocks++
+			}
+		}
+		limit(blocks)
+	}
+	if count < 0 {
+		return 1
+	}
+	return count
+}
+
 func (s *Scheduler) Submit(submission *models.Submission, problem *Problem) {
-	clusterName := problem.Cluster
+	clusterName := routeCluster(submission, problem)
 	if queue, ok := s.queues[clusterName]; ok {
-		queue <- QueuedSubmission{Submission: submission, Problem: problem}
+		position := queue.push(QueuedSubmission{Submission: submission, Problem: problem})
+		PublishSubmissionStatus(submission, int64(position))
 		zap.S().Infof("submission %s for problem %s added to queue for cluster '%s'", submission.ID, problem.ID, clusterName)
 	} else {
 		zap.S().Errorf("submission %s for problem %s has an invalid cluster '%s', dropping", submission.ID, problem.ID, clusterName)
@@ -233,73 +353,426 @@ func (s *Scheduler) Submit(submission *models.Submission, problem *Problem) {
 	}
 }
 
+// Requeue adds a queued submission to its cluster queue unless it is already waiting in one, so
+// submissions that were lost from the queues can be recovered without a restart. It reports
+// whether the submission was added, and fails without touching the submission if its cluster
+// does not exist.
+func (s *Scheduler) Requeue(submission *models.Submission, problem *Problem) (bool, error) {
+	clusterName := routeCluster(submission, problem)
+	if _, ok := s.queues[clusterName]; !ok {
+		return false, fmt.Errorf("cluster '%s' does not exist", clusterName)
+	}
+	for _, queue := range s.queues {
+		if queue.contains(submission.ID) {
+			return false, nil
+		}
+	}
+	s.Submit(submission, problem)
+	return true, nil
+}
+
 func (s *Scheduler) Run() {
 	for clusterName, queue := range s.queues {
-		go s.clusterWorker(clusterName, queue)
+		for i := range s.clusters[clusterName].WorkerCount() {
+			go s.clusterWorker(clusterName, queue, i)
+		}
 	}
 }
 
-func (s *Scheduler) clusterWorker(clusterName string, queue <-chan QueuedSubmission) {
-	zap.S().Infof("starting worker for cluster '%s'", clusterName)
-	for job := range queue {
-		var node *NodeState
-		var allocatedCores []int
-		zap.S().Infof("processing submission %s for cluster '%s'", job.Submission.ID, clusterName)
+// clusterWorker matches queued jobs of a cluster to free nodes. A cluster may run several
+// workers; they coordinate through the claims of the queue, so each job is handled by one
+// worker at a time.
+func (s *Scheduler) clusterWorker(clusterName string, queue *clusterQueue, id int) {
+	zap.S().Infof("starting worker %d for cluster '%s'", id, clusterName)
+	for {
+		if s.schedulePass(clusterName, queue) {
+			// Something started; look again right away in case more jobs fit.
+			continue
+		}
+		select {
+		case <-queue.notify:
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
 
-		for {
-			var currentSub models.Submission
-			if err := s.db.First(&currentSub, "id = ?", job.Submission.ID).Error; err != nil {
-				if err == gorm.ErrRecordNotFound {
-					zap.S().Warnf("submission %s was deleted from DB, dropping job.", job.Submission.ID)
-				} else {
-					zap.S().Errorf("failed to refetch submission %s from DB: %v", job.Submission.ID, err)
-				}
-				node = nil
-				break
+// schedulePass tries to start the job at the head of the queue. If it does not fit, jobs further
+// back are backfilled onto idle resources as long as they do not delay the head job's reserved
+// start (EASY backfill). It reports whether a job was started.
+func (s *Scheduler) schedulePass(clusterName string, queue *clusterQueue) bool {
+	s.rerouteStalled(clusterName, queue)
+	if !s.acquireSlot() {
+		// The global cap is reached; everything stays queued until a slot is released.
+		return false
+	}
+	cluster := s.clusters[clusterName]
+	if !cluster.acquireSlot() {
+		s.releaseSlot()
+		return false
+	}
+	started := false
+	defer func() {
+		if !started {
+			cluster.releaseSlot()
+			s.releaseSlot()
+		}
+	}()
+
+	var head *QueuedSubmission
+	var res *reservation
+	for _, job := range queue.snapshot() {
+		job := job
+		if head == nil {
+			head = &job
+			if !queue.claim(job.Submission.ID) {
+				// Another worker is starting the head job; keep its place reserved anyway.
+				res = s.reserve(clusterName, head)
+				continue
 			}
-			if currentSub.Status != models.StatusQueued {
-				zap.S().Infof("submission %s is no longer in queued status (%s), skipping processing.", currentSub.ID, currentSub.Status)
-				node = nil
-				break
+			if !s.refreshQueuedJob(clusterName, queue, &job) {
+				queue.unclaim(job.Submission.ID)
+				head = nil
+				continue
+			}
+			zap.S().Debugf("searching for available node for submission %s in cluster %s", job.Submission.ID, clusterName)
+			if node, cores := s.allocate(clusterName, &job, nil); node != nil {
+				started = true
+				s.startJob(clusterName, queue, &job, node, cores)
+				return true
 			}
+			queue.unclaim(job.Submission.ID)
+			res = s.reserve(clusterName, head)
+			continue
+		}
+
+		// Backfill: only touch the DB once the job is known to fit.
+		if !s.fitsNow(clusterName, &job, res) || !queue.claim(job.Submission.ID) {
+			continue
+		}
+		if !s.refreshQueuedJob(clusterName, queue, &job) {
+			queue.unclaim(job.Submission.ID)
+			continue
+		}
+		if node, cores := s.allocate(clusterName, &job, res); node != nil {
+			zap.S().Infof("backfilling submission %s ahead of %s", job.Submission.ID, head.Submission.ID)
+			started = true
+			s.startJob(clusterName, queue, &job, node, cores)
+			return true
+		}
+		queue.unclaim(job.Submission.ID)
+	}
+	return false
+}
+
+// refreshQueuedJob reloads the job's submission and drops it from the queue if it was deleted
+// or is no longer queued (e.g. interrupted).
+func (s *Scheduler) refreshQueuedJob(clusterName string, queue *clusterQueue, job *QueuedSubmission) bool {
+	var currentSub models.Submission
+	if err := s.db.First(&currentSub, "id = ?", job.Submission.ID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			zap.S().Warnf("submission %s was deleted from DB, dropping job.", job.Submission.ID)
+			queue.remove(job.Submission.ID)
+			s.publishQueuePositions(clusterName)
+		} else {
+			zap.S().Errorf("failed to refetch submission %s from DB: %v", job.Submission.ID, err)
+		}
+		return false
+	}
+	if currentSub.Status != models.StatusQueued {
+		zap.S().Infof("submission %s is no longer in queued status (%s), skipping processing.", currentSub.ID, currentSub.Status)
+		queue.remove(currentSub.ID)
+		s.publishQueuePositions(clusterName)
+		return false
+	}
+	job.Submission = &currentSub
+	return true
+}
+
+// startJob marks a claimed job as running and dispatches it. The claim is given up on return.
+func (s *Scheduler) startJob(clusterName string, queue *clusterQueue, job *QueuedSubmission, node *NodeState, allocatedCores []int) {
+	defer queue.unclaim(job.Submission.ID)
+	if !queue.remove(job.Submission.ID) {
+		// Withdrawn by the user (RemoveQueued) after the job was picked.
+		zap.S().Infof("submission %s was withdrawn before it started", job.Submission.ID)
+		s.ReleaseResources(job.Submission.ID)
+		return
+	}
+	zap.S().Infof("node %s assigned to submission %s", node.Name, job.Submission.ID)
+
+	var coreStrs []string
+	for _, c := range allocatedCores {
+		coreStrs = append(coreStrs, strconv.Itoa(c))
+	}
+
+	job.Submission.Cluster = clusterName
+	job.Submission.Node = node.Name
+	job.Submission.Status = models.StatusRunning
+	job.Submission.AllocatedCores = strings.Join(coreStrs, ",")
+
+	// Only a submission that is still queued may start, in case it was interrupted since it
+	// was read.
+	result := s.db.Model(&models.Submission{}).
+		Where("id = ? AND status = ?", job.Submission.ID, models.StatusQueued).
+		Updates(map[string]interface{}{
+			"cluster":         job.Submission.Cluster,
+			"node":            job.Submission.Node,
+			"status":          job.Submission.Status,
+			"allocated_cores": job.Submission.AllocatedCores,
+		})
+	if result.Error != nil {
+		zap.S().Errorf("failed to update submission status for %s: %v", job.Submission.ID, result.Error)
+		s.ReleaseResources(job.Submission.ID)
+		return
+	}
+	if result.RowsAffected == 0 {
+		zap.S().Infof("submission %s left the queued status before it started", job.Submission.ID)
+		s.ReleaseResources(job.Submission.ID)
+		s.publishQueuePositions(clusterName)
+		return
+	}
+	PublishSubmissionStatus(job.Submission, 0)
+	s.publishQueuePositions(clusterName)
 
-			job.Submission = &currentSub
+	go s.dispatcher.Dispatch(job.Submission, job.Problem, node, allocatedCores)
+}
 
-			zap.S().Debugf("searching for available node for submission %s in cluster %s", currentSub.ID, clusterName)
-			node, allocatedCores = s.findAvailableNode(clusterName, job.Problem.CPU, job.Problem.Memory)
-			if node != nil {
+// RemoveQueued removes a submission from its cluster queue. Once it returns true, the scheduler
+// will not start the submission anymore. Callers should publish the new queue positions with
+// PublishQueuePositions after updating the database.
+func (s *Scheduler) RemoveQueued(submissionID string) bool {
+	for _, queue := range s.queues {
+		if queue.remove(submissionID) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunPostSubmissionHook starts the post-submission hook for a submission finished outside the
+// dispatcher, such as by an interrupt. The hook runs at most once per submission.
+func (s *Scheduler) RunPostSubmissionHook(sub *models.Submission) {
+	if s.dispatcher.hook == nil {
+		return
+	}
+	s.dispatcher.hook.run(sub, s.dispatcher.findContestIDForProblem(sub.ProblemID))
+}
+
+// expectedDuration is an upper bound of how long a problem's workflow can run.
+func expectedDuration(problem *Problem) time.Duration {
+	var total time.Duration
+	for _, flow := range problem.Workflow {
+		total += time.Duration(flow.Timeout) * time.Second
+	}
+	if limit := time.Duration(problem.Timeout) * time.Second; limit > 0 && limit < total {
+		return limit
+	}
+	return total
+}
+
+// findCoreBlock returns the first free, aligned block of requiredCPU cores, -1 if there is
+// none, or -2 if no cores are required.
+func findCoreBlock(usedCores []bool, requiredCPU int) int {
+	if requiredCPU <= 0 {
+		return -2
+	}
+	for i := 0; i <= len(usedCores)-requiredCPU; i += requiredCPU {
+		isBlockFree := true
+		for j := 0; j < requiredCPU; j++ {
+			if usedCores[i+j] {
+				isBlockFree = false
 				break
 			}
+		}
+		if isBlockFree {
+			return i
+		}
+	}
+	return -1
+}
 
-			time.Sleep(1 * time.Second)
+// reservation is the earliest node and time at which the head job is guaranteed to fit.
+type reservation struct {
+	node string
+	at   time.Time
+	job  *QueuedSubmission
+}
+
+// reserve computes the head job's reservation from the expected end times of running jobs.
+// It returns nil if the job cannot fit on any node, in which case backfill is unrestricted.
+func (s *Scheduler) reserve(clusterName string, head *QueuedSubmission) *reservation {
+	cluster, ok := s.clusters[clusterName]
+	if !ok {
+		return nil
+	}
+	cluster.Lock()
+	defer cluster.Unlock()
+
+	var best *reservation
+	for _, node := range cluster.candidateNodes() {
+		node.Lock()
+		if !node.IsPaused {
+			if at, ok := node.earliestStart(head.Problem, nil, time.Time{}); ok && (best == nil || at.Before(best.at)) {
+				best = &reservation{node: node.Name, at: at, job: head}
+			}
 		}
+		node.Unlock()
+	}
+	return best
+}
 
-		if node == nil {
-			continue
+// earliestStart simulates running jobs finishing in order of their expected end and returns when
+// the problem would first fit on the node. extra is an additional allocation assumed to be held
+// until past the deadline. If deadline is set, only jobs finishing by then are released.
+// The caller must hold the node lock.
+func (n *NodeState) earliestStart(problem *Problem, extra *allocation, deadline time.Time) (time.Time, bool) {
+	if problem.PinnedCores() > len(n.UsedCores) || problem.MilliCPU() > n.capacityMilliCPU() || problem.Memory > n.Memory {
+		return time.Time{}, false
+	}
+
+	usedCores := append([]bool(nil), n.UsedCores...)
+	usedMemory, usedMilliCPU := n.UsedMemory, n.UsedMilliCPU
+	if extra != nil {
+		for _, core := range extra.cores {
+			usedCores[core] = true
 		}
+		usedMemory += extra.memory
+		usedMilliCPU += extra.milliCPU
+	}
 
-		zap.S().Infof("node %s assigned to submission %s", node.Name, job.Submission.ID)
+	fits := func() bool {
+		return n.Memory-usedMemory >= problem.Memory && n.capacityMilliCPU()-usedMilliCPU >= problem.MilliCPU() &&
+			findCoreBlock(usedCores, problem.PinnedCores()) != -1
+	}
+	now := time.Now()
+	if fits() {
+		return now, true
+	}
 
-		var coreStrs []string
-		for _, c := range allocatedCores {
-			coreStrs = append(coreStrs, strconv.Itoa(c))
+	jobs := make([]*allocation, 0, len(n.running))
+	for _, a := range n.running {
+		jobs = append(jobs, a)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].expectedEnd.Before(jobs[j].expectedEnd) })
+
+	for _, a := range jobs {
+		if !deadline.IsZero() && a.expectedEnd.After(deadline) {
+			break
+		}
+		for _, core := range a.cores {
+			if core >= 0 && core < len(usedCores) {
+				usedCores[core] = false
+			}
+		}
+		usedMemory -= a.memory
+		usedMilliCPU -= a.milliCPU
+		if fits() {
+			if a.expectedEnd.Before(now) {
+				return now, true
+			}
+			return a.expectedEnd, true
 		}
+	}
+	return time.Time{}, false
+}
+
+// backfillAllowed reports whether starting the job on the node with the given cores keeps the
+// head job's reservation intact. The caller must hold the node lock.
+func (n *NodeState) backfillAllowed(job *QueuedSubmission, cores []int, res *reservation) bool {
+	if res == nil || n.Name != res.node {
+		return true
+	}
+	if !time.Now().Add(expectedDuration(job.Problem)).After(res.at) {
+		return true
+	}
+	// The job would still be running at the reserved time: only allow it if the head job
+	// fits next to it.
+	_, ok := n.earliestStart(res.job.Problem, &allocation{cores: cores, milliCPU: job.Problem.MilliCPU(), memory: job.Problem.Memory}, res.at)
+	return ok
+}
 
-		job.Submission.Node = node.Name
-		job.Submission.Status = models.StatusRunning
-		job.Submission.AllocatedCores = strings.Join(coreStrs, ",")
+// capacityMilliCPU is the node's total CPU in thousandths of a core.
+func (n *NodeState) capacityMilliCPU() int64 {
+	return int64(n.CPU) * 1000
+}
 
-		if err := s.db.Save(job.Submission).Error; err != nil {
-			zap.S().Errorf("failed to update submission status for %s: %v", job.Submission.ID, err)
-			s.ReleaseResources(job.Problem.Cluster, node.Name, allocatedCores, job.Problem.Memory)
-			continue
+// load is the larger of the node's used CPU and memory fractions. The caller must hold the node lock.
+func (n *NodeState) load() float64 {
+	return math.Max(float64(n.UsedMilliCPU)/float64(n.capacityMilliCPU()), float64(n.UsedMemory)/float64(n.Memory))
+}
+
+// candidateNodes returns the cluster's nodes in the order its strategy tries them: configuration
+// order for firstfit, then least loaded first for spread or most loaded first for binpack.
+// The caller must hold the cluster lock.
+func (c *ClusterState) candidateNodes() []*NodeState {
+	nodes := make([]*NodeState, 0, len(c.Nodes))
+	for _, n := range c.Cluster.Nodes {
+		if node, ok := c.Nodes[n.Name]; ok {
+			nodes = append(nodes, node)
+		}
+	}
+	if c.Strategy != config.NodeStrategySpread && c.Strategy != config.NodeStrategyBinPack {
+		return nodes
+	}
+
+	load := make(map[*NodeState]float64, len(nodes))
+	for _, node := range nodes {
+		node.Lock()
+		load[node] = node.load()
+		node.Unlock()
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if c.Strategy == config.NodeStrategySpread {
+			return load[nodes[i]] < load[nodes[j]]
+		}
+		return load[nodes[i]] > load[nodes[j]]
+	})
+	return nodes
+}
+
+// placement checks that the node has enough free memory and CPU for the problem right now and
+// returns the first free block of cores to pin it to, -2 if it is not pinned, or -1 if it does
+// not fit. The caller must hold the node lock.
+func (n *NodeState) placement(problem *Problem) int {
+	if n.IsPaused || n.Memory-n.UsedMemory < problem.Memory || n.capacityMilliCPU()-n.UsedMilliCPU < problem.MilliCPU() {
+		return -1
+	}
+	return findCoreBlock(n.UsedCores, problem.PinnedCores())
+}
+
+// fitsNow reports whether the job could be started right away without committing anything.
+func (s *Scheduler) fitsNow(clusterName string, job *QueuedSubmission, res *reservation) bool {
+	cluster, ok := s.clusters[clusterName]
+	if !ok {
+		return false
+	}
+	cluster.Lock()
+	defer cluster.Unlock()
+
+	for _, node := range cluster.candidateNodes() {
+		node.Lock()
+		start := node.placement(job.Problem)
+		ok := start != -1 && node.backfillAllowed(job, blockCores(start, job.Problem.PinnedCores()), res)
+		node.Unlock()
+		if ok {
+			return true
 		}
+	}
+	return false
+}
 
-		go s.dispatcher.Dispatch(job.Submission, job.Problem, node, allocatedCores)
+func blockCores(start, requiredCPU int) []int {
+	cores := make([]int, requiredCPU)
+	if start >= 0 {
+		for i := range cores {
+			cores[i] = start + i
+		}
 	}
+	return cores
 }
 
-func (s *Scheduler) findAvailableNode(clusterName string, requiredCPU int, requiredMemory int64) (*NodeState, []int) {
+// allocate finds a node for the job and reserves its resources. With a reservation, only
+// placements that do not delay the reserved head job are considered.
+func (s *Scheduler) allocate(clusterName string, job *QueuedSubmission, res *reservation) (*NodeState, []int) {
 	cluster, ok := s.clusters[clusterName]
 	if !ok {
 		return nil, nil
@@ -308,71 +781,242 @@ func (s *Scheduler) findAvailableNode(clusterName string, requiredCPU int, requi
 	cluster.Lock()
 	defer cluster.Unlock()
 
-	for _, node := range cluster.Nodes {
+	requiredCores, requiredMilliCPU, requiredMemory := job.Problem.PinnedCores(), job.Problem.MilliCPU(), job.Problem.Memory
+	for _, node := range cluster.candidateNodes() {
 		node.Lock()
-		if node.IsPaused {
+		startCore := node.placement(job.Problem)
+		if startCore == -1 {
+			node.Unlock()
+			continue
+		}
+		allocatedCores := blockCores(startCore, requiredCores)
+		if !node.backfillAllowed(job, allocatedCores, res) {
 			node.Unlock()
 			continue
 		}
 
-		if node.Memory-node.UsedMemory >= requiredMemory {
-			startCore := -1
-			if requiredCPU > 0 {
-				for i := 0; i <= len(node.UsedCores)-requiredCPU; i += requiredCPU {
-					isBlockFree := true
-					for j := 0; j < requiredCPU; j++ {
-						if node.UsedCores[i+j] {
-							isBlockFree = false
-							break
-						}
-					}
-					if isBlockFree {
-						startCore = i
-						break
-					}
-				}
-			} else {
-				startCore = -2
-			}
-
-			if startCore != -1 {
-				allocatedCores := make([]int, requiredCPU)
-				if startCore != -2 {
-					for i := 0; i < requiredCPU; i++ {
-						coreID := startCore + i
-						node.UsedCores[coreID] = true
-						allocatedCores[i] = coreID
-					}
-				}
-				node.UsedMemory += requiredMemory
-				node.Unlock()
-				return node, allocatedCores
+		if startCore != -2 {
+			for _, coreID := range allocatedCores {
+				node.UsedCores[coreID] = true
 			}
 		}
+		node.UsedMemory += requiredMemory
+		node.UsedMilliCPU += requiredMilliCPU
+		node.running[job.Submission.ID] = &allocation{
+			cores:       allocatedCores,
+			milliCPU:    requiredMilliCPU,
+			memory:      requiredMemory,
+			expectedEnd: time.Now().Add(expectedDuration(job.Problem)),
+		}
 		node.Unlock()
+		return node, allocatedCores
 	}
 	return nil, nil
 }
 
-func (s *Scheduler) ReleaseResources(clusterName, nodeName string, coresToRelease []int, memory int64) {
-	if cluster, ok := s.clusters[clusterName]; ok {
-		if node, ok := cluster.Nodes[nodeName]; ok {
+// ReleaseResources frees the resources held by a submission. It is safe to call more than once.
+func (s *Scheduler) ReleaseResources(submissionID string) {
+	for _, cluster := range s.clusters {
+		for _, node := range cluster.Nodes {
 			node.Lock()
-			for _, coreID := range coresToRelease {
+			alloc, ok := node.running[submissionID]
+			if !ok {
+				node.Unlock()
+				continue
+			}
+			delete(node.running, submissionID)
+			for _, coreID := range alloc.cores {
 				if coreID >= 0 && coreID < len(node.UsedCores) {
 					node.UsedCores[coreID] = false
 				}
 			}
-			node.UsedMemory -= memory
+			node.UsedMemory -= alloc.memory
 			if node.UsedMemory < 0 {
 				node.UsedMemory = 0
 			}
+			node.UsedMilliCPU -= alloc.milliCPU
+			if node.UsedMilliCPU < 0 {
+				node.UsedMilliCPU = 0
+			}
 			node.Unlock()
+			cluster.releaseSlot()
+			s.releaseSlot()
+
 			var coreStrs []string
-			for _, c := range coresToRelease {
+			for _, c := range alloc.cores {
 				coreStrs = append(coreStrs, strconv.Itoa(c))
 			}
-			zap.S().Infof("released resources (cores: [%s], mem: %dMB) from node %s", strings.Join(coreStrs, ","), memory, nodeName)
+			zap.S().Infof("released resources (cores: [%s], cpu: %dm, mem: %dMB) of submission %s from node %s", strings.Join(coreStrs, ","), alloc.milliCPU, alloc.memory, submissionID, node.Name)
+			return
+		}
+	}
+}
+
+// acquireSlot takes one of the global running slots, failing if max_concurrent_total is reached.
+func (s *Scheduler) acquireSlot() bool {
+	limit := int64(s.cfg.MaxConcurrentTotal)
+	for {
+		current := atomic.LoadInt64(&s.runningTotal)
+		if limit > 0 && current >= limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.runningTotal, current, current+1) {
+			return true
+		}
+	}
+}
+
+func (s *Scheduler) releaseSlot() {
+	if atomic.AddInt64(&s.runningTotal, -1) < 0 {
+		atomic.StoreInt64(&s.runningTotal, 0)
+	}
+}
+
+// acquireSlot takes one of the cluster's running slots, failing if max_concurrent is reached.
+func (c *ClusterState) acquireSlot() bool {
+	limit := int64(c.MaxConcurrent)
+	for {
+		current := atomic.LoadInt64(&c.Running)
+		if limit > 0 && current >= limit {
+			return false
 		}
+		if atomic.CompareAndSwapInt64(&c.Running, current, current+1) {
+			return true
+		}
+	}
+}
+
+func (c *ClusterState) releaseSlot() {
+	if atomic.AddInt64(&c.Running, -1) < 0 {
+		atomic.StoreInt64(&c.Running, 0)
+	}
+}
+
+// GetConcurrencyUsage returns the number of running submissions and the global cap (0 = unlimited).
+func (s *Scheduler) GetConcurrencyUsage() (int64, int) {
+	return atomic.LoadInt64(&s.runningTotal), s.cfg.MaxConcurrentTotal
+}
+
+// clusterQueue is a FIFO queue of submissions that the workers can look past the head of.
+type clusterQueue struct {
+	sync.Mutex
+	items   []QueuedSubmission
+	claimed map[string]bool      // jobs a worker is currently trying to start
+	stalled map[string]time.Time // jobs no node of the cluster can run, and since when
+	notify  chan struct{}
+}
+
+func newClusterQueue() *clusterQueue {
+	return &clusterQueue{claimed: make(map[string]bool), stalled: make(map[string]time.Time), notify: make(chan struct{}, 1)}
+}
+
+// markStalled records whether a job currently has no node able to run it and returns since when
+// it has been stalled, or now if it is not.
+func (q *clusterQueue) markStalled(submissionID string, stalled bool, now time.Time) time.Time {
+	q.Lock()
+	defer q.Unlock()
+	if !stalled {
+		delete(q.stalled, submissionID)
+		return now
 	}
+	since, ok := q.stalled[submissionID]
+	if !ok {
+		q.stalled[submissionID] = now
+		since = now
+	}
+	return since
+}
+
+// claim marks a job as handled by the calling worker. It fails if another worker holds it.
+func (q *clusterQueue) claim(submissionID string) bool {
+	q.Lock()
+	defer q.Unlock()
+	if q.claimed[submissionID] {
+		return false
+	}
+	q.claimed[submissionID] = true
+	return true
+}
+
+func (q *clusterQueue) unclaim(submissionID string) {
+	q.Lock()
+	delete(q.claimed, submissionID)
+	q.Unlock()
+}
+
+// push appends a job and returns the number of jobs ahead of it. A job that is already queued
+// keeps its place.
+func (q *clusterQueue) push(job QueuedSubmission) int {
+	q.Lock()
+	position := slices.IndexFunc(q.items, func(item QueuedSubmission) bool { return item.Submission.ID == job.Submission.ID })
+	if position < 0 {
+		job.EnqueuedAt = time.Now()
+		q.items = append(q.items, job)
+		position = len(q.items) - 1
+	}
+	q.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return position
+}
+
+func (q *clusterQueue) snapshot() []QueuedSubmission {
+	q.Lock()
+	defer q.Unlock()
+	return append([]QueuedSubmission(nil), q.items...)
+}
+
+// entries describes the queued jobs in order, taken under one lock so positions, claims and
+// stalls are consistent with each other.
+func (q *clusterQueue) entries() []QueueEntry {
+	q.Lock()
+	defer q.Unlock()
+	entries := make([]QueueEntry, len(q.items))
+	for i, job := range q.items {
+		sub := job.Submission
+		entries[i] = QueueEntry{
+			Position:     i,
+			SubmissionID: sub.ID,
+			UserID:       sub.UserID,
+			ProblemID:    sub.ProblemID,
+			CPU:          job.Problem.CPU,
+			Memory:       job.Problem.Memory,
+			CreatedAt:    sub.CreatedAt,
+			EnqueuedAt:   job.EnqueuedAt,
+			Starting:     q.claimed[sub.ID],
+		}
+		if since, ok := q.stalled[sub.ID]; ok {
+			entries[i].StalledSince = &since
+		}
+	}
+	return entries
+}
+
+// remove drops a submission from the queue and reports whether it was queued.
+func (q *clusterQueue) remove(submissionID string) bool {
+	q.Lock()
+	defer q.Unlock()
+	for i, job := range q.items {
+		if job.Submission.ID == submissionID {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			delete(q.stalled, submissionID)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *clusterQueue) contains(submissionID string) bool {
+	q.Lock()
+	defer q.Unlock()
+	return slices.ContainsFunc(q.items, func(item QueuedSubmission) bool { return item.Submission.ID == submissionID })
+}
+
+func (q *clusterQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.items)
 }
@@ -0,0 +1,144 @@
+package judger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZJUSCT/CSOJ/internal/config"
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+)
+
+type testAllocation struct {
+	cores    []int
+	milliCPU int64
+	endsIn   time.Duration
+}
+
+type testNode struct {
+	name    string
+	cpu     int
+	paused  bool
+	running []testAllocation
+}
+
+// newTestScheduler builds a scheduler with one cluster "c" whose nodes hold the given
+// allocations, each expected to end its offset after now.
+func newTestScheduler(now time.Time, nodes ...testNode) *Scheduler {
+	cluster := &config.Cluster{Name: "c"}
+	for _, n := range nodes {
+		cluster.Nodes = append(cluster.Nodes, config.Node{Name: n.name, CPU: n.cpu, Memory: 8192})
+	}
+	state := &ClusterState{Cluster: cluster, Nodes: make(map[string]*NodeState)}
+	for i, n := range nodes {
+		node := &NodeState{
+			Node:      &cluster.Nodes[i],
+			UsedCores: make([]bool, n.cpu),
+			IsPaused:  n.paused,
+			running:   make(map[string]*allocation),
+		}
+		for j, a := range n.running {
+			for _, core := range a.cores {
+				node.UsedCores[core] = true
+			}
+			node.UsedMilliCPU += a.milliCPU
+			node.running[string(rune('a'+j))] = &allocation{cores: a.cores, milliCPU: a.milliCPU, expectedEnd: now.Add(a.endsIn)}
+		}
+		state.Nodes[n.name] = node
+	}
+	return &Scheduler{clusters: map[string]*ClusterState{"c": state}}
+}
+
+func testJob(id string, cpu float64, duration time.Duration) *QueuedSubmission {
+	return &QueuedSubmission{
+		Submission: &models.Submission{ID: id},
+		Problem:    &Problem{ID: id, CPU: cpu, Memory: 256, Workflow: []WorkflowStep{{Timeout: int(duration / time.Second)}}},
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    []testNode
+		head     *QueuedSubmission
+		job      *QueuedSubmission
+		wantNode string        // node of the head's reservation, "" for none
+		wantAt   time.Duration // reserved start after now
+		wantFits bool          // whether the job may start now
+	}{
+		{
+			name:     "short job backfilled onto idle cores",
+			nodes:    []testNode{{name: "n1", cpu: 4, running: []testAllocation{{cores: []int{0, 1}, milliCPU: 2000, endsIn: time.Hour}}}},
+			head:     testJob("head", 4, 10*time.Minute),
+			job:      testJob("small", 2, 30*time.Minute),
+			wantNode: "n1", wantAt: time.Hour, wantFits: true,
+		},
+		{
+			name:     "long job rejected because it would delay the head",
+			nodes:    []testNode{{name: "n1", cpu: 4, running: []testAllocation{{cores: []int{0, 1}, milliCPU: 2000, endsIn: time.Hour}}}},
+			head:     testJob("head", 4, 10*time.Minute),
+			job:      testJob("small", 2, 2*time.Hour),
+			wantNode: "n1", wantAt: time.Hour, wantFits: false,
+		},
+		{
+			name: "long job allowed when the head still fits beside it",
+			nodes: []testNode{{name: "n1", cpu: 4, running: []testAllocation{
+				{cores: []int{0, 1}, milliCPU: 2000, endsIn: time.Hour},
+				{cores: []int{2}, milliCPU: 1000, endsIn: 3 * time.Hour},
+			}}},
+			head:     testJob("head", 2, 10*time.Minute),
+			job:      testJob("small", 1, 2*time.Hour),
+			wantNode: "n1", wantAt: time.Hour, wantFits: true,
+		},
+		{
+			name:     "head that fits nowhere leaves backfill unrestricted",
+			nodes:    []testNode{{name: "n1", cpu: 4, running: []testAllocation{{cores: []int{0, 1}, milliCPU: 2000, endsIn: time.Hour}}}},
+			head:     testJob("head", 8, 10*time.Minute),
+			job:      testJob("small", 2, 2*time.Hour),
+			wantFits: true,
+		},
+		{
+			name:     "fractional job leaves the head's share free",
+			nodes:    []testNode{{name: "n1", cpu: 2, running: []testAllocation{{milliCPU: 1000, endsIn: 30 * time.Minute}}}},
+			head:     testJob("head", 1.5, 10*time.Minute),
+			job:      testJob("small", 0.5, 2*time.Hour),
+			wantNode: "n1", wantAt: 30 * time.Minute, wantFits: true,
+		},
+		{
+			name:     "fractional job takes part of the head's share",
+			nodes:    []testNode{{name: "n1", cpu: 2, running: []testAllocation{{milliCPU: 1000, endsIn: 30 * time.Minute}}}},
+			head:     testJob("head", 1.5, 10*time.Minute),
+			job:      testJob("small", 0.75, 2*time.Hour),
+			wantNode: "n1", wantAt: 30 * time.Minute, wantFits: false,
+		},
+		{
+			name: "paused node is neither reserved nor backfilled",
+			nodes: []testNode{
+				{name: "paused", cpu: 4, paused: true},
+				{name: "busy", cpu: 4, running: []testAllocation{{cores: []int{0, 1, 2, 3}, milliCPU: 4000, endsIn: time.Hour}}},
+			},
+			head:     testJob("head", 4, 10*time.Minute),
+			job:      testJob("small", 1, 10*time.Minute),
+			wantNode: "busy", wantAt: time.Hour, wantFits: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			s := newTestScheduler(now, tt.nodes...)
+
+			res := s.reserve("c", tt.head)
+			switch {
+			case tt.wantNode == "" && res != nil:
+				t.Fatalf("got reservation on %s, want none", res.node)
+			case tt.wantNode != "" && res == nil:
+				t.Fatalf("got no reservation, want one on %s", tt.wantNode)
+			case res != nil && (res.node != tt.wantNode || !res.at.Equal(now.Add(tt.wantAt))):
+				t.Fatalf("reserved %s at +%v, want %s at +%v", res.node, res.at.Sub(now), tt.wantNode, tt.wantAt)
+			}
+
+			if got := s.fitsNow("c", tt.job, res); got != tt.wantFits {
+				t.Errorf("fitsNow = %v, want %v", got, tt.wantFits)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package judger
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ZJUSCT/CSOJ/internal/database"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// scoringEnv holds the variables of a contest's scoring expression. All maps have an entry for
+// every problem of the contest.
+type scoringEnv struct {
+	Problems     []string           `expr:"problems"`      // problem IDs in contest order
+	Scores       map[string]int     `expr:"scores"`        // best score per problem
+	ScoreList    []int              `expr:"score_list"`    // best scores in contest order
+	Attempts     map[string]int     `expr:"attempts"`      // valid, finished submissions per problem
+	SolveMinutes map[string]float64 `expr:"solve_minutes"` // minutes from the contest start to the best score, 0 if unscored
+}
+
+// scoringBuiltins are the functions available to scoring expressions. Anything reading the clock
+// or parsing data is left out, so totals depend on the results alone.
+var scoringBuiltins = []string{
+	"all", "none", "any", "one", "filter", "map", "find", "count", "sum", "reduce", "len",
+	"abs", "ceil", "floor", "round", "int", "float", "max", "min", "mean", "median",
+	"first", "last", "take", "keys", "values", "reverse", "sort", "sortBy",
+}
+
+// compileScoring checks a contest's scoring expression and prepares it for evaluation.
+func compileScoring(source string) (*vm.Program, error) {
+	opts := []expr.Option{expr.Env(scoringEnv{}), expr.AsFloat64(), expr.DisableAllBuiltins()}
+	for _, name := range scoringBuiltins {
+		opts = append(opts, expr.EnableBuiltin(name))
+	}
+	program, err := expr.Compile(source, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scoring expression: %w", err)
+	}
+	return program, nil
+}
+
+// resolveICPC ranks the contest ICPC-style if its problems use the "icpc" score mode. That mode
+// changes how the whole leaderboard is ranked, so it cannot be mixed with other modes or a
+// scoring expression.
+func resolveICPC(c *Contest, problems []*Problem) error {
+	icpcProblems := make(map[string]database.ICPCProblem)
+	for _, p := range problems {
+		if p.Score.Mode == "icpc" {
+			icpcProblems[p.ID] = p.Score.ICPC()
+		}
+	}
+	if len(icpcProblems) == 0 {
+		return nil
+	}
+	if len(icpcProblems) != len(problems) {
+		return fmt.Errorf("contest %s mixes the icpc score mode with other score modes", c.ID)
+	}
+	if c.Scoring != "" {
+		return fmt.Errorf("contest %s cannot use a scoring expression with the icpc score mode", c.ID)
+	}
+	c.icpcProblems = icpcProblems
+	return nil
+}
+
+// LeaderboardScoring returns how the contest's leaderboard is ranked: ICPC-style, by the custom
+// total of its scoring expression, or nil if the contest sums its problem scores.
+func (c *Contest) LeaderboardScoring() *database.Scoring {
+	if c.icpcProblems != nil {
+		ranking := &database.ICPCRanking{Start: c.StartTime, Problems: c.icpcProblems}
+		if c.LockScoresAtEnd {
+			// Submissions after the end are not scored, so they must not change the ranking.
+			ranking.Until = c.EndTime
+		}
+		return &database.Scoring{ProblemIDs: append([]string(nil), c.ProblemIDs...), ICPC: ranking}
+	}
+	if c.scoringProgram == nil {
+		return nil
+	}
+	program, start := c.scoringProgram, c.StartTime
+	problemIDs := append([]string(nil), c.ProblemIDs...)
+	return &database.Scoring{
+		ProblemIDs: problemIDs,
+		Total: func(results map[string]database.ProblemResult) (int, error) {
+			env := scoringEnv{
+				Problems:     problemIDs,
+				Scores:       make(map[string]int, len(problemIDs)),
+				ScoreList:    make([]int, len(problemIDs)),
+				Attempts:     make(map[string]int, len(problemIDs)),
+				SolveMinutes: make(map[string]float64, len(problemIDs)),
+			}
+			for i, id := range problemIDs {
+				r := results[id]
+				env.Scores[id] = r.Score
+				env.ScoreList[i] = r.Score
+				env.Attempts[id] = r.Attempts
+				if r.Score > 0 && !r.LastScoreTime.IsZero() {
+					env.SolveMinutes[id] = max(r.LastScoreTime.Sub(start).Minutes(), 0)
+				} else {
+					env.SolveMinutes[id] = 0
+				}
+			}
+			out, err := expr.Run(program, env)
+			if err != nil {
+				return 0, err
+			}
+			total := out.(float64)
+			if math.IsNaN(total) || math.IsInf(total, 0) {
+				return 0, fmt.Errorf("scoring expression returned %v", total)
+			}
+			return int(math.Round(total)), nil
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package judger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Defaults applied to every step container unless the step overrides them.
+const (
+	defaultPidsLimit = 512
+	defaultNofile    = 4096
+)
+
+var defaultSecurityOpt = []string{"no-new-privileges:true"}
+
+var validUlimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true, "memlock": true,
+	"msgqueue": true, "nice": true, "nofile": true, "nproc": true, "rss": true, "rtprio": true,
+	"rttime": true, "sigpending": true, "stack": true,
+}
+
+type Ulimit struct {
+	Name string `yaml:"name" json:"name"`
+	Soft int64  `yaml:"soft" json:"soft"`
+	Hard int64  `yaml:"hard" json:"hard"`
+}
+
+// ContainerSecurity holds the hardening options applied to a step's container.
+type ContainerSecurity struct {
+	Ulimits     []Ulimit
+	SecurityOpt []string // seccomp profiles are inlined as JSON, as the Docker API expects
+	PidsLimit   int64
+}
+
+// resolveSecurity validates a step's ulimits and security options and inlines seccomp profiles.
+// Relative profile paths are resolved inside the problem directory.
+func resolveSecurity(p *Problem, step *WorkflowStep) error {
+	for _, u := range step.Ulimits {
+		if !validUlimitNames[u.Name] {
+			return fmt.Errorf("unknown ulimit %q", u.Name)
+		}
+		if u.Soft > u.Hard && u.Hard >= 0 {
+			return fmt.Errorf("ulimit %q: soft limit %d exceeds hard limit %d", u.Name, u.Soft, u.Hard)
+		}
+	}
+
+	step.resolvedSecurityOpt = nil
+	for _, opt := range step.SecurityOpt {
+		key, value, _ := strings.Cut(opt, "=")
+		if key != "seccomp" || value == "unconfined" {
+			step.resolvedSecurityOpt = append(step.resolvedSecurityOpt, opt)
+			continue
+		}
+
+		path := value
+		if !filepath.IsAbs(path) {
+			base, err := filepath.Abs(p.BasePath)
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(base, filepath.FromSlash(value))
+			if !strings.HasPrefix(path, base+string(filepath.Separator)) {
+				return fmt.Errorf("seccomp profile %q escapes the problem directory", value)
+			}
+		}
+		profile, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read seccomp profile: %w", err)
+		}
+		if !json.Valid(profile) {
+			return fmt.Errorf("seccomp profile %q is not valid JSON", value)
+		}
+		step.resolvedSecurityOpt = append(step.resolvedSecurityOpt, "seccomp="+string(profile))
+	}
+	return nil
+}
+
+// containerSecurity merges the step's settings over the restrictive defaults: ulimits are
+// overridden by name, a non-empty security_opt replaces the default list, and pids_limit
+// replaces the default (-1 removes the limit).
+func (s *WorkflowStep) containerSecurity() ContainerSecurity {
+	sec := ContainerSecurity{
+		SecurityOpt: defaultSecurityOpt,
+		PidsLimit:   defaultPidsLimit,
+	}
+	if len(s.SecurityOpt) > 0 {
+		sec.SecurityOpt = s.resolvedSecurityOpt
+	}
+	if s.PidsLimit != 0 {
+		sec.PidsLimit = s.PidsLimit
+	}
+
+	hasNofile := false
+	for _, u := range s.Ulimits {
+		if u.Name == "nofile" {
+			hasNofile = true
+		}
+	}
+	if !hasNofile {
+		sec.Ulimits = append(sec.Ulimits, Ulimit{Name: "nofile", Soft: defaultNofile, Hard: defaultNofile})
+	}
+	sec.Ulimits = append(sec.Ulimits, s.Ulimits...)
+	return sec
+}
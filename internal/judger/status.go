@@ -0,0 +1,131 @@
+package judger
+
+import (
+	"encoding/json"
+
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+	"github.com/ZJUSCT/CSOJ/internal/pubsub"
+	"go.uber.org/zap"
+)
+
+// SubmissionStatusEvent describes a submission status change or queue position update.
+// It is published on the submission's topic with the "status" stream.
+type SubmissionStatusEvent struct {
+	SubmissionID string        `json:"submission_id"`
+	Status       models.Status `json:"status"`
+	CurrentStep  int           `json:"current_step"`
+	Position     int64         `json:"position"` // Number of queued submissions ahead, 0 if not queued
+	Score        int           `json:"score"`
+	// CurrentCommand and TotalCommands give the progress through the commands of the current
+	// step while the submission runs. TotalCommands is 0 when unknown.
+	CurrentCommand int `json:"current_command"`
+	TotalCommands  int `json:"total_commands"`
+}
+
+// FormatStatusMessage formats a status event as a websocket message. The command progress is
+// taken from the running container among the submission's loaded containers, if any.
+func FormatStatusMessage(sub *models.Submission, position int64) []byte {
+	var running *models.Container
+	if sub.Status == models.StatusRunning {
+		for i := range sub.Containers {
+			if sub.Containers[i].Status == models.StatusRunning {
+				running = &sub.Containers[i]
+			}
+		}
+	}
+	return formatStatusEvent(sub, position, running)
+}
+
+// PublishCommandProgress publishes the submission's status with the command progress of the
+// container running its current step.
+func PublishCommandProgress(sub *models.Submission, cont *models.Container) {
+	pubsub.GetBroker().Publish(sub.ID, formatStatusEvent(sub, 0, cont))
+}
+
+func formatStatusEvent(sub *models.Submission, position int64, cont *models.Container) []byte {
+	event := SubmissionStatusEvent{
+		SubmissionID: sub.ID,
+		Status:       sub.Status,
+		CurrentStep:  sub.CurrentStep,
+		Position:     position,
+		Score:        sub.Score,
+	}
+	if cont != nil {
+		event.CurrentCommand = cont.CurrentCommand
+		event.TotalCommands = cont.TotalCommands
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return pubsub.FormatMessage("error", "failed to encode status event")
+	}
+	return pubsub.FormatMessage("status", string(data))
+}
+
+// ParseStatusMessage decodes a status event from a websocket message. ok is false for messages
+// of other streams.
+func ParseStatusMessage(msg []byte) (event SubmissionStatusEvent, ok bool) {
+	var wsMsg pubsub.WsMessage
+	if err := json.Unmarshal(msg, &wsMsg); err != nil || wsMsg.Stream != "status" {
+		return event, false
+	}
+	if err := json.Unmarshal([]byte(wsMsg.Data), &event); err != nil {
+		return event, false
+	}
+	return event, true
+}
+
+// Before reports whether e describes an earlier point of the submission's judging than other:
+// an earlier status, or while running an earlier step or command. Queue position updates are
+// never considered earlier than each other.
+func (e SubmissionStatusEvent) Before(other SubmissionStatusEvent) bool {
+	if a, b := statusStage(e.Status), statusStage(other.Status); a != b {
+		return a < b
+	}
+	if e.Status != models.StatusRunning {
+		return false
+	}
+	if e.CurrentStep != other.CurrentStep {
+		return e.CurrentStep < other.CurrentStep
+	}
+	return e.CurrentCommand < other.CurrentCommand
+}
+
+// statusStage orders the statuses a submission passes through.
+func statusStage(status models.Status) int {
+	switch status {
+	case models.StatusQueued:
+		return 0
+	case models.StatusRunning:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// PublishSubmissionStatus publishes the submission's current status on its topic.
+func PublishSubmissionStatus(sub *models.Submission, position int64) {
+	pubsub.GetBroker().Publish(sub.ID, FormatStatusMessage(sub, position))
+}
+
+// PublishQueuePositions pushes updated queue positions to watched submissions of a cluster.
+func (s *Scheduler) PublishQueuePositions(clusterName string) {
+	s.publishQueuePositions(clusterName)
+}
+
+// publishQueuePositions pushes updated queue positions to watched submissions of a cluster.
+func (s *Scheduler) publishQueuePositions(clusterName string) {
+	var queued []models.Submission
+	if err := s.db.Select("id", "status", "current_step", "score").
+		Where("status = ? AND cluster = ?", models.StatusQueued, clusterName).
+		Order("created_at asc").
+		Find(&queued).Error; err != nil {
+		zap.S().Warnf("failed to load queued submissions for cluster %s: %v", clusterName, err)
+		return
+	}
+	broker := pubsub.GetBroker()
+	for i := range queued {
+		if broker.HasSubscribers(queued[i].ID) {
+			broker.Publish(queued[i].ID, FormatStatusMessage(&queued[i], int64(i)))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package judger
+
+import (
+	"testing"
+
+	"github.com/ZJUSCT/CSOJ/internal/database/models"
+)
+
+func TestSubmissionStatusEventBefore(t *testing.T) {
+	queued := SubmissionStatusEvent{Status: models.StatusQueued, Position: 3}
+	running := func(step, command int) SubmissionStatusEvent {
+		return SubmissionStatusEvent{Status: models.StatusRunning, CurrentStep: step, CurrentCommand: command}
+	}
+	success := SubmissionStatusEvent{Status: models.StatusSuccess, CurrentStep: 2, Score: 100}
+	failed := SubmissionStatusEvent{Status: models.StatusFailed, CurrentStep: 1}
+
+	tests := []struct {
+		name   string
+		e      SubmissionStatusEvent
+		other  SubmissionStatusEvent
+		before bool
+	}{
+		{"queued before running", queued, running(0, 0), true},
+		{"running before success", running(2, 4), success, true},
+		{"running before failed", running(0, 0), failed, true},
+		{"success not before running", success, running(1, 0), false},
+		{"running not before queued", running(0, 0), queued, false},
+		{"earlier step", running(0, 5), running(1, 0), true},
+		{"later step", running(1, 0), running(0, 5), false},
+		{"earlier command", running(1, 1), running(1, 2), true},
+		{"same progress", running(1, 2), running(1, 2), false},
+		{"queue positions", SubmissionStatusEvent{Status: models.StatusQueued, Position: 5}, queued, false},
+		{"final statuses", failed, success, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.e.Before(tt.other); got != tt.before {
+				t.Errorf("Before = %v, want %v", got, tt.before)
+			}
+		})
+	}
+}
+
+func TestParseStatusMessage(t *testing.T) {
+	sub := &models.Submission{ID: "s1", Status: models.StatusRunning, CurrentStep: 2, Score: 7}
+	event, ok := ParseStatusMessage(FormatStatusMessage(sub, 0))
+	if !ok {
+		t.Fatal("status message not parsed")
+	}
+	if event.SubmissionID != "s1" || event.Status != models.StatusRunning || event.CurrentStep != 2 || event.Score != 7 {
+		t.Errorf("got %+v", event)
+	}
+	if _, ok := ParseStatusMessage([]byte(`{"stream":"stdout","data":"hello"}`)); ok {
+		t.Error("log message parsed as a status event")
+	}
+}
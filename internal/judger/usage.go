@@ -0,0 +1,99 @@
+package judger
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ContainerUsage is a live resource snapshot of a running judge container, as reported by Docker.
+// Unlike the scheduler's accounting, it reflects actual use rather than reservations.
+type ContainerUsage struct {
+	DockerID         string  `json:"docker_id"`
+	Name             string  `json:"name"`
+	SubmissionID     string  `json:"submission_id"`
+	ContainerID      string  `json:"container_id"`
+	ProblemID        string  `json:"problem_id"`
+	UserID           string  `json:"user_id"`
+	Step             string  `json:"step"`
+	CPUPercent       float64 `json:"cpu_percent"` // 100 is one fully used core
+	MemoryBytes      uint64  `json:"memory_bytes"`
+	MemoryLimitBytes uint64  `json:"memory_limit_bytes"`
+	Pids             uint64  `json:"pids"`
+	Error            string  `json:"error,omitempty"` // set if the stats of this container could not be read
+}
+
+// JudgeContainerUsage returns the live usage of the running containers launched by the judger on
+// this node, recognized by their labels. Stats are sampled concurrently; each takes about a second
+// since Docker measures CPU usage over an interval.
+func (m *DockerManager) JudgeContainerUsage(ctx context.Context) ([]ContainerUsage, error) {
+	containers, err := m.cli.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelSubmissionID)),
+	})
+	if err != nil {
+		return nil, m.observe(err)
+	}
+
+	usage := make([]ContainerUsage, len(containers))
+	var wg sync.WaitGroup
+	for i, summary := range containers {
+		usage[i] = ContainerUsage{
+			DockerID:     summary.ID,
+			SubmissionID: summary.Labels[LabelSubmissionID],
+			ContainerID:  summary.Labels[LabelContainerID],
+			ProblemID:    summary.Labels[LabelProblemID],
+			UserID:       summary.Labels[LabelUserID],
+			Step:         summary.Labels[LabelStep],
+		}
+		if len(summary.Names) > 0 {
+			usage[i].Name = summary.Names[0]
+		}
+		wg.Add(1)
+		go func(u *ContainerUsage) {
+			defer wg.Done()
+			if err := m.readUsage(ctx, u); err != nil {
+				u.Error = err.Error()
+			}
+		}(&usage[i])
+	}
+	wg.Wait()
+	return usage, nil
+}
+
+func (m *DockerManager) readUsage(ctx context.Context, u *ContainerUsage) error {
+	resp, err := m.cli.ContainerStats(ctx, u.DockerID, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var stats container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return err
+	}
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		u.CPUPercent = cpuDelta / systemDelta * onlineCPUs * 100
+	}
+
+	// Like `docker stats`, page cache that can be reclaimed does not count as used memory.
+	u.MemoryBytes = stats.MemoryStats.Usage
+	cache := stats.MemoryStats.Stats["inactive_file"] // cgroup v2
+	if v, ok := stats.MemoryStats.Stats["total_inactive_file"]; ok {
+		cache = v // cgroup v1
+	}
+	if cache < u.MemoryBytes {
+		u.MemoryBytes -= cache
+	}
+	u.MemoryLimitBytes = stats.MemoryStats.Limit
+	u.Pids = stats.PidsStats.Current
+	return nil
+}
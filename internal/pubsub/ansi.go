@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// Values of the ansi query parameter of the log endpoints.
+const (
+	ANSIKeep  = "keep"  // return logs as recorded (default)
+	ANSIStrip = "strip" // remove ANSI escape sequences such as colors
+)
+
+// ParseANSIMode reports whether the ansi query parameter asks for escape sequences to be stripped.
+func ParseANSIMode(mode string) (bool, error) {
+	switch mode {
+	case "", ANSIKeep:
+		return false, nil
+	case ANSIStrip:
+		return true, nil
+	default:
+		return false, fmt.Errorf("ansi must be %q or %q", ANSIKeep, ANSIStrip)
+	}
+}
+
+// ansiPattern matches CSI sequences (colors, cursor movement), OSC sequences (window titles,
+// hyperlinks) and the remaining short escapes such as cursor save/restore.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[ -/]*[0-~]`)
+
+// StripANSI removes ANSI escape sequences from s.
+func StripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// StripMessageANSI removes ANSI escape sequences from the data of a formatted message. Lines
+// that are not messages are returned unchanged.
+func StripMessageANSI(line []byte) []byte {
+	var msg WsMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return line
+	}
+	msg.Data = StripANSI(msg.Data)
+	stripped, err := json.Marshal(msg)
+	if err != nil {
+		return line
+	}
+	return stripped
+}
+
+// CopyLog writes a stored NDJSON log to w, optionally with ANSI escape sequences stripped from
+// every message. The file on disk is never modified.
+func CopyLog(w io.Writer, r io.Reader, stripANSI bool) error {
+	if !stripANSI {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+	for scanner.Scan() {
+		if _, err := w.Write(append(StripMessageANSI(scanner.Bytes()), '\n')); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
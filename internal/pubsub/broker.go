@@ -1,8 +1,12 @@
 package pubsub
 
 import (
+	"bufio"
 	"encoding/json"
+	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -10,13 +14,55 @@ import (
 // Broker a simple in-memory pub/sub system.
 type Broker struct {
 	mu          sync.RWMutex
-	subscribers map[string][]chan []byte // topic -> list of subscriber channels
+	subscribers map[string][]*subscriber // topic -> list of subscribers
 	cache       map[string][][]byte      // topic -> list of cached messages
+	lastActive  map[string]time.Time     // topic -> last subscribe, unsubscribe or publish
+	bufferSize  int                      // live messages a subscriber may fall behind by
+}
+
+// defaultBufferSize is the number of live messages a subscriber may have pending before it is
+// considered to have fallen behind.
+const defaultBufferSize = 128
+
+// subscriber is one subscription to a topic.
+type subscriber struct {
+	ch      chan []byte
+	lagged  chan struct{} // closed once a message had to be dropped for this subscriber
+	lagOnce sync.Once
+}
+
+// markLagged records that a message was dropped for the subscriber. Safe for concurrent use.
+func (s *subscriber) markLagged() {
+	s.lagOnce.Do(func() { close(s.lagged) })
+}
+
+// Subscription delivers the messages of a topic to one subscriber.
+type Subscription struct {
+	// C receives the topic's cached messages followed by live ones. It is closed when the topic is
+	// closed or the subscription is.
+	C   <-chan []byte
+	sub *subscriber
+
+	closeOnce   sync.Once
+	unsubscribe func()
+}
+
+// Lagged is closed once the subscriber fell so far behind that a message had to be dropped for
+// it. The messages received from C after that have a gap, so the reader should stop and have its
+// client reconnect, which replays the topic from the cache.
+func (s *Subscription) Lagged() <-chan struct{} {
+	return s.sub.lagged
+}
+
+// Close unsubscribes from the topic. It may be called more than once.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(s.unsubscribe)
 }
 
 type WsMessage struct {
-	Stream string `json:"stream"`
-	Data   string `json:"data"`
+	Stream string     `json:"stream"`
+	Data   string     `json:"data"`
+	TS     *time.Time `json:"ts,omitempty"` // when the message was produced, missing in logs written before timestamps were recorded
 }
 
 var (
@@ -28,44 +74,74 @@ var (
 func GetBroker() *Broker {
 	once.Do(func() {
 		broker = &Broker{
-			subscribers: make(map[string][]chan []byte),
+			subscribers: make(map[string][]*subscriber),
 			cache:       make(map[string][][]byte),
+			lastActive:  make(map[string]time.Time),
+			bufferSize:  defaultBufferSize,
 		}
 	})
 	return broker
 }
 
-// Subscribe subscribes to a topic. It first sends all cached messages to the new
-// subscriber, then adds the subscriber to receive live messages.
-func (b *Broker) Subscribe(topic string) (<-chan []byte, func()) {
+// SetBufferSize sets how many live messages new subscribers may fall behind by before they are
+// marked as lagged. Values below 1 restore the default.
+func (b *Broker) SetBufferSize(size int) {
 	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size < 1 {
+		size = defaultBufferSize
+	}
+	b.bufferSize = size
+}
 
-	ch := make(chan []byte, 128) // Use a buffered channel
+// Subscribe subscribes to a topic. The cached messages are queued for the new subscriber
+// before any live message, so the replay is complete and in order however long it is.
+func (b *Broker) Subscribe(topic string) *Subscription {
+	return b.subscribe(topic, true)
+}
 
-	// Send cached history to the new subscriber.
-	// We do this inside the lock to get a consistent snapshot.
-	// The actual sending happens in a goroutine to avoid blocking the broker.
-	history := b.cache[topic]
+// SubscribeLive subscribes to a topic without replaying its cache, for readers that load the
+// current state elsewhere and only need the changes from now on.
+func (b *Broker) SubscribeLive(topic string) *Subscription {
+	return b.subscribe(topic, false)
+}
 
-	go func() {
-		for _, msg := range history {
-			ch <- msg
-		}
-	}()
+func (b *Broker) subscribe(topic string, replay bool) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	b.subscribers[topic] = append(b.subscribers[topic], ch)
-	b.mu.Unlock() // Unlock after modifying subscribers map
+	var history [][]byte
+	if replay {
+		history = b.cache[topic]
+	}
+	sub := &subscriber{
+		ch:     make(chan []byte, len(history)+b.bufferSize),
+		lagged: make(chan struct{}),
+	}
+	for _, msg := range history {
+		sub.ch <- msg
+	}
+
+	b.subscribers[topic] = append(b.subscribers[topic], sub)
+	b.lastActive[topic] = time.Now()
 
 	unsubscribe := func() {
 		b.mu.Lock()
 		defer b.mu.Unlock()
 
 		subscribers := b.subscribers[topic]
-		for i, sub := range subscribers {
-			if sub == ch {
-				// Remove the channel from the slice
+		for i, s := range subscribers {
+			if s == sub {
+				// Remove the subscriber from the slice
 				b.subscribers[topic] = append(subscribers[:i], subscribers[i+1:]...)
-				close(ch)
+				b.lastActive[topic] = time.Now()
+				if len(b.subscribers[topic]) == 0 {
+					delete(b.subscribers, topic)
+					if _, cached := b.cache[topic]; !cached {
+						delete(b.lastActive, topic)
+					}
+				}
+				close(sub.ch)
 				break
 			}
 		}
@@ -73,7 +149,18 @@ func (b *Broker) Subscribe(topic string) (<-chan []byte, func()) {
 	}
 
 	zap.S().Debugf("new subscription to topic %s, sent %d cached messages", topic, len(history))
-	return ch, unsubscribe
+	return &Subscription{C: sub.ch, sub: sub, unsubscribe: unsubscribe}
+}
+
+// send delivers a message to a subscriber without blocking. If its buffer is full the message is
+// dropped for it and the subscriber is marked as lagged, so a slow client never blocks the
+// publisher and its reader learns about the gap.
+func (s *subscriber) send(msg []byte) {
+	select {
+	case s.ch <- msg:
+	default:
+		s.markLagged()
+	}
 }
 
 // Publish publishes a message to all subscribers of a topic and caches it.
@@ -84,15 +171,21 @@ func (b *Broker) Publish(topic string, msg []byte) {
 	// Add message to cache.
 	// For production, you might want to add a cache size limit per topic to prevent memory exhaustion.
 	b.cache[topic] = append(b.cache[topic], msg)
+	b.lastActive[topic] = time.Now()
 
-	// Broadcast to live subscribers (non-blocking).
-	for _, ch := range b.subscribers[topic] {
-		select {
-		case ch <- msg:
-		default:
-			// If a subscriber's channel is full, drop the message for them.
-			// This prevents a slow client from blocking the publisher.
-		}
+	for _, sub := range b.subscribers[topic] {
+		sub.send(msg)
+	}
+}
+
+// Broadcast sends a message to the current subscribers of a topic without caching it, for
+// long-lived topics whose history is available elsewhere.
+func (b *Broker) Broadcast(topic string, msg []byte) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subscribers[topic] {
+		sub.send(msg)
 	}
 }
 
@@ -101,23 +194,149 @@ func (b *Broker) CloseTopic(topic string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	// Crucially, delete the cache to free up memory, even if nobody subscribed
+	delete(b.cache, topic)
+	delete(b.lastActive, topic)
 	if subscribers, ok := b.subscribers[topic]; ok {
-		for _, ch := range subscribers {
-			close(ch)
+		for _, sub := range subscribers {
+			close(sub.ch)
 		}
 		delete(b.subscribers, topic)
-		// Crucially, delete the cache to free up memory
-		delete(b.cache, topic)
 		zap.S().Infof("closed pubsub topic %s and cleared cache", topic)
 	}
 }
 
+// HasSubscribers reports whether anyone is currently subscribed to a topic.
+func (b *Broker) HasSubscribers(topic string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers[topic]) > 0
+}
+
+// TopicStats describes one topic of the broker.
+type TopicStats struct {
+	Topic          string    `json:"topic"`
+	Subscribers    int       `json:"subscribers"`
+	CachedMessages int       `json:"cached_messages"`
+	CachedBytes    int       `json:"cached_bytes"`
+	LastActive     time.Time `json:"last_active"`
+}
+
+// Stats is a snapshot of the broker's topics, to spot topics that are never closed.
+type Stats struct {
+	Topics         int          `json:"topics"`
+	Subscribers    int          `json:"subscribers"`
+	CachedMessages int          `json:"cached_messages"`
+	CachedBytes    int          `json:"cached_bytes"`
+	TopicList      []TopicStats `json:"topic_list"` // least recently active first
+}
+
+// Stats returns the current topics with their subscriber and cache sizes.
+func (b *Broker) Stats() Stats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := Stats{TopicList: make([]TopicStats, 0)}
+	for _, topic := range b.topics() {
+		ts := TopicStats{
+			Topic:          topic,
+			Subscribers:    len(b.subscribers[topic]),
+			CachedMessages: len(b.cache[topic]),
+			LastActive:     b.lastActive[topic],
+		}
+		for _, msg := range b.cache[topic] {
+			ts.CachedBytes += len(msg)
+		}
+		stats.Subscribers += ts.Subscribers
+		stats.CachedMessages += ts.CachedMessages
+		stats.CachedBytes += ts.CachedBytes
+		stats.TopicList = append(stats.TopicList, ts)
+	}
+	stats.Topics = len(stats.TopicList)
+	sort.Slice(stats.TopicList, func(i, j int) bool {
+		return stats.TopicList[i].LastActive.Before(stats.TopicList[j].LastActive)
+	})
+	return stats
+}
+
+// topics lists every topic with subscribers or cached messages. The caller must hold the lock.
+func (b *Broker) topics() []string {
+	topics := make([]string, 0, len(b.cache))
+	for topic := range b.cache {
+		topics = append(topics, topic)
+	}
+	for topic := range b.subscribers {
+		if _, ok := b.cache[topic]; !ok {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// ReapIdle closes the topics that have no subscribers and saw no activity for longer than idle,
+// returning how many were closed. It catches topics whose publisher never called CloseTopic.
+func (b *Broker) ReapIdle(idle time.Duration) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cutoff := time.Now().Add(-idle)
+	reaped := 0
+	for _, topic := range b.topics() {
+		if len(b.subscribers[topic]) > 0 || b.lastActive[topic].After(cutoff) {
+			continue
+		}
+		delete(b.cache, topic)
+		delete(b.subscribers, topic)
+		delete(b.lastActive, topic)
+		reaped++
+	}
+	return reaped
+}
+
+// StartReaper calls ReapIdle periodically. It never returns.
+func (b *Broker) StartReaper(idle time.Duration) {
+	ticker := time.NewTicker(min(idle, reapInterval))
+	defer ticker.Stop()
+	for range ticker.C {
+		if reaped := b.ReapIdle(idle); reaped > 0 {
+			zap.S().Warnf("reaped %d idle pubsub topics that were never closed", reaped)
+		}
+	}
+}
+
+// reapInterval is the longest time between two runs of the reaper.
+const reapInterval = 5 * time.Minute
+
 // Helper to format stream messages
 func FormatMessage(streamType string, data string) []byte {
-	msg := WsMessage{Stream: streamType, Data: data}
+	now := time.Now()
+	msg := WsMessage{Stream: streamType, Data: data, TS: &now}
 	bytes, err := json.Marshal(msg)
 	if err != nil {
 		return []byte(`{"stream": "error", "data": "json format error"}`)
 	}
 	return bytes
 }
+
+// maxLogLineSize bounds a single NDJSON line of a stored log.
+const maxLogLineSize = 4 << 20
+
+// ParseLog reads a stored NDJSON container log. Consecutive messages of the same stream are
+// merged into one entry, which keeps the timestamp of its first message. Malformed lines are skipped.
+func ParseLog(r io.Reader) ([]WsMessage, error) {
+	entries := make([]WsMessage, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+	for scanner.Scan() {
+		var msg WsMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if n := len(entries); n > 0 && entries[n-1].Stream == msg.Stream {
+			entries[n-1].Data += msg.Data
+			continue
+		}
+		entries = append(entries, msg)
+	}
+	return entries, scanner.Err()
+}
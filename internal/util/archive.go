@@ -0,0 +1,267 @@
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Supported archive formats for downloads.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// WriteZip streams the contents of srcDir as a zip archive to w.
+func WriteZip(w io.Writer, srcDir string) error {
+	zipWriter := zip.NewWriter(w)
+	if err := AddDirToZip(zipWriter, srcDir, ""); err != nil {
+		return err
+	}
+	return zipWriter.Close()
+}
+
+// AddDirToZip writes the contents of srcDir to the zip archive under the given prefix directory
+// (the archive root if empty). The caller closes the writer.
+func AddDirToZip(zipWriter *zip.Writer, srcDir, prefix string) error {
+	return filepath.Walk(srcDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, relPath)) // Use forward slashes in zip
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return copyFileTo(writer, path)
+	})
+}
+
+// WriteTarGz streams the contents of srcDir as a gzip-compressed tar archive to w.
+func WriteTarGz(w io.Writer, srcDir string) error {
+	gzWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzWriter)
+	err := filepath.Walk(srcDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." || !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return copyFileTo(tarWriter, path)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzWriter.Close()
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// ServeDirectoryArchive streams srcDir to the client as a downloadable archive named
+// baseName plus the format's extension. The format defaults to zip.
+func ServeDirectoryArchive(c *gin.Context, srcDir, baseName, format string) {
+	var contentType string
+	var write func(io.Writer, string) error
+	switch format {
+	case "", ArchiveZip:
+		format, contentType, write = ArchiveZip, "application/zip", WriteZip
+	case ArchiveTarGz:
+		contentType, write = "application/gzip", WriteTarGz
+	default:
+		Error(c, http.StatusBadRequest, fmt.Sprintf("unsupported archive format: %s", format))
+		return
+	}
+
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", baseName, format))
+	c.Status(http.StatusOK)
+
+	// The response is already streaming, so errors can only be logged.
+	if err := write(c.Writer, srcDir); err != nil {
+		Logger(c).Errorf("failed to stream %s archive of %s: %v", format, srcDir, err)
+		c.Abort()
+	}
+}
+
+// ArchiveStats describes what an uploaded archive expands to.
+type ArchiveStats struct {
+	Format       string
+	Entries      int
+	ExpandedSize int64
+}
+
+// InspectArchive detects zip, tar and gzip files by their magic bytes and reports their number
+// of entries and uncompressed size. ok is false for any other file. Zip and tar sizes are taken
+// from the archive metadata. Gzip records no reliable size, so the stream is decompressed, reading
+// at most maxExpanded+1 bytes: a larger result is reported as maxExpanded+1. A gzipped tar is
+// counted entry by entry.
+func InspectArchive(path string, maxExpanded int64) (stats ArchiveStats, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ArchiveStats{}, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ArchiveStats{}, false, err
+	}
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ArchiveStats{}, false, err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return ArchiveStats{}, false, fmt.Errorf("invalid zip archive: %w", err)
+		}
+		stats = ArchiveStats{Format: ArchiveZip, Entries: len(zr.File)}
+		for _, file := range zr.File {
+			if stats.ExpandedSize <= maxExpanded { // stop adding once over, so forged sizes cannot overflow
+				stats.ExpandedSize += int64(min(file.UncompressedSize64, math.MaxInt64/2))
+			}
+		}
+		return stats, true, nil
+
+	case isTarHeader(header):
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return ArchiveStats{}, false, err
+		}
+		stats, err = inspectTar(f, maxExpanded)
+		stats.Format = "tar"
+		return stats, true, err
+
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return ArchiveStats{}, false, err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return ArchiveStats{}, false, fmt.Errorf("invalid gzip file: %w", err)
+		}
+		defer gz.Close()
+		limited := &countingReader{r: io.LimitReader(gz, maxExpanded+1)}
+		inner := bufio.NewReaderSize(limited, 512)
+		if peek, _ := inner.Peek(512); isTarHeader(peek) {
+			stats, err = inspectTar(inner, maxExpanded)
+			stats.Format = ArchiveTarGz
+		} else {
+			stats = ArchiveStats{Format: "gzip", Entries: 1}
+			_, err = io.Copy(io.Discard, inner)
+		}
+		stats.ExpandedSize = max(stats.ExpandedSize, limited.n)
+		if err != nil && limited.n <= maxExpanded {
+			return stats, true, fmt.Errorf("invalid gzip file: %w", err)
+		}
+		return stats, true, nil
+	}
+	return ArchiveStats{}, false, nil
+}
+
+// inspectTar counts the entries and total file size of a tar stream. It stops early once the
+// size exceeds maxExpanded.
+func inspectTar(r io.Reader, maxExpanded int64) (ArchiveStats, error) {
+	var stats ArchiveStats
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			if stats.ExpandedSize > maxExpanded {
+				return stats, nil
+			}
+			return stats, fmt.Errorf("invalid tar archive: %w", err)
+		}
+		stats.Entries++
+		stats.ExpandedSize += max(hdr.Size, 0)
+		if stats.ExpandedSize > maxExpanded {
+			return stats, nil
+		}
+	}
+}
+
+// isTarHeader reports whether b starts with a POSIX or GNU tar header.
+func isTarHeader(b []byte) bool {
+	return len(b) >= 262 && bytes.Equal(b[257:262], []byte("ustar"))
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
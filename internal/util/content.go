@@ -0,0 +1,160 @@
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ContentArchiveExt is appended to the submission ID for content stored as one compressed archive
+// instead of a loose directory.
+const ContentArchiveExt = ".tar.gz"
+
+// ContentPaths returns the two forms a submission's content can take below root: a loose
+// directory and a compressed archive. At most one of them exists.
+func ContentPaths(root, id string) (dir, archive string) {
+	dir = filepath.Join(root, id)
+	return dir, dir + ContentArchiveExt
+}
+
+// CompressContent packs the loose content directory of a submission into its archive and
+// removes the directory.
+func CompressContent(root, id string) error {
+	dir, archive := ContentPaths(root, id)
+	tmp, err := os.CreateTemp(root, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := WriteTarGz(tmp, dir); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), archive); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// OpenContent returns a directory holding the content of a submission. Compressed content is
+// extracted into a temporary directory, which cleanup removes; for a loose directory cleanup does
+// nothing. The error satisfies os.IsNotExist if the submission has no content on disk.
+func OpenContent(root, id string) (dir string, cleanup func(), err error) {
+	dir, archive := ContentPaths(root, id)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir, func() {}, nil
+	}
+	if _, err := os.Stat(archive); err != nil {
+		return "", nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "csoj-content-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(tmp) }
+	if err := ExtractTarGz(archive, tmp); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to extract %s: %w", archive, err)
+	}
+	return tmp, cleanup, nil
+}
+
+// RemoveContent deletes the content of a submission in whichever form it is stored.
+func RemoveContent(root, id string) error {
+	dir, archive := ContentPaths(root, id)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Remove(archive); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// MoveContent moves a submission's content, in whichever form it is stored, from one root to
+// another, reporting whether there was anything to move. Content removed by the retention janitor
+// is simply missing.
+func MoveContent(srcRoot, dstRoot, id string) (bool, error) {
+	srcDir, srcArchive := ContentPaths(srcRoot, id)
+	dstDir, dstArchive := ContentPaths(dstRoot, id)
+	src, dst := srcDir, dstDir
+	if _, err := os.Stat(srcDir); err != nil {
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+		if _, err := os.Stat(srcArchive); err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		src, dst = srcArchive, dstArchive
+	}
+	if err := os.MkdirAll(dstRoot, 0755); err != nil {
+		return false, err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ExtractTarGz unpacks a gzip-compressed tar archive into dst. Only directories and regular files
+// are extracted, and entries that would end up outside dst are rejected.
+func ExtractTarGz(archive, dst string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	root := filepath.Clean(dst) + string(os.PathSeparator)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(target+string(os.PathSeparator), root) {
+			return fmt.Errorf("archive entry %q escapes the destination", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// HashDirectory returns a SHA-256 over the regular files of dir. Files are visited in lexical
+// order and contribute their slash-separated relative path and content, so the hash does not
+// depend on file modes, times or the order files were written in.
+func HashDirectory(dir string) (string, error) {
+	tree := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		content := sha256.New()
+		if _, err := io.Copy(content, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(tree, "%s\x00%x\n", filepath.ToSlash(relPath), content.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tree.Sum(nil)), nil
+}
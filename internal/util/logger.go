@@ -0,0 +1,22 @@
+package util
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// LoggerKey is the gin context key under which the request-scoped logger is stored.
+const LoggerKey = "logger"
+
+// Logger returns the request-scoped logger attached by the request logger middleware.
+// It falls back to the global logger when no request logger is present.
+func Logger(c *gin.Context) *zap.SugaredLogger {
+	if c != nil {
+		if v, ok := c.Get(LoggerKey); ok {
+			if logger, ok := v.(*zap.SugaredLogger); ok {
+				return logger
+			}
+		}
+	}
+	return zap.S()
+}
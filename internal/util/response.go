@@ -1,10 +1,10 @@
 package util
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 )
 
 type Response struct {
@@ -22,21 +22,31 @@ func Success(c *gin.Context, data interface{}, message string) {
 }
 
 func Error(c *gin.Context, code int, err interface{}) {
+	ErrorWithData(c, code, err, nil)
+}
+
+// ErrorWithData is like Error but also returns data describing the failure.
+func ErrorWithData(c *gin.Context, code int, err interface{}, data interface{}) {
 	msg := ""
 	switch e := err.(type) {
 	case string:
 		msg = e
 	case error:
 		msg = e.Error()
+		// Handlers report a body cut off by http.MaxBytesReader like any bad request.
+		var tooLarge *http.MaxBytesError
+		if errors.As(e, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
 	default:
 		msg = "Internal Server Error"
 	}
 
-	zap.S().Errorf("API Error: %s", msg)
+	Logger(c).Errorf("API Error: %s", msg)
 
 	c.JSON(code, Response{
 		Code:    -1,
-		Data:    nil,
+		Data:    data,
 		Message: msg,
 	})
 }